@@ -0,0 +1,383 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Listing types of an item.
+const (
+	// ListingFixed sells the item to the first buyer at Item.Price. It is the default.
+	ListingFixed = "fixed"
+	// ListingAuction sells the item to the highest bidder when the auction ends.
+	ListingAuction = "auction"
+)
+
+const (
+	// AuctionExtensionWindow is the anti-sniping rule: a bid placed this close to the end of an
+	// auction pushes the end to this long after the bid, so that others can answer it.
+	AuctionExtensionWindow = 5 * time.Minute
+	// maxAuctionDuration is how far in the future an auction may end.
+	maxAuctionDuration = 30 * 24 * time.Hour
+	// defaultMinIncrement is used when a seller does not set min_increment.
+	defaultMinIncrement = 100
+	// auctionCloseInterval is how often ended auctions are closed.
+	auctionCloseInterval = time.Minute
+	defaultBidLimit      = 50
+	maxBidLimit          = 200
+)
+
+// Order statuses.
+const (
+	// OrderPending is an order waiting for the buyer to pay and receive the item.
+	OrderPending = "pending"
+	// OrderCompleted is an order the buyer confirmed receiving. Its parties may review each other.
+	OrderCompleted = "completed"
+)
+
+var (
+	// ErrAuctionNotFound is returned for items which are not auctions.
+	ErrAuctionNotFound = errors.New("item is not an auction")
+	ErrAuctionEnded    = errors.New("auction has ended")
+	// ErrBidTooLow is returned for bids below the start price or the minimum increment.
+	ErrBidTooLow = errors.New("bid is too low")
+	// ErrAuctionHasBids is returned for deleting an auction which received bids before it closed.
+	ErrAuctionHasBids = errors.New("auction has bids")
+	// errBidConflict makes placeBid try again when another bid was placed meanwhile.
+	errBidConflict = errors.New("auction changed while bidding")
+)
+
+// Auction is the terms and state of an item listed as an auction.
+type Auction struct {
+	ItemID       int       `json:"-"`
+	StartPrice   int       `json:"start_price"`
+	MinIncrement int       `json:"min_increment"`
+	EndsAt       time.Time `json:"ends_at"`
+	// HighestBid is zero before the first bid.
+	HighestBid      int `json:"highest_bid"`
+	HighestBidderID int `json:"highest_bidder_id,omitempty"`
+	BidCount        int `json:"bid_count"`
+	// ClosedAt is set once the auction has been settled by the scheduler.
+	ClosedAt *time.Time `json:"closed_at,omitempty"`
+}
+
+// Bid is an accepted bid on an auction.
+type Bid struct {
+	ID        int64     `json:"id"`
+	ItemID    int       `json:"item_id"`
+	BidderID  int       `json:"bidder_id"`
+	Amount    int       `json:"amount"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Order is the sale of an item to a buyer.
+type Order struct {
+	ID        int       `json:"id"`
+	ItemID    int       `json:"item_id"`
+	SellerID  int       `json:"seller_id"`
+	BuyerID   int       `json:"buyer_id"`
+	Price     int       `json:"price"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	// CompletedAt is set when the order is completed.
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// minimumBid returns the lowest amount the next bid may have.
+func (a *Auction) minimumBid() int {
+	if a.BidCount == 0 {
+		return a.StartPrice
+	}
+	return a.HighestBid + a.MinIncrement
+}
+
+// ended reports whether bids are no longer accepted at now.
+func (a *Auction) ended(now time.Time) bool {
+	return a.ClosedAt != nil || !now.Before(a.EndsAt)
+}
+
+// placeBid validates b against the auction at now and makes it the highest bid, extending the
+// auction when it is placed within AuctionExtensionWindow of the end.
+func (a *Auction) placeBid(b *Bid, now time.Time) error {
+	if a.ended(now) {
+		return ErrAuctionEnded
+	}
+	if min := a.minimumBid(); b.Amount < min {
+		return fmt.Errorf("bid must be at least %d yen: %w", min, ErrBidTooLow)
+	}
+	a.HighestBid, a.HighestBidderID = b.Amount, b.BidderID
+	a.BidCount++
+	if end := now.Add(AuctionExtensionWindow); a.EndsAt.Before(end) {
+		a.EndsAt = end
+	}
+	return nil
+}
+
+// insertAuction stores the auction of a newly inserted item with tx.
+func insertAuction(ctx context.Context, tx querier, placeholder func(int) string, item *Item) error {
+	if item.ListingType != ListingAuction {
+		return nil
+	}
+	if item.Auction == nil {
+		return errors.New("auction terms are required")
+	}
+	a := item.Auction
+	a.ItemID = item.ID
+	a.EndsAt = a.EndsAt.UTC()
+	query := `INSERT INTO auctions (item_id, start_price, min_increment, ends_at) VALUES (` + params(placeholder, 4) + `)`
+	if _, err := tx.ExecContext(ctx, query, a.ItemID, a.StartPrice, a.MinIncrement, a.EndsAt); err != nil {
+		return fmt.Errorf("failed to insert auction: %w", err)
+	}
+	return nil
+}
+
+// AuctionRepository stores the auctions of items with their bids, and the orders of sold items.
+// Bids raise the price of the item and closing sells it, so it shares the database of the
+// ItemRepository.
+type AuctionRepository interface {
+	// GetAuction returns the auction of an item. ErrAuctionNotFound is returned if the item is
+	// not listed as an auction.
+	GetAuction(ctx context.Context, itemID int) (*Auction, error)
+	// PlaceBid validates bid at now and stores it as the highest bid, setting its ID and
+	// CreatedAt, and returns the updated auction. ErrAuctionEnded and ErrBidTooLow reject bids.
+	PlaceBid(ctx context.Context, bid *Bid, now time.Time) (*Auction, error)
+	// ListBids returns up to limit bids on an item, newest first.
+	ListBids(ctx context.Context, itemID, limit int) ([]*Bid, error)
+	// EndedAuctions returns up to limit items whose auction ended at now and is not closed yet.
+	EndedAuctions(ctx context.Context, now time.Time, limit int) ([]int, error)
+	// CloseAuction closes an ended auction. If it received bids, the item is sold to the highest
+	// bidder and the order is returned; otherwise the order is nil. Closing an auction which is
+	// running or already closed has no effect.
+	CloseAuction(ctx context.Context, itemID int, now time.Time) (*Order, error)
+	// ListOrders returns the orders userID bought or sold, newest first.
+	ListOrders(ctx context.Context, userID int) ([]*Order, error)
+	// GetOrder returns ErrOrderNotFound when there is no such order.
+	GetOrder(ctx context.Context, id int) (*Order, error)
+	// CompleteOrder marks a pending order completed at now. ErrOrderNotPending is returned if it
+	// is already completed.
+	CompleteOrder(ctx context.Context, id int, now time.Time) (*Order, error)
+}
+
+// AuctionCloseJob is the periodic job closing ended auctions.
+const AuctionCloseJob JobType[struct{}] = "auction_close"
+
+// auctionCloseJob returns the AuctionCloseJob handler. now is the clock deciding which
+// auctions have ended.
+func auctionCloseJob(auctions AuctionRepository, items ItemRepository, now func() time.Time) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		_, err := CloseEndedAuctions(ctx, auctions, items, now())
+		return err
+	}
+}
+
+// CloseEndedAuctions closes the auctions ended at now and returns the orders created for their
+// winners. items is told about the items closing changed, for its cache.
+func CloseEndedAuctions(ctx context.Context, auctions AuctionRepository, items ItemRepository, now time.Time) ([]*Order, error) {
+	ids, err := auctions.EndedAuctions(ctx, now, 100)
+	if err != nil {
+		return nil, err
+	}
+	var orders []*Order
+	for _, id := range ids {
+		order, err := auctions.CloseAuction(ctx, id, now)
+		if err != nil {
+			return orders, fmt.Errorf("failed to close auction of item %d: %w", id, err)
+		}
+		itemChanged(items, id)
+		if order == nil {
+			slog.Info("auction closed without a sale", "item_id", id)
+			continue
+		}
+		slog.Info("auction closed", "item_id", id, "order_id", order.ID, "price", order.Price)
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
+
+// parseAuctionTerms reads the auction fields of POST /items into req.
+func parseAuctionTerms(r *http.Request, req *AddItemRequest) error {
+	req.ListingType = r.FormValue("listing_type")
+	switch req.ListingType {
+	case "", ListingFixed:
+		for _, key := range []string{"start_price", "min_increment", "ends_at"} {
+			if r.FormValue(key) != "" {
+				return fmt.Errorf("%s is only for auctions", key)
+			}
+		}
+		return nil
+	case ListingAuction:
+	default:
+		return errors.New("listing_type must be fixed or auction")
+	}
+
+	if req.Price != 0 {
+		return errors.New("use start_price instead of price for auctions")
+	}
+	var err error
+	if req.StartPrice, err = parsePrice(r, "start_price"); err != nil {
+		return err
+	}
+	if req.StartPrice == 0 {
+		return errors.New("start_price is required for auctions")
+	}
+	if req.MinIncrement, err = parsePrice(r, "min_increment"); err != nil {
+		return err
+	}
+	if req.MinIncrement == 0 {
+		req.MinIncrement = defaultMinIncrement
+	}
+	v := r.FormValue("ends_at")
+	if v == "" {
+		return errors.New("ends_at is required for auctions")
+	}
+	if req.EndsAt, err = time.Parse(time.RFC3339, v); err != nil {
+		return errors.New("ends_at must be an RFC 3339 time")
+	}
+	return nil
+}
+
+// checkAuctionEnd reports an error unless an auction ending at endsAt may be listed at now.
+func checkAuctionEnd(endsAt, now time.Time) error {
+	if !endsAt.After(now) {
+		return errors.New("ends_at must be in the future")
+	}
+	if endsAt.Sub(now) > maxAuctionDuration {
+		return fmt.Errorf("auctions may last at most %d days", int(maxAuctionDuration/(24*time.Hour)))
+	}
+	return nil
+}
+
+// now returns the current time of the handlers' clock.
+func (h *Handlers) now() time.Time {
+	if h.clock != nil {
+		return h.clock()
+	}
+	return time.Now()
+}
+
+// PlaceBid is a handler to bid on an auction for POST /items/{id}/bids .
+// The form field amount is the bid in yen. Sellers cannot bid on their own items.
+func (h *Handlers) PlaceBid(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, signedIn := userIDFromContext(ctx)
+	if !signedIn {
+		http.Error(w, "sign in to bid", http.StatusUnauthorized)
+		return
+	}
+	itemID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		return
+	}
+	amount, err := parsePrice(r, "amount")
+	if err != nil || amount == 0 {
+		http.Error(w, "amount must be a positive integer", http.StatusBadRequest)
+		return
+	}
+	item, err := h.itemRepo.Select(ctx, itemID)
+	if err != nil {
+		if errors.Is(err, ErrItemNotFound) {
+			http.Error(w, "Item not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to get item", http.StatusInternalServerError)
+		return
+	}
+	if item.SellerID == userID {
+		http.Error(w, "sellers cannot bid on their own items", http.StatusForbidden)
+		return
+	}
+
+	bid := &Bid{ItemID: itemID, BidderID: userID, Amount: amount}
+	auction, err := h.auctions.PlaceBid(ctx, bid, h.now())
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrItemNotFound):
+			http.Error(w, "Item not found", http.StatusNotFound)
+		case errors.Is(err, ErrAuctionNotFound):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, ErrAuctionEnded), errors.Is(err, ErrBidTooLow):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		default:
+			slog.Error("failed to place bid", "item_id", itemID, "error", err)
+			http.Error(w, "failed to place bid", http.StatusInternalServerError)
+		}
+		return
+	}
+	itemChanged(h.itemRepo, itemID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"bid": bid, "auction": auction, "minimum_bid": auction.minimumBid()})
+}
+
+// GetBids is a handler to return the bid history of an auction, newest first, for
+// GET /items/{id}/bids . limit caps the number of bids.
+func (h *Handlers) GetBids(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	itemID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		return
+	}
+	limit := defaultBidLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxBidLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxBidLimit), http.StatusBadRequest)
+			return
+		}
+	}
+	if _, err := h.itemRepo.Select(ctx, itemID); err != nil {
+		if errors.Is(err, ErrItemNotFound) {
+			http.Error(w, "Item not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to get item", http.StatusInternalServerError)
+		return
+	}
+	auction, err := h.auctions.GetAuction(ctx, itemID)
+	if err != nil {
+		if errors.Is(err, ErrAuctionNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to get auction", http.StatusInternalServerError)
+		return
+	}
+	bids, err := h.auctions.ListBids(ctx, itemID, limit)
+	if err != nil {
+		http.Error(w, "failed to get bids", http.StatusInternalServerError)
+		return
+	}
+	if bids == nil {
+		bids = []*Bid{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"bids": bids, "auction": auction, "minimum_bid": auction.minimumBid()})
+}
+
+// GetMyOrders is a handler to return the orders the signed-in user bought or sold, newest
+// first, for GET /me/orders .
+func (h *Handlers) GetMyOrders(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, signedIn := userIDFromContext(ctx)
+	if !signedIn {
+		http.Error(w, "sign in to see your orders", http.StatusUnauthorized)
+		return
+	}
+	orders, err := h.auctions.ListOrders(ctx, userID)
+	if err != nil {
+		http.Error(w, "failed to get orders", http.StatusInternalServerError)
+		return
+	}
+	if orders == nil {
+		orders = []*Order{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"orders": orders})
+}
@@ -0,0 +1,519 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// sqlAuctionRepository stores auctions, bids and orders in SQLite or PostgreSQL. placeholder
+// is the dialect's bind parameter.
+type sqlAuctionRepository struct {
+	db          *sql.DB
+	placeholder func(int) string
+}
+
+// NewAuctionRepository returns an AuctionRepository using the database opened by OpenDatabase with dsn.
+func NewAuctionRepository(db *sql.DB, dsn string) AuctionRepository {
+	d, _ := parseDSN(dsn)
+	return &sqlAuctionRepository{db: db, placeholder: d.placeholder()}
+}
+
+// GetAuction returns the auction of an item.
+func (r *sqlAuctionRepository) GetAuction(ctx context.Context, itemID int) (*Auction, error) {
+	return r.get(ctx, r.db, itemID)
+}
+
+// get returns the auction of an item. q may be a transaction.
+func (r *sqlAuctionRepository) get(ctx context.Context, q querier, itemID int) (*Auction, error) {
+	query := `SELECT item_id, start_price, min_increment, ends_at, highest_bid, highest_bidder_id, bid_count, closed_at
+        FROM auctions WHERE item_id = ` + r.placeholder(1)
+	var a Auction
+	var bidderID sql.NullInt64
+	var closedAt sql.NullTime
+	err := q.QueryRowContext(ctx, query, itemID).Scan(&a.ItemID, &a.StartPrice, &a.MinIncrement, &a.EndsAt,
+		&a.HighestBid, &bidderID, &a.BidCount, &closedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAuctionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get auction: %w", err)
+	}
+	a.HighestBidderID = int(bidderID.Int64)
+	if closedAt.Valid {
+		a.ClosedAt = &closedAt.Time
+	}
+	return &a, nil
+}
+
+// PlaceBid validates and stores bid in one transaction. The auction row is only updated if no
+// other bid was accepted since it was read; otherwise the bid is validated again.
+func (r *sqlAuctionRepository) PlaceBid(ctx context.Context, bid *Bid, now time.Time) (*Auction, error) {
+	for {
+		a, err := r.tryPlaceBid(ctx, bid, now)
+		if !errors.Is(err, errBidConflict) {
+			return a, err
+		}
+	}
+}
+
+func (r *sqlAuctionRepository) tryPlaceBid(ctx context.Context, bid *Bid, now time.Time) (*Auction, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var deleted sql.NullTime
+	err = tx.QueryRowContext(ctx, `SELECT deleted_at FROM items WHERE id = `+r.placeholder(1), bid.ItemID).Scan(&deleted)
+	if errors.Is(err, sql.ErrNoRows) || deleted.Valid {
+		return nil, ErrItemNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to place bid: %w", err)
+	}
+	a, err := r.get(ctx, tx, bid.ItemID)
+	if err != nil {
+		return nil, err
+	}
+	bidCount := a.BidCount
+	if err := a.placeBid(bid, now); err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`UPDATE auctions SET highest_bid = %s, highest_bidder_id = %s, bid_count = %s, ends_at = %s
+        WHERE item_id = %s AND bid_count = %s AND closed_at IS NULL`,
+		r.placeholder(1), r.placeholder(2), r.placeholder(3), r.placeholder(4), r.placeholder(5), r.placeholder(6))
+	result, err := tx.ExecContext(ctx, query, a.HighestBid, a.HighestBidderID, a.BidCount, a.EndsAt.UTC(), a.ItemID, bidCount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to place bid: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return nil, errBidConflict
+	}
+	bid.CreatedAt = now.UTC()
+	query = `INSERT INTO bids (item_id, bidder_id, amount, created_at) VALUES (` + params(r.placeholder, 4) + `) RETURNING id`
+	if err := tx.QueryRowContext(ctx, query, bid.ItemID, bid.BidderID, bid.Amount, bid.CreatedAt).Scan(&bid.ID); err != nil {
+		return nil, fmt.Errorf("failed to place bid: %w", err)
+	}
+	// 一覧や検索で現在価格が見えるように商品の価格も上げる
+	query = fmt.Sprintf(`UPDATE items SET price = %s, version = version + 1, updated_at = %s WHERE id = %s`,
+		r.placeholder(1), r.placeholder(2), r.placeholder(3))
+	if _, err := tx.ExecContext(ctx, query, bid.Amount, bid.CreatedAt, bid.ItemID); err != nil {
+		return nil, fmt.Errorf("failed to update item price: %w", err)
+	}
+	if err := recordChange(ctx, tx, r.placeholder, AuditCreate, AuditEntityBid, bid.ItemID, nil, bid); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to place bid: %w", err)
+	}
+	return a, nil
+}
+
+// ListBids returns up to limit bids on an item, newest first.
+func (r *sqlAuctionRepository) ListBids(ctx context.Context, itemID, limit int) ([]*Bid, error) {
+	query := `SELECT id, item_id, bidder_id, amount, created_at FROM bids WHERE item_id = ` + r.placeholder(1) + `
+        ORDER BY id DESC LIMIT ` + strconv.Itoa(limit)
+	rows, err := r.db.QueryContext(ctx, query, itemID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list bids: %w", err)
+	}
+	defer rows.Close()
+
+	var bids []*Bid
+	for rows.Next() {
+		var b Bid
+		if err := rows.Scan(&b.ID, &b.ItemID, &b.BidderID, &b.Amount, &b.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan bid: %w", err)
+		}
+		bids = append(bids, &b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list bids: %w", err)
+	}
+	return bids, nil
+}
+
+// EndedAuctions returns the items whose auction ended at now but has not been closed.
+func (r *sqlAuctionRepository) EndedAuctions(ctx context.Context, now time.Time, limit int) ([]int, error) {
+	query := `SELECT item_id FROM auctions WHERE closed_at IS NULL AND ends_at <= ` + r.placeholder(1) + `
+        ORDER BY ends_at, item_id LIMIT ` + strconv.Itoa(limit)
+	rows, err := r.db.QueryContext(ctx, query, now.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ended auctions: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan auction: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list ended auctions: %w", err)
+	}
+	return ids, nil
+}
+
+// CloseAuction closes an ended auction and sells the item to the highest bidder in one
+// transaction, writing the ItemSold event. It returns the order, or nil when the auction is
+// still running, was already closed or received no bids.
+func (r *sqlAuctionRepository) CloseAuction(ctx context.Context, itemID int, now time.Time) (*Order, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now = now.UTC()
+	query := fmt.Sprintf(`UPDATE auctions SET closed_at = %s WHERE item_id = %s AND closed_at IS NULL AND ends_at <= %s`,
+		r.placeholder(1), r.placeholder(2), r.placeholder(3))
+	result, err := tx.ExecContext(ctx, query, now, itemID, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to close auction: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		if _, err := r.get(ctx, tx, itemID); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+	a, err := r.get(ctx, tx, itemID)
+	if err != nil {
+		return nil, err
+	}
+	before, err := selectItemByID(ctx, tx, r.placeholder, itemID)
+	if err != nil {
+		return nil, err
+	}
+	// 入札がない、または削除された商品は売らずに終える
+	if a.BidCount == 0 || before.DeletedAt != nil {
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("failed to close auction: %w", err)
+		}
+		return nil, nil
+	}
+
+	order := &Order{ItemID: itemID, SellerID: before.SellerID, BuyerID: a.HighestBidderID, Price: a.HighestBid, Status: OrderPending, CreatedAt: now}
+	query = `INSERT INTO orders (item_id, seller_id, buyer_id, price, status, created_at) VALUES (` + params(r.placeholder, 6) + `) RETURNING id`
+	if err := tx.QueryRowContext(ctx, query, order.ItemID, order.SellerID, order.BuyerID, order.Price, order.Status, order.CreatedAt).Scan(&order.ID); err != nil {
+		return nil, fmt.Errorf("failed to create order: %w", err)
+	}
+	query = fmt.Sprintf(`UPDATE items SET sold_at = %s, version = version + 1, updated_at = %s WHERE id = %s`,
+		r.placeholder(1), r.placeholder(2), r.placeholder(3))
+	if _, err := tx.ExecContext(ctx, query, now, now, itemID); err != nil {
+		return nil, fmt.Errorf("failed to mark item sold: %w", err)
+	}
+	after, err := selectItemByID(ctx, tx, r.placeholder, itemID)
+	if err != nil {
+		return nil, err
+	}
+	after.Auction = a
+	if err := recordChange(ctx, tx, r.placeholder, AuditSell, AuditEntityItem, itemID, before, after); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to close auction: %w", err)
+	}
+	return order, nil
+}
+
+const orderColumns = `id, item_id, seller_id, buyer_id, price, status, created_at, completed_at`
+
+func scanOrder(row rowScanner) (*Order, error) {
+	var o Order
+	var completedAt sql.NullTime
+	if err := row.Scan(&o.ID, &o.ItemID, &o.SellerID, &o.BuyerID, &o.Price, &o.Status, &o.CreatedAt, &completedAt); err != nil {
+		return nil, err
+	}
+	if completedAt.Valid {
+		o.CompletedAt = &completedAt.Time
+	}
+	return &o, nil
+}
+
+// ListOrders returns the orders userID bought or sold, newest first.
+func (r *sqlAuctionRepository) ListOrders(ctx context.Context, userID int) ([]*Order, error) {
+	query := `SELECT ` + orderColumns + ` FROM orders
+        WHERE buyer_id = ` + r.placeholder(1) + ` OR seller_id = ` + r.placeholder(2) + ` ORDER BY id DESC`
+	rows, err := r.db.QueryContext(ctx, query, userID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list orders: %w", err)
+	}
+	defer rows.Close()
+
+	var orders []*Order
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan order: %w", err)
+		}
+		orders = append(orders, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list orders: %w", err)
+	}
+	return orders, nil
+}
+
+// selectOrder returns an order. The ReviewRepository reads the orders it reviews with it too.
+func selectOrder(ctx context.Context, q querier, placeholder func(int) string, id int) (*Order, error) {
+	o, err := scanOrder(q.QueryRowContext(ctx, `SELECT `+orderColumns+` FROM orders WHERE id = `+placeholder(1), id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order: %w", err)
+	}
+	return o, nil
+}
+
+// GetOrder returns an order.
+func (r *sqlAuctionRepository) GetOrder(ctx context.Context, id int) (*Order, error) {
+	return selectOrder(ctx, r.db, r.placeholder, id)
+}
+
+// CompleteOrder marks a pending order completed at now.
+func (r *sqlAuctionRepository) CompleteOrder(ctx context.Context, id int, now time.Time) (*Order, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := selectOrder(ctx, tx, r.placeholder, id)
+	if err != nil {
+		return nil, err
+	}
+	now = now.UTC()
+	query := fmt.Sprintf(`UPDATE orders SET status = %s, completed_at = %s WHERE id = %s AND status = %s`,
+		r.placeholder(1), r.placeholder(2), r.placeholder(3), r.placeholder(4))
+	result, err := tx.ExecContext(ctx, query, OrderCompleted, now, id, OrderPending)
+	if err != nil {
+		return nil, fmt.Errorf("failed to complete order: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return nil, ErrOrderNotPending
+	}
+	after := *before
+	after.Status, after.CompletedAt = OrderCompleted, &now
+	if err := recordChange(ctx, tx, r.placeholder, AuditUpdate, AuditEntityOrder, id, before, &after); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to complete order: %w", err)
+	}
+	return &after, nil
+}
+
+// memoryAuctionRepository keeps auctions, bids and orders in the memory store of a
+// memoryItemRepository, whose items they price and sell.
+type memoryAuctionRepository struct {
+	*memoryItemRepository
+}
+
+// NewMemoryAuctionRepository returns the AuctionRepository sharing the memory store of items,
+// which must be returned by NewMemoryItemRepository.
+func NewMemoryAuctionRepository(items ItemRepository) AuctionRepository {
+	return memoryAuctionRepository{items.(*memoryItemRepository)}
+}
+
+// GetAuction returns the auction of an item.
+func (m memoryAuctionRepository) GetAuction(ctx context.Context, itemID int) (*Auction, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get auction: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	a, ok := m.auctions[itemID]
+	if !ok {
+		return nil, ErrAuctionNotFound
+	}
+	return &a, nil
+}
+
+// PlaceBid validates and stores a bid and raises the price of the item to it.
+func (m memoryAuctionRepository) PlaceBid(ctx context.Context, bid *Bid, now time.Time) (*Auction, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to place bid: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	item, ok := m.items[bid.ItemID]
+	if !ok || item.DeletedAt != nil {
+		return nil, ErrItemNotFound
+	}
+	a, ok := m.auctions[bid.ItemID]
+	if !ok {
+		return nil, ErrAuctionNotFound
+	}
+	if err := a.placeBid(bid, now); err != nil {
+		return nil, err
+	}
+	stored := *bid
+	stored.ID = m.nextBidID
+	stored.CreatedAt = now.UTC()
+	a.EndsAt = a.EndsAt.UTC()
+	if err := m.recordChange(ctx, AuditCreate, AuditEntityBid, bid.ItemID, nil, &stored); err != nil {
+		return nil, err
+	}
+	m.nextBidID++
+	m.bids = append(m.bids, &stored)
+	m.auctions[bid.ItemID] = a
+	item.Price = bid.Amount
+	item.Version++
+	item.UpdatedAt = stored.CreatedAt
+	m.items[bid.ItemID] = item
+	*bid = stored
+	return &a, nil
+}
+
+// ListBids returns up to limit bids on an item, newest first.
+func (m memoryAuctionRepository) ListBids(ctx context.Context, itemID, limit int) ([]*Bid, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list bids: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var bids []*Bid
+	for i := len(m.bids) - 1; i >= 0 && len(bids) < limit; i-- {
+		if b := *m.bids[i]; b.ItemID == itemID {
+			bids = append(bids, &b)
+		}
+	}
+	return bids, nil
+}
+
+// EndedAuctions returns the items whose auction ended at now but has not been closed.
+func (m memoryAuctionRepository) EndedAuctions(ctx context.Context, now time.Time, limit int) ([]int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list ended auctions: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var ended []Auction
+	for _, a := range m.auctions {
+		if a.ClosedAt == nil && !a.EndsAt.After(now) {
+			ended = append(ended, a)
+		}
+	}
+	slices.SortFunc(ended, func(a, b Auction) int {
+		return cmp.Or(a.EndsAt.Compare(b.EndsAt), cmp.Compare(a.ItemID, b.ItemID))
+	})
+	var ids []int
+	for _, a := range ended[:min(limit, len(ended))] {
+		ids = append(ids, a.ItemID)
+	}
+	return ids, nil
+}
+
+// CloseAuction closes an ended auction and sells the item to the highest bidder.
+func (m memoryAuctionRepository) CloseAuction(ctx context.Context, itemID int, now time.Time) (*Order, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to close auction: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a, ok := m.auctions[itemID]
+	if !ok {
+		return nil, ErrAuctionNotFound
+	}
+	if a.ClosedAt != nil || a.EndsAt.After(now) {
+		return nil, nil
+	}
+	now = now.UTC()
+	a.ClosedAt = &now
+	item := m.items[itemID]
+	if a.BidCount == 0 || item.DeletedAt != nil {
+		m.auctions[itemID] = a
+		return nil, nil
+	}
+
+	before := m.withCategory(item)
+	item.SoldAt = &now
+	item.Version++
+	item.UpdatedAt = now
+	after := m.withCategory(item)
+	after.Auction = &a
+	if err := m.recordChange(ctx, AuditSell, AuditEntityItem, itemID, before, after); err != nil {
+		return nil, err
+	}
+	order := Order{ID: m.nextOrderID, ItemID: itemID, SellerID: item.SellerID, BuyerID: a.HighestBidderID, Price: a.HighestBid, Status: OrderPending, CreatedAt: now}
+	m.nextOrderID++
+	m.orders = append(m.orders, &order)
+	m.auctions[itemID] = a
+	m.items[itemID] = item
+	return &order, nil
+}
+
+// ListOrders returns the orders userID bought or sold, newest first.
+func (m memoryAuctionRepository) ListOrders(ctx context.Context, userID int) ([]*Order, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list orders: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var orders []*Order
+	for i := len(m.orders) - 1; i >= 0; i-- {
+		if o := *m.orders[i]; o.BuyerID == userID || o.SellerID == userID {
+			orders = append(orders, &o)
+		}
+	}
+	return orders, nil
+}
+
+// GetOrder returns an order.
+func (m memoryAuctionRepository) GetOrder(ctx context.Context, id int) (*Order, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get order: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	o := m.order(id)
+	if o == nil {
+		return nil, ErrOrderNotFound
+	}
+	order := *o
+	return &order, nil
+}
+
+// CompleteOrder marks a pending order completed at now.
+func (m memoryAuctionRepository) CompleteOrder(ctx context.Context, id int, now time.Time) (*Order, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to complete order: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o := m.order(id)
+	if o == nil {
+		return nil, ErrOrderNotFound
+	}
+	if o.Status != OrderPending {
+		return nil, ErrOrderNotPending
+	}
+	now = now.UTC()
+	after := *o
+	after.Status, after.CompletedAt = OrderCompleted, &now
+	if err := m.recordChange(ctx, AuditUpdate, AuditEntityOrder, id, o, &after); err != nil {
+		return nil, err
+	}
+	*o = after
+	return &after, nil
+}
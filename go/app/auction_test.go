@@ -0,0 +1,443 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeClock is an injectable clock for tests.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+func TestAuctionPlaceBid(t *testing.T) {
+	t.Parallel()
+
+	endsAt := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	cases := map[string]struct {
+		auction Auction
+		amount  int
+		now     time.Time
+		wantErr error
+		// wantEndsAt is the end of the auction after an accepted bid.
+		wantEndsAt time.Time
+	}{
+		"ok: first bid at the start price": {
+			auction:    Auction{StartPrice: 1000, MinIncrement: 100, EndsAt: endsAt},
+			amount:     1000,
+			now:        endsAt.Add(-time.Hour),
+			wantEndsAt: endsAt,
+		},
+		"ok: raise by the minimum increment": {
+			auction:    Auction{StartPrice: 1000, MinIncrement: 100, EndsAt: endsAt, HighestBid: 1500, BidCount: 3},
+			amount:     1600,
+			now:        endsAt.Add(-time.Hour),
+			wantEndsAt: endsAt,
+		},
+		"ok: late bids extend the auction": {
+			auction:    Auction{StartPrice: 1000, MinIncrement: 100, EndsAt: endsAt},
+			amount:     1000,
+			now:        endsAt.Add(-time.Second),
+			wantEndsAt: endsAt.Add(-time.Second).Add(AuctionExtensionWindow),
+		},
+		"ok: bids just outside the window do not extend it": {
+			auction:    Auction{StartPrice: 1000, MinIncrement: 100, EndsAt: endsAt},
+			amount:     1000,
+			now:        endsAt.Add(-AuctionExtensionWindow),
+			wantEndsAt: endsAt,
+		},
+		"ng: below the start price": {
+			auction: Auction{StartPrice: 1000, MinIncrement: 100, EndsAt: endsAt},
+			amount:  999,
+			now:     endsAt.Add(-time.Hour),
+			wantErr: ErrBidTooLow,
+		},
+		"ng: below the minimum increment": {
+			auction: Auction{StartPrice: 1000, MinIncrement: 100, EndsAt: endsAt, HighestBid: 1500, BidCount: 3},
+			amount:  1599,
+			now:     endsAt.Add(-time.Hour),
+			wantErr: ErrBidTooLow,
+		},
+		"ng: at the end": {
+			auction: Auction{StartPrice: 1000, MinIncrement: 100, EndsAt: endsAt},
+			amount:  5000,
+			now:     endsAt,
+			wantErr: ErrAuctionEnded,
+		},
+		"ng: closed": {
+			auction: Auction{StartPrice: 1000, MinIncrement: 100, EndsAt: endsAt, ClosedAt: &endsAt},
+			amount:  5000,
+			now:     endsAt.Add(-time.Hour),
+			wantErr: ErrAuctionEnded,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			a := tc.auction
+			err := a.placeBid(&Bid{BidderID: 7, Amount: tc.amount}, tc.now)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if tc.wantErr != nil {
+				if diff := cmp.Diff(tc.auction, a); diff != "" {
+					t.Errorf("expected a rejected bid not to change the auction (-want +got):\n%s", diff)
+				}
+				return
+			}
+			if a.HighestBid != tc.amount || a.HighestBidderID != 7 || a.BidCount != tc.auction.BidCount+1 {
+				t.Errorf("expected the bid to be the highest, got %+v", a)
+			}
+			if !a.EndsAt.Equal(tc.wantEndsAt) {
+				t.Errorf("expected the auction to end at %v, got %v", tc.wantEndsAt, a.EndsAt)
+			}
+		})
+	}
+}
+
+func TestBidHandlers(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2026, 5, 1, 11, 0, 0, 0, time.UTC)
+	endsAt := start.Add(time.Hour)
+
+	type step struct {
+		method string
+		path   string
+		userID int
+		amount string
+		// at is the time of the request as an offset from start.
+		at     time.Duration
+		status int
+	}
+
+	cases := map[string][]step{
+		"ok: outbid and see the history": {
+			{http.MethodPost, "/items/1/bids", 2, "1000", 0, http.StatusCreated},
+			{http.MethodPost, "/items/1/bids", 3, "1100", time.Minute, http.StatusCreated},
+			{http.MethodGet, "/items/1/bids", 0, "", time.Minute, http.StatusOK},
+		},
+		"ok: bids after the original end are accepted when extended": {
+			{http.MethodPost, "/items/1/bids", 2, "1000", time.Hour - time.Minute, http.StatusCreated},
+			{http.MethodPost, "/items/1/bids", 3, "1100", time.Hour + time.Minute, http.StatusCreated},
+		},
+		"ng: not signed in": {
+			{http.MethodPost, "/items/1/bids", 0, "1000", 0, http.StatusUnauthorized},
+		},
+		"ng: own item": {
+			{http.MethodPost, "/items/1/bids", 1, "1000", 0, http.StatusForbidden},
+		},
+		"ng: invalid amounts": {
+			{http.MethodPost, "/items/1/bids", 2, "", 0, http.StatusBadRequest},
+			{http.MethodPost, "/items/1/bids", 2, "-1", 0, http.StatusBadRequest},
+			{http.MethodPost, "/items/1/bids", 2, "999", 0, http.StatusUnprocessableEntity},
+		},
+		"ng: ended": {
+			{http.MethodPost, "/items/1/bids", 2, "1000", time.Hour, http.StatusUnprocessableEntity},
+		},
+		"ng: not an auction": {
+			{http.MethodPost, "/items/2/bids", 3, "1000", 0, http.StatusConflict},
+			{http.MethodGet, "/items/2/bids", 0, "", 0, http.StatusNotFound},
+		},
+		"ng: missing items": {
+			{http.MethodPost, "/items/99/bids", 2, "1000", 0, http.StatusNotFound},
+			{http.MethodGet, "/items/99/bids", 0, "", 0, http.StatusNotFound},
+			{http.MethodGet, "/items/abc/bids", 0, "", 0, http.StatusBadRequest},
+		},
+	}
+
+	for name, steps := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			repo := NewMemoryItemRepository()
+			auction := &Item{Name: "camera", Category: "camera", SellerID: 1, Price: 1000, ListingType: ListingAuction,
+				Auction: &Auction{StartPrice: 1000, MinIncrement: 100, EndsAt: endsAt}}
+			if err := repo.Insert(ctx, auction); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.Insert(ctx, &Item{Name: "tripod", Category: "camera", SellerID: 2, Price: 800}); err != nil {
+				t.Fatal(err)
+			}
+			clock := &fakeClock{now: start}
+			h := &Handlers{itemRepo: repo, auctions: NewMemoryAuctionRepository(repo), clock: clock.Now}
+			mux := http.NewServeMux()
+			mux.HandleFunc("POST /items/{id}/bids", h.PlaceBid)
+			mux.HandleFunc("GET /items/{id}/bids", h.GetBids)
+
+			for _, s := range steps {
+				clock.Set(start.Add(s.at))
+				form := url.Values{}
+				if s.amount != "" {
+					form.Set("amount", s.amount)
+				}
+				req := httptest.NewRequest(s.method, s.path, strings.NewReader(form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				if s.userID != 0 {
+					req = req.WithContext(ContextWithUserID(req.Context(), s.userID))
+				}
+				rr := httptest.NewRecorder()
+				mux.ServeHTTP(rr, req)
+				if rr.Code != s.status {
+					t.Fatalf("%s %s: expected status %d, got %d: %s", s.method, s.path, s.status, rr.Code, rr.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestGetBidsResponse(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	start := time.Date(2026, 5, 1, 11, 0, 0, 0, time.UTC)
+	repo := NewMemoryItemRepository()
+	item := &Item{Name: "camera", Category: "camera", SellerID: 1, ListingType: ListingAuction,
+		Auction: &Auction{StartPrice: 1000, MinIncrement: 100, EndsAt: start.Add(time.Hour)}}
+	if err := repo.Insert(ctx, item); err != nil {
+		t.Fatal(err)
+	}
+	auctions := NewMemoryAuctionRepository(repo)
+	for i, amount := range []int{1000, 1200} {
+		if _, err := auctions.PlaceBid(ctx, &Bid{ItemID: item.ID, BidderID: 2 + i, Amount: amount}, start.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := &Handlers{itemRepo: repo, auctions: auctions, clock: func() time.Time { return start }}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/{id}/bids", h.GetBids)
+	mux.HandleFunc("GET /items/{id}", h.GetItem)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/items/1/bids?limit=1", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body)
+	}
+	var resp struct {
+		Bids       []*Bid   `json:"bids"`
+		Auction    *Auction `json:"auction"`
+		MinimumBid int      `json:"minimum_bid"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Bids) != 1 || resp.Bids[0].Amount != 1200 || resp.Bids[0].BidderID != 3 {
+		t.Errorf("expected only the newest bid, got %+v", resp.Bids)
+	}
+	if resp.Auction.BidCount != 2 || resp.MinimumBid != 1300 {
+		t.Errorf("unexpected auction %+v with minimum bid %d", resp.Auction, resp.MinimumBid)
+	}
+
+	// 商品の取得にもオークションの状態が付く
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/items/1", nil))
+	var got Item
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Price != 1200 || got.Auction == nil || got.Auction.HighestBidderID != 3 {
+		t.Errorf("expected the item with its auction, got %+v", got)
+	}
+}
+
+func TestAuctionCloseJob(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	start := time.Date(2026, 5, 1, 11, 0, 0, 0, time.UTC)
+	repo := NewMemoryItemRepository()
+	auctions := NewMemoryAuctionRepository(repo)
+	for i, endsAt := range []time.Time{start.Add(time.Hour), start.Add(2 * time.Hour)} {
+		item := &Item{Name: "camera", Category: "camera", SellerID: 1, ListingType: ListingAuction,
+			Auction: &Auction{StartPrice: 1000, MinIncrement: 100, EndsAt: endsAt}}
+		if err := repo.Insert(ctx, item); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := auctions.PlaceBid(ctx, &Bid{ItemID: item.ID, BidderID: 2 + i, Amount: 1000}, start); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 売れた商品はキャッシュからも消える
+	cache := NewCachingItemRepository(repo, CacheOptions{TTL: time.Hour})
+	if _, err := cache.Select(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	clock := &fakeClock{now: start.Add(time.Hour)}
+	job := auctionCloseJob(auctions, cache, clock.Now)
+	if err := job(ctx); err != nil {
+		t.Fatalf("failed to close auctions: %v", err)
+	}
+	// 同じ時刻にもう一度動いても注文は増えない
+	if err := job(ctx); err != nil {
+		t.Fatalf("failed to close auctions: %v", err)
+	}
+	if item, err := cache.Select(ctx, 1); err != nil || item.SoldAt == nil {
+		t.Errorf("expected the cached item to be sold, got %+v: %v", item, err)
+	}
+
+	h := &Handlers{itemRepo: repo, auctions: auctions}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /me/orders", h.GetMyOrders)
+	orders := func(userID int) []*Order {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/me/orders", nil)
+		req = req.WithContext(ContextWithUserID(req.Context(), userID))
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body)
+		}
+		var resp struct {
+			Orders []*Order `json:"orders"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Orders
+	}
+	if got := orders(2); len(got) != 1 || got[0].ItemID != 1 || got[0].Price != 1000 || got[0].Status != OrderPending {
+		t.Errorf("expected the winner of the ended auction to have an order, got %+v", got)
+	}
+	if got := orders(3); len(got) != 0 {
+		t.Errorf("expected the running auction not to be closed, got %+v", got)
+	}
+
+	clock.Set(start.Add(2 * time.Hour))
+	if err := job(ctx); err != nil {
+		t.Fatalf("failed to close auctions: %v", err)
+	}
+	if got := orders(1); len(got) != 2 {
+		t.Errorf("expected the seller to see both orders, got %+v", got)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/me/orders", nil)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 when not signed in, got %d", rr.Code)
+	}
+}
+
+func TestParseAuctionTerms(t *testing.T) {
+	t.Parallel()
+
+	endsAt := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	cases := map[string]struct {
+		form    url.Values
+		want    AddItemRequest
+		wantErr bool
+	}{
+		"ok: fixed price by default": {
+			form: url.Values{"price": {"500"}},
+			want: AddItemRequest{Price: 500},
+		},
+		"ok: auction": {
+			form: url.Values{"listing_type": {"auction"}, "start_price": {"1000"}, "min_increment": {"50"}, "ends_at": {"2026-05-01T21:00:00+09:00"}},
+			want: AddItemRequest{ListingType: ListingAuction, StartPrice: 1000, MinIncrement: 50, EndsAt: endsAt},
+		},
+		"ok: default min increment": {
+			form: url.Values{"listing_type": {"auction"}, "start_price": {"1000"}, "ends_at": {"2026-05-01T12:00:00Z"}},
+			want: AddItemRequest{ListingType: ListingAuction, StartPrice: 1000, MinIncrement: defaultMinIncrement, EndsAt: endsAt},
+		},
+		"ng: unknown listing type": {
+			form:    url.Values{"listing_type": {"lottery"}},
+			wantErr: true,
+		},
+		"ng: auction fields on a fixed price item": {
+			form:    url.Values{"price": {"500"}, "ends_at": {"2026-05-01T12:00:00Z"}},
+			wantErr: true,
+		},
+		"ng: price on an auction": {
+			form:    url.Values{"listing_type": {"auction"}, "price": {"500"}, "start_price": {"1000"}, "ends_at": {"2026-05-01T12:00:00Z"}},
+			wantErr: true,
+		},
+		"ng: missing start price": {
+			form:    url.Values{"listing_type": {"auction"}, "ends_at": {"2026-05-01T12:00:00Z"}},
+			wantErr: true,
+		},
+		"ng: missing end": {
+			form:    url.Values{"listing_type": {"auction"}, "start_price": {"1000"}},
+			wantErr: true,
+		},
+		"ng: invalid end": {
+			form:    url.Values{"listing_type": {"auction"}, "start_price": {"1000"}, "ends_at": {"tomorrow"}},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodPost, "/items", strings.NewReader(tc.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req := &AddItemRequest{}
+			req.Price, _ = parsePrice(r, "price")
+			err := parseAuctionTerms(r, req)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", req)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(&tc.want, req, cmp.Comparer(func(a, b time.Time) bool { return a.Equal(b) })); diff != "" {
+				t.Errorf("unexpected request (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCheckAuctionEnd(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	cases := map[string]struct {
+		endsAt  time.Time
+		wantErr bool
+	}{
+		"ok: tomorrow":      {endsAt: now.Add(24 * time.Hour)},
+		"ok: longest":       {endsAt: now.Add(maxAuctionDuration)},
+		"ng: now":           {endsAt: now, wantErr: true},
+		"ng: past":          {endsAt: now.Add(-time.Hour), wantErr: true},
+		"ng: too far ahead": {endsAt: now.Add(maxAuctionDuration + time.Second), wantErr: true},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := checkAuctionEnd(tc.endsAt, now); (err != nil) != tc.wantErr {
+				t.Errorf("expected error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,336 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Audit actions.
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	AuditPurge   = "purge"
+	// AuditSell is the sale of an item, e.g. to the winner of its auction.
+	AuditSell = "sell"
+)
+
+// Audited entities.
+const (
+	AuditEntityItem          = "item"
+	AuditEntityCategory      = "category"
+	AuditEntityComment       = "comment"
+	AuditEntityCommentReport = "comment_report"
+	AuditEntityLike          = "like"
+	AuditEntityBid           = "bid"
+	AuditEntityOffer         = "offer"
+	AuditEntityOrder         = "order"
+	AuditEntityReview        = "review"
+	AuditEntityProfile       = "profile"
+	AuditEntityWebhook       = "webhook"
+)
+
+// AuditEntry is an immutable record of one change made through ItemRepository.
+type AuditEntry struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	// ActorID is the signed-in user who made the change. Zero means anonymous or a background job.
+	ActorID  int    `json:"actor_id,omitempty"`
+	Action   string `json:"action"`
+	Entity   string `json:"entity"`
+	EntityID int    `json:"entity_id"`
+	// Before and After are JSON snapshots of the entity. Before is empty on create, After on purge.
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+	// Diff maps every changed top-level field to its old and new value.
+	Diff      map[string]AuditChange `json:"diff,omitempty"`
+	RequestID string                 `json:"request_id,omitempty"`
+}
+
+// AuditChange is the old and new JSON value of a field.
+type AuditChange struct {
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+// AuditQuery filters ItemRepository.ListAudit. Zero fields do not filter.
+type AuditQuery struct {
+	ActorID  int
+	Action   string
+	Entity   string
+	EntityID int
+	Since    time.Time
+	Until    time.Time
+	// BeforeID returns entries older than this ID, for paging.
+	BeforeID int64
+	// Limit caps the number of entries. It defaults to defaultAuditLimit.
+	Limit int
+}
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// limit returns q.Limit within (0, maxAuditLimit].
+func (q AuditQuery) limit() int {
+	if q.Limit <= 0 {
+		return defaultAuditLimit
+	}
+	return min(q.Limit, maxAuditLimit)
+}
+
+// newAuditEntry builds the entry for a change made with ctx. before and after must be nil or
+// snapshots of the entity; nil is recorded as an empty snapshot.
+func newAuditEntry(ctx context.Context, action, entity string, entityID int, before, after any) (*AuditEntry, error) {
+	e := &AuditEntry{
+		CreatedAt: time.Now().UTC(),
+		Action:    action,
+		Entity:    entity,
+		EntityID:  entityID,
+	}
+	e.ActorID, _ = userIDFromContext(ctx)
+	e.RequestID = requestIDFromContext(ctx)
+
+	var err error
+	if before != nil {
+		if e.Before, err = json.Marshal(before); err != nil {
+			return nil, fmt.Errorf("failed to encode audit snapshot: %w", err)
+		}
+	}
+	if after != nil {
+		if e.After, err = json.Marshal(after); err != nil {
+			return nil, fmt.Errorf("failed to encode audit snapshot: %w", err)
+		}
+	}
+	if e.Diff, err = auditDiff(e.Before, e.After); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// auditDiff compares the top-level fields of two JSON objects. Missing fields are null.
+func auditDiff(before, after json.RawMessage) (map[string]AuditChange, error) {
+	var b, a map[string]json.RawMessage
+	if len(before) > 0 {
+		if err := json.Unmarshal(before, &b); err != nil {
+			return nil, fmt.Errorf("failed to diff audit snapshots: %w", err)
+		}
+	}
+	if len(after) > 0 {
+		if err := json.Unmarshal(after, &a); err != nil {
+			return nil, fmt.Errorf("failed to diff audit snapshots: %w", err)
+		}
+	}
+
+	null := json.RawMessage("null")
+	diff := make(map[string]AuditChange)
+	for key, old := range b {
+		if v, ok := a[key]; !ok || !bytes.Equal(old, v) {
+			change := AuditChange{Before: old, After: null}
+			if ok {
+				change.After = v
+			}
+			diff[key] = change
+		}
+	}
+	for key, v := range a {
+		if _, ok := b[key]; !ok {
+			diff[key] = AuditChange{Before: null, After: v}
+		}
+	}
+	if len(diff) == 0 {
+		return nil, nil
+	}
+	return diff, nil
+}
+
+// querier is implemented by *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// nullJSON stores an empty snapshot as NULL.
+func nullJSON(v json.RawMessage) sql.NullString {
+	return sql.NullString{String: string(v), Valid: len(v) > 0}
+}
+
+// writeAudit appends an entry to audit_log. It is called with the transaction making the change,
+// so that the change and its entry are committed together. placeholder is the dialect's bind parameter.
+func writeAudit(ctx context.Context, tx querier, placeholder func(int) string, action, entity string, entityID int, before, after any) error {
+	e, err := newAuditEntry(ctx, action, entity, entityID, before, after)
+	if err != nil {
+		return err
+	}
+	var diff []byte
+	if e.Diff != nil {
+		if diff, err = json.Marshal(e.Diff); err != nil {
+			return fmt.Errorf("failed to encode audit diff: %w", err)
+		}
+	}
+
+	query := `INSERT INTO audit_log (created_at, actor_id, action, entity, entity_id, before_json, after_json, diff_json, request_id) VALUES (` + params(placeholder, 9) + `)`
+	_, err = tx.ExecContext(ctx, query, e.CreatedAt, nullInt(int64(e.ActorID)), e.Action, e.Entity, e.EntityID,
+		nullJSON(e.Before), nullJSON(e.After), nullJSON(diff), sql.NullString{String: e.RequestID, Valid: e.RequestID != ""})
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
+
+// listAudit returns the audit_log entries matching q, newest first.
+func listAudit(ctx context.Context, db querier, placeholder func(int) string, q AuditQuery) ([]*AuditEntry, error) {
+	var conds []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, cond+" "+placeholder(len(args)))
+	}
+	if q.ActorID != 0 {
+		add("actor_id =", q.ActorID)
+	}
+	if q.Action != "" {
+		add("action =", q.Action)
+	}
+	if q.Entity != "" {
+		add("entity =", q.Entity)
+	}
+	if q.EntityID != 0 {
+		add("entity_id =", q.EntityID)
+	}
+	// SQLite は時刻を文字列で比較するので UTC に揃える
+	if !q.Since.IsZero() {
+		add("created_at >=", q.Since.UTC())
+	}
+	if !q.Until.IsZero() {
+		add("created_at <", q.Until.UTC())
+	}
+	if q.BeforeID != 0 {
+		add("id <", q.BeforeID)
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+
+	query := `SELECT id, created_at, actor_id, action, entity, entity_id, before_json, after_json, diff_json, request_id
+        FROM audit_log ` + where + ` ORDER BY id DESC LIMIT ` + strconv.Itoa(q.limit())
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve audit log: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		var actorID sql.NullInt64
+		var before, after, diff, requestID sql.NullString
+		if err := rows.Scan(&e.ID, &e.CreatedAt, &actorID, &e.Action, &e.Entity, &e.EntityID, &before, &after, &diff, &requestID); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		e.ActorID = int(actorID.Int64)
+		e.RequestID = requestID.String
+		if before.Valid {
+			e.Before = json.RawMessage(before.String)
+		}
+		if after.Valid {
+			e.After = json.RawMessage(after.String)
+		}
+		if diff.Valid {
+			if err := json.Unmarshal([]byte(diff.String), &e.Diff); err != nil {
+				return nil, fmt.Errorf("failed to decode audit diff: %w", err)
+			}
+		}
+		entries = append(entries, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve audit log: %w", err)
+	}
+	return entries, nil
+}
+
+// GetAuditLog is a handler to return audit entries for GET /admin/audit .
+// Filters: actor_id, action, entity, entity_id, since, until (RFC 3339), before_id and limit.
+func (h *Handlers) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r.Context()) {
+		http.Error(w, "only admins can read the audit log", http.StatusForbidden)
+		return
+	}
+
+	q, err := parseAuditQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entries, err := h.itemRepo.ListAudit(r.Context(), q)
+	if err != nil {
+		http.Error(w, "failed to get audit log", http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []*AuditEntry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"entries": entries})
+}
+
+// parseAuditQuery reads the filters of GET /admin/audit .
+func parseAuditQuery(r *http.Request) (AuditQuery, error) {
+	v := r.URL.Query()
+	q := AuditQuery{Action: v.Get("action"), Entity: v.Get("entity")}
+
+	ints := []struct {
+		name string
+		dst  *int
+	}{
+		{"actor_id", &q.ActorID},
+		{"entity_id", &q.EntityID},
+		{"limit", &q.Limit},
+	}
+	for _, p := range ints {
+		if s := v.Get(p.name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				return q, fmt.Errorf("%s must be a non-negative integer", p.name)
+			}
+			*p.dst = n
+		}
+	}
+	if s := v.Get("before_id"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 0 {
+			return q, fmt.Errorf("before_id must be a non-negative integer")
+		}
+		q.BeforeID = n
+	}
+
+	times := []struct {
+		name string
+		dst  *time.Time
+	}{
+		{"since", &q.Since},
+		{"until", &q.Until},
+	}
+	for _, p := range times {
+		if s := v.Get(p.name); s != "" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return q, fmt.Errorf("%s must be an RFC 3339 time", p.name)
+			}
+			*p.dst = t
+		}
+	}
+	return q, nil
+}
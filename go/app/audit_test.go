@@ -0,0 +1,155 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGetAuditLog(t *testing.T) {
+	t.Parallel()
+
+	const admin = 1
+	repo := NewMemoryItemRepository()
+	ctx := ContextWithUserID(context.Background(), 2)
+	repo.Insert(ctx, &Item{Name: "jacket", Category: "fashion"})
+	repo.Insert(ctx, &Item{Name: "camera", Category: "electronics"})
+	h := &Handlers{itemRepo: repo, adminUserIDs: map[int]bool{admin: true}}
+
+	cases := map[string]struct {
+		query  string
+		userID int
+		status int
+		// want is the entity of each returned entry
+		want []string
+	}{
+		"ok: all entries newest first": {
+			userID: admin,
+			status: http.StatusOK,
+			want:   []string{"item", "category", "item", "category"},
+		},
+		"ok: filtered by entity and limited": {
+			query:  "?entity=item&actor_id=2&limit=1",
+			userID: admin,
+			status: http.StatusOK,
+			want:   []string{"item"},
+		},
+		"ok: no match": {
+			query:  "?since=2999-01-01T00:00:00Z",
+			userID: admin,
+			status: http.StatusOK,
+			want:   []string{},
+		},
+		"ng: bad filter": {
+			query:  "?since=yesterday",
+			userID: admin,
+			status: http.StatusBadRequest,
+		},
+		"ng: not an admin": {
+			userID: 2,
+			status: http.StatusForbidden,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodGet, "/admin/audit"+tt.query, nil)
+			req = req.WithContext(ContextWithUserID(req.Context(), tt.userID))
+			rr := httptest.NewRecorder()
+			h.GetAuditLog(rr, req)
+
+			if rr.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, rr.Code, rr.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Entries []AuditEntry `json:"entries"`
+			}
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			got := []string{}
+			for _, e := range resp.Entries {
+				got = append(got, e.Entity)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected entries (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAuditLogIsAppendOnly(t *testing.T) {
+	t.Parallel()
+
+	dsns := map[string]func(t *testing.T) string{
+		"sqlite": func(t *testing.T) string {
+			return filepath.Join(t.TempDir(), "test.sqlite3")
+		},
+		"postgres": func(t *testing.T) string {
+			return newPostgresTestSchema(t, postgresTestDSN(t))
+		},
+	}
+
+	for name, dsn := range dsns {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			db, repo, err := OpenDatabase(dsn(t))
+			if err != nil {
+				t.Fatalf("failed to open database: %v", err)
+			}
+			defer db.Close()
+			if err := repo.Insert(context.Background(), &Item{Name: "jacket", Category: "fashion"}); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := db.Exec(`UPDATE audit_log SET actor_id = 1`); err == nil {
+				t.Error("expected audit_log to reject updates")
+			}
+			if _, err := db.Exec(`DELETE FROM audit_log`); err == nil {
+				t.Error("expected audit_log to reject deletes")
+			}
+		})
+	}
+}
+
+func TestWithRequestID(t *testing.T) {
+	t.Parallel()
+
+	var got string
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = requestIDFromContext(r.Context())
+	}))
+
+	cases := map[string]struct {
+		header string
+		keep   bool
+	}{
+		"ok: client ID is kept":       {header: "abc-123", keep: true},
+		"ok: missing ID is generated": {header: ""},
+		"ok: invalid ID is replaced":  {header: "bad id\n"},
+	}
+	for name, tt := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(requestIDHeader, tt.header)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if got == "" || rr.Header().Get(requestIDHeader) != got {
+			t.Errorf("%s: expected the response to echo the request ID %q, got %q", name, got, rr.Header().Get(requestIDHeader))
+		}
+		if tt.keep != (got == tt.header) {
+			t.Errorf("%s: unexpected request ID %q for header %q", name, got, tt.header)
+		}
+	}
+}
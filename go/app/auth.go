@@ -0,0 +1,94 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers identifying the signed-in user. The API does not authenticate users itself: the front
+// proxy signs in the user, removes these headers from the client request and sets its own.
+const (
+	userIDHeader = "X-User-ID"
+	// userSignatureHeader proves that userIDHeader was set by the front proxy. It is signed like
+	// webhook deliveries, with the user ID as the body; see SignUserID.
+	userSignatureHeader = "X-User-Signature"
+)
+
+// userSignatureTolerance is how old a user signature may be, to limit replays.
+const userSignatureTolerance = 5 * time.Minute
+
+type contextKey int
+
+const (
+	userIDContextKey contextKey = iota
+	requestIDContextKey
+)
+
+// UserAuth decides whether userIDHeader is believed.
+type UserAuth struct {
+	// Secret is shared with the front proxy, which signs userIDHeader with it.
+	Secret string
+	// TrustHeader believes userIDHeader without a signature. Only for local development, or when
+	// nothing but a proxy which strips the client's headers can reach the server.
+	TrustHeader bool
+	// Now returns the current time. time.Now is used when nil.
+	Now func() time.Time
+}
+
+// SignUserID returns the userSignatureHeader value for userID signed at t, for front proxies
+// and tests.
+func SignUserID(secret string, userID int, t time.Time) string {
+	return SignWebhook(secret, t, []byte(strconv.Itoa(userID)))
+}
+
+// withUser stores the user identified by userIDHeader in the request context.
+// Requests without the header are treated as anonymous. With neither a secret nor TrustHeader
+// the header is ignored, since any client could send it.
+func withUser(next http.Handler, auth UserAuth) http.Handler {
+	now := auth.Now
+	if now == nil {
+		now = time.Now
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := r.Header.Get(userIDHeader)
+		if v == "" || (auth.Secret == "" && !auth.TrustHeader) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		userID, err := strconv.Atoi(v)
+		if err != nil || userID <= 0 {
+			http.Error(w, "invalid "+userIDHeader+" header", http.StatusUnauthorized)
+			return
+		}
+		if auth.Secret != "" {
+			sig := r.Header.Get(userSignatureHeader)
+			if err := VerifyWebhookSignature(auth.Secret, sig, []byte(v), userSignatureTolerance, now()); err != nil {
+				slog.Warn("rejected unsigned user", "user_id", userID, "error", err)
+				http.Error(w, "invalid "+userSignatureHeader+" header", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(ContextWithUserID(r.Context(), userID)))
+	})
+}
+
+// ContextWithUserID returns a copy of ctx carrying userID.
+// Changes made with the context are attributed to the user in the audit log.
+func ContextWithUserID(ctx context.Context, userID int) context.Context {
+	return context.WithValue(ctx, userIDContextKey, userID)
+}
+
+// userIDFromContext returns the signed-in user, or false for anonymous requests.
+func userIDFromContext(ctx context.Context) (int, bool) {
+	userID, ok := ctx.Value(userIDContextKey).(int)
+	return userID, ok
+}
+
+// isAdmin reports whether the signed-in user is one of the admins given by Server.AdminUserIDs.
+func (h *Handlers) isAdmin(ctx context.Context) bool {
+	userID, ok := userIDFromContext(ctx)
+	return ok && h.adminUserIDs[userID]
+}
@@ -0,0 +1,60 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWithUser(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	signed := UserAuth{Secret: "secret", Now: func() time.Time { return now }}
+
+	cases := map[string]struct {
+		auth      UserAuth
+		userID    string
+		signature string
+		status    int
+		// want is the user in the context, or zero for anonymous requests.
+		want int
+	}{
+		"ok: anonymous":                  {auth: signed, status: http.StatusOK},
+		"ok: signed":                     {auth: signed, userID: "7", signature: SignUserID("secret", 7, now), status: http.StatusOK, want: 7},
+		"ok: trusted header":             {auth: UserAuth{TrustHeader: true}, userID: "7", status: http.StatusOK, want: 7},
+		"ok: header ignored without key": {auth: UserAuth{}, userID: "7", status: http.StatusOK},
+		"ng: unsigned":                   {auth: signed, userID: "7", status: http.StatusUnauthorized},
+		"ng: signed for another user":    {auth: signed, userID: "7", signature: SignUserID("secret", 8, now), status: http.StatusUnauthorized},
+		"ng: wrong secret":               {auth: signed, userID: "7", signature: SignUserID("other", 7, now), status: http.StatusUnauthorized},
+		"ng: expired signature": {auth: signed, userID: "7", signature: SignUserID("secret", 7, now.Add(-userSignatureTolerance-time.Second)),
+			status: http.StatusUnauthorized},
+		"ng: invalid user id": {auth: UserAuth{TrustHeader: true}, userID: "abc", status: http.StatusUnauthorized},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got := 0
+			h := withUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ = userIDFromContext(r.Context())
+			}), tc.auth)
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.userID != "" {
+				req.Header.Set(userIDHeader, tc.userID)
+			}
+			if tc.signature != "" {
+				req.Header.Set(userSignatureHeader, tc.signature)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			if rr.Code != tc.status {
+				t.Fatalf("expected status %d, got %d: %s", tc.status, rr.Code, rr.Body)
+			}
+			if got != tc.want {
+				t.Errorf("expected user %d, got %d", tc.want, got)
+			}
+		})
+	}
+}
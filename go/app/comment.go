@@ -0,0 +1,250 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrCommentNotFound is returned by CommentRepository when no comment has the requested ID.
+var ErrCommentNotFound = errors.New("comment not found")
+
+const (
+	// maxCommentLength is the longest comment body in characters.
+	maxCommentLength = 1000
+	// maxReportReasonLength is the longest reason given with a report.
+	maxReportReasonLength = 500
+	defaultCommentLimit   = 50
+	maxCommentLimit       = 200
+)
+
+// Comment is a public question or answer on an item page.
+type Comment struct {
+	ID     int `json:"id"`
+	ItemID int `json:"item_id"`
+	// UserID is the user who posted the comment.
+	UserID    int       `json:"user_id"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+	// ReportCount is how many users reported the comment. Only the seller and admins see it.
+	ReportCount int `json:"report_count,omitempty"`
+}
+
+// CommentReport is a user's report of an inappropriate comment.
+type CommentReport struct {
+	CommentID  int       `json:"comment_id"`
+	ReporterID int       `json:"reporter_id"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// CommentRepository stores the comments on items and their reports. Items count their comments,
+// so it shares the database of the ItemRepository.
+type CommentRepository interface {
+	// AddComment stores c, setting its ID and CreatedAt. ErrItemNotFound is returned if the item
+	// is missing or deleted.
+	AddComment(ctx context.Context, c *Comment) error
+	// ListComments returns up to limit comments on an item posted after afterID (all when zero), oldest first.
+	ListComments(ctx context.Context, itemID, afterID, limit int) ([]*Comment, error)
+	// GetComment returns ErrCommentNotFound when there is no such comment.
+	GetComment(ctx context.Context, id int) (*Comment, error)
+	// DeleteComment removes a comment with its reports. ErrCommentNotFound is returned if it is missing.
+	DeleteComment(ctx context.Context, id int) error
+	// ReportComment records a report of a comment. A user reporting the same comment again has no effect.
+	ReportComment(ctx context.Context, report *CommentReport) error
+}
+
+// commentOnItem returns the comment {comment_id} of the item {id} in the path.
+// It writes an error and returns false when either is invalid or they do not match.
+func (h *Handlers) commentOnItem(w http.ResponseWriter, r *http.Request) (*Comment, bool) {
+	itemID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		return nil, false
+	}
+	commentID, err := strconv.Atoi(r.PathValue("comment_id"))
+	if err != nil {
+		http.Error(w, "comment_id must be an integer", http.StatusBadRequest)
+		return nil, false
+	}
+	c, err := h.comments.GetComment(r.Context(), commentID)
+	if errors.Is(err, ErrCommentNotFound) || (err == nil && c.ItemID != itemID) {
+		http.Error(w, "comment not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "failed to get comment", http.StatusInternalServerError)
+		return nil, false
+	}
+	return c, true
+}
+
+// AddComment is a handler to post a public comment on an item for POST /items/{id}/comments .
+// Form field: body.
+func (h *Handlers) AddComment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	itemID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		return
+	}
+	userID, signedIn := userIDFromContext(ctx)
+	if !signedIn {
+		http.Error(w, "sign in to comment", http.StatusUnauthorized)
+		return
+	}
+
+	body := strings.TrimSpace(r.FormValue("body"))
+	if body == "" {
+		http.Error(w, "body is required", http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(body) > maxCommentLength {
+		http.Error(w, fmt.Sprintf("body is too long (max %d chars)", maxCommentLength), http.StatusBadRequest)
+		return
+	}
+
+	c := &Comment{ItemID: itemID, UserID: userID, Body: body}
+	if err := h.comments.AddComment(ctx, c); err != nil {
+		if errors.Is(err, ErrItemNotFound) {
+			http.Error(w, "Item not found", http.StatusNotFound)
+			return
+		}
+		slog.Error("failed to add comment", "item_id", itemID, "error", err)
+		http.Error(w, "failed to add comment", http.StatusInternalServerError)
+		return
+	}
+	itemChanged(h.itemRepo, itemID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(c)
+}
+
+// GetComments is a handler to return the comments on an item, oldest first, for
+// GET /items/{id}/comments . The next page is read with after_id; limit defaults to 50.
+// Report counts are only shown to the seller and admins.
+func (h *Handlers) GetComments(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	itemID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		return
+	}
+	afterID := 0
+	if s := r.URL.Query().Get("after_id"); s != "" {
+		if afterID, err = strconv.Atoi(s); err != nil || afterID < 0 {
+			http.Error(w, "after_id must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+	}
+	limit := defaultCommentLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = min(limit, maxCommentLimit)
+	}
+
+	item, err := h.itemRepo.Select(ctx, itemID)
+	if err != nil {
+		if errors.Is(err, ErrItemNotFound) {
+			http.Error(w, "Item not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to get item", http.StatusInternalServerError)
+		return
+	}
+	comments, err := h.comments.ListComments(ctx, itemID, afterID, limit)
+	if err != nil {
+		http.Error(w, "failed to get comments", http.StatusInternalServerError)
+		return
+	}
+	if comments == nil {
+		comments = []*Comment{}
+	}
+	userID, signedIn := userIDFromContext(ctx)
+	if !h.isAdmin(ctx) && !(signedIn && userID == item.SellerID) {
+		for _, c := range comments {
+			c.ReportCount = 0
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"comments": comments})
+}
+
+// DeleteComment is a handler to delete a comment for DELETE /items/{id}/comments/{comment_id} .
+// The seller of the item, the author and admins can delete it.
+func (h *Handlers) DeleteComment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, signedIn := userIDFromContext(ctx)
+	if !signedIn {
+		http.Error(w, "sign in to delete comments", http.StatusUnauthorized)
+		return
+	}
+	c, ok := h.commentOnItem(w, r)
+	if !ok {
+		return
+	}
+	// 削除済みの商品でも出品者はコメントを消せるようにする
+	item, err := h.selectIncludingDeleted(ctx, c.ItemID)
+	if err != nil {
+		http.Error(w, "failed to get item", http.StatusInternalServerError)
+		return
+	}
+	if userID != c.UserID && userID != item.SellerID && !h.isAdmin(ctx) {
+		http.Error(w, "only the seller, the author and admins can delete this comment", http.StatusForbidden)
+		return
+	}
+
+	if err := h.comments.DeleteComment(ctx, c.ID); err != nil {
+		if errors.Is(err, ErrCommentNotFound) {
+			http.Error(w, "comment not found", http.StatusNotFound)
+			return
+		}
+		slog.Error("failed to delete comment", "comment_id", c.ID, "error", err)
+		http.Error(w, "failed to delete comment", http.StatusInternalServerError)
+		return
+	}
+	itemChanged(h.itemRepo, c.ItemID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ReportComment is a handler to report an inappropriate comment for
+// POST /items/{id}/comments/{comment_id}/report . Form field: reason (optional).
+func (h *Handlers) ReportComment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, signedIn := userIDFromContext(ctx)
+	if !signedIn {
+		http.Error(w, "sign in to report comments", http.StatusUnauthorized)
+		return
+	}
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if utf8.RuneCountInString(reason) > maxReportReasonLength {
+		http.Error(w, fmt.Sprintf("reason is too long (max %d chars)", maxReportReasonLength), http.StatusBadRequest)
+		return
+	}
+	c, ok := h.commentOnItem(w, r)
+	if !ok {
+		return
+	}
+
+	report := &CommentReport{CommentID: c.ID, ReporterID: userID, Reason: reason}
+	if err := h.comments.ReportComment(ctx, report); err != nil {
+		if errors.Is(err, ErrCommentNotFound) {
+			http.Error(w, "comment not found", http.StatusNotFound)
+			return
+		}
+		slog.Error("failed to report comment", "comment_id", c.ID, "error", err)
+		http.Error(w, "failed to report comment", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,304 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// sqlCommentRepository stores comments in SQLite or PostgreSQL. placeholder is the dialect's bind parameter.
+type sqlCommentRepository struct {
+	db          *sql.DB
+	placeholder func(int) string
+}
+
+// NewCommentRepository returns a CommentRepository using the database opened by OpenDatabase with dsn.
+func NewCommentRepository(db *sql.DB, dsn string) CommentRepository {
+	d, _ := parseDSN(dsn)
+	return &sqlCommentRepository{db: db, placeholder: d.placeholder()}
+}
+
+// commentColumns is the column list scanned by scanComment.
+const commentColumns = `cm.id, cm.item_id, cm.user_id, cm.body, cm.created_at,
+        (SELECT COUNT(*) FROM comment_reports r WHERE r.comment_id = cm.id) AS report_count`
+
+// scanComment scans a row selected with commentColumns.
+func scanComment(row rowScanner) (*Comment, error) {
+	var c Comment
+	if err := row.Scan(&c.ID, &c.ItemID, &c.UserID, &c.Body, &c.CreatedAt, &c.ReportCount); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// AddComment stores c on a visible item and records it in the audit log.
+func (r *sqlCommentRepository) AddComment(ctx context.Context, c *Comment) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists int
+	err = tx.QueryRowContext(ctx, `SELECT 1 FROM items WHERE id = `+r.placeholder(1)+` AND deleted_at IS NULL`, c.ItemID).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrItemNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add comment: %w", err)
+	}
+
+	now := time.Now().UTC()
+	query := `INSERT INTO comments (item_id, user_id, body, created_at) VALUES (` + params(r.placeholder, 4) + `) RETURNING id`
+	if err := tx.QueryRowContext(ctx, query, c.ItemID, c.UserID, c.Body, now).Scan(&c.ID); err != nil {
+		return fmt.Errorf("failed to add comment: %w", err)
+	}
+	c.CreatedAt = now
+	c.ReportCount = 0
+	if err := recordChange(ctx, tx, r.placeholder, AuditCreate, AuditEntityComment, c.ID, nil, c); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to add comment: %w", err)
+	}
+	return nil
+}
+
+// ListComments returns up to limit comments on an item after afterID, oldest first.
+func (r *sqlCommentRepository) ListComments(ctx context.Context, itemID, afterID, limit int) ([]*Comment, error) {
+	query := `SELECT ` + commentColumns + ` FROM comments cm
+        WHERE cm.item_id = ` + r.placeholder(1) + ` AND cm.id > ` + r.placeholder(2) + `
+        ORDER BY cm.id LIMIT ` + strconv.Itoa(limit)
+	rows, err := r.db.QueryContext(ctx, query, itemID, afterID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list comments: %w", err)
+	}
+	defer rows.Close()
+
+	var comments []*Comment
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan comment: %w", err)
+		}
+		comments = append(comments, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list comments: %w", err)
+	}
+	return comments, nil
+}
+
+// GetComment returns a comment by ID.
+func (r *sqlCommentRepository) GetComment(ctx context.Context, id int) (*Comment, error) {
+	return r.get(ctx, r.db, id)
+}
+
+// get returns a comment by ID. q may be a transaction.
+func (r *sqlCommentRepository) get(ctx context.Context, q querier, id int) (*Comment, error) {
+	query := `SELECT ` + commentColumns + ` FROM comments cm WHERE cm.id = ` + r.placeholder(1)
+	c, err := scanComment(q.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCommentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get comment: %w", err)
+	}
+	return c, nil
+}
+
+// DeleteComment removes a comment, its reports going with it by the foreign key, and records
+// it in the audit log.
+func (r *sqlCommentRepository) DeleteComment(ctx context.Context, id int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := r.get(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM comments WHERE id = `+r.placeholder(1), id); err != nil {
+		return fmt.Errorf("failed to delete comment: %w", err)
+	}
+	if err := recordChange(ctx, tx, r.placeholder, AuditDelete, AuditEntityComment, id, before, nil); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to delete comment: %w", err)
+	}
+	return nil
+}
+
+// ReportComment records a report unless the reporter already reported the comment.
+func (r *sqlCommentRepository) ReportComment(ctx context.Context, report *CommentReport) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := r.get(ctx, tx, report.CommentID); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	query := `INSERT INTO comment_reports (comment_id, reporter_id, reason, created_at) VALUES (` + params(r.placeholder, 4) + `)
+        ON CONFLICT (comment_id, reporter_id) DO NOTHING`
+	result, err := tx.ExecContext(ctx, query, report.CommentID, report.ReporterID, report.Reason, now)
+	if err != nil {
+		return fmt.Errorf("failed to report comment: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to report comment: %w", err)
+	} else if n == 0 {
+		return nil
+	}
+	report.CreatedAt = now
+	if err := recordChange(ctx, tx, r.placeholder, AuditCreate, AuditEntityCommentReport, report.CommentID, nil, report); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to report comment: %w", err)
+	}
+	return nil
+}
+
+// memoryCommentRepository keeps comments in the memory store of a memoryItemRepository, which
+// also counts them for its items and drops them with purged items.
+type memoryCommentRepository struct {
+	*memoryItemRepository
+}
+
+// NewMemoryCommentRepository returns the CommentRepository sharing the memory store of items,
+// which must be returned by NewMemoryItemRepository.
+func NewMemoryCommentRepository(items ItemRepository) CommentRepository {
+	return memoryCommentRepository{items.(*memoryItemRepository)}
+}
+
+// comment returns the comment with the given ID. m.mu must be held.
+func (m memoryCommentRepository) comment(id int) *Comment {
+	i, found := slices.BinarySearchFunc(m.comments, id, func(c *Comment, id int) int { return c.ID - id })
+	if !found {
+		return nil
+	}
+	return m.comments[i]
+}
+
+// withReports returns a copy of c carrying its report count. m.mu must be held.
+func (m memoryCommentRepository) withReports(c *Comment) *Comment {
+	copied := *c
+	copied.ReportCount = len(m.reports[c.ID])
+	return &copied
+}
+
+// AddComment stores a comment on an item.
+func (m memoryCommentRepository) AddComment(ctx context.Context, c *Comment) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to add comment: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if item, ok := m.items[c.ItemID]; !ok || item.DeletedAt != nil {
+		return ErrItemNotFound
+	}
+	stored := *c
+	stored.ID = m.nextCommentID
+	stored.CreatedAt = time.Now().UTC()
+	stored.ReportCount = 0
+	if err := m.recordChange(ctx, AuditCreate, AuditEntityComment, stored.ID, nil, stored); err != nil {
+		return err
+	}
+	m.nextCommentID++
+	m.comments = append(m.comments, &stored)
+	m.commentCounts[stored.ItemID]++
+	*c = stored
+	return nil
+}
+
+// ListComments returns a page of comments on an item.
+func (m memoryCommentRepository) ListComments(ctx context.Context, itemID, afterID, limit int) ([]*Comment, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list comments: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var comments []*Comment
+	for _, c := range m.comments {
+		if len(comments) == limit {
+			break
+		}
+		if c.ItemID == itemID && c.ID > afterID {
+			comments = append(comments, m.withReports(c))
+		}
+	}
+	return comments, nil
+}
+
+// GetComment returns a comment by ID.
+func (m memoryCommentRepository) GetComment(ctx context.Context, id int) (*Comment, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get comment: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	c := m.comment(id)
+	if c == nil {
+		return nil, ErrCommentNotFound
+	}
+	return m.withReports(c), nil
+}
+
+// DeleteComment removes a comment.
+func (m memoryCommentRepository) DeleteComment(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to delete comment: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.comment(id)
+	if c == nil {
+		return ErrCommentNotFound
+	}
+	if err := m.recordChange(ctx, AuditDelete, AuditEntityComment, id, m.withReports(c), nil); err != nil {
+		return err
+	}
+	m.removeComments(func(c *Comment) bool { return c.ID == id })
+	return nil
+}
+
+// ReportComment records a report of a comment.
+func (m memoryCommentRepository) ReportComment(ctx context.Context, report *CommentReport) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to report comment: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.comment(report.CommentID) == nil {
+		return ErrCommentNotFound
+	}
+	if _, ok := m.reports[report.CommentID][report.ReporterID]; ok {
+		return nil
+	}
+	stored := *report
+	stored.CreatedAt = time.Now().UTC()
+	if err := m.recordChange(ctx, AuditCreate, AuditEntityCommentReport, stored.CommentID, nil, stored); err != nil {
+		return err
+	}
+	if m.reports[stored.CommentID] == nil {
+		m.reports[stored.CommentID] = make(map[int]CommentReport)
+	}
+	m.reports[stored.CommentID][stored.ReporterID] = stored
+	*report = stored
+	return nil
+}
@@ -0,0 +1,211 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCommentHandlers(t *testing.T) {
+	t.Parallel()
+
+	const (
+		seller = 1
+		author = 2
+		other  = 3
+		admin  = 4
+	)
+	type step struct {
+		method string
+		path   string
+		form   url.Values
+		userID int
+		status int
+	}
+
+	cases := map[string][]step{
+		"ok: post, page and delete comments": {
+			{http.MethodPost, "/items/1/comments", url.Values{"body": {"is it waterproof?"}}, author, http.StatusCreated},
+			{http.MethodPost, "/items/1/comments", url.Values{"body": {"yes"}}, seller, http.StatusCreated},
+			{http.MethodGet, "/items/1/comments?after_id=1&limit=10", nil, 0, http.StatusOK},
+			{http.MethodDelete, "/items/1/comments/1", nil, author, http.StatusNoContent},
+			{http.MethodDelete, "/items/1/comments/2", nil, admin, http.StatusNoContent},
+			{http.MethodDelete, "/items/1/comments/2", nil, admin, http.StatusNotFound},
+		},
+		"ok: sellers delete comments on their items": {
+			{http.MethodPost, "/items/1/comments", url.Values{"body": {"rude"}}, author, http.StatusCreated},
+			{http.MethodPost, "/items/1/comments/1/report", url.Values{"reason": {"abusive"}}, other, http.StatusNoContent},
+			{http.MethodPost, "/items/1/comments/1/report", nil, other, http.StatusNoContent},
+			{http.MethodDelete, "/items/1/comments/1", nil, seller, http.StatusNoContent},
+			{http.MethodDelete, "/items/1/comments/1", nil, seller, http.StatusNotFound},
+		},
+		"ng: not signed in": {
+			{http.MethodPost, "/items/1/comments", url.Values{"body": {"hello"}}, 0, http.StatusUnauthorized},
+			{http.MethodDelete, "/items/1/comments/1", nil, 0, http.StatusUnauthorized},
+			{http.MethodPost, "/items/1/comments/1/report", nil, 0, http.StatusUnauthorized},
+		},
+		"ng: invalid comments": {
+			{http.MethodPost, "/items/1/comments", url.Values{"body": {" "}}, author, http.StatusBadRequest},
+			{http.MethodPost, "/items/1/comments", url.Values{"body": {strings.Repeat("あ", maxCommentLength+1)}}, author, http.StatusBadRequest},
+			{http.MethodPost, "/items/99/comments", url.Values{"body": {"hello"}}, author, http.StatusNotFound},
+			{http.MethodGet, "/items/99/comments", nil, 0, http.StatusNotFound},
+			{http.MethodGet, "/items/1/comments?limit=0", nil, 0, http.StatusBadRequest},
+			{http.MethodGet, "/items/1/comments?after_id=-1", nil, 0, http.StatusBadRequest},
+		},
+		"ng: only the seller, the author and admins delete": {
+			{http.MethodPost, "/items/1/comments", url.Values{"body": {"hello"}}, author, http.StatusCreated},
+			{http.MethodDelete, "/items/1/comments/1", nil, other, http.StatusForbidden},
+			{http.MethodDelete, "/items/2/comments/1", nil, seller, http.StatusNotFound},
+			{http.MethodPost, "/items/1/comments/1/report", url.Values{"reason": {strings.Repeat("a", maxReportReasonLength+1)}}, other, http.StatusBadRequest},
+			{http.MethodPost, "/items/1/comments/9/report", nil, other, http.StatusNotFound},
+		},
+	}
+
+	for name, steps := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, mux := newCommentServer(t, seller, admin)
+			for _, s := range steps {
+				req := httptest.NewRequest(s.method, s.path, strings.NewReader(s.form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				if s.userID != 0 {
+					req = req.WithContext(ContextWithUserID(req.Context(), s.userID))
+				}
+				rr := httptest.NewRecorder()
+				mux.ServeHTTP(rr, req)
+				if rr.Code != s.status {
+					t.Fatalf("%s %s: expected status %d, got %d: %s", s.method, s.path, s.status, rr.Code, rr.Body)
+				}
+			}
+		})
+	}
+}
+
+// newCommentServer returns handlers with two items listed by seller and a mux routing the comment endpoints.
+func newCommentServer(t *testing.T, seller, admin int) (*Handlers, *http.ServeMux) {
+	t.Helper()
+	repo := NewMemoryItemRepository()
+	ctx := ContextWithUserID(context.Background(), seller)
+	for _, name := range []string{"jacket", "coat"} {
+		if err := repo.Insert(ctx, &Item{Name: name, Category: "fashion", SellerID: seller}); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+	}
+	h := &Handlers{itemRepo: repo, comments: NewMemoryCommentRepository(repo), adminUserIDs: map[int]bool{admin: true}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/{id}", h.GetItem)
+	mux.HandleFunc("POST /items/{id}/comments", h.AddComment)
+	mux.HandleFunc("GET /items/{id}/comments", h.GetComments)
+	mux.HandleFunc("DELETE /items/{id}/comments/{comment_id}", h.DeleteComment)
+	mux.HandleFunc("POST /items/{id}/comments/{comment_id}/report", h.ReportComment)
+	return h, mux
+}
+
+func TestGetCommentsReportCounts(t *testing.T) {
+	t.Parallel()
+
+	const seller, author, admin = 1, 2, 3
+	h, mux := newCommentServer(t, seller, admin)
+	ctx := context.Background()
+	c := &Comment{ItemID: 1, UserID: author, Body: "spam"}
+	if err := h.comments.AddComment(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.comments.ReportComment(ctx, &CommentReport{CommentID: c.ID, ReporterID: 9}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]struct {
+		userID int
+		want   int
+	}{
+		"ok: seller sees reports":    {seller, 1},
+		"ok: admin sees reports":     {admin, 1},
+		"ok: others do not":          {author, 0},
+		"ok: anonymous users do not": {0, 0},
+	}
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			req := httptest.NewRequest(http.MethodGet, "/items/1/comments", nil)
+			if tt.userID != 0 {
+				req = req.WithContext(ContextWithUserID(req.Context(), tt.userID))
+			}
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+			var resp struct {
+				Comments []*Comment `json:"comments"`
+			}
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if len(resp.Comments) != 1 || resp.Comments[0].ReportCount != tt.want {
+				t.Errorf("expected %d reports, got %+v", tt.want, resp.Comments)
+			}
+		})
+	}
+}
+
+func TestGetItemCommentCount(t *testing.T) {
+	t.Parallel()
+
+	const seller = 1
+	h, mux := newCommentServer(t, seller, 0)
+	get := func(etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/items/1", nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	first := get("")
+	etag := first.Header().Get("ETag")
+	if err := h.comments.AddComment(context.Background(), &Comment{ItemID: 1, UserID: 2, Body: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// コメントが増えたら古い ETag では 304 にならない
+	rr := get(etag)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d after a new comment, got %d", http.StatusOK, rr.Code)
+	}
+	var item Item
+	if err := json.NewDecoder(rr.Body).Decode(&item); err != nil {
+		t.Fatal(err)
+	}
+	if item.CommentCount != 1 {
+		t.Errorf("expected comment_count 1, got %d", item.CommentCount)
+	}
+}
+
+func TestUpdateItemAfterComment(t *testing.T) {
+	t.Parallel()
+
+	const seller = 1
+	h, mux := newCommentServer(t, seller, 0)
+	mux.HandleFunc("PATCH /items/{id}", h.UpdateItem)
+	get := httptest.NewRecorder()
+	mux.ServeHTTP(get, httptest.NewRequest(http.MethodGet, "/items/1", nil))
+	if err := h.comments.AddComment(context.Background(), &Comment{ItemID: 1, UserID: 2, Body: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 他のユーザーのコメントでは商品は変わっていないので、出品者の編集は失敗しない
+	req := httptest.NewRequest(http.MethodPatch, "/items/1", strings.NewReader("name=coat"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("If-Match", get.Header().Get("ETag"))
+	req = req.WithContext(ContextWithUserID(req.Context(), seller))
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+}
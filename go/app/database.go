@@ -0,0 +1,237 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	schema "mercari-build-training/db"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// DefaultDatabaseDSN is the SQLite database used when no DSN is configured.
+const DefaultDatabaseDSN = "db/mercari.sqlite3"
+
+// dialect identifies the SQL database behind an ItemRepository.
+type dialect string
+
+const (
+	dialectSQLite   dialect = "sqlite3"
+	dialectPostgres dialect = "postgres"
+)
+
+// schemaFile is the file in db.Schema creating the tables for d.
+func (d dialect) schemaFile() string {
+	if d == dialectPostgres {
+		return "items.postgres.sql"
+	}
+	return "items.sql"
+}
+
+// columnMigrations lists the columns added after a table was first released.
+// CREATE TABLE IF NOT EXISTS does not touch existing tables, so they are added here.
+// Definitions must be valid for every dialect.
+var columnMigrations = []struct{ table, column, definition string }{
+	{"items", "seller_id", "INTEGER"},
+	{"items", "image_hash", "BIGINT"},
+	{"items", "version", "INTEGER NOT NULL DEFAULT 1"},
+	{"items", "updated_at", "TIMESTAMP"},
+	{"items", "deleted_at", "TIMESTAMP"},
+	{"items", "like_count", "INTEGER NOT NULL DEFAULT 0"},
+	{"items", "price", "INTEGER NOT NULL DEFAULT 0"},
+	{"items", "listing_type", "TEXT NOT NULL DEFAULT 'fixed'"},
+	{"items", "sold_at", "TIMESTAMP"},
+	{"notifications", "params", "TEXT"},
+	{"orders", "completed_at", "TIMESTAMP"},
+}
+
+// sqlitePragmas are applied to every SQLite connection through go-sqlite3 DSN parameters.
+// WAL lets readers run alongside a writer, and busy_timeout makes writers wait for the lock
+// instead of failing with SQLITE_BUSY. Transactions take the write lock when they begin, so
+// one which reads before writing cannot fail to upgrade its lock.
+// Parameters already present in the DSN take precedence.
+var sqlitePragmas = []struct{ key, value string }{
+	{"_journal_mode", "WAL"},
+	{"_foreign_keys", "on"},
+	{"_busy_timeout", "5000"},
+	{"_txlock", "immediate"},
+}
+
+// placeholder returns the bind parameter function of d.
+func (d dialect) placeholder() func(int) string {
+	if d == dialectPostgres {
+		return postgresPlaceholder
+	}
+	return sqlitePlaceholder
+}
+
+// parseDSN splits a database DSN into its dialect and the data source name passed to the driver.
+// postgres:// and postgresql:// URLs select PostgreSQL; sqlite3://path or a plain path selects SQLite.
+func parseDSN(dsn string) (dialect, string) {
+	switch {
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		return dialectPostgres, dsn
+	case strings.HasPrefix(dsn, "sqlite3://"):
+		return dialectSQLite, strings.TrimPrefix(dsn, "sqlite3://")
+	default:
+		return dialectSQLite, dsn
+	}
+}
+
+// sqliteSource adds sqlitePragmas to a SQLite data source name.
+func sqliteSource(source string) string {
+	path, rawQuery, _ := strings.Cut(source, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		// go-sqlite3 が解釈できないものはそのまま渡してエラーにさせる
+		return source
+	}
+	for _, p := range sqlitePragmas {
+		if !query.Has(p.key) {
+			query.Set(p.key, p.value)
+		}
+	}
+	return path + "?" + query.Encode()
+}
+
+// checkSQLiteDir reports a clear error when the directory of a SQLite database file is
+// missing or not writable. SQLite itself only says "unable to open database file".
+func checkSQLiteDir(source string) error {
+	path, _, _ := strings.Cut(source, "?")
+	path = strings.TrimPrefix(path, "file:")
+	if path == "" || path == ":memory:" {
+		return nil
+	}
+	return checkWritableDir("database", filepath.Dir(path))
+}
+
+// checkWritableDir reports a clear error when dir is missing, is not a directory or cannot be
+// written to. what names the directory in the error.
+func checkWritableDir(what, dir string) error {
+	info, err := os.Stat(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		abs, _ := filepath.Abs(dir)
+		return fmt.Errorf("%s directory %s does not exist; create it or point the server at another path", what, abs)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check %s directory: %w", what, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s directory %s is not a directory", what, dir)
+	}
+
+	// 権限は実際に書き込んでみないと分からない (SQLite の WAL も -wal / -shm ファイルを作る)
+	f, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		abs, _ := filepath.Abs(dir)
+		return fmt.Errorf("%s directory %s is not writable: %w", what, abs, err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return nil
+}
+
+// OpenDatabase opens the database named by dsn, creates or migrates its tables using the
+// embedded schema and returns the ItemRepository implementation for its dialect.
+// SQLite databases are opened with sqlitePragmas.
+func OpenDatabase(dsn string) (*sql.DB, ItemRepository, error) {
+	d, source := parseDSN(dsn)
+	if d == dialectSQLite {
+		if err := checkSQLiteDir(source); err != nil {
+			return nil, nil, err
+		}
+		source = sqliteSource(source)
+	}
+	db, err := sql.Open(string(d), source)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	if err := setupDatabase(db, d); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return db, newItemRepository(db, d), nil
+}
+
+// newItemRepository returns the ItemRepository implementation for d.
+func newItemRepository(db *sql.DB, d dialect) ItemRepository {
+	if d == dialectPostgres {
+		return NewPostgresItemRepository(db)
+	}
+	return NewItemRepository(db)
+}
+
+// items.sql を実行し、テーブルを作成する
+func SetupDatabase(db *sql.DB) error {
+	return setupDatabase(db, dialectSQLite)
+}
+
+// setupDatabase runs the embedded schema for d and applies columnMigrations.
+func setupDatabase(db *sql.DB, d dialect) error {
+	ddl, err := fs.ReadFile(schema.Schema, d.schemaFile())
+	if err != nil {
+		return fmt.Errorf("failed to read schema file: %w", err)
+	}
+	_, err = db.Exec(string(ddl))
+	if err != nil {
+		return fmt.Errorf("failed to execute schema: %w", err)
+	}
+	for _, c := range columnMigrations {
+		if err := addColumnIfMissing(db, d, c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+	slog.Info("Database setup complete", "dialect", d)
+	return nil
+}
+
+// addColumnIfMissing adds a column to a table created by an older schema.
+func addColumnIfMissing(db *sql.DB, d dialect, table, column, definition string) error {
+	if d == dialectPostgres {
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s", table, column, definition)); err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+		}
+		return nil
+	}
+
+	// SQLite には ADD COLUMN IF NOT EXISTS が無いので、既存のカラムを調べる
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, typ        string
+			dflt             sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return fmt.Errorf("failed to inspect table %s: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	rows.Close()
+
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+	return nil
+}
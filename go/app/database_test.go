@@ -0,0 +1,109 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSQLiteSource(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		source string
+		want   string
+	}{
+		"ok: pragmas are added": {
+			source: "db/mercari.sqlite3",
+			want:   "db/mercari.sqlite3?_busy_timeout=5000&_foreign_keys=on&_journal_mode=WAL&_txlock=immediate",
+		},
+		"ok: parameters in the DSN take precedence": {
+			source: "db/mercari.sqlite3?_busy_timeout=100&cache=shared",
+			want:   "db/mercari.sqlite3?_busy_timeout=100&_foreign_keys=on&_journal_mode=WAL&_txlock=immediate&cache=shared",
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := sqliteSource(tt.source); got != tt.want {
+				t.Errorf("sqliteSource(%q) = %q, want %q", tt.source, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenDatabase(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ok: pragmas are applied", func(t *testing.T) {
+		t.Parallel()
+
+		db, _, err := OpenDatabase(filepath.Join(t.TempDir(), "test.sqlite3"))
+		if err != nil {
+			t.Fatalf("failed to open database: %v", err)
+		}
+		defer db.Close()
+
+		var journalMode string
+		var foreignKeys, busyTimeout int
+		if err := db.QueryRow("PRAGMA journal_mode").Scan(&journalMode); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.QueryRow("PRAGMA foreign_keys").Scan(&foreignKeys); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.QueryRow("PRAGMA busy_timeout").Scan(&busyTimeout); err != nil {
+			t.Fatal(err)
+		}
+		if journalMode != "wal" || foreignKeys != 1 || busyTimeout != 5000 {
+			t.Errorf("journal_mode=%s foreign_keys=%d busy_timeout=%d, want wal 1 5000", journalMode, foreignKeys, busyTimeout)
+		}
+	})
+
+	t.Run("ng: database directory is missing", func(t *testing.T) {
+		t.Parallel()
+
+		_, _, err := OpenDatabase(filepath.Join(t.TempDir(), "missing", "test.sqlite3"))
+		if err == nil || !strings.Contains(err.Error(), "does not exist") {
+			t.Errorf("expected a missing directory error, got %v", err)
+		}
+	})
+}
+
+func TestCheckWritableDir(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]struct {
+		dir  string
+		want string
+	}{
+		"ok: writable directory": {dir: dir},
+		"ng: missing directory":  {dir: filepath.Join(dir, "missing"), want: "image directory"},
+		"ng: file":               {dir: file, want: "is not a directory"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := checkWritableDir("image", tc.dir)
+			if tc.want == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("expected an error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// itemETag returns a strong ETag which changes whenever the item is updated, commented on or liked.
+// The counts are only appended when non-zero, so other ETags stay as they were.
+func itemETag(item *Item) string {
+	etag := fmt.Sprintf(`"item-%d-v%d`, item.ID, item.Version)
+	if item.CommentCount > 0 {
+		etag += fmt.Sprintf("-c%d", item.CommentCount)
+	}
+	if item.LikeCount > 0 {
+		etag += fmt.Sprintf("-l%d", item.LikeCount)
+	}
+	return etag + `"`
+}
+
+// itemVersionETag returns the validator writes to an item are conditional on. Unlike itemETag it
+// leaves out the counts, which other users change without editing the item.
+func itemVersionETag(item *Item) string {
+	return fmt.Sprintf(`"item-%d-v%d"`, item.ID, item.Version)
+}
+
+// versionOfItemETag reduces an ETag returned by itemETag to the validator of itemVersionETag.
+// Other values are returned as they are.
+func versionOfItemETag(etag string) string {
+	rest, ok := strings.CutPrefix(etag, `"item-`)
+	if !ok || !strings.HasSuffix(rest, `"`) {
+		return etag
+	}
+	i := strings.Index(rest, "-v")
+	if i < 0 {
+		return etag
+	}
+	j := i + len("-v")
+	for j < len(rest) && '0' <= rest[j] && rest[j] <= '9' {
+		j++
+	}
+	return `"item-` + rest[:j] + `"`
+}
+
+// itemsETag returns a strong ETag for a list of items, derived from each item's ID, version
+// and counts.
+func itemsETag(items []*Item) string {
+	h := sha256.New()
+	for _, item := range items {
+		fmt.Fprintf(h, "%d:%d:%d:%d;", item.ID, item.Version, item.CommentCount, item.LikeCount)
+	}
+	return `"items-` + hex.EncodeToString(h.Sum(nil))[:32] + `"`
+}
+
+// etagMatches reports whether etag is listed in an If-Match / If-None-Match header value.
+// If-None-Match uses the weak comparison (W/ prefixes ignored), If-Match the strong one.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		} else if candidate == etag && !strings.HasPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeNotModified sets the ETag and answers 304 when the client already has the representation.
+// It returns true when the response has been written.
+func writeNotModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// ifMatchVersion returns the version a write must be conditional on: the version of item,
+// checked with checkIfMatch, when If-Match was sent and zero otherwise.
+func ifMatchVersion(r *http.Request, item *Item) int {
+	if r.Header.Get("If-Match") == "" {
+		return 0
+	}
+	return item.Version
+}
+
+// requireIfMatch answers 428 when the request has no If-Match header, so that writes cannot
+// silently overwrite changes the client has not seen. It returns false when the response has been written.
+func requireIfMatch(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("If-Match") != "" {
+		return true
+	}
+	http.Error(w, "If-Match is required", http.StatusPreconditionRequired)
+	return false
+}
+
+// checkIfMatch answers 412 when the If-Match header does not match the current version of item.
+// Only the version of the ETags is compared, so that a comment or a like by another user does
+// not fail the seller's write. It returns false when the response has been written.
+func checkIfMatch(w http.ResponseWriter, r *http.Request, item *Item) bool {
+	im := r.Header.Get("If-Match")
+	if im == "" {
+		return true
+	}
+	validator := itemVersionETag(item)
+	for _, candidate := range strings.Split(im, ",") {
+		if etagMatches(versionOfItemETag(strings.TrimSpace(candidate)), validator, false) {
+			return true
+		}
+	}
+	w.Header().Set("ETag", itemETag(item))
+	http.Error(w, "item has been modified", http.StatusPreconditionFailed)
+	return false
+}
@@ -0,0 +1,384 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// EventSink receives domain events from EventDispatcher.
+// Delivery is at least once, so sinks may see the same event ID more than once.
+type EventSink interface {
+	// Name identifies the sink in logs.
+	Name() string
+	// Deliver sends e. A returned error makes the dispatcher retry it later.
+	Deliver(ctx context.Context, e *Event) error
+}
+
+// WebhookSink POSTs every event as JSON to URL. Responses other than 2xx are failures.
+type WebhookSink struct {
+	URL string
+	// Client sends the requests. http.DefaultClient is used when nil.
+	Client *http.Client
+}
+
+// Name returns the webhook URL.
+func (s *WebhookSink) Name() string {
+	return "webhook " + s.URL
+}
+
+// Deliver posts e to the webhook.
+func (s *WebhookSink) Deliver(ctx context.Context, e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-ID", fmt.Sprint(e.ID))
+	req.Header.Set("X-Event-Type", e.Type)
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post event: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// FileSink appends every event as a line of JSON (NDJSON) to a file.
+type FileSink struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileSink returns a sink appending to path, which is created when missing.
+func NewFileSink(path string) *FileSink {
+	return &FileSink{path: path}
+}
+
+// Name returns the file path.
+func (s *FileSink) Name() string {
+	return "file " + s.path
+}
+
+// Deliver appends e to the file.
+func (s *FileSink) Deliver(ctx context.Context, e *Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 1 行ずつ開き直すので、ログローテーションでファイルが移動しても追従できる
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open event file: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write event: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write event: %w", err)
+	}
+	return nil
+}
+
+// EventBus is an in-process sink fanning events out to subscribers. It keeps the latest events
+// so that subscribers can resume after a reconnect. Subscribers that fall behind are
+// unsubscribed rather than holding up the sender. Every server feeds its own bus with an
+// EventTailer, since EventDispatcher delivers each event on one server only.
+type EventBus struct {
+	mu      sync.Mutex
+	subs    map[chan *Event]struct{}
+	history []*Event
+	size    int
+	// closed is set by Close; later subscribers get a closed channel.
+	closed bool
+}
+
+// NewEventBus returns a bus without subscribers, remembering up to history events.
+func NewEventBus(history int) *EventBus {
+	return &EventBus{subs: make(map[chan *Event]struct{}), size: history}
+}
+
+// Name returns "bus".
+func (b *EventBus) Name() string {
+	return "bus"
+}
+
+// Subscribe returns the remembered events with IDs after afterID, and a channel receiving the
+// events delivered from now on, buffering up to buffer of them. The channel is closed when the
+// subscriber falls behind, cancel is called or the bus is closed.
+func (b *EventBus) Subscribe(afterID int64, buffer int) (replay []*Event, events <-chan *Event, cancel func()) {
+	ch := make(chan *Event, buffer)
+	b.mu.Lock()
+	for _, e := range b.history {
+		if e.ID > afterID {
+			copied := *e
+			replay = append(replay, &copied)
+		}
+	}
+	if b.closed {
+		close(ch)
+	} else {
+		b.subs[ch] = struct{}{}
+	}
+	b.mu.Unlock()
+
+	return replay, ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.unsubscribe(ch)
+	}
+}
+
+// Close closes the channels of every subscriber, so that long-lived streams end when the
+// server shuts down instead of holding it up.
+func (b *EventBus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for ch := range b.subs {
+		b.unsubscribe(ch)
+	}
+}
+
+// unsubscribe closes ch unless it is already closed. b.mu must be held.
+func (b *EventBus) unsubscribe(ch chan *Event) {
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// Deliver remembers e and sends it to every subscriber.
+func (b *EventBus) Deliver(ctx context.Context, e *Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// outbox から同じイベントが再送されることがある
+	if n := len(b.history); n > 0 && b.history[n-1].ID >= e.ID {
+		return nil
+	}
+	if b.size > 0 {
+		copied := *e
+		b.history = append(b.history, &copied)
+		if len(b.history) > b.size {
+			b.history = b.history[len(b.history)-b.size:]
+		}
+	}
+	for ch := range b.subs {
+		copied := *e
+		select {
+		case ch <- &copied:
+		default:
+			slog.Warn("event subscriber is falling behind; unsubscribed", "event_id", e.ID)
+			b.unsubscribe(ch)
+		}
+	}
+	return nil
+}
+
+// EventDispatcherOptions configures EventDispatcher. Zero fields use the defaults.
+type EventDispatcherOptions struct {
+	// BatchSize is the largest number of events delivered per poll. It defaults to 100.
+	BatchSize int
+	// RetryBase is the delay before the first retry, doubled on every further failure
+	// up to RetryMax. They default to one second and one hour.
+	RetryBase time.Duration
+	RetryMax  time.Duration
+	// Now returns the current time. time.Now is used when nil.
+	Now func() time.Time
+}
+
+// EventDispatcher delivers the outbox to its sinks. An event is marked delivered once every
+// sink has accepted it; otherwise it is retried with exponential backoff, to all sinks.
+type EventDispatcher struct {
+	repo  ItemRepository
+	sinks []EventSink
+	opts  EventDispatcherOptions
+}
+
+// NewEventDispatcher returns a dispatcher reading repo's outbox.
+func NewEventDispatcher(repo ItemRepository, sinks []EventSink, opts EventDispatcherOptions) *EventDispatcher {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.RetryBase <= 0 {
+		opts.RetryBase = time.Second
+	}
+	if opts.RetryMax <= 0 {
+		opts.RetryMax = time.Hour
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &EventDispatcher{repo: repo, sinks: sinks, opts: opts}
+}
+
+// backoff returns the delay before retrying something which has failed attempts times before:
+// base doubled on every further failure, up to max.
+func backoff(base, max time.Duration, attempts int) time.Duration {
+	delay := base
+	for range attempts {
+		delay *= 2
+		if delay >= max {
+			return max
+		}
+	}
+	return delay
+}
+
+// DispatchOnce delivers the events due now and returns how many were delivered and failed.
+func (d *EventDispatcher) DispatchOnce(ctx context.Context) (delivered, failed int, err error) {
+	now := d.opts.Now()
+	events, err := d.repo.PendingEvents(ctx, now, d.opts.BatchSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, e := range events {
+		if err := d.deliver(ctx, e); err != nil {
+			next := now.Add(backoff(d.opts.RetryBase, d.opts.RetryMax, e.Attempts))
+			slog.Warn("failed to deliver event", "event_id", e.ID, "type", e.Type, "attempts", e.Attempts+1, "retry_at", next, "error", err)
+			if err := d.repo.MarkEventFailed(ctx, e.ID, next, err.Error()); err != nil {
+				return delivered, failed, err
+			}
+			failed++
+			continue
+		}
+		if err := d.repo.MarkEventDelivered(ctx, e.ID); err != nil {
+			return delivered, failed, err
+		}
+		delivered++
+	}
+	return delivered, failed, nil
+}
+
+// deliver sends e to every sink, returning the first failure.
+func (d *EventDispatcher) deliver(ctx context.Context, e *Event) error {
+	for _, s := range d.sinks {
+		if err := s.Deliver(ctx, e); err != nil {
+			return fmt.Errorf("%s: %w", s.Name(), err)
+		}
+	}
+	return nil
+}
+
+// EventTailerOptions configures EventTailer. Zero fields use the defaults.
+type EventTailerOptions struct {
+	// BatchSize is the largest number of events read per query. It defaults to 100.
+	BatchSize int
+	// GapTimeout is how long an event following a missing ID is held back. It defaults to five seconds.
+	GapTimeout time.Duration
+	// Now returns the current time. time.Now is used when nil.
+	Now func() time.Time
+}
+
+// EventTailer feeds a sink every event of the outbox in ID order, independently of
+// EventDispatcher and of whether the event was delivered. Each server runs its own, so that the
+// live feed of every server sees every event.
+//
+// IDs are taken when an event is written but become visible when its transaction commits, so
+// on PostgreSQL a lower ID can appear after a higher one. An event following a missing ID is
+// therefore held back until it is GapTimeout old; IDs still missing by then belong to rolled
+// back transactions or pruned events.
+type EventTailer struct {
+	repo ItemRepository
+	sink EventSink
+	opts EventTailerOptions
+	// lastID is the ID of the last event passed to sink.
+	lastID int64
+}
+
+// NewEventTailer returns a tailer reading repo's outbox from the oldest event it keeps.
+func NewEventTailer(repo ItemRepository, sink EventSink, opts EventTailerOptions) *EventTailer {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.GapTimeout <= 0 {
+		opts.GapTimeout = 5 * time.Second
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &EventTailer{repo: repo, sink: sink, opts: opts}
+}
+
+// TailOnce passes the events written since the last call to the sink and returns how many.
+// It is not safe for concurrent use.
+func (t *EventTailer) TailOnce(ctx context.Context) (int, error) {
+	n := 0
+	for {
+		events, err := t.repo.EventsAfter(ctx, t.lastID, t.opts.BatchSize)
+		if err != nil {
+			return n, err
+		}
+		now := t.opts.Now()
+		for _, e := range events {
+			if !tailReady(t.lastID, e.ID, e.CreatedAt, now, t.opts.GapTimeout) {
+				return n, nil
+			}
+			if err := t.sink.Deliver(ctx, e); err != nil {
+				return n, fmt.Errorf("%s: %w", t.sink.Name(), err)
+			}
+			t.lastID = e.ID
+			n++
+		}
+		if len(events) < t.opts.BatchSize {
+			return n, nil
+		}
+	}
+}
+
+// tailReady reports whether a tailer which has passed on lastID can pass on the row id written
+// at createdAt. A row following a missing ID waits until it is gapTimeout old, except for the
+// first row read, since those before it may have been pruned.
+func tailReady(lastID, id int64, createdAt, now time.Time, gapTimeout time.Duration) bool {
+	return lastID == 0 || id == lastID+1 || now.Sub(createdAt) >= gapTimeout
+}
+
+// EventPruneJob is the periodic job deleting delivered events from the outbox.
+const EventPruneJob JobType[struct{}] = "event_prune"
+
+const (
+	// DefaultEventRetention is how long delivered events are kept in the outbox.
+	DefaultEventRetention = 7 * 24 * time.Hour
+	// eventPruneInterval is how often EventPruneJob runs.
+	eventPruneInterval = time.Hour
+)
+
+// eventPruneJob returns the EventPruneJob handler deleting the events delivered retention before now.
+func eventPruneJob(repo ItemRepository, retention time.Duration, now func() time.Time) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		n, err := repo.PruneEvents(ctx, now().Add(-retention))
+		if err != nil {
+			return fmt.Errorf("failed to prune delivered events: %w", err)
+		}
+		if n > 0 {
+			slog.Info("delivered events pruned", "count", n)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,273 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/mock/gomock"
+)
+
+// recordingSink remembers the events it accepts and fails while err is set.
+type recordingSink struct {
+	err    error
+	events []string
+}
+
+func (s *recordingSink) Name() string { return "recording" }
+
+func (s *recordingSink) Deliver(ctx context.Context, e *Event) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.events = append(s.events, e.Type)
+	return nil
+}
+
+func TestEventDispatcher(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewMemoryItemRepository()
+	if err := repo.Insert(ctx, &Item{Name: "jacket", Category: "fashion"}); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	sink := &recordingSink{err: errors.New("unavailable")}
+	d := NewEventDispatcher(repo, []EventSink{sink}, EventDispatcherOptions{
+		RetryBase: time.Second,
+		RetryMax:  3 * time.Second,
+		Now:       func() time.Time { return now },
+	})
+
+	// 失敗するたびに 1s, 2s, 3s (上限) と間隔を空けて再送する
+	for _, wait := range []time.Duration{0, time.Second, 2 * time.Second, 3 * time.Second} {
+		now = now.Add(wait)
+		delivered, failed, err := d.DispatchOnce(ctx)
+		if err != nil {
+			t.Fatalf("DispatchOnce failed: %v", err)
+		}
+		if delivered != 0 || failed != 2 {
+			t.Fatalf("after %v: expected 2 failed events, got %d delivered and %d failed", wait, delivered, failed)
+		}
+		if _, failed, _ := d.DispatchOnce(ctx); failed != 0 {
+			t.Fatalf("after %v: expected no retry before the backoff, got %d", wait, failed)
+		}
+	}
+
+	sink.err = nil
+	now = now.Add(3 * time.Second)
+	delivered, failed, err := d.DispatchOnce(ctx)
+	if err != nil {
+		t.Fatalf("DispatchOnce failed: %v", err)
+	}
+	if delivered != 2 || failed != 0 {
+		t.Errorf("expected 2 delivered events, got %d delivered and %d failed", delivered, failed)
+	}
+	if diff := cmp.Diff([]string{EventCategoryCreated, EventItemCreated}, sink.events); diff != "" {
+		t.Errorf("unexpected delivered events (-want +got):\n%s", diff)
+	}
+	if delivered, _, _ := d.DispatchOnce(ctx); delivered != 0 {
+		t.Errorf("expected delivered events not to be sent again, got %d", delivered)
+	}
+}
+
+func TestEventTailer(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewMemoryItemRepository()
+	if err := repo.Insert(ctx, &Item{Name: "jacket", Category: "fashion"}); err != nil {
+		t.Fatal(err)
+	}
+	// 別のサーバーのディスパッチャーが配信済みにしても、各サーバーの tailer は全イベントを読む
+	if _, _, err := NewEventDispatcher(repo, nil, EventDispatcherOptions{}).DispatchOnce(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	servers := []*recordingSink{{}, {}}
+	tailers := []*EventTailer{}
+	for _, sink := range servers {
+		tailers = append(tailers, NewEventTailer(repo, sink, EventTailerOptions{BatchSize: 1}))
+	}
+	for _, tailer := range tailers {
+		if n, err := tailer.TailOnce(ctx); err != nil || n != 2 {
+			t.Fatalf("expected 2 events, got %d: %v", n, err)
+		}
+	}
+	if err := repo.Insert(ctx, &Item{Name: "coat", Category: "fashion"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tailer := range tailers {
+		if n, err := tailer.TailOnce(ctx); err != nil || n != 1 {
+			t.Fatalf("expected only the new event, got %d: %v", n, err)
+		}
+	}
+	for _, sink := range servers {
+		if diff := cmp.Diff([]string{EventCategoryCreated, EventItemCreated, EventItemCreated}, sink.events); diff != "" {
+			t.Errorf("unexpected events (-want +got):\n%s", diff)
+		}
+	}
+}
+
+func TestEventTailerWaitsForGaps(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Now()
+	// visible はコミット済みのイベント。ID 2 はまだコミットされていない
+	visible := []*Event{{ID: 1, CreatedAt: now}, {ID: 3, CreatedAt: now}}
+	ctrl := gomock.NewController(t)
+	repo := NewMockItemRepository(ctrl)
+	repo.EXPECT().EventsAfter(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, afterID int64, limit int) ([]*Event, error) {
+		var events []*Event
+		for _, e := range visible {
+			if e.ID > afterID && len(events) < limit {
+				events = append(events, e)
+			}
+		}
+		return events, nil
+	}).AnyTimes()
+
+	bus := NewEventBus(10)
+	tailer := NewEventTailer(repo, bus, EventTailerOptions{GapTimeout: time.Second, Now: func() time.Time { return now }})
+	tail := func(want ...int64) {
+		t.Helper()
+		before, _, cancel := bus.Subscribe(0, 1)
+		cancel()
+		if _, err := tailer.TailOnce(ctx); err != nil {
+			t.Fatalf("TailOnce failed: %v", err)
+		}
+		after, _, cancel := bus.Subscribe(0, 1)
+		cancel()
+		got := []int64{}
+		for _, e := range after[len(before):] {
+			got = append(got, e.ID)
+		}
+		if diff := cmp.Diff(append([]int64{}, want...), got); diff != "" {
+			t.Errorf("unexpected events (-want +got):\n%s", diff)
+		}
+	}
+
+	// 欠けた ID の後ろは待たせる
+	tail(1)
+	tail()
+	// 遅れてコミットされたイベントも ID 順に届く
+	visible = []*Event{visible[0], {ID: 2, CreatedAt: now.Add(-time.Millisecond)}, visible[1]}
+	tail(2, 3)
+	// ロールバックで欠けたままの ID は GapTimeout 後に飛ばす
+	visible = append(visible, &Event{ID: 5, CreatedAt: now})
+	tail()
+	now = now.Add(time.Second)
+	tail(5)
+}
+
+func TestFileSink(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	sink := NewFileSink(path)
+	for i, typ := range []string{EventItemCreated, EventItemUpdated} {
+		e := &Event{ID: int64(i + 1), Type: typ, Payload: json.RawMessage(`{"id":1}`)}
+		if err := sink.Deliver(context.Background(), e); err != nil {
+			t.Fatalf("Deliver failed: %v", err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var got []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		got = append(got, e.Type)
+	}
+	if diff := cmp.Diff([]string{EventItemCreated, EventItemUpdated}, got); diff != "" {
+		t.Errorf("unexpected events (-want +got):\n%s", diff)
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		status  int
+		wantErr bool
+	}{
+		"ok: accepted":     {status: http.StatusNoContent},
+		"ng: server error": {status: http.StatusServiceUnavailable, wantErr: true},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var got Event
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				json.Unmarshal(body, &got)
+				if r.Header.Get("X-Event-Type") != got.Type {
+					t.Errorf("expected X-Event-Type %q, got %q", got.Type, r.Header.Get("X-Event-Type"))
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			sink := &WebhookSink{URL: srv.URL, Client: srv.Client()}
+			err := sink.Deliver(context.Background(), &Event{ID: 3, Type: EventItemCreated, Payload: json.RawMessage(`{}`)})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if got.ID != 3 || got.Type != EventItemCreated {
+				t.Errorf("unexpected event received: %+v", got)
+			}
+		})
+	}
+}
+
+func TestEventBus(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	bus := NewEventBus(2)
+	for i := range 3 {
+		bus.Deliver(ctx, &Event{ID: int64(i + 1)})
+	}
+
+	// 覚えている直近 2 件のうち、指定した ID より後のものを返す
+	replay, events, cancel := bus.Subscribe(2, 1)
+	if len(replay) != 1 || replay[0].ID != 3 {
+		t.Errorf("expected event 3 to be replayed, got %v", replay)
+	}
+	// 再送されたイベントは配らない
+	bus.Deliver(ctx, &Event{ID: 3})
+	// バッファを超えると購読を打ち切り、配信は止まらない
+	for _, id := range []int64{4, 5} {
+		if err := bus.Deliver(ctx, &Event{ID: id}); err != nil {
+			t.Fatalf("Deliver failed: %v", err)
+		}
+	}
+	if e := <-events; e.ID != 4 {
+		t.Errorf("expected event 4, got %d", e.ID)
+	}
+	if _, ok := <-events; ok {
+		t.Error("expected a slow subscriber to be unsubscribed")
+	}
+	cancel()
+}
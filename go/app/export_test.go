@@ -0,0 +1,7 @@
+package app
+
+// Test helpers used by the tests in package app_test.
+var (
+	PostgresTestDSN       = postgresTestDSN
+	NewPostgresTestSchema = newPostgresTestSchema
+)
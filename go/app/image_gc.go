@@ -0,0 +1,111 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+const (
+	// defaultImageName is the placeholder served by GetImage. It is never collected.
+	defaultImageName = "default.jpg"
+	// DefaultImageGCGracePeriod is how old an unreferenced image must be before it is deleted.
+	DefaultImageGCGracePeriod = 24 * time.Hour
+)
+
+// storedImagePattern matches the file names produced by storeImage.
+var storedImagePattern = regexp.MustCompile(`^[0-9a-f]{64}\.(jpg|png)$`)
+
+// ImageGCOptions configures CollectImageGarbage.
+type ImageGCOptions struct {
+	// GracePeriod protects recently written files whose item may not be inserted yet.
+	GracePeriod time.Duration
+	// DryRun reports what would be deleted without deleting anything.
+	DryRun bool
+	// Now returns the current time. time.Now is used when nil.
+	Now func() time.Time
+}
+
+// ImageGCResult is the outcome of a CollectImageGarbage run.
+type ImageGCResult struct {
+	// Removed lists the unreferenced files that were (or, in dry-run mode, would be) deleted.
+	Removed []string
+	// Referenced is the number of files still used by at least one item.
+	Referenced int
+	// Skipped is the number of unreferenced files kept because they are within the grace period.
+	Skipped int
+}
+
+// CollectImageGarbage deletes image files in dir which no item or avatar references.
+// Only files named by storeImage are considered, so default.jpg and anything else are left untouched.
+func CollectImageGarbage(ctx context.Context, repo ItemRepository, dir string, opts ImageGCOptions) (*ImageGCResult, error) {
+	now := time.Now
+	if opts.Now != nil {
+		now = opts.Now
+	}
+
+	// 参照を取得する前のファイル一覧を使うことで、取得後に保存された画像を消さない
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image dir: %w", err)
+	}
+	refs, err := repo.ImageReferences(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ImageGCResult{}
+	cutoff := now().Add(-opts.GracePeriod)
+	for _, e := range entries {
+		name := e.Name()
+		if name == defaultImageName || !e.Type().IsRegular() || !storedImagePattern.MatchString(name) {
+			continue
+		}
+		if refs[name] > 0 {
+			result.Referenced++
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			// 一覧取得後に削除されたファイルは無視する
+			if os.IsNotExist(err) {
+				continue
+			}
+			return result, fmt.Errorf("failed to stat image: %w", err)
+		}
+		if info.ModTime().After(cutoff) {
+			result.Skipped++
+			continue
+		}
+
+		if !opts.DryRun {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+				return result, fmt.Errorf("failed to remove image: %w", err)
+			}
+		}
+		result.Removed = append(result.Removed, name)
+	}
+	return result, nil
+}
+
+// ImageGCJob is the periodic job deleting unreferenced images.
+const ImageGCJob JobType[struct{}] = "image_gc"
+
+// imageGCJob returns the ImageGCJob handler collecting the garbage in dir.
+func imageGCJob(repo ItemRepository, dir string, gracePeriod time.Duration) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		result, err := CollectImageGarbage(ctx, repo, dir, ImageGCOptions{GracePeriod: gracePeriod})
+		if err != nil {
+			return fmt.Errorf("failed to collect unreferenced images: %w", err)
+		}
+		if len(result.Removed) > 0 {
+			slog.Info("unreferenced images removed", "count", len(result.Removed))
+		}
+		return nil
+	}
+}
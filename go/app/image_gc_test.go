@@ -0,0 +1,103 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/mock/gomock"
+)
+
+func TestCollectImageGarbage(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)
+	old := now.Add(-48 * time.Hour)
+
+	referenced := strings.Repeat("a", 64) + ".jpg"
+	orphan := strings.Repeat("b", 64) + ".jpg"
+	fresh := strings.Repeat("c", 64) + ".png"
+
+	type wants struct {
+		removed   []string
+		remaining []string
+	}
+	cases := map[string]struct {
+		dryRun bool
+		wants
+	}{
+		"ok: removes old unreferenced images": {
+			wants: wants{
+				removed:   []string{orphan},
+				remaining: []string{referenced, fresh, defaultImageName, "notes.txt"},
+			},
+		},
+		"ok: dry run keeps everything": {
+			dryRun: true,
+			wants: wants{
+				removed:   []string{orphan},
+				remaining: []string{referenced, orphan, fresh, defaultImageName, "notes.txt"},
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			files := map[string]time.Time{
+				referenced:       old,
+				orphan:           old,
+				fresh:            now.Add(-time.Hour),
+				defaultImageName: old,
+				"notes.txt":      old,
+			}
+			for f, mtime := range files {
+				path := filepath.Join(dir, f)
+				if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+					t.Fatalf("failed to write file: %v", err)
+				}
+				if err := os.Chtimes(path, mtime, mtime); err != nil {
+					t.Fatalf("failed to set mtime: %v", err)
+				}
+			}
+
+			ctrl := gomock.NewController(t)
+			mockRepo := NewMockItemRepository(ctrl)
+			mockRepo.EXPECT().ImageReferences(gomock.Any()).Return(map[string]int{referenced: 2}, nil)
+
+			result, err := CollectImageGarbage(context.Background(), mockRepo, dir, ImageGCOptions{
+				GracePeriod: 24 * time.Hour,
+				DryRun:      tt.dryRun,
+				Now:         func() time.Time { return now },
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.wants.removed, result.Removed); diff != "" {
+				t.Errorf("unexpected removed files (-want +got):\n%s", diff)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatalf("failed to read dir: %v", err)
+			}
+			var remaining []string
+			for _, e := range entries {
+				remaining = append(remaining, e.Name())
+			}
+			sort.Strings(remaining)
+			sort.Strings(tt.wants.remaining)
+			if diff := cmp.Diff(tt.wants.remaining, remaining); diff != "" {
+				t.Errorf("unexpected remaining files (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
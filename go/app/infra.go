@@ -1,20 +1,26 @@
 package app
 
 import (
+	"cmp"
 	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"log/slog"
-	"os"
+	"strings"
+	"time"
 	// STEP 5-1: uncomment this line
 	_ "github.com/mattn/go-sqlite3"
 )
 
 var (
-	errImageNotFound    = errors.New("image not found")
-	errItemNotFound     = errors.New("item not found")
-	errCategoryNotFound = errors.New("category not found")
+	errImageNotFound = errors.New("image not found")
+	// ErrItemNotFound is returned by ItemRepository when no item has the requested ID.
+	ErrItemNotFound = errors.New("item not found")
+	// ErrCategoryNotFound is returned by ItemRepository when no category has the requested name.
+	ErrCategoryNotFound = errors.New("category not found")
+	// ErrVersionConflict is returned when an item changed since the version the caller read.
+	ErrVersionConflict = errors.New("item version conflict")
 )
 
 /*
@@ -39,6 +45,114 @@ type Item struct {
 	Category   string `json:"category"`
 	ImageName  string `json:"image_name"`
 	CategoryID int    `json:"-"`
+	// SellerID is the user who listed the item. Zero means an anonymous listing.
+	SellerID int `json:"seller_id,omitempty"`
+	// ImageHash is the perceptual hash of the image. Zero means it could not be computed.
+	ImageHash uint64 `json:"-"`
+	// Version is incremented on every update and used for ETags and optimistic locking.
+	Version   int       `json:"version"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// DeletedAt is set while the item is in the trash. Deleted items are hidden unless asked for.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// CommentCount is the number of public comments on the item.
+	CommentCount int `json:"comment_count"`
+	// LikeCount is the number of users who like the item.
+	LikeCount int `json:"like_count"`
+	// Price is the asking price in yen. Zero means the seller did not set one.
+	// For auctions it is the highest bid, or the start price before the first bid.
+	Price int `json:"price"`
+	// ListingType is ListingFixed or ListingAuction.
+	ListingType string `json:"listing_type"`
+	// Auction holds the terms and state of an auction. It is set on Insert and by GET /items/{id}.
+	Auction *Auction `json:"auction,omitempty"`
+	// SoldAt is set when the item is sold.
+	SoldAt *time.Time `json:"sold_at,omitempty"`
+}
+
+// ItemQuery selects items for ItemRepository.Find. Zero fields do not filter.
+type ItemQuery struct {
+	ID int
+	// Keyword matches names containing it, as Search does.
+	Keyword string
+	// SellerID returns the items listed by this user.
+	SellerID int
+	// IncludeDeleted also returns items in the trash.
+	IncludeDeleted bool
+}
+
+// where builds the WHERE clause for q. like is the LIKE operator of the dialect and
+// placeholder returns the n-th (1-based) bind parameter.
+func (q ItemQuery) where(like string, placeholder func(n int) string) (string, []any) {
+	var conds []string
+	var args []any
+	if q.ID != 0 {
+		args = append(args, q.ID)
+		conds = append(conds, "i.id = "+placeholder(len(args)))
+	}
+	if q.Keyword != "" {
+		args = append(args, likePattern(q.Keyword))
+		conds = append(conds, "i.name "+like+" "+placeholder(len(args))+` ESCAPE '\'`)
+	}
+	if q.SellerID != 0 {
+		args = append(args, q.SellerID)
+		conds = append(conds, "i.seller_id = "+placeholder(len(args)))
+	}
+	if !q.IncludeDeleted {
+		conds = append(conds, "i.deleted_at IS NULL")
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(conds, " AND "), args
+}
+
+// itemColumns is the column list scanned by scanItem.
+// コメント数は相関サブクエリで数え、商品ごとに別のクエリを発行しない (comments_item インデックスを使う)
+const itemColumns = `i.id, i.name, c.name AS category, i.category_id, i.image_name, i.seller_id, i.image_hash, i.version, i.updated_at, i.deleted_at,
+        (SELECT COUNT(*) FROM comments cm WHERE cm.item_id = i.id) AS comment_count, i.like_count, i.price,
+        i.listing_type, i.sold_at`
+
+// rowScanner is implemented by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanItem scans a row selected with itemColumns.
+func scanItem(row rowScanner) (*Item, error) {
+	var item Item
+	var sellerID, imageHash sql.NullInt64
+	var updatedAt, deletedAt, soldAt sql.NullTime
+	if err := row.Scan(&item.ID, &item.Name, &item.Category, &item.CategoryID, &item.ImageName, &sellerID, &imageHash, &item.Version, &updatedAt, &deletedAt, &item.CommentCount, &item.LikeCount, &item.Price,
+		&item.ListingType, &soldAt); err != nil {
+		return nil, err
+	}
+	item.UpdatedAt = updatedAt.Time
+	if deletedAt.Valid {
+		item.DeletedAt = &deletedAt.Time
+	}
+	if soldAt.Valid {
+		item.SoldAt = &soldAt.Time
+	}
+	item.SellerID = int(sellerID.Int64)
+	// SQLite の INTEGER は符号付きなので、ビット列をそのまま保存している
+	item.ImageHash = uint64(imageHash.Int64)
+	return &item, nil
+}
+
+// scanItems scans all rows selected with itemColumns.
+func scanItems(rows *sql.Rows) ([]*Item, error) {
+	var items []*Item
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan item: %w", err)
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to scan item: %w", err)
+	}
+	return items, nil
 }
 
 // Please run `go generate ./...` to generate the mock implementation
@@ -53,13 +167,55 @@ type ItemRepository interface {
 	GetCategories(ctx context.Context) ([]Category, error)
 	GetCategoryByName(ctx context.Context, name string) (*Category, error)
 	InsertCategory(ctx context.Context, name string) (*Category, error)
+	// Update saves the name and category of item. When version is non-zero the update only
+	// succeeds if the stored item still has that version, otherwise ErrVersionConflict is returned.
+	Update(ctx context.Context, item *Item, version int) error
+	// ImageReferences returns how many items and avatars reference each image file name.
+	// Items in the trash count, so that restoring them brings their image back.
+	ImageReferences(ctx context.Context) (map[string]int, error)
+	// ImageHashes returns the perceptual hashes of the items not in the trash, in ID order,
+	// so that similar images are found without loading every item.
+	ImageHashes(ctx context.Context) ([]ImageHash, error)
+	// Find returns the items matching q in ID order. List, Select and Search never return deleted items.
+	Find(ctx context.Context, q ItemQuery) ([]*Item, error)
+	// Delete moves an item to the trash. ErrItemNotFound is returned if it is missing or already
+	// deleted. A non-zero version makes it conditional like Update.
+	Delete(ctx context.Context, id, version int) error
+	// Restore takes an item out of the trash. ErrItemNotFound is returned if it is not in the trash.
+	// A non-zero version makes it conditional like Update.
+	Restore(ctx context.Context, id, version int) error
+	// Purge removes items deleted before deletedBefore for good and returns them.
+	Purge(ctx context.Context, deletedBefore time.Time) ([]*Item, error)
+	// ListAudit returns the audit entries matching q, newest first. Every write above, and those of
+	// the repositories sharing the database such as CommentRepository, appends an entry in the same
+	// transaction, attributed to the user and request ID in its context.
+	ListAudit(ctx context.Context, q AuditQuery) ([]*AuditEntry, error)
+
+	// Writes also append their domain Event to the outbox in the same transaction.
+	// PendingEvents returns up to limit undelivered events due at now, oldest first.
+	PendingEvents(ctx context.Context, now time.Time, limit int) ([]*Event, error)
+	// EventsAfter returns up to limit events with IDs after afterID, oldest first, whether they
+	// were delivered or not. Every server tails the outbox with it to feed its own EventBus.
+	EventsAfter(ctx context.Context, afterID int64, limit int) ([]*Event, error)
+	// MarkEventDelivered records that an event reached every sink.
+	MarkEventDelivered(ctx context.Context, id int64) error
+	// MarkEventFailed records a failed delivery attempt and when to retry it.
+	MarkEventFailed(ctx context.Context, id int64, next time.Time, reason string) error
+	// PruneEvents deletes events delivered before deliveredBefore and returns how many.
+	PruneEvents(ctx context.Context, deliveredBefore time.Time) (int, error)
 }
 
 // InsertCategory inserts a new category into the repository.
 func (r *itemRepository) InsertCategory(ctx context.Context, name string) (*Category, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
 	// カテゴリを追加するSQLクエリ
 	query := `INSERT INTO categories (name) VALUES (?) RETURNING id`
-	result, err := r.db.ExecContext(ctx, query, name)
+	result, err := tx.ExecContext(ctx, query, name)
 	if err != nil {
 		return nil, fmt.Errorf("insert category failed: %w", err)
 	}
@@ -70,7 +226,14 @@ func (r *itemRepository) InsertCategory(ctx context.Context, name string) (*Cate
 		return nil, fmt.Errorf("retrieve last insert ID failed: %w", err)
 	}
 
-	return &Category{ID: int(id), Name: name}, nil
+	category := &Category{ID: int(id), Name: name}
+	if err := recordChange(ctx, tx, sqlitePlaceholder, AuditCreate, AuditEntityCategory, category.ID, nil, category); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("insert category failed: %w", err)
+	}
+	return category, nil
 }
 
 // itemRepository is an implementation of ItemRepository
@@ -84,53 +247,184 @@ func NewItemRepository(db *sql.DB) ItemRepository {
 	return &itemRepository{db: db}
 }
 
-// items.sql を実行し、テーブルを作成する
-func SetupDatabase(db *sql.DB) error {
-	schema, err := os.ReadFile("db/items.sql")
+// likePattern matches names containing keyword. % and _ in keyword match literally.
+func likePattern(keyword string) string {
+	r := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+	return "%" + r.Replace(keyword) + "%"
+}
+
+// sqlitePlaceholder is the bind parameter of SQLite.
+func sqlitePlaceholder(int) string {
+	return "?"
+}
+
+// purgeItems hard-deletes the items deleted before deletedBefore and records them in the audit
+// log. Only the trashed rows are selected, in the same transaction as the deletes.
+func purgeItems(ctx context.Context, db *sql.DB, placeholder func(int) string, deletedBefore time.Time) ([]*Item, error) {
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to read schema file: %w", err)
+		return nil, fmt.Errorf("failed to purge items: %w", err)
 	}
-	_, err = db.Exec(string(schema))
+	defer tx.Rollback()
+
+	query := `
+        SELECT ` + itemColumns + `
+        FROM items i
+        JOIN categories c ON i.category_id = c.id
+        WHERE i.deleted_at IS NOT NULL AND i.deleted_at < ` + placeholder(1) + `
+        ORDER BY i.id`
+	rows, err := tx.QueryContext(ctx, query, deletedBefore.UTC())
 	if err != nil {
-		return fmt.Errorf("failed to execute schema: %w", err)
+		return nil, fmt.Errorf("failed to find items to purge: %w", err)
+	}
+	trash, err := scanItems(rows)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// PostgreSQL の READ COMMITTED では選んだ後に復元されうるので、削除時にも条件を確かめる
+	query = `DELETE FROM items WHERE id = ` + placeholder(1) + ` AND deleted_at IS NOT NULL AND deleted_at < ` + placeholder(2)
+	var purged []*Item
+	for _, item := range trash {
+		result, err := tx.ExecContext(ctx, query, item.ID, deletedBefore.UTC())
+		if err != nil {
+			return nil, fmt.Errorf("failed to purge item %d: %w", item.ID, err)
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return nil, fmt.Errorf("failed to purge item %d: %w", item.ID, err)
+		} else if n == 0 {
+			continue
+		}
+		if err := recordChange(ctx, tx, placeholder, AuditPurge, AuditEntityItem, item.ID, item, nil); err != nil {
+			return nil, err
+		}
+		purged = append(purged, item)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to purge items: %w", err)
+	}
+	return purged, nil
+}
+
+// selectItemByID returns an item whether or not it is deleted. q may be a transaction.
+func selectItemByID(ctx context.Context, q querier, placeholder func(int) string, id int) (*Item, error) {
+	query := `
+        SELECT ` + itemColumns + `
+        FROM items i
+        JOIN categories c ON i.category_id = c.id
+        WHERE i.id = ` + placeholder(1)
+	item, err := scanItem(q.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrItemNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to select item: %w", err)
+	}
+	return item, nil
+}
+
+// setItemDeleted moves an item into or out of the trash and records it in the audit log.
+// When version is non-zero the item must still have that version.
+func setItemDeleted(ctx context.Context, db *sql.DB, placeholder func(int) string, id, version int, deleted bool) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := selectItemByID(ctx, tx, placeholder, id)
+	if err != nil {
+		return err
+	}
+	if (before.DeletedAt != nil) == deleted {
+		return ErrItemNotFound
+	}
+	if version != 0 && before.Version != version {
+		return ErrVersionConflict
+	}
+
+	now := time.Now().UTC()
+	action, deletedAt, cond := AuditRestore, sql.NullTime{}, "IS NOT NULL"
+	if deleted {
+		action, deletedAt, cond = AuditDelete, sql.NullTime{Time: now, Valid: true}, "IS NULL"
+	}
+	// 読み取り後に他の人が変更していたら 0 行になる
+	query := fmt.Sprintf(`UPDATE items SET deleted_at = %s, version = version + 1, updated_at = %s WHERE id = %s AND deleted_at %s AND version = %s`,
+		placeholder(1), placeholder(2), placeholder(3), cond, placeholder(4))
+	result, err := tx.ExecContext(ctx, query, deletedAt, now, id, before.Version)
+	if err != nil {
+		return fmt.Errorf("failed to update item: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update item: %w", err)
+	}
+	if n == 0 {
+		return ErrVersionConflict
+	}
+	after, err := selectItemByID(ctx, tx, placeholder, id)
+	if err != nil {
+		return err
+	}
+	if err := recordChange(ctx, tx, placeholder, action, AuditEntityItem, id, before, after); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to update item: %w", err)
 	}
-	slog.Info("Database setup complete")
 	return nil
 }
 
+// nullInt stores zero as NULL.
+func nullInt(v int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: v, Valid: v != 0}
+}
+
 // `categories` から ID を取得（なければ新規作成）
-func (i *itemRepository) getOrCreateCategoryID(ctx context.Context, categoryName string) (int, error) {
+func (i *itemRepository) getOrCreateCategoryID(ctx context.Context, tx *sql.Tx, categoryName string) (int, error) {
+	// カテゴリが存在しない場合、新しく追加
+	// 同時に同じカテゴリが作られても一意制約で失敗しないように ON CONFLICT を使う
+	result, err := tx.ExecContext(ctx, "INSERT INTO categories (name) VALUES (?) ON CONFLICT (name) DO NOTHING", categoryName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert category: %w", err)
+	}
 	var categoryID int
-	err := i.db.QueryRowContext(ctx, "SELECT id FROM categories WHERE name = ?", categoryName).Scan(&categoryID)
-	if err == sql.ErrNoRows {
-		// カテゴリが存在しない場合、新しく追加
-		result, err := i.db.ExecContext(ctx, "INSERT INTO categories (name) VALUES (?)", categoryName)
-		if err != nil {
-			return 0, fmt.Errorf("failed to insert category: %w", err)
-		}
-		lastInsertID, err := result.LastInsertId()
-		if err != nil {
-			return 0, fmt.Errorf("failed to retrieve last insert ID for category: %w", err)
-		}
-		categoryID = int(lastInsertID)
-	} else if err != nil {
+	err = tx.QueryRowContext(ctx, "SELECT id FROM categories WHERE name = ?", categoryName).Scan(&categoryID)
+	if err != nil {
 		return 0, fmt.Errorf("failed to retrieve category ID: %w", err)
 	}
+	// 実際に追加したときだけ監査ログに残す
+	if n, err := result.RowsAffected(); err == nil && n == 1 {
+		category := Category{ID: categoryID, Name: categoryName}
+		if err := recordChange(ctx, tx, sqlitePlaceholder, AuditCreate, AuditEntityCategory, categoryID, nil, category); err != nil {
+			return 0, err
+		}
+	}
 	return categoryID, nil
 }
 
 // 5-1 Insert inserts an item into the repository.
 func (i *itemRepository) Insert(ctx context.Context, item *Item) error {
+	// 商品と監査ログを同じトランザクションで書き込む
+	tx, err := i.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
 	// カテゴリ ID を取得（新規なら追加）
-	categoryID, err := i.getOrCreateCategoryID(ctx, item.Category)
+	categoryID, err := i.getOrCreateCategoryID(ctx, tx, item.Category)
 	if err != nil {
 		slog.Error("failed to get category ID", "category", item.Category, "error", err)
 		return err
 	}
-	query := `INSERT INTO items (name, category_id, image_name) VALUES (?, ?, ?)`
+	query := `INSERT INTO items (name, category_id, image_name, seller_id, image_hash, price, listing_type, version, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, 1, ?)`
 	slog.Info("Executing insert query", "query", query, "name", item.Name, "category_id", categoryID, "image_name", item.ImageName)
 
-	result, err := i.db.ExecContext(ctx, query, item.Name, categoryID, item.ImageName)
+	now := time.Now().UTC()
+	item.ListingType = cmp.Or(item.ListingType, ListingFixed)
+	result, err := tx.ExecContext(ctx, query, item.Name, categoryID, item.ImageName, nullInt(int64(item.SellerID)), nullInt(int64(item.ImageHash)), item.Price, item.ListingType, now)
 	if err != nil {
 		slog.Error("failed to execute insert query", "error", err)
 		return fmt.Errorf("failed to insert item: %w", err)
@@ -143,16 +437,80 @@ func (i *itemRepository) Insert(ctx context.Context, item *Item) error {
 		return fmt.Errorf("failed to retrieve last insert ID: %w", err)
 	}
 	item.ID = int(id) // ここで ID をセット
+	item.CategoryID = categoryID
+	item.Version = 1
+	item.UpdatedAt = now
+	if err := insertAuction(ctx, tx, sqlitePlaceholder, item); err != nil {
+		return err
+	}
+
+	if err := recordChange(ctx, tx, sqlitePlaceholder, AuditCreate, AuditEntityItem, item.ID, nil, item); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to insert item: %w", err)
+	}
 	slog.Info("Item inserted successfully", "id", item.ID)
 	return nil
 }
 
+// Update saves the name and category of an item and increments its version.
+func (i *itemRepository) Update(ctx context.Context, item *Item, version int) error {
+	tx, err := i.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	categoryID, err := i.getOrCreateCategoryID(ctx, tx, item.Category)
+	if err != nil {
+		return err
+	}
+	before, err := selectItemByID(ctx, tx, sqlitePlaceholder, item.ID)
+	if err != nil {
+		return err
+	}
+	if before.DeletedAt != nil {
+		return ErrItemNotFound
+	}
+
+	// version を条件に含めて、読み取り後に他の人が更新していたら 0 行になるようにする
+	now := time.Now().UTC()
+	query := `UPDATE items SET name = ?, category_id = ?, version = version + 1, updated_at = ? WHERE id = ? AND deleted_at IS NULL AND (? = 0 OR version = ?)`
+	result, err := tx.ExecContext(ctx, query, item.Name, categoryID, now, item.ID, version, version)
+	if err != nil {
+		return fmt.Errorf("failed to update item: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update item: %w", err)
+	}
+	if n == 0 {
+		return ErrVersionConflict
+	}
+
+	updated, err := selectItemByID(ctx, tx, sqlitePlaceholder, item.ID)
+	if err != nil {
+		return err
+	}
+	if err := recordChange(ctx, tx, sqlitePlaceholder, AuditUpdate, AuditEntityItem, item.ID, before, updated); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to update item: %w", err)
+	}
+	*item = *updated
+	return nil
+}
+
 // インターフェイス（関数名、引数、戻り値組み合わせ）と同じ関数名と引数と戻り値を指定する
 func (i *itemRepository) List(ctx context.Context) ([]*Item, error) {
 	query := `
-        SELECT i.id, i.name, c.name AS category, i.image_name 
+        SELECT ` + itemColumns + `
         FROM items i
         JOIN categories c ON i.category_id = c.id
+        WHERE i.deleted_at IS NULL
+        ORDER BY i.id
     `
 	rows, err := i.db.QueryContext(ctx, query)
 	if err != nil {
@@ -160,16 +518,7 @@ func (i *itemRepository) List(ctx context.Context) ([]*Item, error) {
 	}
 	defer rows.Close()
 
-	var items []*Item
-	for rows.Next() {
-		var item Item
-		if err := rows.Scan(&item.ID, &item.Name, &item.Category, &item.ImageName); err != nil {
-			return nil, fmt.Errorf("failed to scan item: %w", err)
-		}
-		items = append(items, &item)
-	}
-
-	return items, nil
+	return scanItems(rows)
 }
 
 // GetCategories retrieves all categories
@@ -200,7 +549,7 @@ func (r *itemRepository) GetCategoryByName(ctx context.Context, name string) (*C
 	var category Category
 	if err := row.Scan(&category.ID, &category.Name); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("category with name %s not found", name)
+			return nil, fmt.Errorf("category with name %s: %w", name, ErrCategoryNotFound)
 		}
 		return nil, fmt.Errorf("retrieve category failed: %w", err)
 	}
@@ -210,49 +559,139 @@ func (r *itemRepository) GetCategoryByName(ctx context.Context, name string) (*C
 // 5-1selectの実装
 func (i *itemRepository) Select(ctx context.Context, id int) (*Item, error) {
 	query := `
-        SELECT i.id, i.name, c.name AS category, i.image_name 
+        SELECT ` + itemColumns + `
         FROM items i
         JOIN categories c ON i.category_id = c.id
-        WHERE i.id = ?
+        WHERE i.id = ? AND i.deleted_at IS NULL
     `
 	row := i.db.QueryRowContext(ctx, query, id)
 
 	// idが1以上の値以外になる場合はidをNotFoundにする
-	var item Item
-	err := row.Scan(&item.ID, &item.Name, &item.Category, &item.ImageName)
+	item, err := scanItem(row)
 	if errors.Is(err, sql.ErrNoRows) {
-		return nil, errItemNotFound
+		return nil, ErrItemNotFound
 	}
 	if err != nil {
 		slog.Error("failed to select item", "error", err)
 		return nil, fmt.Errorf("failed to select item: %w", err)
 	}
-	return &item, err
+	return item, err
 }
 
 // itemRepository の Search メソッド実装
 func (i *itemRepository) Search(ctx context.Context, keyword string) ([]*Item, error) {
 	// LIKE検索で部分一致するものを探す
 	query := `
-        SELECT i.id, i.name, c.name AS category, i.image_name 
+        SELECT ` + itemColumns + `
         FROM items i
         JOIN categories c ON i.category_id = c.id
-        WHERE i.name LIKE ?
+        WHERE i.name LIKE ? ESCAPE '\' AND i.deleted_at IS NULL
+        ORDER BY i.id
     `
-	rows, err := i.db.QueryContext(ctx, query, "%"+keyword+"%")
+	rows, err := i.db.QueryContext(ctx, query, likePattern(keyword))
 	if err != nil {
 		return nil, fmt.Errorf("failed to search items: %w", err)
 	}
 	defer rows.Close()
 
-	var items []*Item
+	return scanItems(rows)
+}
+
+// ImageReferences counts the items and avatars referencing each image file.
+func (i *itemRepository) ImageReferences(ctx context.Context) (map[string]int, error) {
+	query := `
+        SELECT image_name, COUNT(*) FROM (
+            SELECT image_name FROM items WHERE image_name IS NOT NULL
+            UNION ALL
+            SELECT avatar_name FROM profiles WHERE avatar_name IS NOT NULL
+        ) refs GROUP BY image_name
+    `
+	rows, err := i.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count image references: %w", err)
+	}
+	defer rows.Close()
+
+	refs := make(map[string]int)
 	for rows.Next() {
-		var item Item
-		if err := rows.Scan(&item.ID, &item.Name, &item.Category, &item.ImageName); err != nil {
-			return nil, fmt.Errorf("failed to scan item: %w", err)
+		var name string
+		var count int
+		if err := rows.Scan(&name, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan image reference: %w", err)
 		}
-		items = append(items, &item)
+		refs[name] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to count image references: %w", err)
 	}
+	return refs, nil
+}
 
-	return items, nil
+// ImageHashes returns the image hashes of the items not in the trash.
+func (i *itemRepository) ImageHashes(ctx context.Context) ([]ImageHash, error) {
+	return selectImageHashes(ctx, i.db)
+}
+
+// Find returns the items matching q.
+func (i *itemRepository) Find(ctx context.Context, q ItemQuery) ([]*Item, error) {
+	where, args := q.where("LIKE", sqlitePlaceholder)
+	query := `
+        SELECT ` + itemColumns + `
+        FROM items i
+        JOIN categories c ON i.category_id = c.id
+        ` + where + `
+        ORDER BY i.id
+    `
+	rows, err := i.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find items: %w", err)
+	}
+	defer rows.Close()
+
+	return scanItems(rows)
+}
+
+// Delete moves an item to the trash.
+func (i *itemRepository) Delete(ctx context.Context, id, version int) error {
+	return setItemDeleted(ctx, i.db, sqlitePlaceholder, id, version, true)
+}
+
+// Restore takes an item out of the trash.
+func (i *itemRepository) Restore(ctx context.Context, id, version int) error {
+	return setItemDeleted(ctx, i.db, sqlitePlaceholder, id, version, false)
+}
+
+// Purge removes items deleted before deletedBefore for good.
+func (i *itemRepository) Purge(ctx context.Context, deletedBefore time.Time) ([]*Item, error) {
+	return purgeItems(ctx, i.db, sqlitePlaceholder, deletedBefore)
+}
+
+// ListAudit returns the audit entries matching q, newest first.
+func (i *itemRepository) ListAudit(ctx context.Context, q AuditQuery) ([]*AuditEntry, error) {
+	return listAudit(ctx, i.db, sqlitePlaceholder, q)
+}
+
+// PendingEvents returns undelivered events due at now.
+func (i *itemRepository) PendingEvents(ctx context.Context, now time.Time, limit int) ([]*Event, error) {
+	return pendingEvents(ctx, i.db, sqlitePlaceholder, now, limit)
+}
+
+// EventsAfter returns the events after afterID, delivered or not.
+func (i *itemRepository) EventsAfter(ctx context.Context, afterID int64, limit int) ([]*Event, error) {
+	return eventsAfter(ctx, i.db, sqlitePlaceholder, afterID, limit)
+}
+
+// MarkEventDelivered records that an event reached every sink.
+func (i *itemRepository) MarkEventDelivered(ctx context.Context, id int64) error {
+	return markEventDelivered(ctx, i.db, sqlitePlaceholder, id)
+}
+
+// MarkEventFailed records a failed delivery attempt.
+func (i *itemRepository) MarkEventFailed(ctx context.Context, id int64, next time.Time, reason string) error {
+	return markEventFailed(ctx, i.db, sqlitePlaceholder, id, next, reason)
+}
+
+// PruneEvents deletes delivered events.
+func (i *itemRepository) PruneEvents(ctx context.Context, deliveredBefore time.Time) (int, error) {
+	return pruneEvents(ctx, i.db, sqlitePlaceholder, deliveredBefore)
 }
@@ -0,0 +1,387 @@
+package app
+
+import (
+	"container/list"
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// DefaultCacheTTL and DefaultCacheSize are used when CacheOptions leaves them zero.
+const (
+	DefaultCacheTTL  = 5 * time.Second
+	DefaultCacheSize = 1024
+)
+
+// CacheOptions configures CachingItemRepository.
+type CacheOptions struct {
+	// TTL is how long a cached result is served. Writes through the cache invalidate earlier,
+	// so TTL only bounds staleness caused by other processes writing to the same database.
+	TTL time.Duration
+	// MaxEntries bounds the number of cached results. The least recently used one is evicted first.
+	MaxEntries int
+	// Now returns the current time. It defaults to time.Now.
+	Now func() time.Time
+}
+
+// CacheStats counts how CachingItemRepository answered reads.
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+}
+
+// cacheEntry is a cached result. value is never handed out directly; see cloneCached.
+type cacheEntry struct {
+	key       string
+	value     any
+	expiresAt time.Time
+}
+
+// CachingItemRepository is a read-through cache in front of another ItemRepository.
+// List, Select, Search, GetCategories and GetCategoryByName are cached. Writes go to the wrapped repository and invalidate the results they may change.
+// Writes through the repositories sharing its database, such as a new comment, report the items they change with ItemChanged.
+// Concurrent misses for the same key share a single load.
+type CachingItemRepository struct {
+	repo ItemRepository
+	ttl  time.Duration
+	size int
+	now  func() time.Time
+
+	loads singleflight.Group
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	// gen is incremented on every invalidation, so loads started before a write are not stored.
+	gen   uint64
+	stats CacheStats
+}
+
+var _ ItemRepository = (*CachingItemRepository)(nil)
+
+// NewCachingItemRepository wraps repo with a cache.
+func NewCachingItemRepository(repo ItemRepository, opts CacheOptions) *CachingItemRepository {
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultCacheTTL
+	}
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = DefaultCacheSize
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &CachingItemRepository{
+		repo:    repo,
+		ttl:     opts.TTL,
+		size:    opts.MaxEntries,
+		now:     opts.Now,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// ItemChanged drops an item and the cached lists after a write through another repository
+// changed what they carry, such as the comment count or the price of the item.
+func (c *CachingItemRepository) ItemChanged(id int) {
+	c.invalidateItems(id)
+}
+
+// itemChanged calls ItemChanged if items is cached.
+func itemChanged(items ItemRepository, id int) {
+	if c, ok := items.(*CachingItemRepository); ok {
+		c.ItemChanged(id)
+	}
+}
+
+// Stats returns the hit/miss counters and the current number of entries.
+func (c *CachingItemRepository) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	return stats
+}
+
+// cache keys
+const (
+	cacheKeyList       = "list"
+	cacheKeyCategories = "categories"
+	cacheKeyItem       = "item:"
+	cacheKeySearch     = "search:"
+	cacheKeyCategory   = "category:"
+)
+
+// get returns the cached value for key, or calls load once for all concurrent callers and caches
+// its result. Errors are never cached.
+func (c *CachingItemRepository) get(ctx context.Context, key string, load func(context.Context) (any, error)) (any, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		entry := e.Value.(*cacheEntry)
+		if c.now().Before(entry.expiresAt) {
+			c.lru.MoveToFront(e)
+			c.stats.Hits++
+			c.mu.Unlock()
+			return cloneCached(entry.value), nil
+		}
+		c.removeElement(e)
+	}
+	c.stats.Misses++
+	gen := c.gen
+	c.mu.Unlock()
+
+	// 世代をキーに含めて、書き込みより前に始まった読み込みに後から相乗りしないようにする
+	ch := c.loads.DoChan(key+"@"+strconv.FormatUint(gen, 10), func() (any, error) {
+		// 最初の呼び出し元がキャンセルしても、相乗りしている呼び出し元には結果を返す
+		v, err := load(context.WithoutCancel(ctx))
+		if err != nil {
+			return nil, err
+		}
+		c.store(key, gen, v)
+		return v, nil
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return cloneCached(res.Val), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// store caches v unless the cache was invalidated after the load started.
+func (c *CachingItemRepository) store(key string, gen uint64, v any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+	if e, ok := c.entries[key]; ok {
+		c.removeElement(e)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, value: cloneCached(v), expiresAt: c.now().Add(c.ttl)})
+	for c.lru.Len() > c.size {
+		c.removeElement(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// removeElement drops an entry. c.mu must be held.
+func (c *CachingItemRepository) removeElement(e *list.Element) {
+	c.lru.Remove(e)
+	delete(c.entries, e.Value.(*cacheEntry).key)
+}
+
+// invalidate drops the given keys and every key starting with one of prefixes.
+func (c *CachingItemRepository) invalidate(keys []string, prefixes ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	for _, key := range keys {
+		if e, ok := c.entries[key]; ok {
+			c.removeElement(e)
+		}
+	}
+	for key, e := range c.entries {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(key, prefix) {
+				c.removeElement(e)
+				break
+			}
+		}
+	}
+}
+
+// invalidateItems drops everything an item write can change. A write may also create a category.
+func (c *CachingItemRepository) invalidateItems(id int) {
+	c.invalidate([]string{cacheKeyList, cacheKeyCategories, cacheKeyItem + strconv.Itoa(id)}, cacheKeySearch)
+}
+
+// cloneCached copies a cached value so that callers can modify what they get,
+// as UpdateItem does with the result of Select.
+func cloneCached(v any) any {
+	switch v := v.(type) {
+	case *Item:
+		item := *v
+		return &item
+	case []*Item:
+		if v == nil {
+			return v
+		}
+		items := make([]*Item, len(v))
+		for i, item := range v {
+			copied := *item
+			items[i] = &copied
+		}
+		return items
+	case *Category:
+		category := *v
+		return &category
+	case []Category:
+		if v == nil {
+			return v
+		}
+		return append([]Category(nil), v...)
+	default:
+		return v
+	}
+}
+
+// Insert inserts an item and invalidates the cached lists.
+func (c *CachingItemRepository) Insert(ctx context.Context, item *Item) error {
+	err := c.repo.Insert(ctx, item)
+	// 失敗しても途中まで書き込まれている可能性があるので、常に無効化する
+	c.invalidateItems(item.ID)
+	return err
+}
+
+// Update updates an item and invalidates it and the cached lists.
+func (c *CachingItemRepository) Update(ctx context.Context, item *Item, version int) error {
+	err := c.repo.Update(ctx, item, version)
+	c.invalidateItems(item.ID)
+	return err
+}
+
+// List returns all items.
+func (c *CachingItemRepository) List(ctx context.Context) ([]*Item, error) {
+	v, err := c.get(ctx, cacheKeyList, func(ctx context.Context) (any, error) {
+		return c.repo.List(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]*Item), nil
+}
+
+// Select returns the item with the given ID.
+func (c *CachingItemRepository) Select(ctx context.Context, id int) (*Item, error) {
+	v, err := c.get(ctx, cacheKeyItem+strconv.Itoa(id), func(ctx context.Context) (any, error) {
+		return c.repo.Select(ctx, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Item), nil
+}
+
+// Search returns the items whose name contains keyword.
+func (c *CachingItemRepository) Search(ctx context.Context, keyword string) ([]*Item, error) {
+	v, err := c.get(ctx, cacheKeySearch+keyword, func(ctx context.Context) (any, error) {
+		return c.repo.Search(ctx, keyword)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]*Item), nil
+}
+
+// GetCategories retrieves all categories
+func (c *CachingItemRepository) GetCategories(ctx context.Context) ([]Category, error) {
+	v, err := c.get(ctx, cacheKeyCategories, func(ctx context.Context) (any, error) {
+		return c.repo.GetCategories(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]Category), nil
+}
+
+// GetCategoryByName retrieves a category by name.
+// Only found categories are cached, so a category created elsewhere is seen immediately.
+func (c *CachingItemRepository) GetCategoryByName(ctx context.Context, name string) (*Category, error) {
+	v, err := c.get(ctx, cacheKeyCategory+name, func(ctx context.Context) (any, error) {
+		return c.repo.GetCategoryByName(ctx, name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Category), nil
+}
+
+// InsertCategory inserts a new category and invalidates the cached category list.
+func (c *CachingItemRepository) InsertCategory(ctx context.Context, name string) (*Category, error) {
+	category, err := c.repo.InsertCategory(ctx, name)
+	c.invalidate([]string{cacheKeyCategories, cacheKeyCategory + name})
+	return category, err
+}
+
+// Find is not cached; it is only used for occasional admin requests.
+func (c *CachingItemRepository) Find(ctx context.Context, q ItemQuery) ([]*Item, error) {
+	return c.repo.Find(ctx, q)
+}
+
+// Delete moves an item to the trash and invalidates it and the cached lists.
+func (c *CachingItemRepository) Delete(ctx context.Context, id, version int) error {
+	err := c.repo.Delete(ctx, id, version)
+	c.invalidateItems(id)
+	return err
+}
+
+// Restore takes an item out of the trash and invalidates the cached lists.
+func (c *CachingItemRepository) Restore(ctx context.Context, id, version int) error {
+	err := c.repo.Restore(ctx, id, version)
+	c.invalidateItems(id)
+	return err
+}
+
+// Purge removes deleted items for good. Cached reads never contain deleted items, so only
+// the items themselves are dropped from the cache.
+func (c *CachingItemRepository) Purge(ctx context.Context, deletedBefore time.Time) ([]*Item, error) {
+	purged, err := c.repo.Purge(ctx, deletedBefore)
+	c.invalidate(nil, cacheKeyItem)
+	return purged, err
+}
+
+// ListAudit is not cached; the audit log must be exact.
+func (c *CachingItemRepository) ListAudit(ctx context.Context, q AuditQuery) ([]*AuditEntry, error) {
+	return c.repo.ListAudit(ctx, q)
+}
+
+// PendingEvents is not cached.
+func (c *CachingItemRepository) PendingEvents(ctx context.Context, now time.Time, limit int) ([]*Event, error) {
+	return c.repo.PendingEvents(ctx, now, limit)
+}
+
+// EventsAfter is not cached.
+func (c *CachingItemRepository) EventsAfter(ctx context.Context, afterID int64, limit int) ([]*Event, error) {
+	return c.repo.EventsAfter(ctx, afterID, limit)
+}
+
+// MarkEventDelivered records that an event reached every sink.
+func (c *CachingItemRepository) MarkEventDelivered(ctx context.Context, id int64) error {
+	return c.repo.MarkEventDelivered(ctx, id)
+}
+
+// MarkEventFailed records a failed delivery attempt.
+func (c *CachingItemRepository) MarkEventFailed(ctx context.Context, id int64, next time.Time, reason string) error {
+	return c.repo.MarkEventFailed(ctx, id, next, reason)
+}
+
+// PruneEvents deletes delivered events.
+func (c *CachingItemRepository) PruneEvents(ctx context.Context, deliveredBefore time.Time) (int, error) {
+	return c.repo.PruneEvents(ctx, deliveredBefore)
+}
+
+// ImageReferences is not cached; image GC must see every item and avatar.
+func (c *CachingItemRepository) ImageReferences(ctx context.Context) (map[string]int, error) {
+	return c.repo.ImageReferences(ctx)
+}
+
+// ImageHashes is not cached; it is already much lighter than List.
+func (c *CachingItemRepository) ImageHashes(ctx context.Context) ([]ImageHash, error) {
+	return c.repo.ImageHashes(ctx)
+}
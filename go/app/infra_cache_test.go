@@ -0,0 +1,180 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/mock/gomock"
+)
+
+func TestCachingItemRepository(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	items := []*Item{{ID: 1, Name: "jacket", Category: "fashion", Version: 1}}
+
+	t.Run("ok: reads are served from the cache", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		m := NewMockItemRepository(ctrl)
+		m.EXPECT().List(gomock.Any()).Return(items, nil).Times(1)
+		m.EXPECT().GetCategoryByName(gomock.Any(), "fashion").Return(&Category{ID: 1, Name: "fashion"}, nil).Times(1)
+		cache := NewCachingItemRepository(m, CacheOptions{})
+
+		for range 3 {
+			got, err := cache.List(ctx)
+			if err != nil {
+				t.Fatalf("List failed: %v", err)
+			}
+			if diff := cmp.Diff(items, got); diff != "" {
+				t.Errorf("unexpected items (-want +got):\n%s", diff)
+			}
+			// 呼び出し元が書き換えてもキャッシュには影響しない
+			got[0].Name = "changed"
+
+			if _, err := cache.GetCategoryByName(ctx, "fashion"); err != nil {
+				t.Fatalf("GetCategoryByName failed: %v", err)
+			}
+		}
+
+		want := CacheStats{Hits: 4, Misses: 2, Entries: 2}
+		if diff := cmp.Diff(want, cache.Stats()); diff != "" {
+			t.Errorf("unexpected stats (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("ok: entries expire after the TTL", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		ctrl := gomock.NewController(t)
+		m := NewMockItemRepository(ctrl)
+		m.EXPECT().Select(gomock.Any(), 1).Return(items[0], nil).Times(2)
+		cache := NewCachingItemRepository(m, CacheOptions{TTL: time.Minute, Now: func() time.Time { return now }})
+
+		cache.Select(ctx, 1)
+		now = now.Add(59 * time.Second)
+		cache.Select(ctx, 1)
+		now = now.Add(time.Second)
+		cache.Select(ctx, 1)
+	})
+
+	t.Run("ok: least recently used entries are evicted", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		m := NewMockItemRepository(ctrl)
+		m.EXPECT().Search(gomock.Any(), "a").Return(nil, nil).Times(1)
+		m.EXPECT().Search(gomock.Any(), "b").Return(nil, nil).Times(2)
+		m.EXPECT().Search(gomock.Any(), "c").Return(nil, nil).Times(1)
+		cache := NewCachingItemRepository(m, CacheOptions{MaxEntries: 2})
+
+		cache.Search(ctx, "a")
+		cache.Search(ctx, "b")
+		cache.Search(ctx, "a")
+		cache.Search(ctx, "c") // b を追い出す
+		cache.Search(ctx, "a")
+		cache.Search(ctx, "b")
+
+		if got := cache.Stats().Evictions; got != 2 {
+			t.Errorf("expected 2 evictions, got %d", got)
+		}
+	})
+
+	t.Run("ok: writes invalidate cached reads", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		m := NewMockItemRepository(ctrl)
+		m.EXPECT().List(gomock.Any()).Return(items, nil).Times(3)
+		m.EXPECT().Select(gomock.Any(), 1).Return(items[0], nil).Times(2)
+		m.EXPECT().Search(gomock.Any(), "jac").Return(items, nil).Times(2)
+		m.EXPECT().Insert(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, item *Item) error {
+			item.ID = 2
+			return nil
+		})
+		m.EXPECT().Update(gomock.Any(), gomock.Any(), 1).Return(nil)
+		cache := NewCachingItemRepository(m, CacheOptions{})
+
+		cache.List(ctx)
+		cache.Select(ctx, 1)
+		cache.Search(ctx, "jac")
+
+		cache.Insert(ctx, &Item{Name: "coat", Category: "fashion"})
+		cache.List(ctx)
+		cache.Select(ctx, 1) // 別の商品の追加では無効化されない
+		cache.Search(ctx, "jac")
+
+		cache.Update(ctx, &Item{ID: 1, Name: "jacket", Category: "fashion"}, 1)
+		cache.List(ctx)
+		cache.Select(ctx, 1)
+	})
+
+	t.Run("ok: changes through other repositories invalidate the item", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		m := NewMockItemRepository(ctrl)
+		m.EXPECT().List(gomock.Any()).Return(items, nil).Times(2)
+		m.EXPECT().Select(gomock.Any(), 1).Return(items[0], nil).Times(2)
+		cache := NewCachingItemRepository(m, CacheOptions{})
+
+		cache.List(ctx)
+		cache.Select(ctx, 1)
+		// コメントなど別のリポジトリでの書き込み
+		itemChanged(cache, 1)
+		cache.List(ctx)
+		cache.Select(ctx, 1)
+	})
+
+	t.Run("ng: errors are not cached", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		m := NewMockItemRepository(ctrl)
+		m.EXPECT().GetCategoryByName(gomock.Any(), "toys").Return(nil, ErrCategoryNotFound).Times(2)
+		cache := NewCachingItemRepository(m, CacheOptions{})
+
+		for range 2 {
+			if _, err := cache.GetCategoryByName(ctx, "toys"); !errors.Is(err, ErrCategoryNotFound) {
+				t.Errorf("expected ErrCategoryNotFound, got %v", err)
+			}
+		}
+	})
+
+	t.Run("ok: concurrent misses share one load", func(t *testing.T) {
+		t.Parallel()
+
+		release := make(chan struct{})
+		ctrl := gomock.NewController(t)
+		m := NewMockItemRepository(ctrl)
+		m.EXPECT().List(gomock.Any()).DoAndReturn(func(context.Context) ([]*Item, error) {
+			<-release
+			return items, nil
+		}).Times(1)
+		cache := NewCachingItemRepository(m, CacheOptions{})
+
+		const n = 8
+		var wg sync.WaitGroup
+		for range n {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := cache.List(ctx); err != nil {
+					t.Errorf("List failed: %v", err)
+				}
+			}()
+		}
+		// 全員が読み込みを待つまで待つ
+		for cache.Stats().Misses < n {
+			time.Sleep(time.Millisecond)
+		}
+		close(release)
+		wg.Wait()
+	})
+}
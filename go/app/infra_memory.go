@@ -0,0 +1,604 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryItemRepository is a pure-Go implementation of ItemRepository which keeps everything in memory.
+// It needs neither CGO nor a db directory, so it suits frontend development, demos and CI.
+// Its semantics match itemRepository: sequential IDs, categories created on Insert and
+// case-insensitive (ASCII) substring search on item names.
+// The memory repositories of comments and the like keep their data in it too, as tables of
+// the same database, since items count them and purging an item removes them.
+type memoryItemRepository struct {
+	mu sync.RWMutex
+
+	items          map[int]Item
+	categories     map[string]Category
+	categoryNames  map[int]string
+	nextItemID     int
+	nextCategoryID int
+	audit          []*AuditEntry
+	outbox         []*memoryEvent
+	nextEventID    int64
+	// comments are kept in ID order. commentCounts is maintained with them so that listing
+	// items does not count comments item by item.
+	comments      []*Comment
+	commentCounts map[int]int
+	reports       map[int]map[int]CommentReport
+	nextCommentID int
+	// likes maps item IDs to the users who like them and when.
+	likes map[int]map[int]time.Time
+	// auctions are keyed by item ID. bids are kept in ID order.
+	auctions    map[int]Auction
+	bids        []*Bid
+	nextBidID   int64
+	orders      []*Order
+	nextOrderID int
+	// offers are keyed by ID. offerEvents are kept in ID order.
+	offers           map[int]Offer
+	nextOfferID      int
+	offerEvents      []*OfferEvent
+	nextOfferEventID int64
+	// reviews are kept in ID order.
+	reviews      []*Review
+	nextReviewID int
+	profiles     map[int]*Profile
+}
+
+// memoryEvent is an outbox entry with its delivery state.
+type memoryEvent struct {
+	Event
+	nextAttemptAt time.Time
+	delivered     *time.Time
+	lastError     string
+}
+
+// NewMemoryItemRepository creates an empty memoryItemRepository.
+func NewMemoryItemRepository() ItemRepository {
+	return &memoryItemRepository{
+		items:            make(map[int]Item),
+		categories:       make(map[string]Category),
+		categoryNames:    make(map[int]string),
+		nextItemID:       1,
+		nextCategoryID:   1,
+		nextEventID:      1,
+		commentCounts:    make(map[int]int),
+		reports:          make(map[int]map[int]CommentReport),
+		nextCommentID:    1,
+		likes:            make(map[int]map[int]time.Time),
+		auctions:         make(map[int]Auction),
+		nextBidID:        1,
+		nextOrderID:      1,
+		offers:           make(map[int]Offer),
+		nextOfferID:      1,
+		nextOfferEventID: 1,
+		nextReviewID:     1,
+		profiles:         make(map[int]*Profile),
+	}
+}
+
+// getOrCreateCategory returns a category, creating it when missing. m.mu must be held for writing.
+func (m *memoryItemRepository) getOrCreateCategory(ctx context.Context, name string) (Category, error) {
+	if c, ok := m.categories[name]; ok {
+		return c, nil
+	}
+	c := Category{ID: m.nextCategoryID, Name: name}
+	if err := m.recordChange(ctx, AuditCreate, AuditEntityCategory, c.ID, nil, c); err != nil {
+		return Category{}, err
+	}
+	m.nextCategoryID++
+	m.categories[name] = c
+	m.categoryNames[c.ID] = name
+	return c, nil
+}
+
+// recordChange appends the audit entry and domain event of a change. m.mu must be held for writing.
+// It is called before the change is applied, so that a failure leaves nothing half done.
+func (m *memoryItemRepository) recordChange(ctx context.Context, action, entity string, entityID int, before, after any) error {
+	e, err := newAuditEntry(ctx, action, entity, entityID, before, after)
+	if err != nil {
+		return err
+	}
+	event, err := newEvent(action, entity, after)
+	if err != nil {
+		return err
+	}
+	e.ID = int64(len(m.audit) + 1)
+	m.audit = append(m.audit, e)
+	if event != nil {
+		event.ID = m.nextEventID
+		m.nextEventID++
+		m.outbox = append(m.outbox, &memoryEvent{Event: *event, nextAttemptAt: event.CreatedAt})
+	}
+	return nil
+}
+
+// withCategory returns a copy of item carrying its current category name and comment count.
+// m.mu must be held.
+func (m *memoryItemRepository) withCategory(item Item) *Item {
+	item.Category = m.categoryNames[item.CategoryID]
+	item.CommentCount = m.commentCounts[item.ID]
+	return &item
+}
+
+// sorted returns copies of the items matching keep in ID order. m.mu must be held.
+func (m *memoryItemRepository) sorted(keep func(Item) bool) []*Item {
+	var items []*Item
+	for _, item := range m.items {
+		if keep(item) {
+			items = append(items, m.withCategory(item))
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	return items
+}
+
+// Insert inserts an item into the repository.
+func (m *memoryItemRepository) Insert(ctx context.Context, item *Item) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to insert item: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	category, err := m.getOrCreateCategory(ctx, item.Category)
+	if err != nil {
+		return err
+	}
+	stored := *item
+	stored.ID = m.nextItemID
+	stored.LikeCount = 0
+	stored.CategoryID = category.ID
+	stored.Version = 1
+	stored.UpdatedAt = time.Now().UTC()
+	stored.ListingType = cmp.Or(stored.ListingType, ListingFixed)
+	// オークションの状態は items ではなく auctions に持つ
+	stored.Auction = nil
+	if stored.ListingType == ListingAuction {
+		if item.Auction == nil {
+			return errors.New("auction terms are required")
+		}
+		auction := *item.Auction
+		auction.ItemID = stored.ID
+		auction.EndsAt = auction.EndsAt.UTC()
+		stored.Auction = &auction
+	}
+	if err := m.recordChange(ctx, AuditCreate, AuditEntityItem, stored.ID, nil, m.withCategory(stored)); err != nil {
+		return err
+	}
+	m.nextItemID++
+	if stored.Auction != nil {
+		m.auctions[stored.ID] = *stored.Auction
+	}
+	*item = stored
+	stored.Auction = nil
+	m.items[stored.ID] = stored
+	return nil
+}
+
+// Update saves the name and category of an item and increments its version.
+func (m *memoryItemRepository) Update(ctx context.Context, item *Item, version int) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to update item: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.items[item.ID]
+	if !ok || stored.DeletedAt != nil {
+		return ErrItemNotFound
+	}
+	if version != 0 && stored.Version != version {
+		return ErrVersionConflict
+	}
+	category, err := m.getOrCreateCategory(ctx, item.Category)
+	if err != nil {
+		return err
+	}
+	before := m.withCategory(stored)
+	stored.Name = item.Name
+	stored.CategoryID = category.ID
+	stored.Version++
+	stored.UpdatedAt = time.Now().UTC()
+	after := m.withCategory(stored)
+	if err := m.recordChange(ctx, AuditUpdate, AuditEntityItem, item.ID, before, after); err != nil {
+		return err
+	}
+	m.items[item.ID] = stored
+	*item = *after
+	return nil
+}
+
+// List returns all items.
+func (m *memoryItemRepository) List(ctx context.Context) ([]*Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve items: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.sorted(func(item Item) bool { return item.DeletedAt == nil }), nil
+}
+
+// Select returns the item with the given ID.
+func (m *memoryItemRepository) Select(ctx context.Context, id int) (*Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to select item: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	item, ok := m.items[id]
+	if !ok || item.DeletedAt != nil {
+		return nil, ErrItemNotFound
+	}
+	return m.withCategory(item), nil
+}
+
+// Search returns the items whose name contains keyword, ignoring ASCII case like SQLite's LIKE.
+func (m *memoryItemRepository) Search(ctx context.Context, keyword string) ([]*Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to search items: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keyword = asciiLower(keyword)
+	return m.sorted(func(item Item) bool {
+		return item.DeletedAt == nil && strings.Contains(asciiLower(item.Name), keyword)
+	}), nil
+}
+
+// asciiLower lower-cases ASCII letters only, as SQLite's LIKE does.
+func asciiLower(s string) string {
+	return strings.Map(func(r rune) rune {
+		if 'A' <= r && r <= 'Z' {
+			return r + ('a' - 'A')
+		}
+		return r
+	}, s)
+}
+
+// GetCategories retrieves all categories
+func (m *memoryItemRepository) GetCategories(ctx context.Context) ([]Category, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("retrieve categories failed: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var categories []Category
+	for _, c := range m.categories {
+		categories = append(categories, c)
+	}
+	sort.Slice(categories, func(i, j int) bool { return categories[i].ID < categories[j].ID })
+	return categories, nil
+}
+
+// GetCategoryByName retrieves a category by name
+func (m *memoryItemRepository) GetCategoryByName(ctx context.Context, name string) (*Category, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("retrieve category failed: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	c, ok := m.categories[name]
+	if !ok {
+		return nil, fmt.Errorf("category with name %s: %w", name, ErrCategoryNotFound)
+	}
+	return &c, nil
+}
+
+// InsertCategory inserts a new category into the repository.
+func (m *memoryItemRepository) InsertCategory(ctx context.Context, name string) (*Category, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("insert category failed: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// SQLite の UNIQUE 制約と同じく重複はエラーにする
+	if _, ok := m.categories[name]; ok {
+		return nil, fmt.Errorf("insert category failed: category %s already exists", name)
+	}
+	c, err := m.getOrCreateCategory(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// ImageReferences counts the items referencing each image file.
+func (m *memoryItemRepository) ImageReferences(ctx context.Context) (map[string]int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to count image references: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	refs := make(map[string]int)
+	for _, item := range m.items {
+		refs[item.ImageName]++
+	}
+	for _, p := range m.profiles {
+		if p.AvatarName != "" {
+			refs[p.AvatarName]++
+		}
+	}
+	return refs, nil
+}
+
+// ImageHashes returns the image hashes of the items not in the trash.
+func (m *memoryItemRepository) ImageHashes(ctx context.Context) ([]ImageHash, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list image hashes: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var hashes []ImageHash
+	for _, item := range m.items {
+		if item.DeletedAt == nil && item.ImageHash != 0 {
+			hashes = append(hashes, ImageHash{ItemID: item.ID, SellerID: item.SellerID, Hash: item.ImageHash})
+		}
+	}
+	slices.SortFunc(hashes, func(a, b ImageHash) int { return cmp.Compare(a.ItemID, b.ItemID) })
+	return hashes, nil
+}
+
+// Find returns the items matching q.
+func (m *memoryItemRepository) Find(ctx context.Context, q ItemQuery) ([]*Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find items: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keyword := asciiLower(q.Keyword)
+	return m.sorted(func(item Item) bool {
+		return (q.ID == 0 || item.ID == q.ID) &&
+			strings.Contains(asciiLower(item.Name), keyword) &&
+			(q.SellerID == 0 || item.SellerID == q.SellerID) &&
+			(q.IncludeDeleted || item.DeletedAt == nil)
+	}), nil
+}
+
+// setDeleted moves an item into or out of the trash. When version is non-zero the item must
+// still have that version.
+func (m *memoryItemRepository) setDeleted(ctx context.Context, id, version int, deleted bool) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to update item: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	item, ok := m.items[id]
+	if !ok || (item.DeletedAt != nil) == deleted {
+		return ErrItemNotFound
+	}
+	if version != 0 && item.Version != version {
+		return ErrVersionConflict
+	}
+	before := m.withCategory(item)
+	now := time.Now().UTC()
+	action := AuditRestore
+	item.DeletedAt = nil
+	if deleted {
+		action = AuditDelete
+		item.DeletedAt = &now
+	}
+	item.Version++
+	item.UpdatedAt = now
+	if err := m.recordChange(ctx, action, AuditEntityItem, id, before, m.withCategory(item)); err != nil {
+		return err
+	}
+	m.items[id] = item
+	return nil
+}
+
+// Delete moves an item to the trash.
+func (m *memoryItemRepository) Delete(ctx context.Context, id, version int) error {
+	return m.setDeleted(ctx, id, version, true)
+}
+
+// Restore takes an item out of the trash.
+func (m *memoryItemRepository) Restore(ctx context.Context, id, version int) error {
+	return m.setDeleted(ctx, id, version, false)
+}
+
+// Purge removes items deleted before deletedBefore for good.
+func (m *memoryItemRepository) Purge(ctx context.Context, deletedBefore time.Time) ([]*Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to purge items: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	purged := m.sorted(func(item Item) bool {
+		return item.DeletedAt != nil && item.DeletedAt.Before(deletedBefore)
+	})
+	for _, item := range purged {
+		if err := m.recordChange(ctx, AuditPurge, AuditEntityItem, item.ID, item, nil); err != nil {
+			return nil, err
+		}
+		delete(m.items, item.ID)
+		m.removeComments(func(c *Comment) bool { return c.ItemID == item.ID })
+		delete(m.likes, item.ID)
+		delete(m.auctions, item.ID)
+		m.bids = slices.DeleteFunc(m.bids, func(b *Bid) bool { return b.ItemID == item.ID })
+		for id, o := range m.offers {
+			if o.ItemID == item.ID {
+				delete(m.offers, id)
+				m.offerEvents = slices.DeleteFunc(m.offerEvents, func(e *OfferEvent) bool { return e.OfferID == id })
+			}
+		}
+	}
+	return purged, nil
+}
+
+// ListAudit returns the audit entries matching q, newest first.
+func (m *memoryItemRepository) ListAudit(ctx context.Context, q AuditQuery) ([]*AuditEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve audit log: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var entries []*AuditEntry
+	for i := len(m.audit) - 1; i >= 0 && len(entries) < q.limit(); i-- {
+		e := m.audit[i]
+		if (q.ActorID != 0 && e.ActorID != q.ActorID) ||
+			(q.Action != "" && e.Action != q.Action) ||
+			(q.Entity != "" && e.Entity != q.Entity) ||
+			(q.EntityID != 0 && e.EntityID != q.EntityID) ||
+			(!q.Since.IsZero() && e.CreatedAt.Before(q.Since)) ||
+			(!q.Until.IsZero() && !e.CreatedAt.Before(q.Until)) ||
+			(q.BeforeID != 0 && e.ID >= q.BeforeID) {
+			continue
+		}
+		// 監査ログは書き換えられないように複製して返す
+		copied := *e
+		copied.Before = slices.Clone(e.Before)
+		copied.After = slices.Clone(e.After)
+		copied.Diff = maps.Clone(e.Diff)
+		entries = append(entries, &copied)
+	}
+	return entries, nil
+}
+
+// PendingEvents returns undelivered events due at now.
+func (m *memoryItemRepository) PendingEvents(ctx context.Context, now time.Time, limit int) ([]*Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve events: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var events []*Event
+	for _, e := range m.outbox {
+		if len(events) == limit {
+			break
+		}
+		if e.delivered == nil && !e.nextAttemptAt.After(now) {
+			copied := e.Event
+			copied.Payload = slices.Clone(e.Payload)
+			events = append(events, &copied)
+		}
+	}
+	return events, nil
+}
+
+// EventsAfter returns the events after afterID, delivered or not.
+func (m *memoryItemRepository) EventsAfter(ctx context.Context, afterID int64, limit int) ([]*Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve events: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var events []*Event
+	for _, e := range m.outbox {
+		if len(events) == limit {
+			break
+		}
+		if e.ID > afterID {
+			copied := e.Event
+			copied.Payload = slices.Clone(e.Payload)
+			events = append(events, &copied)
+		}
+	}
+	return events, nil
+}
+
+// MarkEventDelivered records that an event reached every sink.
+func (m *memoryItemRepository) MarkEventDelivered(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to mark event delivered: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e := m.event(id); e != nil {
+		now := time.Now().UTC()
+		e.delivered = &now
+		e.lastError = ""
+	}
+	return nil
+}
+
+// MarkEventFailed records a failed delivery attempt.
+func (m *memoryItemRepository) MarkEventFailed(ctx context.Context, id int64, next time.Time, reason string) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to mark event failed: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e := m.event(id); e != nil {
+		e.Attempts++
+		e.nextAttemptAt = next
+		e.lastError = reason
+	}
+	return nil
+}
+
+// PruneEvents deletes delivered events.
+func (m *memoryItemRepository) PruneEvents(ctx context.Context, deliveredBefore time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("failed to prune events: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := len(m.outbox)
+	m.outbox = slices.DeleteFunc(m.outbox, func(e *memoryEvent) bool {
+		return e.delivered != nil && e.delivered.Before(deliveredBefore)
+	})
+	return n - len(m.outbox), nil
+}
+
+// event returns the outbox entry with the given ID. m.mu must be held.
+func (m *memoryItemRepository) event(id int64) *memoryEvent {
+	for _, e := range m.outbox {
+		if e.ID == id {
+			return e
+		}
+	}
+	return nil
+}
+
+// removeComments deletes the comments matching del with their reports, as the foreign keys
+// of the database do. m.mu must be held for writing.
+func (m *memoryItemRepository) removeComments(del func(*Comment) bool) {
+	m.comments = slices.DeleteFunc(m.comments, func(c *Comment) bool {
+		if !del(c) {
+			return false
+		}
+		m.commentCounts[c.ItemID]--
+		if m.commentCounts[c.ItemID] == 0 {
+			delete(m.commentCounts, c.ItemID)
+		}
+		delete(m.reports, c.ID)
+		return true
+	})
+}
+
+// order returns the stored order with ID id, or nil. m.mu must be held.
+func (m *memoryItemRepository) order(id int) *Order {
+	i, found := slices.BinarySearchFunc(m.orders, id, func(o *Order, id int) int { return cmp.Compare(o.ID, id) })
+	if !found {
+		return nil
+	}
+	return m.orders[i]
+}
@@ -0,0 +1,344 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// postgresItemRepository is an implementation of ItemRepository backed by PostgreSQL.
+// It has the same semantics as itemRepository; only the SQL dialect differs.
+type postgresItemRepository struct {
+	// db is a database connection
+	db *sql.DB
+}
+
+// NewPostgresItemRepository creates a new postgresItemRepository.
+func NewPostgresItemRepository(db *sql.DB) ItemRepository {
+	return &postgresItemRepository{db: db}
+}
+
+// getOrCreateCategoryID returns the ID of a category, creating it when missing.
+func (p *postgresItemRepository) getOrCreateCategoryID(ctx context.Context, tx *sql.Tx, categoryName string) (int, error) {
+	// 同時に同じカテゴリが作られても一意制約で弾かれないように ON CONFLICT を使う
+	result, err := tx.ExecContext(ctx, `INSERT INTO categories (name) VALUES ($1) ON CONFLICT (name) DO NOTHING`, categoryName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert category: %w", err)
+	}
+	var categoryID int
+	if err := tx.QueryRowContext(ctx, `SELECT id FROM categories WHERE name = $1`, categoryName).Scan(&categoryID); err != nil {
+		return 0, fmt.Errorf("failed to retrieve category ID: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 1 {
+		category := Category{ID: categoryID, Name: categoryName}
+		if err := recordChange(ctx, tx, postgresPlaceholder, AuditCreate, AuditEntityCategory, categoryID, nil, category); err != nil {
+			return 0, err
+		}
+	}
+	return categoryID, nil
+}
+
+// Insert inserts an item into the repository.
+func (p *postgresItemRepository) Insert(ctx context.Context, item *Item) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	categoryID, err := p.getOrCreateCategoryID(ctx, tx, item.Category)
+	if err != nil {
+		slog.Error("failed to get category ID", "category", item.Category, "error", err)
+		return err
+	}
+
+	now := time.Now().UTC()
+	item.ListingType = cmp.Or(item.ListingType, ListingFixed)
+	query := `INSERT INTO items (name, category_id, image_name, seller_id, image_hash, price, listing_type, version, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, 1, $8) RETURNING id`
+	err = tx.QueryRowContext(ctx, query, item.Name, categoryID, item.ImageName, nullInt(int64(item.SellerID)), nullInt(int64(item.ImageHash)), item.Price, item.ListingType, now).Scan(&item.ID)
+	if err != nil {
+		return fmt.Errorf("failed to insert item: %w", err)
+	}
+	item.CategoryID = categoryID
+	item.Version = 1
+	item.UpdatedAt = now
+	if err := insertAuction(ctx, tx, postgresPlaceholder, item); err != nil {
+		return err
+	}
+
+	if err := recordChange(ctx, tx, postgresPlaceholder, AuditCreate, AuditEntityItem, item.ID, nil, item); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to insert item: %w", err)
+	}
+	slog.Info("Item inserted successfully", "id", item.ID)
+	return nil
+}
+
+// Update saves the name and category of an item and increments its version.
+func (p *postgresItemRepository) Update(ctx context.Context, item *Item, version int) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	categoryID, err := p.getOrCreateCategoryID(ctx, tx, item.Category)
+	if err != nil {
+		return err
+	}
+	before, err := selectItemByID(ctx, tx, postgresPlaceholder, item.ID)
+	if err != nil {
+		return err
+	}
+	if before.DeletedAt != nil {
+		return ErrItemNotFound
+	}
+
+	query := `UPDATE items SET name = $1, category_id = $2, version = version + 1, updated_at = $3 WHERE id = $4 AND deleted_at IS NULL AND ($5 = 0 OR version = $5)`
+	result, err := tx.ExecContext(ctx, query, item.Name, categoryID, time.Now().UTC(), item.ID, version)
+	if err != nil {
+		return fmt.Errorf("failed to update item: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update item: %w", err)
+	}
+	if n == 0 {
+		return ErrVersionConflict
+	}
+
+	updated, err := selectItemByID(ctx, tx, postgresPlaceholder, item.ID)
+	if err != nil {
+		return err
+	}
+	if err := recordChange(ctx, tx, postgresPlaceholder, AuditUpdate, AuditEntityItem, item.ID, before, updated); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to update item: %w", err)
+	}
+	*item = *updated
+	return nil
+}
+
+// List returns all items.
+func (p *postgresItemRepository) List(ctx context.Context) ([]*Item, error) {
+	query := `
+        SELECT ` + itemColumns + `
+        FROM items i
+        JOIN categories c ON i.category_id = c.id
+        WHERE i.deleted_at IS NULL
+        ORDER BY i.id
+    `
+	rows, err := p.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve items: %w", err)
+	}
+	defer rows.Close()
+
+	return scanItems(rows)
+}
+
+// Select returns the item with the given ID.
+func (p *postgresItemRepository) Select(ctx context.Context, id int) (*Item, error) {
+	query := `
+        SELECT ` + itemColumns + `
+        FROM items i
+        JOIN categories c ON i.category_id = c.id
+        WHERE i.id = $1 AND i.deleted_at IS NULL
+    `
+	item, err := scanItem(p.db.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrItemNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to select item: %w", err)
+	}
+	return item, nil
+}
+
+// Search returns the items whose name contains keyword.
+func (p *postgresItemRepository) Search(ctx context.Context, keyword string) ([]*Item, error) {
+	// SQLite の LIKE に合わせて大文字小文字を区別しない
+	query := `
+        SELECT ` + itemColumns + `
+        FROM items i
+        JOIN categories c ON i.category_id = c.id
+        WHERE i.name ILIKE $1 ESCAPE '\' AND i.deleted_at IS NULL
+        ORDER BY i.id
+    `
+	rows, err := p.db.QueryContext(ctx, query, likePattern(keyword))
+	if err != nil {
+		return nil, fmt.Errorf("failed to search items: %w", err)
+	}
+	defer rows.Close()
+
+	return scanItems(rows)
+}
+
+// GetCategories retrieves all categories
+func (p *postgresItemRepository) GetCategories(ctx context.Context) ([]Category, error) {
+	rows, err := p.db.QueryContext(ctx, `SELECT id, name FROM categories ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("retrieve categories failed: %w", err)
+	}
+	defer rows.Close()
+
+	var categories []Category
+	for rows.Next() {
+		var category Category
+		if err := rows.Scan(&category.ID, &category.Name); err != nil {
+			return nil, fmt.Errorf("scan category failed: %w", err)
+		}
+		categories = append(categories, category)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("retrieve categories failed: %w", err)
+	}
+	return categories, nil
+}
+
+// GetCategoryByName retrieves a category by name
+func (p *postgresItemRepository) GetCategoryByName(ctx context.Context, name string) (*Category, error) {
+	var category Category
+	err := p.db.QueryRowContext(ctx, `SELECT id, name FROM categories WHERE name = $1`, name).Scan(&category.ID, &category.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("category with name %s: %w", name, ErrCategoryNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("retrieve category failed: %w", err)
+	}
+	return &category, nil
+}
+
+// InsertCategory inserts a new category into the repository.
+func (p *postgresItemRepository) InsertCategory(ctx context.Context, name string) (*Category, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int
+	if err := tx.QueryRowContext(ctx, `INSERT INTO categories (name) VALUES ($1) RETURNING id`, name).Scan(&id); err != nil {
+		return nil, fmt.Errorf("insert category failed: %w", err)
+	}
+	category := &Category{ID: id, Name: name}
+	if err := recordChange(ctx, tx, postgresPlaceholder, AuditCreate, AuditEntityCategory, id, nil, category); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("insert category failed: %w", err)
+	}
+	return category, nil
+}
+
+// ImageReferences counts the items referencing each image file.
+func (p *postgresItemRepository) ImageReferences(ctx context.Context) (map[string]int, error) {
+	rows, err := p.db.QueryContext(ctx, `
+        SELECT image_name, COUNT(*) FROM (
+            SELECT image_name FROM items WHERE image_name IS NOT NULL
+            UNION ALL
+            SELECT avatar_name FROM profiles WHERE avatar_name IS NOT NULL
+        ) refs GROUP BY image_name
+    `)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count image references: %w", err)
+	}
+	defer rows.Close()
+
+	refs := make(map[string]int)
+	for rows.Next() {
+		var name string
+		var count int
+		if err := rows.Scan(&name, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan image reference: %w", err)
+		}
+		refs[name] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to count image references: %w", err)
+	}
+	return refs, nil
+}
+
+// ImageHashes returns the image hashes of the items not in the trash.
+func (p *postgresItemRepository) ImageHashes(ctx context.Context) ([]ImageHash, error) {
+	return selectImageHashes(ctx, p.db)
+}
+
+// postgresPlaceholder is the n-th bind parameter of PostgreSQL.
+func postgresPlaceholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// Find returns the items matching q.
+func (p *postgresItemRepository) Find(ctx context.Context, q ItemQuery) ([]*Item, error) {
+	where, args := q.where("ILIKE", postgresPlaceholder)
+	query := `
+        SELECT ` + itemColumns + `
+        FROM items i
+        JOIN categories c ON i.category_id = c.id
+        ` + where + `
+        ORDER BY i.id
+    `
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find items: %w", err)
+	}
+	defer rows.Close()
+
+	return scanItems(rows)
+}
+
+// Delete moves an item to the trash.
+func (p *postgresItemRepository) Delete(ctx context.Context, id, version int) error {
+	return setItemDeleted(ctx, p.db, postgresPlaceholder, id, version, true)
+}
+
+// Restore takes an item out of the trash.
+func (p *postgresItemRepository) Restore(ctx context.Context, id, version int) error {
+	return setItemDeleted(ctx, p.db, postgresPlaceholder, id, version, false)
+}
+
+// Purge removes items deleted before deletedBefore for good.
+func (p *postgresItemRepository) Purge(ctx context.Context, deletedBefore time.Time) ([]*Item, error) {
+	return purgeItems(ctx, p.db, postgresPlaceholder, deletedBefore)
+}
+
+// ListAudit returns the audit entries matching q, newest first.
+func (p *postgresItemRepository) ListAudit(ctx context.Context, q AuditQuery) ([]*AuditEntry, error) {
+	return listAudit(ctx, p.db, postgresPlaceholder, q)
+}
+
+// PendingEvents returns undelivered events due at now.
+func (p *postgresItemRepository) PendingEvents(ctx context.Context, now time.Time, limit int) ([]*Event, error) {
+	return pendingEvents(ctx, p.db, postgresPlaceholder, now, limit)
+}
+
+// EventsAfter returns the events after afterID, delivered or not.
+func (p *postgresItemRepository) EventsAfter(ctx context.Context, afterID int64, limit int) ([]*Event, error) {
+	return eventsAfter(ctx, p.db, postgresPlaceholder, afterID, limit)
+}
+
+// MarkEventDelivered records that an event reached every sink.
+func (p *postgresItemRepository) MarkEventDelivered(ctx context.Context, id int64) error {
+	return markEventDelivered(ctx, p.db, postgresPlaceholder, id)
+}
+
+// MarkEventFailed records a failed delivery attempt.
+func (p *postgresItemRepository) MarkEventFailed(ctx context.Context, id int64, next time.Time, reason string) error {
+	return markEventFailed(ctx, p.db, postgresPlaceholder, id, next, reason)
+}
+
+// PruneEvents deletes delivered events.
+func (p *postgresItemRepository) PruneEvents(ctx context.Context, deliveredBefore time.Time) (int, error) {
+	return pruneEvents(ctx, p.db, postgresPlaceholder, deliveredBefore)
+}
@@ -0,0 +1,521 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrJobNotFound = errors.New("job not found")
+	// ErrDuplicateJob is returned by EnqueueJob when an unfinished job has the same unique key.
+	ErrDuplicateJob = errors.New("job with the same unique key is already queued")
+	// ErrJobNotFailed is returned by RetryJob for jobs which have not failed.
+	ErrJobNotFailed = errors.New("only failed jobs can be retried")
+	// ErrJobLeaseLost is returned by FinishJob when the job was claimed again after its
+	// visibility timeout ran out; the other worker's outcome wins.
+	ErrJobLeaseLost = errors.New("job has been claimed by another worker")
+	// ErrJobPermanent marks handler errors which retrying cannot fix. Wrap it with %w to fail
+	// the job without using up its attempts.
+	ErrJobPermanent = errors.New("job cannot succeed")
+)
+
+// Statuses of a Job.
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// DefaultJobMaxAttempts is how many times a job is tried before it fails, unless it was
+// enqueued with its own JobOptions.MaxAttempts.
+const DefaultJobMaxAttempts = 5
+
+const (
+	defaultJobLimit = 50
+	maxJobLimit     = 200
+)
+
+// Job is a unit of background work queued in the database.
+type Job struct {
+	ID      int64           `json:"id"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+	// UniqueKey keeps a second job with the same key from being queued until this one finishes.
+	UniqueKey   string `json:"unique_key,omitempty"`
+	Status      string `json:"status"`
+	Attempts    int    `json:"attempts"`
+	MaxAttempts int    `json:"max_attempts"`
+	// RunAt is when the job is run next.
+	RunAt time.Time `json:"run_at"`
+	// LockedUntil is when a running job becomes visible to other workers again, in case the
+	// worker running it died.
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+// finished reports whether the job will not run again unless it is retried by an admin.
+func (j *Job) finished() bool {
+	return j.Status == JobSucceeded || j.Status == JobFailed
+}
+
+// runnable reports whether j can be claimed at now.
+func (j *Job) runnable(now time.Time) bool {
+	switch j.Status {
+	case JobPending:
+		return !j.RunAt.After(now)
+	case JobRunning:
+		return j.LockedUntil != nil && !j.LockedUntil.After(now)
+	}
+	return false
+}
+
+// JobQuery filters GET /admin/jobs . Jobs are returned newest first.
+type JobQuery struct {
+	Status string
+	Type   string
+	// BeforeID returns the jobs older than this ID, for paging.
+	BeforeID int64
+	Limit    int
+}
+
+// JobRepository stores the background job queue.
+type JobRepository interface {
+	// EnqueueJob inserts j and sets its ID and CreatedAt. It returns ErrDuplicateJob when an
+	// unfinished job has the same UniqueKey.
+	EnqueueJob(ctx context.Context, j *Job) error
+	// ClaimJobs marks up to limit runnable jobs of the given types as running until now+lease
+	// and returns them with their attempt counted. Pending jobs due at now and running jobs
+	// whose lease ran out are runnable. A job is claimed by one worker even across processes.
+	ClaimJobs(ctx context.Context, types []string, now time.Time, lease time.Duration, limit int) ([]*Job, error)
+	// FinishJob saves the outcome of the attempt j was claimed for, and releases the unique key
+	// of finished jobs.
+	FinishJob(ctx context.Context, j *Job) error
+	GetJob(ctx context.Context, id int64) (*Job, error)
+	ListJobs(ctx context.Context, q JobQuery) ([]*Job, error)
+	// RetryJob queues a failed job again at now with a fresh set of attempts.
+	RetryJob(ctx context.Context, id int64, now time.Time) error
+}
+
+// JobOptions are the optional settings of an enqueued job.
+type JobOptions struct {
+	// RunAt delays the job until then. Zero runs it right away.
+	RunAt time.Time
+	// UniqueKey, when set, keeps the job from being queued twice; see Job.UniqueKey.
+	UniqueKey string
+	// MaxAttempts defaults to DefaultJobMaxAttempts.
+	MaxAttempts int
+}
+
+// JobType names a kind of job whose payload is T, so that enqueuers and the handler agree on it.
+type JobType[T any] string
+
+// Enqueue queues a job of type t with payload.
+func (t JobType[T]) Enqueue(ctx context.Context, repo JobRepository, payload T, opts JobOptions) (*Job, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s job: %w", t, err)
+	}
+	now := time.Now().UTC()
+	if opts.RunAt.IsZero() {
+		opts.RunAt = now
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultJobMaxAttempts
+	}
+	j := &Job{
+		Type:        string(t),
+		Payload:     body,
+		UniqueKey:   opts.UniqueKey,
+		Status:      JobPending,
+		MaxAttempts: opts.MaxAttempts,
+		RunAt:       opts.RunAt.UTC(),
+		CreatedAt:   now,
+	}
+	if err := repo.EnqueueJob(ctx, j); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// HandleJob registers fn to run the jobs of type t. Jobs whose payload is not a T fail
+// without being retried.
+func HandleJob[T any](w *JobWorker, t JobType[T], fn func(ctx context.Context, payload T) error) {
+	w.handle(string(t), func(ctx context.Context, payload json.RawMessage) error {
+		var v T
+		if err := json.Unmarshal(payload, &v); err != nil {
+			return fmt.Errorf("failed to decode payload: %w: %w", err, ErrJobPermanent)
+		}
+		return fn(ctx, v)
+	})
+}
+
+// HandleEvery registers fn as a job of type t run every interval, at the multiples of interval
+// since the zero time. Every server schedules the same run times under the same unique keys,
+// so each run happens once however many servers share the queue. Run schedules the first one.
+func HandleEvery(w *JobWorker, t JobType[struct{}], interval time.Duration, fn func(ctx context.Context) error) {
+	w.periodic[string(t)] = interval
+	HandleJob(w, t, func(ctx context.Context, _ struct{}) error {
+		// 失敗しても次の回は予定どおり動かす
+		if err := w.scheduleNext(ctx, string(t), interval); err != nil {
+			return err
+		}
+		return fn(ctx)
+	})
+}
+
+// HandlePoll registers fn to be called every interval by the worker itself, without queueing
+// jobs. It suits work polled every second, such as the outbox and the delivery queues, which
+// keeps its own retry state per row and would otherwise add a job row per poll. Like jobs, a
+// running call is not cancelled by shutdown and Run waits for it.
+func HandlePoll(w *JobWorker, name string, interval time.Duration, fn func(ctx context.Context) error) {
+	w.pollers = append(w.pollers, poller{name: name, interval: interval, fn: fn})
+}
+
+// poller is a function registered with HandlePoll.
+type poller struct {
+	name     string
+	interval time.Duration
+	fn       func(ctx context.Context) error
+}
+
+// JobWorkerOptions configures JobWorker. Zero fields use the defaults.
+type JobWorkerOptions struct {
+	// Concurrency is how many jobs run at once. It defaults to four.
+	Concurrency int
+	// PollInterval is how often runnable jobs are claimed. It defaults to one second.
+	PollInterval time.Duration
+	// VisibilityTimeout is how long a job may run. Jobs are cancelled when it runs out, and
+	// jobs left running by a crashed worker are run again after it. It defaults to five minutes.
+	VisibilityTimeout time.Duration
+	// RetryBase is the delay before the first retry, doubled on every further failure
+	// up to RetryMax. They default to ten seconds and one hour.
+	RetryBase time.Duration
+	RetryMax  time.Duration
+	// Now returns the current time. time.Now is used when nil.
+	Now func() time.Time
+}
+
+// JobWorker runs the queued jobs of the types registered with HandleJob on a pool of goroutines.
+// Failed jobs are retried with exponential backoff until they run out of attempts.
+type JobWorker struct {
+	repo     JobRepository
+	opts     JobWorkerOptions
+	handlers map[string]func(ctx context.Context, payload json.RawMessage) error
+	periodic map[string]time.Duration
+	pollers  []poller
+}
+
+// NewJobWorker returns a worker running the jobs in repo.
+func NewJobWorker(repo JobRepository, opts JobWorkerOptions) *JobWorker {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 4
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.VisibilityTimeout <= 0 {
+		opts.VisibilityTimeout = 5 * time.Minute
+	}
+	if opts.RetryBase <= 0 {
+		opts.RetryBase = 10 * time.Second
+	}
+	if opts.RetryMax <= 0 {
+		opts.RetryMax = time.Hour
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &JobWorker{
+		repo:     repo,
+		opts:     opts,
+		handlers: make(map[string]func(context.Context, json.RawMessage) error),
+		periodic: make(map[string]time.Duration),
+	}
+}
+
+func (w *JobWorker) handle(typ string, fn func(ctx context.Context, payload json.RawMessage) error) {
+	w.handlers[typ] = fn
+}
+
+// types returns the job types the worker can run. Other processes may run other types.
+func (w *JobWorker) types() []string {
+	types := make([]string, 0, len(w.handlers))
+	for typ := range w.handlers {
+		types = append(types, typ)
+	}
+	slices.Sort(types)
+	return types
+}
+
+// scheduleNext queues the run of a periodic job at the next multiple of interval.
+func (w *JobWorker) scheduleNext(ctx context.Context, typ string, interval time.Duration) error {
+	runAt := w.opts.Now().UTC().Truncate(interval).Add(interval)
+	_, err := JobType[struct{}](typ).Enqueue(ctx, w.repo, struct{}{}, JobOptions{
+		RunAt:     runAt,
+		UniqueKey: typ + ":" + strconv.FormatInt(runAt.Unix(), 10),
+	})
+	if err != nil && !errors.Is(err, ErrDuplicateJob) {
+		return err
+	}
+	return nil
+}
+
+// WorkOnce runs the jobs due now, one after another, and returns how many succeeded and failed.
+func (w *JobWorker) WorkOnce(ctx context.Context) (succeeded, failed int, err error) {
+	jobs, err := w.repo.ClaimJobs(ctx, w.types(), w.opts.Now(), w.opts.VisibilityTimeout, w.opts.Concurrency)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, j := range jobs {
+		if err := w.process(ctx, j); err != nil {
+			return succeeded, failed, err
+		}
+		if j.Status == JobSucceeded {
+			succeeded++
+		} else {
+			failed++
+		}
+	}
+	return succeeded, failed, nil
+}
+
+// process runs a claimed job and saves the outcome.
+func (w *JobWorker) process(ctx context.Context, j *Job) error {
+	var err error
+	if j.Attempts > j.MaxAttempts {
+		// 最後の試行中にワーカーが落ち、可視性タイムアウトで拾い直された
+		err = errors.New("job did not finish within the visibility timeout")
+	} else {
+		err = w.run(ctx, j)
+	}
+
+	now := w.opts.Now().UTC()
+	j.LockedUntil = nil
+	if err == nil {
+		j.Status, j.LastError, j.FinishedAt = JobSucceeded, "", &now
+	} else {
+		j.LastError = err.Error()
+		if j.Attempts >= j.MaxAttempts || errors.Is(err, ErrJobPermanent) {
+			j.Status, j.FinishedAt = JobFailed, &now
+			slog.Warn("job failed", "job_id", j.ID, "type", j.Type, "attempts", j.Attempts, "error", err)
+		} else {
+			j.Status, j.RunAt = JobPending, now.Add(backoff(w.opts.RetryBase, w.opts.RetryMax, j.Attempts-1))
+		}
+	}
+	if err := w.repo.FinishJob(ctx, j); err != nil {
+		if errors.Is(err, ErrJobLeaseLost) {
+			slog.Warn("job outlived its visibility timeout", "job_id", j.ID, "type", j.Type)
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// run calls the handler of j within the visibility timeout. A panicking handler fails the attempt.
+func (w *JobWorker) run(ctx context.Context, j *Job) (err error) {
+	fn, ok := w.handlers[j.Type]
+	if !ok {
+		return fmt.Errorf("no handler for job type %q: %w", j.Type, ErrJobPermanent)
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("job panicked: %v", v)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(ctx, w.opts.VisibilityTimeout)
+	defer cancel()
+	return fn(ctx, j.Payload)
+}
+
+// poll calls p every p.interval until ctx is done, within the visibility timeout.
+func (w *JobWorker) poll(ctx context.Context, p poller) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		runCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), w.opts.VisibilityTimeout)
+		if err := p.fn(runCtx); err != nil {
+			slog.Error("poll failed", "name", p.name, "error", err)
+		}
+		cancel()
+	}
+}
+
+// Run schedules the periodic jobs and runs jobs and pollers until ctx is done. It then stops
+// claiming jobs and returns once the running jobs and polls have finished; they are not
+// cancelled by ctx.
+func (w *JobWorker) Run(ctx context.Context) {
+	for typ, interval := range w.periodic {
+		if err := w.scheduleNext(ctx, typ, interval); err != nil {
+			slog.Error("failed to schedule job", "type", typ, "error", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for _, p := range w.pollers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.poll(ctx, p)
+		}()
+	}
+	// slots に空きがある分だけジョブを取る
+	slots := make(chan struct{}, w.opts.Concurrency)
+	ticker := time.NewTicker(w.opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		free := w.opts.Concurrency - len(slots)
+		if free == 0 {
+			continue
+		}
+		jobs, err := w.repo.ClaimJobs(ctx, w.types(), w.opts.Now(), w.opts.VisibilityTimeout, free)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("failed to claim jobs", "error", err)
+			}
+			continue
+		}
+		for _, j := range jobs {
+			slots <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-slots }()
+				// シャットダウン中も実行中のジョブは最後まで動かす
+				if err := w.process(context.WithoutCancel(ctx), j); err != nil {
+					slog.Error("failed to save job", "job_id", j.ID, "error", err)
+				}
+			}()
+		}
+	}
+}
+
+// GetJobs is a handler to return background jobs for GET /admin/jobs .
+// Filters: status, type, before_id and limit.
+func (h *Handlers) GetJobs(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r.Context()) {
+		http.Error(w, "only admins can manage jobs", http.StatusForbidden)
+		return
+	}
+
+	v := r.URL.Query()
+	q := JobQuery{Status: v.Get("status"), Type: v.Get("type"), Limit: defaultJobLimit}
+	switch q.Status {
+	case "", JobPending, JobRunning, JobSucceeded, JobFailed:
+	default:
+		http.Error(w, "unknown status", http.StatusBadRequest)
+		return
+	}
+	if s := v.Get("before_id"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, "before_id must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		q.BeforeID = id
+	}
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxJobLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxJobLimit), http.StatusBadRequest)
+			return
+		}
+		q.Limit = n
+	}
+
+	jobs, err := h.jobs.ListJobs(r.Context(), q)
+	if err != nil {
+		http.Error(w, "failed to get jobs", http.StatusInternalServerError)
+		return
+	}
+	if jobs == nil {
+		jobs = []*Job{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"jobs": jobs})
+}
+
+// GetJob is a handler to return a background job for GET /admin/jobs/{id} .
+func (h *Handlers) GetJob(w http.ResponseWriter, r *http.Request) {
+	j, ok := h.jobFor(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(j)
+}
+
+// RetryJob is a handler to queue a failed job again right away with a fresh set of attempts for
+// POST /admin/jobs/{id}/retry .
+func (h *Handlers) RetryJob(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	j, ok := h.jobFor(w, r)
+	if !ok {
+		return
+	}
+	if err := h.jobs.RetryJob(ctx, j.ID, time.Now()); err != nil {
+		switch {
+		case errors.Is(err, ErrJobNotFailed):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, ErrJobNotFound):
+			http.Error(w, "job not found", http.StatusNotFound)
+		default:
+			slog.Error("failed to retry job", "job_id", j.ID, "error", err)
+			http.Error(w, "failed to retry job", http.StatusInternalServerError)
+		}
+		return
+	}
+	j, err := h.jobs.GetJob(ctx, j.ID)
+	if err != nil {
+		http.Error(w, "failed to get job", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(j)
+}
+
+// jobFor returns the job in the path for admins, or writes the error response.
+func (h *Handlers) jobFor(w http.ResponseWriter, r *http.Request) (*Job, bool) {
+	if !h.isAdmin(r.Context()) {
+		http.Error(w, "only admins can manage jobs", http.StatusForbidden)
+		return nil, false
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		return nil, false
+	}
+	j, err := h.jobs.GetJob(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, ErrJobNotFound) {
+			http.Error(w, "job not found", http.StatusNotFound)
+			return nil, false
+		}
+		http.Error(w, "failed to get job", http.StatusInternalServerError)
+		return nil, false
+	}
+	return j, true
+}
@@ -0,0 +1,373 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sqlJobRepository stores the job queue in SQLite or PostgreSQL. placeholder is the dialect's bind parameter.
+type sqlJobRepository struct {
+	db          *sql.DB
+	placeholder func(int) string
+}
+
+// NewJobRepository returns a JobRepository using the database opened by OpenDatabase with dsn.
+func NewJobRepository(db *sql.DB, dsn string) JobRepository {
+	d, _ := parseDSN(dsn)
+	return &sqlJobRepository{db: db, placeholder: d.placeholder()}
+}
+
+const jobColumns = `id, type, payload, unique_key, status, attempts, max_attempts, run_at, locked_until, last_error, created_at, finished_at`
+
+func scanJob(row rowScanner) (*Job, error) {
+	var j Job
+	var payload string
+	var uniqueKey sql.NullString
+	var lockedUntil, finishedAt sql.NullTime
+	if err := row.Scan(&j.ID, &j.Type, &payload, &uniqueKey, &j.Status, &j.Attempts, &j.MaxAttempts,
+		&j.RunAt, &lockedUntil, &j.LastError, &j.CreatedAt, &finishedAt); err != nil {
+		return nil, err
+	}
+	j.Payload = []byte(payload)
+	j.UniqueKey = uniqueKey.String
+	if lockedUntil.Valid {
+		j.LockedUntil = &lockedUntil.Time
+	}
+	if finishedAt.Valid {
+		j.FinishedAt = &finishedAt.Time
+	}
+	return &j, nil
+}
+
+// EnqueueJob inserts j unless an unfinished job has its unique key.
+func (r *sqlJobRepository) EnqueueJob(ctx context.Context, j *Job) error {
+	query := `INSERT INTO jobs (type, payload, unique_key, status, attempts, max_attempts, run_at, created_at)
+        VALUES (` + params(r.placeholder, 8) + `) ON CONFLICT (unique_key) DO NOTHING RETURNING id`
+	uniqueKey := sql.NullString{String: j.UniqueKey, Valid: j.UniqueKey != ""}
+	err := r.db.QueryRowContext(ctx, query, j.Type, string(j.Payload), uniqueKey, j.Status, j.Attempts, j.MaxAttempts,
+		j.RunAt.UTC(), j.CreatedAt.UTC()).Scan(&j.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrDuplicateJob
+	}
+	if err != nil {
+		return fmt.Errorf("failed to enqueue job: %w", err)
+	}
+	return nil
+}
+
+// runnable returns the condition selecting the jobs which can be claimed at now, and appends
+// its arguments to args.
+func (r *sqlJobRepository) runnable(args *[]any, now time.Time) string {
+	add := func(v any) string {
+		*args = append(*args, v)
+		return r.placeholder(len(*args))
+	}
+	return `((status = ` + add(JobPending) + ` AND run_at <= ` + add(now) + `) OR (status = ` + add(JobRunning) + ` AND locked_until <= ` + add(now) + `))`
+}
+
+// ClaimJobs claims runnable jobs with a conditional UPDATE each, so that a job another worker
+// claimed in the meantime is skipped without locking the table.
+func (r *sqlJobRepository) ClaimJobs(ctx context.Context, types []string, now time.Time, lease time.Duration, limit int) ([]*Job, error) {
+	if len(types) == 0 || limit <= 0 {
+		return nil, nil
+	}
+	now = now.UTC()
+	var args []any
+	for _, t := range types {
+		args = append(args, t)
+	}
+	query := `SELECT id FROM jobs WHERE type IN (` + params(r.placeholder, len(types)) + `) AND ` + r.runnable(&args, now) + `
+        ORDER BY run_at, id LIMIT ` + strconv.Itoa(limit)
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find runnable jobs: %w", err)
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan job: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find runnable jobs: %w", err)
+	}
+
+	var jobs []*Job
+	for _, id := range ids {
+		args := []any{JobRunning, now.Add(lease), id}
+		query := `UPDATE jobs SET status = ` + r.placeholder(1) + `, attempts = attempts + 1, locked_until = ` + r.placeholder(2) + `
+            WHERE id = ` + r.placeholder(3) + ` AND ` + r.runnable(&args, now)
+		result, err := r.db.ExecContext(ctx, query, args...)
+		if err != nil {
+			return jobs, fmt.Errorf("failed to claim job: %w", err)
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			continue
+		}
+		j, err := r.GetJob(ctx, id)
+		if err != nil {
+			return jobs, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+// FinishJob saves the outcome of j's attempt if no other worker has claimed it since.
+func (r *sqlJobRepository) FinishJob(ctx context.Context, j *Job) error {
+	if j.finished() {
+		j.UniqueKey = ""
+	}
+	var finishedAt sql.NullTime
+	if j.FinishedAt != nil {
+		finishedAt = sql.NullTime{Time: j.FinishedAt.UTC(), Valid: true}
+	}
+	query := fmt.Sprintf(`UPDATE jobs SET status = %s, run_at = %s, locked_until = NULL, last_error = %s, finished_at = %s, unique_key = %s
+        WHERE id = %s AND status = %s AND attempts = %s`,
+		r.placeholder(1), r.placeholder(2), r.placeholder(3), r.placeholder(4), r.placeholder(5), r.placeholder(6), r.placeholder(7), r.placeholder(8))
+	result, err := r.db.ExecContext(ctx, query, j.Status, j.RunAt.UTC(), j.LastError, finishedAt,
+		sql.NullString{String: j.UniqueKey, Valid: j.UniqueKey != ""}, j.ID, JobRunning, j.Attempts)
+	if err != nil {
+		return fmt.Errorf("failed to save job: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrJobLeaseLost
+	}
+	return nil
+}
+
+// GetJob returns a job by ID.
+func (r *sqlJobRepository) GetJob(ctx context.Context, id int64) (*Job, error) {
+	j, err := scanJob(r.db.QueryRowContext(ctx, `SELECT `+jobColumns+` FROM jobs WHERE id = `+r.placeholder(1), id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job: %w", err)
+	}
+	return j, nil
+}
+
+// ListJobs returns the jobs matching q, newest first.
+func (r *sqlJobRepository) ListJobs(ctx context.Context, q JobQuery) ([]*Job, error) {
+	var conds []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, cond+" "+r.placeholder(len(args)))
+	}
+	if q.Status != "" {
+		add("status =", q.Status)
+	}
+	if q.Type != "" {
+		add("type =", q.Type)
+	}
+	if q.BeforeID != 0 {
+		add("id <", q.BeforeID)
+	}
+	query := `SELECT ` + jobColumns + ` FROM jobs`
+	if len(conds) > 0 {
+		query += ` WHERE ` + strings.Join(conds, " AND ")
+	}
+	query += ` ORDER BY id DESC`
+	if q.Limit > 0 {
+		query += ` LIMIT ` + strconv.Itoa(q.Limit)
+	}
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []*Job
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan job: %w", err)
+		}
+		jobs = append(jobs, j)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+// RetryJob queues a failed job again at now.
+func (r *sqlJobRepository) RetryJob(ctx context.Context, id int64, now time.Time) error {
+	query := fmt.Sprintf(`UPDATE jobs SET status = %s, attempts = 0, run_at = %s, finished_at = NULL WHERE id = %s AND status = %s`,
+		r.placeholder(1), r.placeholder(2), r.placeholder(3), r.placeholder(4))
+	result, err := r.db.ExecContext(ctx, query, JobPending, now.UTC(), id, JobFailed)
+	if err != nil {
+		return fmt.Errorf("failed to retry job: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		if _, err := r.GetJob(ctx, id); err != nil {
+			return err
+		}
+		return ErrJobNotFailed
+	}
+	return nil
+}
+
+// memoryJobRepository keeps the job queue in memory, for --storage=memory and tests.
+type memoryJobRepository struct {
+	mu     sync.RWMutex
+	jobs   []*Job
+	nextID int64
+}
+
+// NewMemoryJobRepository returns an empty JobRepository kept in memory.
+func NewMemoryJobRepository() JobRepository {
+	return &memoryJobRepository{nextID: 1}
+}
+
+// cloneJob returns a copy of j which shares nothing with it.
+func cloneJob(j *Job) *Job {
+	copied := *j
+	copied.Payload = slices.Clone(j.Payload)
+	if j.LockedUntil != nil {
+		t := *j.LockedUntil
+		copied.LockedUntil = &t
+	}
+	if j.FinishedAt != nil {
+		t := *j.FinishedAt
+		copied.FinishedAt = &t
+	}
+	return &copied
+}
+
+// job returns the stored job with id, or nil.
+func (m *memoryJobRepository) job(id int64) *Job {
+	i, ok := slices.BinarySearchFunc(m.jobs, id, func(j *Job, id int64) int {
+		return int(j.ID - id)
+	})
+	if !ok {
+		return nil
+	}
+	return m.jobs[i]
+}
+
+func (m *memoryJobRepository) EnqueueJob(ctx context.Context, j *Job) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to enqueue job: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if j.UniqueKey != "" && slices.ContainsFunc(m.jobs, func(stored *Job) bool { return stored.UniqueKey == j.UniqueKey }) {
+		return ErrDuplicateJob
+	}
+	j.ID = m.nextID
+	m.nextID++
+	m.jobs = append(m.jobs, cloneJob(j))
+	return nil
+}
+
+func (m *memoryJobRepository) ClaimJobs(ctx context.Context, types []string, now time.Time, lease time.Duration, limit int) ([]*Job, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to claim jobs: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var runnable []*Job
+	for _, j := range m.jobs {
+		if slices.Contains(types, j.Type) && j.runnable(now) {
+			runnable = append(runnable, j)
+		}
+	}
+	slices.SortStableFunc(runnable, func(a, b *Job) int {
+		return a.RunAt.Compare(b.RunAt)
+	})
+	var jobs []*Job
+	lockedUntil := now.Add(lease).UTC()
+	for _, j := range runnable[:min(limit, len(runnable))] {
+		j.Status, j.LockedUntil = JobRunning, &lockedUntil
+		j.Attempts++
+		jobs = append(jobs, cloneJob(j))
+	}
+	return jobs, nil
+}
+
+func (m *memoryJobRepository) FinishJob(ctx context.Context, j *Job) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to save job: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := m.job(j.ID)
+	if stored == nil || stored.Status != JobRunning || stored.Attempts != j.Attempts {
+		return ErrJobLeaseLost
+	}
+	if j.finished() {
+		j.UniqueKey = ""
+	}
+	j.LockedUntil = nil
+	*stored = *cloneJob(j)
+	return nil
+}
+
+func (m *memoryJobRepository) GetJob(ctx context.Context, id int64) (*Job, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get job: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	j := m.job(id)
+	if j == nil {
+		return nil, ErrJobNotFound
+	}
+	return cloneJob(j), nil
+}
+
+func (m *memoryJobRepository) ListJobs(ctx context.Context, q JobQuery) ([]*Job, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var jobs []*Job
+	for _, j := range slices.Backward(m.jobs) {
+		if q.Limit > 0 && len(jobs) == q.Limit {
+			break
+		}
+		if (q.Status != "" && j.Status != q.Status) || (q.Type != "" && j.Type != q.Type) || (q.BeforeID != 0 && j.ID >= q.BeforeID) {
+			continue
+		}
+		jobs = append(jobs, cloneJob(j))
+	}
+	return jobs, nil
+}
+
+func (m *memoryJobRepository) RetryJob(ctx context.Context, id int64, now time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to retry job: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j := m.job(id)
+	if j == nil {
+		return ErrJobNotFound
+	}
+	if j.Status != JobFailed {
+		return ErrJobNotFailed
+	}
+	j.Status, j.Attempts, j.RunAt, j.FinishedAt = JobPending, 0, now.UTC(), nil
+	return nil
+}
@@ -0,0 +1,444 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func jobRepositories() map[string]func(t *testing.T) JobRepository {
+	return map[string]func(t *testing.T) JobRepository{
+		"sqlite": func(t *testing.T) JobRepository {
+			dsn := filepath.Join(t.TempDir(), "test.sqlite3")
+			db, _, err := OpenDatabase(dsn)
+			if err != nil {
+				t.Fatalf("failed to open database: %v", err)
+			}
+			t.Cleanup(func() { db.Close() })
+			return NewJobRepository(db, dsn)
+		},
+		"memory": func(t *testing.T) JobRepository {
+			return NewMemoryJobRepository()
+		},
+	}
+}
+
+type thumbnailPayload struct {
+	ItemID int `json:"item_id"`
+}
+
+const thumbnailJob JobType[thumbnailPayload] = "thumbnail"
+
+func TestJobRepository(t *testing.T) {
+	t.Parallel()
+
+	for name, newRepo := range jobRepositories() {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			repo := newRepo(t)
+			lease := time.Minute
+
+			j, err := thumbnailJob.Enqueue(ctx, repo, thumbnailPayload{ItemID: 1}, JobOptions{UniqueKey: "thumbnail:1"})
+			if err != nil {
+				t.Fatalf("failed to enqueue job: %v", err)
+			}
+			now := time.Now().UTC()
+			if _, err := thumbnailJob.Enqueue(ctx, repo, thumbnailPayload{ItemID: 1}, JobOptions{UniqueKey: "thumbnail:1"}); !errors.Is(err, ErrDuplicateJob) {
+				t.Errorf("expected ErrDuplicateJob, got %v", err)
+			}
+			delayed, err := thumbnailJob.Enqueue(ctx, repo, thumbnailPayload{ItemID: 2}, JobOptions{RunAt: now.Add(time.Hour)})
+			if err != nil {
+				t.Fatalf("failed to enqueue job: %v", err)
+			}
+
+			// 他の種類しか扱わないワーカーには渡さない
+			if got, err := repo.ClaimJobs(ctx, []string{"other"}, now, lease, 10); err != nil || len(got) != 0 {
+				t.Errorf("expected no jobs of other types, got %v, %v", got, err)
+			}
+			got, err := repo.ClaimJobs(ctx, []string{string(thumbnailJob)}, now, lease, 10)
+			if err != nil {
+				t.Fatalf("failed to claim jobs: %v", err)
+			}
+			if len(got) != 1 || got[0].ID != j.ID || got[0].Status != JobRunning || got[0].Attempts != 1 || string(got[0].Payload) != `{"item_id":1}` {
+				t.Fatalf("expected the due job to be claimed, got %+v", got)
+			}
+			if again, err := repo.ClaimJobs(ctx, []string{string(thumbnailJob)}, now, lease, 10); err != nil || len(again) != 0 {
+				t.Errorf("expected a claimed job to be invisible, got %v, %v", again, err)
+			}
+
+			// ワーカーが落ちたら可視性タイムアウトの後に拾い直される
+			reclaimed, err := repo.ClaimJobs(ctx, []string{string(thumbnailJob)}, now.Add(lease), lease, 1)
+			if err != nil {
+				t.Fatalf("failed to claim jobs: %v", err)
+			}
+			if len(reclaimed) != 1 || reclaimed[0].ID != j.ID || reclaimed[0].Attempts != 2 {
+				t.Fatalf("expected the expired job to be claimed again, got %+v", reclaimed)
+			}
+			finished := now.Add(lease)
+			stale := got[0]
+			stale.Status, stale.FinishedAt = JobSucceeded, &finished
+			if err := repo.FinishJob(ctx, stale); !errors.Is(err, ErrJobLeaseLost) {
+				t.Errorf("expected ErrJobLeaseLost for the first claim, got %v", err)
+			}
+			if err := repo.RetryJob(ctx, j.ID, now); !errors.Is(err, ErrJobNotFailed) {
+				t.Errorf("expected ErrJobNotFailed, got %v", err)
+			}
+
+			failed := reclaimed[0]
+			failed.Status, failed.LastError, failed.FinishedAt = JobFailed, "boom", &finished
+			if err := repo.FinishJob(ctx, failed); err != nil {
+				t.Fatalf("failed to finish job: %v", err)
+			}
+			// 終わったジョブは unique key を手放す
+			if _, err := thumbnailJob.Enqueue(ctx, repo, thumbnailPayload{ItemID: 1}, JobOptions{UniqueKey: "thumbnail:1"}); err != nil {
+				t.Errorf("expected the unique key to be released, got %v", err)
+			}
+
+			list, err := repo.ListJobs(ctx, JobQuery{Status: JobFailed})
+			if err != nil {
+				t.Fatalf("failed to list jobs: %v", err)
+			}
+			if len(list) != 1 || list[0].ID != j.ID || list[0].LastError != "boom" || list[0].FinishedAt == nil || list[0].LockedUntil != nil {
+				t.Errorf("expected the failed job, got %+v", list)
+			}
+			if list, err := repo.ListJobs(ctx, JobQuery{BeforeID: delayed.ID + 1, Limit: 1}); err != nil || len(list) != 1 || list[0].ID != delayed.ID {
+				t.Errorf("expected the page before %d, got %+v, %v", delayed.ID+1, list, err)
+			}
+
+			if err := repo.RetryJob(ctx, j.ID, now); err != nil {
+				t.Fatalf("failed to retry job: %v", err)
+			}
+			retried, err := repo.GetJob(ctx, j.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if retried.Status != JobPending || retried.Attempts != 0 || retried.FinishedAt != nil {
+				t.Errorf("expected the job to be pending with fresh attempts, got %+v", retried)
+			}
+			if _, err := repo.GetJob(ctx, 999); !errors.Is(err, ErrJobNotFound) {
+				t.Errorf("expected ErrJobNotFound, got %v", err)
+			}
+			if err := repo.RetryJob(ctx, 999, now); !errors.Is(err, ErrJobNotFound) {
+				t.Errorf("expected ErrJobNotFound, got %v", err)
+			}
+		})
+	}
+}
+
+func TestJobWorker(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		err          error
+		panics       bool
+		wantStatus   string
+		wantAttempts int
+	}{
+		"ok: succeeds":                       {nil, false, JobSucceeded, 1},
+		"ng: retried until the last attempt": {errors.New("temporary"), false, JobFailed, 3},
+		"ng: permanent errors are not retried": {
+			errors.Join(errors.New("item deleted"), ErrJobPermanent), false, JobFailed, 1,
+		},
+		"ng: panics are retried": {nil, true, JobFailed, 3},
+	}
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			repo := NewMemoryJobRepository()
+			now := time.Now()
+			worker := NewJobWorker(repo, JobWorkerOptions{RetryBase: time.Minute, Now: func() time.Time { return now }})
+			var got []int
+			HandleJob(worker, thumbnailJob, func(ctx context.Context, p thumbnailPayload) error {
+				got = append(got, p.ItemID)
+				if tt.panics {
+					panic("unexpected")
+				}
+				return tt.err
+			})
+			j, err := thumbnailJob.Enqueue(ctx, repo, thumbnailPayload{ItemID: 7}, JobOptions{MaxAttempts: 3})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for range 5 {
+				if _, _, err := worker.WorkOnce(ctx); err != nil {
+					t.Fatal(err)
+				}
+				// 次の再実行の時刻まで進める
+				now = now.Add(time.Hour)
+			}
+			j, err = repo.GetJob(ctx, j.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if j.Status != tt.wantStatus || j.Attempts != tt.wantAttempts || len(got) != tt.wantAttempts {
+				t.Errorf("expected %s after %d attempts, got %s after %d attempts with %d calls", tt.wantStatus, tt.wantAttempts, j.Status, j.Attempts, len(got))
+			}
+			if got[0] != 7 {
+				t.Errorf("expected the payload to be decoded, got item %d", got[0])
+			}
+		})
+	}
+}
+
+func TestJobWorkerBackoff(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewMemoryJobRepository()
+	j, err := thumbnailJob.Enqueue(ctx, repo, thumbnailPayload{}, JobOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	worker := NewJobWorker(repo, JobWorkerOptions{RetryBase: time.Minute, Now: func() time.Time { return now }})
+	HandleJob(worker, thumbnailJob, func(ctx context.Context, p thumbnailPayload) error {
+		return errors.New("temporary")
+	})
+
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute} {
+		if succeeded, failed, err := worker.WorkOnce(ctx); err != nil || succeeded != 0 || failed != 1 {
+			t.Fatalf("expected one failure, got %d succeeded, %d failed, %v", succeeded, failed, err)
+		}
+		got, err := repo.GetJob(ctx, j.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != JobPending || !got.RunAt.Equal(now.Add(want)) || got.LastError != "temporary" {
+			t.Errorf("expected a retry in %v, got %+v", want, got)
+		}
+		// 再実行の時刻までは取られない
+		if succeeded, failed, err := worker.WorkOnce(ctx); err != nil || succeeded+failed != 0 {
+			t.Errorf("expected no job before the retry, got %d succeeded, %d failed, %v", succeeded, failed, err)
+		}
+		now = now.Add(want)
+	}
+}
+
+func TestHandleEvery(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewMemoryJobRepository()
+	now := time.Date(2026, 10, 1, 12, 30, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	var runs atomic.Int32
+	const gcJob JobType[struct{}] = "gc"
+	newWorker := func() *JobWorker {
+		w := NewJobWorker(repo, JobWorkerOptions{PollInterval: time.Hour, Now: clock})
+		HandleEvery(w, gcJob, time.Hour, func(ctx context.Context) error {
+			runs.Add(1)
+			return nil
+		})
+		return w
+	}
+
+	// 二台のサーバーが同じ回を予定しても一度しか積まれない
+	for range 2 {
+		runCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		newWorker().Run(runCtx)
+		cancel()
+	}
+	jobs, err := repo.ListJobs(ctx, JobQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || !jobs[0].RunAt.Equal(time.Date(2026, 10, 1, 13, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected one run at the next hour, got %+v", jobs)
+	}
+
+	worker := newWorker()
+	if _, _, err := worker.WorkOnce(ctx); err != nil || runs.Load() != 0 {
+		t.Fatalf("expected no run before the hour, got %d runs, %v", runs.Load(), err)
+	}
+	now = now.Add(30 * time.Minute)
+	if _, _, err := worker.WorkOnce(ctx); err != nil || runs.Load() != 1 {
+		t.Fatalf("expected one run at the hour, got %d runs, %v", runs.Load(), err)
+	}
+	pending, err := repo.ListJobs(ctx, JobQuery{Status: JobPending})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || !pending[0].RunAt.Equal(time.Date(2026, 10, 1, 14, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the next run to be scheduled, got %+v", pending)
+	}
+}
+
+func TestJobWorkerRunWaitsForRunningJobs(t *testing.T) {
+	t.Parallel()
+
+	repo := NewMemoryJobRepository()
+	worker := NewJobWorker(repo, JobWorkerOptions{PollInterval: 10 * time.Millisecond})
+	started, release := make(chan struct{}), make(chan struct{})
+	HandleJob(worker, thumbnailJob, func(ctx context.Context, p thumbnailPayload) error {
+		close(started)
+		<-release
+		// シャットダウンで実行中のジョブは止めない
+		return ctx.Err()
+	})
+	j, err := thumbnailJob.Enqueue(context.Background(), repo, thumbnailPayload{ItemID: 1}, JobOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		worker.Run(ctx)
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job did not start")
+	}
+	cancel()
+	select {
+	case <-done:
+		t.Fatal("Run returned before the running job finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after the job finished")
+	}
+
+	got, err := repo.GetJob(context.Background(), j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != JobSucceeded {
+		t.Errorf("expected the job to succeed, got %+v", got)
+	}
+}
+
+func TestJobWorkerPolls(t *testing.T) {
+	t.Parallel()
+
+	repo := NewMemoryJobRepository()
+	worker := NewJobWorker(repo, JobWorkerOptions{PollInterval: time.Hour})
+	polled, release := make(chan struct{}), make(chan struct{})
+	var calls int
+	HandlePoll(worker, "outbox", 10*time.Millisecond, func(ctx context.Context) error {
+		calls++
+		if calls == 1 {
+			close(polled)
+			<-release
+		}
+		// シャットダウンで実行中の呼び出しは止めない
+		return ctx.Err()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		worker.Run(ctx)
+	}()
+	select {
+	case <-polled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("poller was not called")
+	}
+	cancel()
+	select {
+	case <-done:
+		t.Fatal("Run returned before the running poll finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after the poll finished")
+	}
+
+	// ポーリングはジョブを積まない
+	jobs, err := repo.ListJobs(context.Background(), JobQuery{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 0 {
+		t.Errorf("expected no jobs, got %+v", jobs)
+	}
+}
+
+func TestJobHandlers(t *testing.T) {
+	t.Parallel()
+
+	const admin, user = 1, 2
+	ctx := context.Background()
+	repo := NewMemoryJobRepository()
+	worker := NewJobWorker(repo, JobWorkerOptions{})
+	HandleJob(worker, thumbnailJob, func(ctx context.Context, p thumbnailPayload) error {
+		if p.ItemID == 0 {
+			return ErrJobPermanent
+		}
+		return nil
+	})
+	for _, id := range []int{0, 1} {
+		if _, err := thumbnailJob.Enqueue(ctx, repo, thumbnailPayload{ItemID: id}, JobOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := worker.WorkOnce(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &Handlers{jobs: repo, adminUserIDs: map[int]bool{admin: true}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/jobs", h.GetJobs)
+	mux.HandleFunc("GET /admin/jobs/{id}", h.GetJob)
+	mux.HandleFunc("POST /admin/jobs/{id}/retry", h.RetryJob)
+
+	cases := map[string]struct {
+		method, target string
+		userID         int
+		status         int
+		body           string
+	}{
+		"ok: list failed jobs":     {http.MethodGet, "/admin/jobs?status=failed", admin, http.StatusOK, `"status":"failed"`},
+		"ok: get job":              {http.MethodGet, "/admin/jobs/2", admin, http.StatusOK, `"status":"succeeded"`},
+		"ok: retry failed job":     {http.MethodPost, "/admin/jobs/1/retry", admin, http.StatusAccepted, `"status":"pending"`},
+		"ng: retry succeeded job":  {http.MethodPost, "/admin/jobs/2/retry", admin, http.StatusConflict, ""},
+		"ng: unknown job":          {http.MethodGet, "/admin/jobs/99", admin, http.StatusNotFound, ""},
+		"ng: unknown status":       {http.MethodGet, "/admin/jobs?status=lost", admin, http.StatusBadRequest, ""},
+		"ng: invalid limit":        {http.MethodGet, "/admin/jobs?limit=1000", admin, http.StatusBadRequest, ""},
+		"ng: not an admin":         {http.MethodGet, "/admin/jobs", user, http.StatusForbidden, ""},
+		"ng: retry by a non-admin": {http.MethodPost, "/admin/jobs/1/retry", user, http.StatusForbidden, ""},
+		"ng: anonymous":            {http.MethodGet, "/admin/jobs/1", 0, http.StatusForbidden, ""},
+	}
+	// retry を含むので順番に実行する
+	for _, name := range []string{
+		"ok: list failed jobs", "ok: get job", "ng: retry succeeded job", "ng: unknown job", "ng: unknown status",
+		"ng: invalid limit", "ng: not an admin", "ng: retry by a non-admin", "ng: anonymous", "ok: retry failed job",
+	} {
+		tt := cases[name]
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.userID != 0 {
+				req = req.WithContext(ContextWithUserID(req.Context(), tt.userID))
+			}
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+			if rr.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, rr.Code, rr.Body)
+			}
+			if !strings.Contains(rr.Body.String(), tt.body) {
+				t.Errorf("expected body to contain %s, got %s", tt.body, rr.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Orders of GET /items selected with the sort parameter.
+const (
+	// sortByID lists items in the order they were added. It is the default.
+	sortByID = "id"
+	// sortPopular lists the most liked items first.
+	sortPopular = "popular"
+)
+
+// Like is a user's like of an item, as recorded in the audit log.
+type Like struct {
+	ItemID    int       `json:"item_id"`
+	UserID    int       `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LikeRepository stores the users' likes. Items carry their like count, so it shares the
+// database of the ItemRepository.
+type LikeRepository interface {
+	// Like records that userID likes an item and returns its like count. Liking it again has no
+	// effect. ErrItemNotFound is returned if the item is missing or deleted.
+	Like(ctx context.Context, itemID, userID int) (int, error)
+	// Unlike removes the like of userID, if any, and returns the like count of the item.
+	Unlike(ctx context.Context, itemID, userID int) (int, error)
+	// LikedItems returns the items userID likes, most recently liked first. Deleted items are left out.
+	LikedItems(ctx context.Context, userID int) ([]*Item, error)
+}
+
+// sortItems orders items for the sort parameter of GET /items. Unknown orders are an error.
+func sortItems(items []*Item, order string) error {
+	switch order {
+	case "", sortByID:
+		// リポジトリは ID 順で返す
+		return nil
+	case sortPopular:
+		slices.SortStableFunc(items, func(a, b *Item) int {
+			return cmp.Compare(b.LikeCount, a.LikeCount)
+		})
+		return nil
+	default:
+		return fmt.Errorf("sort must be %s or %s", sortByID, sortPopular)
+	}
+}
+
+// likeItem is the common part of LikeItem and UnlikeItem.
+func (h *Handlers) likeItem(w http.ResponseWriter, r *http.Request, liked bool) {
+	ctx := r.Context()
+	itemID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		return
+	}
+	userID, signedIn := userIDFromContext(ctx)
+	if !signedIn {
+		http.Error(w, "sign in to like items", http.StatusUnauthorized)
+		return
+	}
+
+	var count int
+	if liked {
+		count, err = h.likes.Like(ctx, itemID, userID)
+	} else {
+		count, err = h.likes.Unlike(ctx, itemID, userID)
+	}
+	if err != nil {
+		if errors.Is(err, ErrItemNotFound) {
+			http.Error(w, "Item not found", http.StatusNotFound)
+			return
+		}
+		slog.Error("failed to update like", "item_id", itemID, "error", err)
+		http.Error(w, "failed to update like", http.StatusInternalServerError)
+		return
+	}
+	itemChanged(h.itemRepo, itemID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"liked": liked, "like_count": count})
+}
+
+// LikeItem is a handler to like an item for POST /items/{id}/like . Liking twice is allowed.
+func (h *Handlers) LikeItem(w http.ResponseWriter, r *http.Request) {
+	h.likeItem(w, r, true)
+}
+
+// UnlikeItem is a handler to remove a like for DELETE /items/{id}/like .
+func (h *Handlers) UnlikeItem(w http.ResponseWriter, r *http.Request) {
+	h.likeItem(w, r, false)
+}
+
+// GetMyLikes is a handler to return the items the signed-in user likes, most recently liked
+// first, for GET /me/likes .
+func (h *Handlers) GetMyLikes(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, signedIn := userIDFromContext(ctx)
+	if !signedIn {
+		http.Error(w, "sign in to see your likes", http.StatusUnauthorized)
+		return
+	}
+
+	items, err := h.likes.LikedItems(ctx, userID)
+	if err != nil {
+		http.Error(w, "failed to get liked items", http.StatusInternalServerError)
+		return
+	}
+	if items == nil {
+		items = []*Item{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+}
@@ -0,0 +1,194 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// sqlLikeRepository stores likes in SQLite or PostgreSQL. placeholder is the dialect's bind parameter.
+type sqlLikeRepository struct {
+	db          *sql.DB
+	placeholder func(int) string
+}
+
+// NewLikeRepository returns a LikeRepository using the database opened by OpenDatabase with dsn.
+func NewLikeRepository(db *sql.DB, dsn string) LikeRepository {
+	d, _ := parseDSN(dsn)
+	return &sqlLikeRepository{db: db, placeholder: d.placeholder()}
+}
+
+// setLike adds or removes the like of userID and returns the like count of the item.
+// The like and the count are written in one transaction, and the primary key of likes makes
+// concurrent likes by the same user count once.
+func (r *sqlLikeRepository) setLike(ctx context.Context, itemID, userID int, liked bool) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var count int
+	err = tx.QueryRowContext(ctx, `SELECT like_count FROM items WHERE id = `+r.placeholder(1)+` AND deleted_at IS NULL`, itemID).Scan(&count)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrItemNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to update like: %w", err)
+	}
+
+	like := Like{ItemID: itemID, UserID: userID, CreatedAt: time.Now().UTC()}
+	var result sql.Result
+	delta := 1
+	if liked {
+		query := `INSERT INTO likes (item_id, user_id, created_at) VALUES (` + params(r.placeholder, 3) + `)
+            ON CONFLICT (item_id, user_id) DO NOTHING`
+		result, err = tx.ExecContext(ctx, query, itemID, userID, like.CreatedAt)
+	} else {
+		// 監査ログに残すため、いいねした時刻を読んでから消す
+		query := `SELECT created_at FROM likes WHERE item_id = ` + r.placeholder(1) + ` AND user_id = ` + r.placeholder(2)
+		err = tx.QueryRowContext(ctx, query, itemID, userID).Scan(&like.CreatedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return count, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to update like: %w", err)
+		}
+		result, err = tx.ExecContext(ctx, `DELETE FROM likes WHERE item_id = `+r.placeholder(1)+` AND user_id = `+r.placeholder(2), itemID, userID)
+		delta = -1
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to update like: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return 0, fmt.Errorf("failed to update like: %w", err)
+	} else if n == 0 {
+		return count, nil
+	}
+
+	query := `UPDATE items SET like_count = like_count + ` + r.placeholder(1) + ` WHERE id = ` + r.placeholder(2) + ` RETURNING like_count`
+	if err := tx.QueryRowContext(ctx, query, delta, itemID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to update like count: %w", err)
+	}
+	action, before, after := AuditCreate, any(nil), any(like)
+	if !liked {
+		action, before, after = AuditDelete, like, nil
+	}
+	if err := recordChange(ctx, tx, r.placeholder, action, AuditEntityLike, itemID, before, after); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to update like: %w", err)
+	}
+	return count, nil
+}
+
+// Like records that userID likes an item.
+func (r *sqlLikeRepository) Like(ctx context.Context, itemID, userID int) (int, error) {
+	return r.setLike(ctx, itemID, userID, true)
+}
+
+// Unlike removes the like of userID.
+func (r *sqlLikeRepository) Unlike(ctx context.Context, itemID, userID int) (int, error) {
+	return r.setLike(ctx, itemID, userID, false)
+}
+
+// LikedItems returns the visible items userID likes, most recently liked first.
+func (r *sqlLikeRepository) LikedItems(ctx context.Context, userID int) ([]*Item, error) {
+	query := `
+        SELECT ` + itemColumns + `
+        FROM likes l
+        JOIN items i ON l.item_id = i.id
+        JOIN categories c ON i.category_id = c.id
+        WHERE l.user_id = ` + r.placeholder(1) + ` AND i.deleted_at IS NULL
+        ORDER BY l.created_at DESC, i.id
+    `
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve liked items: %w", err)
+	}
+	defer rows.Close()
+
+	return scanItems(rows)
+}
+
+// memoryLikeRepository keeps likes in the memory store of a memoryItemRepository, whose items
+// carry their like count.
+type memoryLikeRepository struct {
+	*memoryItemRepository
+}
+
+// NewMemoryLikeRepository returns the LikeRepository sharing the memory store of items,
+// which must be returned by NewMemoryItemRepository.
+func NewMemoryLikeRepository(items ItemRepository) LikeRepository {
+	return memoryLikeRepository{items.(*memoryItemRepository)}
+}
+
+// setLike adds or removes the like of userID and keeps the like count of the item with it.
+func (m memoryLikeRepository) setLike(ctx context.Context, itemID, userID int, liked bool) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("failed to update like: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	item, ok := m.items[itemID]
+	if !ok || item.DeletedAt != nil {
+		return 0, ErrItemNotFound
+	}
+	likedAt, exists := m.likes[itemID][userID]
+	if exists == liked {
+		return item.LikeCount, nil
+	}
+	if liked {
+		like := Like{ItemID: itemID, UserID: userID, CreatedAt: time.Now().UTC()}
+		if err := m.recordChange(ctx, AuditCreate, AuditEntityLike, itemID, nil, like); err != nil {
+			return 0, err
+		}
+		if m.likes[itemID] == nil {
+			m.likes[itemID] = make(map[int]time.Time)
+		}
+		m.likes[itemID][userID] = like.CreatedAt
+		item.LikeCount++
+	} else {
+		like := Like{ItemID: itemID, UserID: userID, CreatedAt: likedAt}
+		if err := m.recordChange(ctx, AuditDelete, AuditEntityLike, itemID, like, nil); err != nil {
+			return 0, err
+		}
+		delete(m.likes[itemID], userID)
+		item.LikeCount--
+	}
+	m.items[itemID] = item
+	return item.LikeCount, nil
+}
+
+// Like records that userID likes an item.
+func (m memoryLikeRepository) Like(ctx context.Context, itemID, userID int) (int, error) {
+	return m.setLike(ctx, itemID, userID, true)
+}
+
+// Unlike removes the like of userID.
+func (m memoryLikeRepository) Unlike(ctx context.Context, itemID, userID int) (int, error) {
+	return m.setLike(ctx, itemID, userID, false)
+}
+
+// LikedItems returns the items userID likes, most recently liked first.
+func (m memoryLikeRepository) LikedItems(ctx context.Context, userID int) ([]*Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve liked items: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	items := m.sorted(func(item Item) bool {
+		_, ok := m.likes[item.ID][userID]
+		return ok && item.DeletedAt == nil
+	})
+	slices.SortStableFunc(items, func(a, b *Item) int {
+		return m.likes[b.ID][userID].Compare(m.likes[a.ID][userID])
+	})
+	return items, nil
+}
@@ -0,0 +1,163 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLikeHandlers(t *testing.T) {
+	t.Parallel()
+
+	type step struct {
+		method string
+		path   string
+		userID int
+		status int
+		// want is the body expected for successful likes.
+		want string
+	}
+
+	cases := map[string][]step{
+		"ok: like, like again and unlike": {
+			{http.MethodPost, "/items/1/like", 1, http.StatusOK, `{"like_count":1,"liked":true}`},
+			{http.MethodPost, "/items/1/like", 1, http.StatusOK, `{"like_count":1,"liked":true}`},
+			{http.MethodPost, "/items/1/like", 2, http.StatusOK, `{"like_count":2,"liked":true}`},
+			{http.MethodDelete, "/items/1/like", 1, http.StatusOK, `{"like_count":1,"liked":false}`},
+			{http.MethodDelete, "/items/1/like", 1, http.StatusOK, `{"like_count":1,"liked":false}`},
+			{http.MethodGet, "/me/likes", 2, http.StatusOK, ""},
+		},
+		"ng: not signed in": {
+			{http.MethodPost, "/items/1/like", 0, http.StatusUnauthorized, ""},
+			{http.MethodDelete, "/items/1/like", 0, http.StatusUnauthorized, ""},
+			{http.MethodGet, "/me/likes", 0, http.StatusUnauthorized, ""},
+		},
+		"ng: missing items": {
+			{http.MethodPost, "/items/99/like", 1, http.StatusNotFound, ""},
+			{http.MethodPost, "/items/abc/like", 1, http.StatusBadRequest, ""},
+		},
+	}
+
+	for name, steps := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			repo := NewMemoryItemRepository()
+			if err := repo.Insert(context.Background(), &Item{Name: "jacket", Category: "fashion"}); err != nil {
+				t.Fatal(err)
+			}
+			h := &Handlers{itemRepo: repo, likes: NewMemoryLikeRepository(repo)}
+			mux := http.NewServeMux()
+			mux.HandleFunc("POST /items/{id}/like", h.LikeItem)
+			mux.HandleFunc("DELETE /items/{id}/like", h.UnlikeItem)
+			mux.HandleFunc("GET /me/likes", h.GetMyLikes)
+
+			for _, s := range steps {
+				req := httptest.NewRequest(s.method, s.path, nil)
+				if s.userID != 0 {
+					req = req.WithContext(ContextWithUserID(req.Context(), s.userID))
+				}
+				rr := httptest.NewRecorder()
+				mux.ServeHTTP(rr, req)
+				if rr.Code != s.status {
+					t.Fatalf("%s %s: expected status %d, got %d: %s", s.method, s.path, s.status, rr.Code, rr.Body)
+				}
+				if s.want != "" {
+					if diff := cmp.Diff(s.want+"\n", rr.Body.String()); diff != "" {
+						t.Errorf("%s %s: unexpected body (-want +got):\n%s", s.method, s.path, diff)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteItemAfterLike(t *testing.T) {
+	t.Parallel()
+
+	const seller = 1
+	ctx := context.Background()
+	repo := NewMemoryItemRepository()
+	if err := repo.Insert(ctx, &Item{Name: "jacket", Category: "fashion", SellerID: seller}); err != nil {
+		t.Fatal(err)
+	}
+	h := &Handlers{itemRepo: repo, likes: NewMemoryLikeRepository(repo), offers: NewMemoryOfferRepository(repo)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/{id}", h.GetItem)
+	mux.HandleFunc("DELETE /items/{id}", h.DeleteItem)
+	get := httptest.NewRecorder()
+	mux.ServeHTTP(get, httptest.NewRequest(http.MethodGet, "/items/1", nil))
+	if _, err := h.likes.Like(ctx, 1, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// 他のユーザーのいいねでは商品は変わっていないので、出品者の削除は失敗しない
+	req := httptest.NewRequest(http.MethodDelete, "/items/1", nil)
+	req.Header.Set("If-Match", get.Header().Get("ETag"))
+	req = req.WithContext(ContextWithUserID(req.Context(), seller))
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusNoContent, rr.Code, rr.Body)
+	}
+}
+
+func TestGetItemsSortedByPopularity(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewMemoryItemRepository()
+	for _, name := range []string{"jacket", "coat", "hat"} {
+		if err := repo.Insert(ctx, &Item{Name: name, Category: "fashion"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	likes := NewMemoryLikeRepository(repo)
+	for _, like := range []struct{ itemID, userID int }{{2, 1}, {2, 2}, {3, 1}} {
+		if _, err := likes.Like(ctx, like.itemID, like.userID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := &Handlers{itemRepo: repo}
+
+	cases := map[string]struct {
+		query  string
+		status int
+		want   []string
+	}{
+		"ok: default order":    {"", http.StatusOK, []string{"jacket", "coat", "hat"}},
+		"ok: sort by id":       {"?sort=id", http.StatusOK, []string{"jacket", "coat", "hat"}},
+		"ok: most liked first": {"?sort=popular", http.StatusOK, []string{"coat", "hat", "jacket"}},
+		"ng: unknown order":    {"?sort=price", http.StatusBadRequest, nil},
+	}
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			rr := httptest.NewRecorder()
+			h.GetItems(rr, httptest.NewRequest(http.MethodGet, "/items"+tt.query, nil))
+			if rr.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, rr.Code, rr.Body)
+			}
+			if tt.want == nil {
+				return
+			}
+			var resp struct {
+				Items []*Item `json:"items"`
+			}
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, item := range resp.Items {
+				got = append(got, item.Name)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected order (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,393 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	// ErrConversationNotFound is returned by MessageRepository when no conversation has the requested ID.
+	ErrConversationNotFound = errors.New("conversation not found")
+	// errNotParticipant is returned when a user opens a conversation they are not part of.
+	errNotParticipant = errors.New("not a participant of the conversation")
+	// errItemUnavailable is returned when the item of a conversation can no longer be seen.
+	errItemUnavailable = errors.New("item is no longer available")
+)
+
+const (
+	// maxMessageLength is the longest message body in characters.
+	maxMessageLength = 2000
+	// messagePreviewLength is how many characters of a message its notification shows.
+	messagePreviewLength = 100
+	defaultMessageLimit  = 50
+	maxMessageLimit      = 200
+)
+
+// Conversation is the thread between a buyer and the seller about one item.
+type Conversation struct {
+	ID        int       `json:"id"`
+	ItemID    int       `json:"item_id"`
+	BuyerID   int       `json:"buyer_id"`
+	SellerID  int       `json:"seller_id"`
+	CreatedAt time.Time `json:"created_at"`
+	// UpdatedAt is the time of the latest message.
+	UpdatedAt time.Time `json:"updated_at"`
+	// UnreadCount is how many messages the requesting user has not read. Only ListConversations sets it.
+	UnreadCount int `json:"unread_count"`
+}
+
+// has reports whether userID is the buyer or the seller.
+func (c *Conversation) has(userID int) bool {
+	return userID == c.BuyerID || userID == c.SellerID
+}
+
+// other returns the participant who is not userID.
+func (c *Conversation) other(userID int) int {
+	if userID == c.BuyerID {
+		return c.SellerID
+	}
+	return c.BuyerID
+}
+
+// Message is a message in a Conversation.
+type Message struct {
+	ID             int64     `json:"id"`
+	ConversationID int       `json:"conversation_id"`
+	SenderID       int       `json:"sender_id"`
+	Body           string    `json:"body"`
+	CreatedAt      time.Time `json:"created_at"`
+	// ReadAt is set once the recipient has read the message.
+	ReadAt *time.Time `json:"read_at,omitempty"`
+}
+
+// MessageEvent is a WebSocket frame for the connections of UserIDs, passed from the server which
+// published it to the others through the database.
+type MessageEvent struct {
+	ID        int64
+	CreatedAt time.Time
+	// Origin identifies the messageHub which published the frame.
+	Origin  string
+	UserIDs []int
+	Frame   json.RawMessage
+}
+
+// MessageRepository stores conversations and their messages.
+type MessageRepository interface {
+	// GetOrCreateConversation returns the conversation of buyerID about itemID, starting it with sellerID if there is none.
+	GetOrCreateConversation(ctx context.Context, itemID, buyerID, sellerID int) (*Conversation, error)
+	// GetConversation returns ErrConversationNotFound when there is no such conversation.
+	GetConversation(ctx context.Context, id int) (*Conversation, error)
+	// ListConversations returns the conversations of userID, most recently active first, with UnreadCount set for userID.
+	ListConversations(ctx context.Context, userID int) ([]*Conversation, error)
+	// AddMessage stores m, setting its ID and CreatedAt, and makes it the latest activity of its conversation.
+	AddMessage(ctx context.Context, m *Message) error
+	// ListMessages returns up to limit messages of a conversation older than beforeID (all when zero), newest first.
+	ListMessages(ctx context.Context, conversationID int, beforeID int64, limit int) ([]*Message, error)
+	// MarkRead marks the messages readerID received in a conversation, up to upToID (all when zero),
+	// as read at at. It returns how many were marked.
+	MarkRead(ctx context.Context, conversationID, readerID int, upToID int64, at time.Time) (int, error)
+	// UnreadCount returns how many messages userID has not read in all conversations.
+	UnreadCount(ctx context.Context, userID int) (int, error)
+	// AddMessageEvent stores e, setting its ID and CreatedAt.
+	AddMessageEvent(ctx context.Context, e *MessageEvent) error
+	// MessageEventsAfter returns up to limit events with IDs after afterID, oldest first.
+	MessageEventsAfter(ctx context.Context, afterID int64, limit int) ([]*MessageEvent, error)
+	// PruneMessageEvents deletes the events created before createdBefore and returns how many.
+	PruneMessageEvents(ctx context.Context, createdBefore time.Time) (int, error)
+}
+
+// conversation returns the conversation id if userID takes part in it.
+func (h *Handlers) conversation(ctx context.Context, id, userID int) (*Conversation, error) {
+	c, err := h.messages.GetConversation(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !c.has(userID) {
+		return nil, errNotParticipant
+	}
+	return c, nil
+}
+
+// messageBody returns body without surrounding spaces, or an error if it cannot be sent.
+func messageBody(body string) (string, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return "", fmt.Errorf("body is required")
+	}
+	if utf8.RuneCountInString(body) > maxMessageLength {
+		return "", fmt.Errorf("body is too long (max %d chars)", maxMessageLength)
+	}
+	return body, nil
+}
+
+// messagePreview returns the start of body for notifications.
+func messagePreview(body string) string {
+	if utf8.RuneCountInString(body) <= messagePreviewLength {
+		return body
+	}
+	return string([]rune(body)[:messagePreviewLength]) + "…"
+}
+
+// sendMessage stores a message from senderID, with a body checked by messageBody, and pushes it
+// to both participants. Messages can only be sent while the item can be seen.
+func (h *Handlers) sendMessage(ctx context.Context, c *Conversation, senderID int, body string) (*Message, error) {
+	item, err := h.itemRepo.Select(ctx, c.ItemID)
+	if err != nil {
+		if errors.Is(err, ErrItemNotFound) {
+			return nil, errItemUnavailable
+		}
+		return nil, err
+	}
+
+	m := &Message{ConversationID: c.ID, SenderID: senderID, Body: body}
+	if err := h.messages.AddMessage(ctx, m); err != nil {
+		return nil, err
+	}
+	h.notify(ctx, &Notification{
+		UserID: c.other(senderID),
+		Type:   NotificationNewMessage,
+		ItemID: c.ItemID,
+		Key:    fmt.Sprintf("%s:%d", NotificationNewMessage, m.ID),
+		Params: map[string]string{"item_name": item.Name, "preview": messagePreview(body)},
+	})
+	if h.hub != nil {
+		frame := wsFrame{Type: wsMessage, ConversationID: c.ID, Message: m}
+		h.hub.publish(ctx, frame, c.BuyerID, c.SellerID)
+		h.pushUnread(ctx, c.other(senderID))
+	}
+	return m, nil
+}
+
+// markRead marks the messages readerID received up to upToID as read and sends a read receipt to the sender.
+func (h *Handlers) markRead(ctx context.Context, c *Conversation, readerID int, upToID int64) (int, error) {
+	n, err := h.messages.MarkRead(ctx, c.ID, readerID, upToID, time.Now().UTC())
+	if err != nil || n == 0 || h.hub == nil {
+		return n, err
+	}
+	h.hub.publish(ctx, wsFrame{Type: wsRead, ConversationID: c.ID, UserID: readerID, MessageID: upToID}, c.other(readerID))
+	h.pushUnread(ctx, readerID)
+	return n, nil
+}
+
+// pushUnread sends the unread count of userID to their connections.
+func (h *Handlers) pushUnread(ctx context.Context, userID int) {
+	n, err := h.messages.UnreadCount(ctx, userID)
+	if err != nil {
+		slog.Error("failed to count unread messages", "user_id", userID, "error", err)
+		return
+	}
+	h.hub.publish(ctx, wsFrame{Type: wsUnread, Unread: &n}, userID)
+}
+
+// writeConversationError writes the response for an error returned by conversation.
+func writeConversationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrConversationNotFound):
+		http.Error(w, "conversation not found", http.StatusNotFound)
+	case errors.Is(err, errNotParticipant):
+		http.Error(w, "only the buyer and the seller can read this conversation", http.StatusForbidden)
+	default:
+		http.Error(w, "failed to get conversation", http.StatusInternalServerError)
+	}
+}
+
+// StartConversation is a handler to open the buyer's conversation with the seller about an item
+// for POST /items/{id}/conversations . The existing conversation is returned if there is one.
+func (h *Handlers) StartConversation(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	itemID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		return
+	}
+	userID, signedIn := userIDFromContext(ctx)
+	if !signedIn {
+		http.Error(w, "sign in to message sellers", http.StatusUnauthorized)
+		return
+	}
+
+	// 削除された商品など、見えない商品にはメッセージを送れない
+	item, err := h.itemRepo.Select(ctx, itemID)
+	if err != nil {
+		if errors.Is(err, ErrItemNotFound) {
+			http.Error(w, "Item not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to get item", http.StatusInternalServerError)
+		return
+	}
+	if item.SellerID == 0 {
+		http.Error(w, "this item has no seller to message", http.StatusBadRequest)
+		return
+	}
+	if item.SellerID == userID {
+		http.Error(w, "sellers cannot message themselves", http.StatusBadRequest)
+		return
+	}
+
+	c, err := h.messages.GetOrCreateConversation(ctx, itemID, userID, item.SellerID)
+	if err != nil {
+		slog.Error("failed to start conversation", "item_id", itemID, "error", err)
+		http.Error(w, "failed to start conversation", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+// GetConversations is a handler to return the signed-in user's conversations with their unread
+// counts, most recently active first, for GET /conversations .
+func (h *Handlers) GetConversations(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, signedIn := userIDFromContext(ctx)
+	if !signedIn {
+		http.Error(w, "sign in to read messages", http.StatusUnauthorized)
+		return
+	}
+
+	conversations, err := h.messages.ListConversations(ctx, userID)
+	if err != nil {
+		http.Error(w, "failed to get conversations", http.StatusInternalServerError)
+		return
+	}
+	if conversations == nil {
+		conversations = []*Conversation{}
+	}
+	unread := 0
+	for _, c := range conversations {
+		unread += c.UnreadCount
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"conversations": conversations, "unread": unread})
+}
+
+// GetMessages is a handler to return the messages of a conversation, newest first, for
+// GET /conversations/{id}/messages . Older pages are read with before_id; limit defaults to 50.
+func (h *Handlers) GetMessages(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		return
+	}
+	userID, signedIn := userIDFromContext(ctx)
+	if !signedIn {
+		http.Error(w, "sign in to read messages", http.StatusUnauthorized)
+		return
+	}
+	var beforeID int64
+	if s := r.URL.Query().Get("before_id"); s != "" {
+		if beforeID, err = strconv.ParseInt(s, 10, 64); err != nil || beforeID < 0 {
+			http.Error(w, "before_id must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+	}
+	limit := defaultMessageLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = min(limit, maxMessageLimit)
+	}
+
+	c, err := h.conversation(ctx, id, userID)
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+	messages, err := h.messages.ListMessages(ctx, c.ID, beforeID, limit)
+	if err != nil {
+		http.Error(w, "failed to get messages", http.StatusInternalServerError)
+		return
+	}
+	if messages == nil {
+		messages = []*Message{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"messages": messages})
+}
+
+// PostMessage is a handler to send a message for POST /conversations/{id}/messages .
+// Form field: body. The message is also pushed to both participants' WebSocket connections.
+func (h *Handlers) PostMessage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		return
+	}
+	userID, signedIn := userIDFromContext(ctx)
+	if !signedIn {
+		http.Error(w, "sign in to send messages", http.StatusUnauthorized)
+		return
+	}
+	c, err := h.conversation(ctx, id, userID)
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+
+	body, err := messageBody(r.FormValue("body"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m, err := h.sendMessage(ctx, c, userID, body)
+	if err != nil {
+		if errors.Is(err, errItemUnavailable) {
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		}
+		slog.Error("failed to send message", "conversation_id", c.ID, "error", err)
+		http.Error(w, "failed to send message", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(m)
+}
+
+// ReadMessages is a handler to mark received messages as read for POST /conversations/{id}/read .
+// Form field: up_to, the newest message read. All received messages are marked without it.
+func (h *Handlers) ReadMessages(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		return
+	}
+	userID, signedIn := userIDFromContext(ctx)
+	if !signedIn {
+		http.Error(w, "sign in to read messages", http.StatusUnauthorized)
+		return
+	}
+	var upTo int64
+	if s := r.FormValue("up_to"); s != "" {
+		if upTo, err = strconv.ParseInt(s, 10, 64); err != nil || upTo < 0 {
+			http.Error(w, "up_to must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+	}
+	c, err := h.conversation(ctx, id, userID)
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+
+	n, err := h.markRead(ctx, c, userID, upTo)
+	if err != nil {
+		http.Error(w, "failed to mark messages read", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"read": n})
+}
@@ -0,0 +1,494 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sqlMessageRepository stores messages in SQLite or PostgreSQL. placeholder is the dialect's bind parameter.
+type sqlMessageRepository struct {
+	db          *sql.DB
+	placeholder func(int) string
+}
+
+// NewMessageRepository returns a MessageRepository using the database opened by OpenDatabase with dsn.
+func NewMessageRepository(db *sql.DB, dsn string) MessageRepository {
+	d, _ := parseDSN(dsn)
+	return &sqlMessageRepository{db: db, placeholder: d.placeholder()}
+}
+
+const conversationColumns = `c.id, c.item_id, c.buyer_id, c.seller_id, c.created_at, c.updated_at`
+
+func scanConversation(row rowScanner, extra ...any) (*Conversation, error) {
+	var c Conversation
+	dest := append([]any{&c.ID, &c.ItemID, &c.BuyerID, &c.SellerID, &c.CreatedAt, &c.UpdatedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// GetOrCreateConversation returns the conversation of buyerID about itemID, starting it if needed.
+func (r *sqlMessageRepository) GetOrCreateConversation(ctx context.Context, itemID, buyerID, sellerID int) (*Conversation, error) {
+	now := time.Now().UTC()
+	// 同時に始めても会話は一つだけ作られる
+	insert := `INSERT INTO conversations (item_id, buyer_id, seller_id, created_at, updated_at)
+        VALUES (` + params(r.placeholder, 5) + `) ON CONFLICT (item_id, buyer_id) DO NOTHING`
+	if _, err := r.db.ExecContext(ctx, insert, itemID, buyerID, sellerID, now, now); err != nil {
+		return nil, fmt.Errorf("failed to start conversation: %w", err)
+	}
+	query := `SELECT ` + conversationColumns + ` FROM conversations c WHERE c.item_id = ` + r.placeholder(1) + ` AND c.buyer_id = ` + r.placeholder(2)
+	c, err := scanConversation(r.db.QueryRowContext(ctx, query, itemID, buyerID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get conversation: %w", err)
+	}
+	return c, nil
+}
+
+// GetConversation returns a conversation by ID.
+func (r *sqlMessageRepository) GetConversation(ctx context.Context, id int) (*Conversation, error) {
+	query := `SELECT ` + conversationColumns + ` FROM conversations c WHERE c.id = ` + r.placeholder(1)
+	c, err := scanConversation(r.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrConversationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get conversation: %w", err)
+	}
+	return c, nil
+}
+
+// ListConversations returns the conversations of userID with their unread counts.
+func (r *sqlMessageRepository) ListConversations(ctx context.Context, userID int) ([]*Conversation, error) {
+	query := `SELECT ` + conversationColumns + `,
+            (SELECT COUNT(*) FROM messages m WHERE m.conversation_id = c.id AND m.sender_id <> ` + r.placeholder(1) + ` AND m.read_at IS NULL)
+        FROM conversations c
+        WHERE c.buyer_id = ` + r.placeholder(2) + ` OR c.seller_id = ` + r.placeholder(3) + `
+        ORDER BY c.updated_at DESC, c.id DESC`
+	rows, err := r.db.QueryContext(ctx, query, userID, userID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conversations: %w", err)
+	}
+	defer rows.Close()
+
+	var conversations []*Conversation
+	for rows.Next() {
+		var unread int
+		c, err := scanConversation(rows, &unread)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan conversation: %w", err)
+		}
+		c.UnreadCount = unread
+		conversations = append(conversations, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list conversations: %w", err)
+	}
+	return conversations, nil
+}
+
+// AddMessage stores m and bumps its conversation.
+func (r *sqlMessageRepository) AddMessage(ctx context.Context, m *Message) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	m.CreatedAt = time.Now().UTC()
+	query := `INSERT INTO messages (conversation_id, sender_id, body, created_at) VALUES (` + params(r.placeholder, 4) + `) RETURNING id`
+	if err := tx.QueryRowContext(ctx, query, m.ConversationID, m.SenderID, m.Body, m.CreatedAt).Scan(&m.ID); err != nil {
+		return fmt.Errorf("failed to add message: %w", err)
+	}
+	update := `UPDATE conversations SET updated_at = ` + r.placeholder(1) + ` WHERE id = ` + r.placeholder(2)
+	if _, err := tx.ExecContext(ctx, update, m.CreatedAt, m.ConversationID); err != nil {
+		return fmt.Errorf("failed to add message: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to add message: %w", err)
+	}
+	return nil
+}
+
+// ListMessages returns a page of messages, newest first.
+func (r *sqlMessageRepository) ListMessages(ctx context.Context, conversationID int, beforeID int64, limit int) ([]*Message, error) {
+	query := `SELECT id, conversation_id, sender_id, body, created_at, read_at FROM messages WHERE conversation_id = ` + r.placeholder(1)
+	args := []any{conversationID}
+	if beforeID != 0 {
+		query += ` AND id < ` + r.placeholder(2)
+		args = append(args, beforeID)
+	}
+	rows, err := r.db.QueryContext(ctx, query+` ORDER BY id DESC LIMIT `+strconv.Itoa(limit), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []*Message
+	for rows.Next() {
+		var m Message
+		var readAt sql.NullTime
+		if err := rows.Scan(&m.ID, &m.ConversationID, &m.SenderID, &m.Body, &m.CreatedAt, &readAt); err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		if readAt.Valid {
+			m.ReadAt = &readAt.Time
+		}
+		messages = append(messages, &m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+	return messages, nil
+}
+
+// MarkRead marks received messages as read.
+func (r *sqlMessageRepository) MarkRead(ctx context.Context, conversationID, readerID int, upToID int64, at time.Time) (int, error) {
+	query := `UPDATE messages SET read_at = ` + r.placeholder(1) + `
+        WHERE conversation_id = ` + r.placeholder(2) + ` AND sender_id <> ` + r.placeholder(3) + ` AND read_at IS NULL`
+	args := []any{at.UTC(), conversationID, readerID}
+	if upToID != 0 {
+		query += ` AND id <= ` + r.placeholder(4)
+		args = append(args, upToID)
+	}
+	result, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark messages read: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark messages read: %w", err)
+	}
+	return int(n), nil
+}
+
+// UnreadCount returns how many messages userID has not read.
+func (r *sqlMessageRepository) UnreadCount(ctx context.Context, userID int) (int, error) {
+	query := `SELECT COUNT(*) FROM messages m JOIN conversations c ON c.id = m.conversation_id
+        WHERE (c.buyer_id = ` + r.placeholder(1) + ` OR c.seller_id = ` + r.placeholder(2) + `)
+        AND m.sender_id <> ` + r.placeholder(3) + ` AND m.read_at IS NULL`
+	var n int
+	if err := r.db.QueryRowContext(ctx, query, userID, userID, userID).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count unread messages: %w", err)
+	}
+	return n, nil
+}
+
+// joinUserIDs stores a list of user IDs as a comma separated string.
+func joinUserIDs(userIDs []int) string {
+	list := make([]string, len(userIDs))
+	for i, id := range userIDs {
+		list[i] = strconv.Itoa(id)
+	}
+	return joinList(list)
+}
+
+// splitUserIDs reads a list stored by joinUserIDs.
+func splitUserIDs(s string) ([]int, error) {
+	var userIDs []int
+	for _, v := range splitList(s) {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, id)
+	}
+	return userIDs, nil
+}
+
+// AddMessageEvent stores e and sets its ID and CreatedAt.
+func (r *sqlMessageRepository) AddMessageEvent(ctx context.Context, e *MessageEvent) error {
+	e.CreatedAt = time.Now().UTC()
+	query := `INSERT INTO message_events (created_at, origin, user_ids, frame) VALUES (` + params(r.placeholder, 4) + `) RETURNING id`
+	if err := r.db.QueryRowContext(ctx, query, e.CreatedAt, e.Origin, joinUserIDs(e.UserIDs), string(e.Frame)).Scan(&e.ID); err != nil {
+		return fmt.Errorf("failed to add message event: %w", err)
+	}
+	return nil
+}
+
+// MessageEventsAfter returns the events after afterID, oldest first.
+func (r *sqlMessageRepository) MessageEventsAfter(ctx context.Context, afterID int64, limit int) ([]*MessageEvent, error) {
+	query := `SELECT id, created_at, origin, user_ids, frame FROM message_events WHERE id > ` + r.placeholder(1) + `
+        ORDER BY id LIMIT ` + strconv.Itoa(limit)
+	rows, err := r.db.QueryContext(ctx, query, afterID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve message events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*MessageEvent
+	for rows.Next() {
+		var e MessageEvent
+		var userIDs, frame string
+		if err := rows.Scan(&e.ID, &e.CreatedAt, &e.Origin, &userIDs, &frame); err != nil {
+			return nil, fmt.Errorf("failed to scan message event: %w", err)
+		}
+		if e.UserIDs, err = splitUserIDs(userIDs); err != nil {
+			return nil, fmt.Errorf("failed to scan message event: %w", err)
+		}
+		e.Frame = json.RawMessage(frame)
+		events = append(events, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve message events: %w", err)
+	}
+	return events, nil
+}
+
+// PruneMessageEvents deletes the events created before createdBefore.
+func (r *sqlMessageRepository) PruneMessageEvents(ctx context.Context, createdBefore time.Time) (int, error) {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM message_events WHERE created_at < `+r.placeholder(1), createdBefore.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune message events: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune message events: %w", err)
+	}
+	return int(n), nil
+}
+
+// memoryMessageRepository keeps messages in memory, for --storage=memory and tests.
+type memoryMessageRepository struct {
+	mu            sync.RWMutex
+	conversations []*Conversation
+	messages      []*Message
+	nextMessageID int64
+	events        []*MessageEvent
+	nextEventID   int64
+}
+
+// NewMemoryMessageRepository returns an empty MessageRepository kept in memory.
+func NewMemoryMessageRepository() MessageRepository {
+	return &memoryMessageRepository{nextMessageID: 1, nextEventID: 1}
+}
+
+func cloneMessageEvent(e *MessageEvent) *MessageEvent {
+	copied := *e
+	copied.UserIDs = slices.Clone(e.UserIDs)
+	copied.Frame = slices.Clone(e.Frame)
+	return &copied
+}
+
+func cloneMessage(msg *Message) *Message {
+	copied := *msg
+	if msg.ReadAt != nil {
+		t := *msg.ReadAt
+		copied.ReadAt = &t
+	}
+	return &copied
+}
+
+// find returns the conversation with the given ID. m.mu must be held.
+func (m *memoryMessageRepository) find(id int) *Conversation {
+	for _, c := range m.conversations {
+		if c.ID == id {
+			return c
+		}
+	}
+	return nil
+}
+
+// unread counts the messages userID has not read in c. m.mu must be held.
+func (m *memoryMessageRepository) unread(c *Conversation, userID int) int {
+	n := 0
+	for _, msg := range m.messages {
+		if msg.ConversationID == c.ID && msg.SenderID != userID && msg.ReadAt == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// GetOrCreateConversation returns the conversation of buyerID about itemID, starting it if needed.
+func (m *memoryMessageRepository) GetOrCreateConversation(ctx context.Context, itemID, buyerID, sellerID int) (*Conversation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to start conversation: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, c := range m.conversations {
+		if c.ItemID == itemID && c.BuyerID == buyerID {
+			copied := *c
+			return &copied, nil
+		}
+	}
+	now := time.Now().UTC()
+	c := &Conversation{ID: len(m.conversations) + 1, ItemID: itemID, BuyerID: buyerID, SellerID: sellerID, CreatedAt: now, UpdatedAt: now}
+	m.conversations = append(m.conversations, c)
+	copied := *c
+	return &copied, nil
+}
+
+// GetConversation returns a conversation by ID.
+func (m *memoryMessageRepository) GetConversation(ctx context.Context, id int) (*Conversation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get conversation: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	c := m.find(id)
+	if c == nil {
+		return nil, ErrConversationNotFound
+	}
+	copied := *c
+	return &copied, nil
+}
+
+// ListConversations returns the conversations of userID with their unread counts.
+func (m *memoryMessageRepository) ListConversations(ctx context.Context, userID int) ([]*Conversation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list conversations: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var conversations []*Conversation
+	for _, c := range m.conversations {
+		if c.has(userID) {
+			copied := *c
+			copied.UnreadCount = m.unread(c, userID)
+			conversations = append(conversations, &copied)
+		}
+	}
+	slices.SortFunc(conversations, func(a, b *Conversation) int {
+		if c := b.UpdatedAt.Compare(a.UpdatedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.ID, a.ID)
+	})
+	return conversations, nil
+}
+
+// AddMessage stores msg and bumps its conversation.
+func (m *memoryMessageRepository) AddMessage(ctx context.Context, msg *Message) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to add message: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.find(msg.ConversationID)
+	if c == nil {
+		return fmt.Errorf("failed to add message: %w", ErrConversationNotFound)
+	}
+	msg.ID = m.nextMessageID
+	m.nextMessageID++
+	msg.CreatedAt = time.Now().UTC()
+	c.UpdatedAt = msg.CreatedAt
+	m.messages = append(m.messages, cloneMessage(msg))
+	return nil
+}
+
+// ListMessages returns a page of messages, newest first.
+func (m *memoryMessageRepository) ListMessages(ctx context.Context, conversationID int, beforeID int64, limit int) ([]*Message, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var messages []*Message
+	for _, msg := range slices.Backward(m.messages) {
+		if len(messages) == limit {
+			break
+		}
+		if msg.ConversationID == conversationID && (beforeID == 0 || msg.ID < beforeID) {
+			messages = append(messages, cloneMessage(msg))
+		}
+	}
+	return messages, nil
+}
+
+// MarkRead marks received messages as read.
+func (m *memoryMessageRepository) MarkRead(ctx context.Context, conversationID, readerID int, upToID int64, at time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("failed to mark messages read: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for _, msg := range m.messages {
+		if msg.ConversationID == conversationID && msg.SenderID != readerID && msg.ReadAt == nil && (upToID == 0 || msg.ID <= upToID) {
+			readAt := at.UTC()
+			msg.ReadAt = &readAt
+			n++
+		}
+	}
+	return n, nil
+}
+
+// UnreadCount returns how many messages userID has not read.
+func (m *memoryMessageRepository) UnreadCount(ctx context.Context, userID int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("failed to count unread messages: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	n := 0
+	for _, c := range m.conversations {
+		if c.has(userID) {
+			n += m.unread(c, userID)
+		}
+	}
+	return n, nil
+}
+
+// AddMessageEvent stores e and sets its ID and CreatedAt.
+func (m *memoryMessageRepository) AddMessageEvent(ctx context.Context, e *MessageEvent) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to add message event: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e.ID = m.nextEventID
+	m.nextEventID++
+	e.CreatedAt = time.Now().UTC()
+	m.events = append(m.events, cloneMessageEvent(e))
+	return nil
+}
+
+// MessageEventsAfter returns the events after afterID, oldest first.
+func (m *memoryMessageRepository) MessageEventsAfter(ctx context.Context, afterID int64, limit int) ([]*MessageEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve message events: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var events []*MessageEvent
+	for _, e := range m.events {
+		if len(events) == limit {
+			break
+		}
+		if e.ID > afterID {
+			events = append(events, cloneMessageEvent(e))
+		}
+	}
+	return events, nil
+}
+
+// PruneMessageEvents deletes the events created before createdBefore.
+func (m *memoryMessageRepository) PruneMessageEvents(ctx context.Context, createdBefore time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("failed to prune message events: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := len(m.events)
+	m.events = slices.DeleteFunc(m.events, func(e *MessageEvent) bool { return e.CreatedAt.Before(createdBefore) })
+	return n - len(m.events), nil
+}
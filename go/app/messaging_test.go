@@ -0,0 +1,460 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/gorilla/websocket"
+)
+
+func TestMessageRepository(t *testing.T) {
+	t.Parallel()
+
+	repos := map[string]func(t *testing.T) MessageRepository{
+		"sqlite": func(t *testing.T) MessageRepository {
+			dsn := filepath.Join(t.TempDir(), "test.sqlite3")
+			db, _, err := OpenDatabase(dsn)
+			if err != nil {
+				t.Fatalf("failed to open database: %v", err)
+			}
+			t.Cleanup(func() { db.Close() })
+			return NewMessageRepository(db, dsn)
+		},
+		"memory": func(t *testing.T) MessageRepository {
+			return NewMemoryMessageRepository()
+		},
+	}
+
+	const (
+		buyer  = 1
+		seller = 2
+		other  = 3
+	)
+	for name, newRepo := range repos {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			repo := newRepo(t)
+
+			c, err := repo.GetOrCreateConversation(ctx, 10, buyer, seller)
+			if err != nil {
+				t.Fatalf("failed to start conversation: %v", err)
+			}
+			again, err := repo.GetOrCreateConversation(ctx, 10, buyer, seller)
+			if err != nil {
+				t.Fatalf("failed to start conversation: %v", err)
+			}
+			if again.ID != c.ID {
+				t.Errorf("expected the same conversation, got %d and %d", c.ID, again.ID)
+			}
+			if _, err := repo.GetConversation(ctx, c.ID+100); !errors.Is(err, ErrConversationNotFound) {
+				t.Errorf("expected ErrConversationNotFound, got %v", err)
+			}
+
+			var sent []*Message
+			for i, sender := range []int{buyer, buyer, seller, buyer} {
+				m := &Message{ConversationID: c.ID, SenderID: sender, Body: "hello " + strconv.Itoa(i)}
+				if err := repo.AddMessage(ctx, m); err != nil {
+					t.Fatalf("failed to add message: %v", err)
+				}
+				sent = append(sent, m)
+			}
+
+			page, err := repo.ListMessages(ctx, c.ID, 0, 2)
+			if err != nil {
+				t.Fatalf("failed to list messages: %v", err)
+			}
+			if len(page) != 2 || page[0].ID != sent[3].ID || page[1].ID != sent[2].ID {
+				t.Fatalf("expected the newest two messages, got %+v", page)
+			}
+			page, err = repo.ListMessages(ctx, c.ID, page[1].ID, 10)
+			if err != nil {
+				t.Fatalf("failed to list messages: %v", err)
+			}
+			if len(page) != 2 || page[0].ID != sent[1].ID || page[0].Body != "hello 1" {
+				t.Fatalf("expected the older page, got %+v", page)
+			}
+
+			for userID, want := range map[int]int{buyer: 1, seller: 3, other: 0} {
+				if n, err := repo.UnreadCount(ctx, userID); err != nil || n != want {
+					t.Errorf("user %d: expected %d unread, got %d (%v)", userID, want, n, err)
+				}
+			}
+
+			// 出品者が 2 通目まで読んだ
+			n, err := repo.MarkRead(ctx, c.ID, seller, sent[1].ID, time.Now())
+			if err != nil || n != 2 {
+				t.Fatalf("expected 2 messages marked read, got %d (%v)", n, err)
+			}
+			conversations, err := repo.ListConversations(ctx, seller)
+			if err != nil {
+				t.Fatalf("failed to list conversations: %v", err)
+			}
+			if len(conversations) != 1 || conversations[0].UnreadCount != 1 {
+				t.Errorf("expected one conversation with 1 unread, got %+v", conversations)
+			}
+			page, _ = repo.ListMessages(ctx, c.ID, 0, 10)
+			if page[3].ReadAt == nil || page[1].ReadAt != nil {
+				t.Errorf("expected read receipts on the first messages only, got %+v", page)
+			}
+
+			later, err := repo.GetOrCreateConversation(ctx, 11, other, seller)
+			if err != nil {
+				t.Fatalf("failed to start conversation: %v", err)
+			}
+			if err := repo.AddMessage(ctx, &Message{ConversationID: later.ID, SenderID: other, Body: "is this available?"}); err != nil {
+				t.Fatalf("failed to add message: %v", err)
+			}
+			conversations, _ = repo.ListConversations(ctx, seller)
+			if len(conversations) != 2 || conversations[0].ID != later.ID {
+				t.Errorf("expected the latest conversation first, got %+v", conversations)
+			}
+			if conversations, _ := repo.ListConversations(ctx, buyer); len(conversations) != 1 {
+				t.Errorf("expected only the buyer's conversation, got %+v", conversations)
+			}
+
+			for _, e := range []*MessageEvent{
+				{Origin: "a", UserIDs: []int{buyer, seller}, Frame: json.RawMessage(`{"type":"message"}`)},
+				{Origin: "b", UserIDs: []int{seller}, Frame: json.RawMessage(`{"type":"typing"}`)},
+			} {
+				if err := repo.AddMessageEvent(ctx, e); err != nil {
+					t.Fatalf("failed to add message event: %v", err)
+				}
+			}
+			events, err := repo.MessageEventsAfter(ctx, 0, 10)
+			if err != nil {
+				t.Fatalf("failed to get message events: %v", err)
+			}
+			if len(events) != 2 || events[0].Origin != "a" || string(events[1].Frame) != `{"type":"typing"}` {
+				t.Fatalf("unexpected message events %+v", events)
+			}
+			if diff := cmp.Diff([]int{buyer, seller}, events[0].UserIDs); diff != "" {
+				t.Errorf("unexpected user IDs (-want +got):\n%s", diff)
+			}
+			if events, _ := repo.MessageEventsAfter(ctx, events[0].ID, 10); len(events) != 1 {
+				t.Errorf("expected 1 event after the first, got %+v", events)
+			}
+			if n, err := repo.PruneMessageEvents(ctx, time.Now().Add(time.Minute)); err != nil || n != 2 {
+				t.Errorf("expected 2 pruned events, got %d (%v)", n, err)
+			}
+		})
+	}
+}
+
+// newMessagingHandlers returns handlers with an item listed by seller and one deleted item.
+func newMessagingHandlers(t *testing.T, seller int) (*Handlers, *Item) {
+	t.Helper()
+	ctx := ContextWithUserID(context.Background(), seller)
+	repo := NewMemoryItemRepository()
+	listed := &Item{Name: "jacket", Category: "fashion", SellerID: seller}
+	deleted := &Item{Name: "coat", Category: "fashion", SellerID: seller}
+	for _, item := range []*Item{listed, deleted} {
+		if err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+	}
+	if err := repo.Delete(ctx, deleted.ID, 0); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+	messages := NewMemoryMessageRepository()
+	return &Handlers{itemRepo: repo, messages: messages, hub: newMessageHub(messages)}, listed
+}
+
+func TestMessagingHandlers(t *testing.T) {
+	t.Parallel()
+
+	const (
+		buyer  = 1
+		seller = 2
+		other  = 3
+	)
+	type step struct {
+		method string
+		path   string
+		form   url.Values
+		userID int
+		status int
+	}
+
+	cases := map[string][]step{
+		"ok: buyer and seller exchange messages": {
+			{http.MethodPost, "/items/1/conversations", nil, buyer, http.StatusOK},
+			{http.MethodPost, "/items/1/conversations", nil, buyer, http.StatusOK},
+			{http.MethodPost, "/conversations/1/messages", url.Values{"body": {"is this available?"}}, buyer, http.StatusCreated},
+			{http.MethodPost, "/conversations/1/messages", url.Values{"body": {"yes"}}, seller, http.StatusCreated},
+			{http.MethodGet, "/conversations/1/messages?limit=1", nil, buyer, http.StatusOK},
+			{http.MethodPost, "/conversations/1/read", nil, seller, http.StatusOK},
+			{http.MethodGet, "/conversations", nil, seller, http.StatusOK},
+		},
+		"ng: not signed in": {
+			{http.MethodPost, "/items/1/conversations", nil, 0, http.StatusUnauthorized},
+			{http.MethodGet, "/conversations", nil, 0, http.StatusUnauthorized},
+			{http.MethodGet, "/conversations/1/messages", nil, 0, http.StatusUnauthorized},
+		},
+		"ng: items that cannot be messaged": {
+			{http.MethodPost, "/items/2/conversations", nil, buyer, http.StatusNotFound},
+			{http.MethodPost, "/items/99/conversations", nil, buyer, http.StatusNotFound},
+			{http.MethodPost, "/items/1/conversations", nil, seller, http.StatusBadRequest},
+		},
+		"ng: only participants": {
+			{http.MethodPost, "/items/1/conversations", nil, buyer, http.StatusOK},
+			{http.MethodGet, "/conversations/1/messages", nil, other, http.StatusForbidden},
+			{http.MethodPost, "/conversations/1/messages", url.Values{"body": {"hi"}}, other, http.StatusForbidden},
+			{http.MethodPost, "/conversations/1/read", nil, other, http.StatusForbidden},
+			{http.MethodGet, "/conversations/2/messages", nil, buyer, http.StatusNotFound},
+		},
+		"ng: invalid messages": {
+			{http.MethodPost, "/items/1/conversations", nil, buyer, http.StatusOK},
+			{http.MethodPost, "/conversations/1/messages", url.Values{"body": {"  "}}, buyer, http.StatusBadRequest},
+			{http.MethodPost, "/conversations/1/messages", url.Values{"body": {strings.Repeat("あ", maxMessageLength+1)}}, buyer, http.StatusBadRequest},
+			{http.MethodGet, "/conversations/1/messages?limit=0", nil, buyer, http.StatusBadRequest},
+			{http.MethodGet, "/conversations/1/messages?before_id=x", nil, buyer, http.StatusBadRequest},
+		},
+	}
+
+	for name, steps := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h, _ := newMessagingHandlers(t, seller)
+			mux := http.NewServeMux()
+			mux.HandleFunc("POST /items/{id}/conversations", h.StartConversation)
+			mux.HandleFunc("GET /conversations", h.GetConversations)
+			mux.HandleFunc("GET /conversations/{id}/messages", h.GetMessages)
+			mux.HandleFunc("POST /conversations/{id}/messages", h.PostMessage)
+			mux.HandleFunc("POST /conversations/{id}/read", h.ReadMessages)
+
+			for _, s := range steps {
+				req := httptest.NewRequest(s.method, s.path, strings.NewReader(s.form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				if s.userID != 0 {
+					req = req.WithContext(ContextWithUserID(req.Context(), s.userID))
+				}
+				rr := httptest.NewRecorder()
+				mux.ServeHTTP(rr, req)
+				if rr.Code != s.status {
+					t.Fatalf("%s %s: expected status %d, got %d: %s", s.method, s.path, s.status, rr.Code, rr.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestPostMessageToDeletedItem(t *testing.T) {
+	t.Parallel()
+
+	const buyer, seller = 1, 2
+	h, listed := newMessagingHandlers(t, seller)
+	ctx := context.Background()
+	c, err := h.messages.GetOrCreateConversation(ctx, listed.ID, buyer, seller)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.itemRepo.Delete(ctx, listed.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/conversations/1/messages", strings.NewReader("body=hi"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetPathValue("id", strconv.Itoa(c.ID))
+	req = req.WithContext(ContextWithUserID(req.Context(), buyer))
+	rr := httptest.NewRecorder()
+	h.PostMessage(rr, req)
+	if rr.Code != http.StatusGone {
+		t.Errorf("expected status %d, got %d: %s", http.StatusGone, rr.Code, rr.Body)
+	}
+}
+
+// dialMessages opens GET /ws/messages as userID and returns the connection after the initial unread frame.
+func dialMessages(t *testing.T, srv *httptest.Server, userID int) (*websocket.Conn, int) {
+	t.Helper()
+	header := http.Header{userIDHeader: {strconv.Itoa(userID)}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws/messages", header)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	frame := readFrame(t, conn)
+	if frame.Type != wsUnread || frame.Unread == nil {
+		t.Fatalf("expected the unread count first, got %+v", frame)
+	}
+	return conn, *frame.Unread
+}
+
+func readFrame(t *testing.T, conn *websocket.Conn) wsFrame {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var frame wsFrame
+	if err := conn.ReadJSON(&frame); err != nil {
+		t.Fatalf("failed to read frame: %v", err)
+	}
+	return frame
+}
+
+func TestMessagesSocket(t *testing.T) {
+	t.Parallel()
+
+	const buyer, seller, other = 1, 2, 3
+	h, listed := newMessagingHandlers(t, seller)
+	ctx := context.Background()
+	c, err := h.messages.GetOrCreateConversation(ctx, listed.ID, buyer, seller)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.messages.AddMessage(ctx, &Message{ConversationID: c.ID, SenderID: buyer, Body: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ws/messages", h.MessagesSocket)
+	srv := httptest.NewServer(withUser(mux, UserAuth{TrustHeader: true}))
+	t.Cleanup(srv.Close)
+
+	resp, err := http.Get(srv.URL + "/ws/messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status %d for anonymous users, got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	buyerConn, _ := dialMessages(t, srv, buyer)
+	sellerConn, unread := dialMessages(t, srv, seller)
+	if unread != 1 {
+		t.Errorf("expected 1 unread message for the seller, got %d", unread)
+	}
+
+	sellerConn.WriteJSON(wsFrame{Type: wsTyping, ConversationID: c.ID})
+	if frame := readFrame(t, buyerConn); frame.Type != wsTyping || frame.UserID != seller {
+		t.Errorf("expected a typing indicator, got %+v", frame)
+	}
+
+	buyerConn.WriteJSON(wsFrame{Type: wsMessage, ConversationID: c.ID, Body: "still there?"})
+	for _, conn := range []*websocket.Conn{buyerConn, sellerConn} {
+		if frame := readFrame(t, conn); frame.Type != wsMessage || frame.Message == nil || frame.Message.Body != "still there?" {
+			t.Errorf("expected the new message, got %+v", frame)
+		}
+	}
+	if frame := readFrame(t, sellerConn); frame.Type != wsUnread || *frame.Unread != 2 {
+		t.Errorf("expected 2 unread messages, got %+v", frame)
+	}
+
+	sellerConn.WriteJSON(wsFrame{Type: wsRead, ConversationID: c.ID})
+	if frame := readFrame(t, buyerConn); frame.Type != wsRead || frame.UserID != seller {
+		t.Errorf("expected a read receipt, got %+v", frame)
+	}
+	if frame := readFrame(t, sellerConn); frame.Type != wsUnread || *frame.Unread != 0 {
+		t.Errorf("expected no unread messages, got %+v", frame)
+	}
+
+	otherConn, _ := dialMessages(t, srv, other)
+	otherConn.WriteJSON(wsFrame{Type: wsMessage, ConversationID: c.ID, Body: "hi"})
+	if frame := readFrame(t, otherConn); frame.Type != wsError {
+		t.Errorf("expected an error for a stranger, got %+v", frame)
+	}
+
+	// 部外者のメッセージは保存されない
+	if messages, _ := h.messages.ListMessages(ctx, c.ID, 0, 10); len(messages) != 2 {
+		t.Errorf("expected 2 messages, got %d", len(messages))
+	}
+}
+
+func TestMessagesSocketAcrossServers(t *testing.T) {
+	t.Parallel()
+
+	const buyer, seller = 1, 2
+	h, listed := newMessagingHandlers(t, seller)
+	ctx := context.Background()
+	c, err := h.messages.GetOrCreateConversation(ctx, listed.ID, buyer, seller)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 同じ DB を使う二台のサーバー。買い手と出品者は別々のサーバーにつなぐ
+	servers := []*Handlers{h, {itemRepo: h.itemRepo, messages: h.messages, hub: newMessageHub(h.messages)}}
+	var srvs []*httptest.Server
+	for _, s := range servers {
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /ws/messages", s.MessagesSocket)
+		srv := httptest.NewServer(withUser(mux, UserAuth{TrustHeader: true}))
+		t.Cleanup(srv.Close)
+		srvs = append(srvs, srv)
+
+		// サーバーのワーカーの代わりに他のサーバーのフレームを読む
+		tailCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		t.Cleanup(func() {
+			cancel()
+			<-done
+		})
+		go func() {
+			defer close(done)
+			ticker := time.NewTicker(10 * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-tailCtx.Done():
+					return
+				case <-ticker.C:
+					s.hub.tailOnce(tailCtx)
+				}
+			}
+		}()
+	}
+	buyerConn, _ := dialMessages(t, srvs[0], buyer)
+	sellerConn, _ := dialMessages(t, srvs[1], seller)
+
+	buyerConn.WriteJSON(wsFrame{Type: wsMessage, ConversationID: c.ID, Body: "still there?"})
+	for _, conn := range []*websocket.Conn{buyerConn, sellerConn} {
+		if frame := readFrame(t, conn); frame.Type != wsMessage || frame.Message == nil || frame.Message.Body != "still there?" {
+			t.Errorf("expected the new message, got %+v", frame)
+		}
+	}
+	if frame := readFrame(t, sellerConn); frame.Type != wsUnread || *frame.Unread != 1 {
+		t.Errorf("expected 1 unread message, got %+v", frame)
+	}
+
+	// 自分のサーバーが送ったフレームは二度届かない
+	sellerConn.WriteJSON(wsFrame{Type: wsTyping, ConversationID: c.ID})
+	if frame := readFrame(t, buyerConn); frame.Type != wsTyping || frame.UserID != seller {
+		t.Errorf("expected a typing indicator, got %+v", frame)
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	t.Parallel()
+
+	h := &Handlers{wsOrigins: []string{"http://localhost:3000"}}
+	cases := map[string]struct {
+		origin string
+		want   bool
+	}{
+		"ok: no origin":      {"", true},
+		"ok: same origin":    {"http://example.com", true},
+		"ok: allowed origin": {"http://localhost:3000", true},
+		"ng: other origin":   {"http://evil.example", false},
+		"ng: invalid origin": {"://", false},
+	}
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/ws/messages", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if got := h.checkOrigin(req); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
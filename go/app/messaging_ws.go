@@ -0,0 +1,343 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Types of the JSON frames exchanged on GET /ws/messages .
+const (
+	// wsMessage carries a new message. Clients send it with conversation_id and body.
+	wsMessage = "message"
+	// wsTyping tells the other participant that user_id is typing. It is not stored.
+	wsTyping = "typing"
+	// wsRead is a read receipt: user_id has read the conversation up to message_id (all when zero).
+	wsRead = "read"
+	// wsUnread carries the recipient's unread count. The server sends it on connect and on changes.
+	wsUnread = "unread"
+	// wsError reports a frame the server rejected.
+	wsError = "error"
+)
+
+const (
+	// wsSendBuffer is how many frames a connection may fall behind before it is closed.
+	wsSendBuffer = 32
+	// wsPingInterval keeps idle connections open through proxies; a pong must arrive within wsPongWait.
+	wsPingInterval = 30 * time.Second
+	wsPongWait     = 60 * time.Second
+	wsWriteWait    = 10 * time.Second
+	// wsMaxFrameSize caps the frames read from clients.
+	wsMaxFrameSize = 16 << 10
+	// messageEventBatch is how many frames of other servers are read per query.
+	messageEventBatch = 100
+	// messageEventGapTimeout is how long a frame following a missing ID is held back, as for EventTailer.
+	messageEventGapTimeout = 5 * time.Second
+)
+
+// wsFrame is a frame of the messaging WebSocket protocol. Fields are set according to Type.
+type wsFrame struct {
+	Type           string   `json:"type"`
+	ConversationID int      `json:"conversation_id,omitempty"`
+	Body           string   `json:"body,omitempty"`
+	Message        *Message `json:"message,omitempty"`
+	UserID         int      `json:"user_id,omitempty"`
+	MessageID      int64    `json:"message_id,omitempty"`
+	Unread         *int     `json:"unread,omitempty"`
+	Error          string   `json:"error,omitempty"`
+}
+
+// wsClient is one WebSocket connection of a signed-in user.
+type wsClient struct {
+	userID int
+	send   chan []byte
+}
+
+// messageHub routes frames to the connections of each user. A user may be connected from
+// several tabs or devices, and to another server sharing the database: frames are also stored in
+// repo, and every hub reads those of the others with tailOnce.
+type messageHub struct {
+	mu      sync.Mutex
+	clients map[int]map[*wsClient]struct{}
+	repo    MessageRepository
+	// origin identifies the frames published by this hub, which it has already sent.
+	origin string
+	// started is when the hub was created. Older frames are not sent to its connections.
+	started time.Time
+	// lastID is the ID of the last event read by tailOnce.
+	lastID int64
+}
+
+func newMessageHub(repo MessageRepository) *messageHub {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return &messageHub{
+		clients: make(map[int]map[*wsClient]struct{}),
+		repo:    repo,
+		origin:  hex.EncodeToString(buf),
+		started: time.Now(),
+	}
+}
+
+func (h *messageHub) register(c *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.clients[c.userID] == nil {
+		h.clients[c.userID] = make(map[*wsClient]struct{})
+	}
+	h.clients[c.userID][c] = struct{}{}
+}
+
+// unregister removes c and closes its send channel. It may be called more than once.
+func (h *messageHub) unregister(c *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(c)
+}
+
+// remove does the work of unregister. h.mu must be held.
+func (h *messageHub) remove(c *wsClient) {
+	if _, ok := h.clients[c.userID][c]; !ok {
+		return
+	}
+	delete(h.clients[c.userID], c)
+	if len(h.clients[c.userID]) == 0 {
+		delete(h.clients, c.userID)
+	}
+	close(c.send)
+}
+
+// publish sends frame to every connection of userIDs without waiting for them, here and on the
+// other servers. Connections which fall behind are closed; clients reload the history when they
+// reconnect.
+func (h *messageHub) publish(ctx context.Context, frame wsFrame, userIDs ...int) {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		slog.Error("failed to encode frame", "error", err)
+		return
+	}
+	e := &MessageEvent{Origin: h.origin, UserIDs: userIDs, Frame: data}
+	if err := h.repo.AddMessageEvent(ctx, e); err != nil {
+		slog.Error("failed to pass frame to other servers", "type", frame.Type, "error", err)
+	}
+	h.send(data, userIDs)
+}
+
+// tailOnce sends the frames published by the other servers since the last call to the
+// connections of this one. It is not safe for concurrent use.
+func (h *messageHub) tailOnce(ctx context.Context) error {
+	for {
+		events, err := h.repo.MessageEventsAfter(ctx, h.lastID, messageEventBatch)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, e := range events {
+			if !tailReady(h.lastID, e.ID, e.CreatedAt, now, messageEventGapTimeout) {
+				return nil
+			}
+			if e.Origin != h.origin && !e.CreatedAt.Before(h.started) {
+				h.send(e.Frame, e.UserIDs)
+			}
+			h.lastID = e.ID
+		}
+		if len(events) < messageEventBatch {
+			return nil
+		}
+	}
+}
+
+// send queues an encoded frame on every connection of userIDs.
+func (h *messageHub) send(data []byte, userIDs []int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, userID := range userIDs {
+		if slices.Contains(userIDs[:i], userID) {
+			continue
+		}
+		for c := range h.clients[userID] {
+			select {
+			case c.send <- data:
+			default:
+				slog.Warn("message connection is falling behind; closed", "user_id", userID)
+				h.remove(c)
+			}
+		}
+	}
+}
+
+// checkOrigin accepts same-origin requests and the origins given by Server.WebSocketOrigins.
+func (h *Handlers) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return u.Host == r.Host || slices.Contains(h.wsOrigins, origin)
+}
+
+// MessagesSocket is a handler to exchange messages in real time for GET /ws/messages .
+// Clients send message, typing and read frames; the server pushes new messages, typing
+// indicators, read receipts and the unread count of the user.
+func (h *Handlers) MessagesSocket(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, signedIn := userIDFromContext(ctx)
+	if !signedIn {
+		http.Error(w, "sign in to read messages", http.StatusUnauthorized)
+		return
+	}
+
+	upgrader := websocket.Upgrader{CheckOrigin: h.checkOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade はエラーレスポンスを書き込み済み
+		return
+	}
+	client := &wsClient{userID: userID, send: make(chan []byte, wsSendBuffer)}
+	h.hub.register(client)
+	defer h.hub.unregister(client)
+	go writeFrames(conn, client.send)
+	h.pushUnread(ctx, userID)
+
+	conn.SetReadLimit(wsMaxFrameSize)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		var frame wsFrame
+		if err := conn.ReadJSON(&frame); err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				h.hub.publishTo(client, wsFrame{Type: wsError, Error: "frames must be JSON"})
+				continue
+			}
+			return
+		}
+		if err := h.handleFrame(r, userID, frame); err != nil {
+			h.hub.publishTo(client, wsFrame{Type: wsError, ConversationID: frame.ConversationID, Error: err.Error()})
+		}
+	}
+}
+
+// publishTo sends frame to one connection, closing it if it falls behind.
+func (h *messageHub) publishTo(c *wsClient, frame wsFrame) {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[c.userID][c]; !ok {
+		return
+	}
+	select {
+	case c.send <- data:
+	default:
+		h.remove(c)
+	}
+}
+
+// handleFrame acts on a frame sent by userID.
+func (h *Handlers) handleFrame(r *http.Request, userID int, frame wsFrame) error {
+	ctx := r.Context()
+	c, err := h.conversation(ctx, frame.ConversationID, userID)
+	if err != nil {
+		if errors.Is(err, ErrConversationNotFound) || errors.Is(err, errNotParticipant) {
+			return err
+		}
+		slog.Error("failed to get conversation", "conversation_id", frame.ConversationID, "error", err)
+		return errors.New("failed to get conversation")
+	}
+
+	switch frame.Type {
+	case wsMessage:
+		body, err := messageBody(frame.Body)
+		if err != nil {
+			return err
+		}
+		if _, err := h.sendMessage(ctx, c, userID, body); err != nil {
+			if errors.Is(err, errItemUnavailable) {
+				return err
+			}
+			slog.Error("failed to send message", "conversation_id", c.ID, "error", err)
+			return errors.New("failed to send message")
+		}
+	case wsTyping:
+		h.hub.publish(ctx, wsFrame{Type: wsTyping, ConversationID: c.ID, UserID: userID}, c.other(userID))
+	case wsRead:
+		if _, err := h.markRead(ctx, c, userID, frame.MessageID); err != nil {
+			slog.Error("failed to mark messages read", "conversation_id", c.ID, "error", err)
+			return errors.New("failed to mark messages read")
+		}
+	default:
+		return errors.New("unknown frame type")
+	}
+	return nil
+}
+
+// writeFrames writes the frames from send to conn and pings it while idle, until send is closed.
+func writeFrames(conn *websocket.Conn, send <-chan []byte) {
+	ticker := time.NewTicker(wsPingInterval)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+	}()
+	for {
+		select {
+		case data, ok := <-send:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// MessageEventPruneJob is the periodic job deleting the frames passed between servers.
+const MessageEventPruneJob JobType[struct{}] = "message_event_prune"
+
+const (
+	// messageEventRetention is how long frames are kept for the servers to read them.
+	messageEventRetention = time.Hour
+	// messageEventPruneInterval is how often MessageEventPruneJob runs.
+	messageEventPruneInterval = time.Hour
+)
+
+// messageEventPruneJob returns the MessageEventPruneJob handler deleting the frames created
+// retention before now.
+func messageEventPruneJob(repo MessageRepository, retention time.Duration, now func() time.Time) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		n, err := repo.PruneMessageEvents(ctx, now().Add(-retention))
+		if err != nil {
+			return fmt.Errorf("failed to prune message events: %w", err)
+		}
+		if n > 0 {
+			slog.Info("message events pruned", "count", n)
+		}
+		return nil
+	}
+}
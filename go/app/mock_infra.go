@@ -12,10 +12,53 @@ package app
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	gomock "go.uber.org/mock/gomock"
 )
 
+// MockrowScanner is a mock of rowScanner interface.
+type MockrowScanner struct {
+	ctrl     *gomock.Controller
+	recorder *MockrowScannerMockRecorder
+	isgomock struct{}
+}
+
+// MockrowScannerMockRecorder is the mock recorder for MockrowScanner.
+type MockrowScannerMockRecorder struct {
+	mock *MockrowScanner
+}
+
+// NewMockrowScanner creates a new mock instance.
+func NewMockrowScanner(ctrl *gomock.Controller) *MockrowScanner {
+	mock := &MockrowScanner{ctrl: ctrl}
+	mock.recorder = &MockrowScannerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockrowScanner) EXPECT() *MockrowScannerMockRecorder {
+	return m.recorder
+}
+
+// Scan mocks base method.
+func (m *MockrowScanner) Scan(dest ...any) error {
+	m.ctrl.T.Helper()
+	varargs := []any{}
+	for _, a := range dest {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Scan", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Scan indicates an expected call of Scan.
+func (mr *MockrowScannerMockRecorder) Scan(dest ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Scan", reflect.TypeOf((*MockrowScanner)(nil).Scan), dest...)
+}
+
 // MockItemRepository is a mock of ItemRepository interface.
 type MockItemRepository struct {
 	ctrl     *gomock.Controller
@@ -40,6 +83,50 @@ func (m *MockItemRepository) EXPECT() *MockItemRepositoryMockRecorder {
 	return m.recorder
 }
 
+// Delete mocks base method.
+func (m *MockItemRepository) Delete(ctx context.Context, id, version int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", ctx, id, version)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockItemRepositoryMockRecorder) Delete(ctx, id, version any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockItemRepository)(nil).Delete), ctx, id, version)
+}
+
+// EventsAfter mocks base method.
+func (m *MockItemRepository) EventsAfter(ctx context.Context, afterID int64, limit int) ([]*Event, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EventsAfter", ctx, afterID, limit)
+	ret0, _ := ret[0].([]*Event)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// EventsAfter indicates an expected call of EventsAfter.
+func (mr *MockItemRepositoryMockRecorder) EventsAfter(ctx, afterID, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EventsAfter", reflect.TypeOf((*MockItemRepository)(nil).EventsAfter), ctx, afterID, limit)
+}
+
+// Find mocks base method.
+func (m *MockItemRepository) Find(ctx context.Context, q ItemQuery) ([]*Item, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Find", ctx, q)
+	ret0, _ := ret[0].([]*Item)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Find indicates an expected call of Find.
+func (mr *MockItemRepositoryMockRecorder) Find(ctx, q any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Find", reflect.TypeOf((*MockItemRepository)(nil).Find), ctx, q)
+}
+
 // GetCategories mocks base method.
 func (m *MockItemRepository) GetCategories(ctx context.Context) ([]Category, error) {
 	m.ctrl.T.Helper()
@@ -70,6 +157,36 @@ func (mr *MockItemRepositoryMockRecorder) GetCategoryByName(ctx, name any) *gomo
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCategoryByName", reflect.TypeOf((*MockItemRepository)(nil).GetCategoryByName), ctx, name)
 }
 
+// ImageHashes mocks base method.
+func (m *MockItemRepository) ImageHashes(ctx context.Context) ([]ImageHash, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImageHashes", ctx)
+	ret0, _ := ret[0].([]ImageHash)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImageHashes indicates an expected call of ImageHashes.
+func (mr *MockItemRepositoryMockRecorder) ImageHashes(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageHashes", reflect.TypeOf((*MockItemRepository)(nil).ImageHashes), ctx)
+}
+
+// ImageReferences mocks base method.
+func (m *MockItemRepository) ImageReferences(ctx context.Context) (map[string]int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImageReferences", ctx)
+	ret0, _ := ret[0].(map[string]int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImageReferences indicates an expected call of ImageReferences.
+func (mr *MockItemRepositoryMockRecorder) ImageReferences(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageReferences", reflect.TypeOf((*MockItemRepository)(nil).ImageReferences), ctx)
+}
+
 // Insert mocks base method.
 func (m *MockItemRepository) Insert(ctx context.Context, item *Item) error {
 	m.ctrl.T.Helper()
@@ -114,6 +231,108 @@ func (mr *MockItemRepositoryMockRecorder) List(ctx any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockItemRepository)(nil).List), ctx)
 }
 
+// ListAudit mocks base method.
+func (m *MockItemRepository) ListAudit(ctx context.Context, q AuditQuery) ([]*AuditEntry, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListAudit", ctx, q)
+	ret0, _ := ret[0].([]*AuditEntry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListAudit indicates an expected call of ListAudit.
+func (mr *MockItemRepositoryMockRecorder) ListAudit(ctx, q any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAudit", reflect.TypeOf((*MockItemRepository)(nil).ListAudit), ctx, q)
+}
+
+// MarkEventDelivered mocks base method.
+func (m *MockItemRepository) MarkEventDelivered(ctx context.Context, id int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkEventDelivered", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkEventDelivered indicates an expected call of MarkEventDelivered.
+func (mr *MockItemRepositoryMockRecorder) MarkEventDelivered(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkEventDelivered", reflect.TypeOf((*MockItemRepository)(nil).MarkEventDelivered), ctx, id)
+}
+
+// MarkEventFailed mocks base method.
+func (m *MockItemRepository) MarkEventFailed(ctx context.Context, id int64, next time.Time, reason string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkEventFailed", ctx, id, next, reason)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkEventFailed indicates an expected call of MarkEventFailed.
+func (mr *MockItemRepositoryMockRecorder) MarkEventFailed(ctx, id, next, reason any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkEventFailed", reflect.TypeOf((*MockItemRepository)(nil).MarkEventFailed), ctx, id, next, reason)
+}
+
+// PendingEvents mocks base method.
+func (m *MockItemRepository) PendingEvents(ctx context.Context, now time.Time, limit int) ([]*Event, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PendingEvents", ctx, now, limit)
+	ret0, _ := ret[0].([]*Event)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PendingEvents indicates an expected call of PendingEvents.
+func (mr *MockItemRepositoryMockRecorder) PendingEvents(ctx, now, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PendingEvents", reflect.TypeOf((*MockItemRepository)(nil).PendingEvents), ctx, now, limit)
+}
+
+// PruneEvents mocks base method.
+func (m *MockItemRepository) PruneEvents(ctx context.Context, deliveredBefore time.Time) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PruneEvents", ctx, deliveredBefore)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PruneEvents indicates an expected call of PruneEvents.
+func (mr *MockItemRepositoryMockRecorder) PruneEvents(ctx, deliveredBefore any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PruneEvents", reflect.TypeOf((*MockItemRepository)(nil).PruneEvents), ctx, deliveredBefore)
+}
+
+// Purge mocks base method.
+func (m *MockItemRepository) Purge(ctx context.Context, deletedBefore time.Time) ([]*Item, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Purge", ctx, deletedBefore)
+	ret0, _ := ret[0].([]*Item)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Purge indicates an expected call of Purge.
+func (mr *MockItemRepositoryMockRecorder) Purge(ctx, deletedBefore any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Purge", reflect.TypeOf((*MockItemRepository)(nil).Purge), ctx, deletedBefore)
+}
+
+// Restore mocks base method.
+func (m *MockItemRepository) Restore(ctx context.Context, id, version int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Restore", ctx, id, version)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Restore indicates an expected call of Restore.
+func (mr *MockItemRepositoryMockRecorder) Restore(ctx, id, version any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Restore", reflect.TypeOf((*MockItemRepository)(nil).Restore), ctx, id, version)
+}
+
 // Search mocks base method.
 func (m *MockItemRepository) Search(ctx context.Context, keyword string) ([]*Item, error) {
 	m.ctrl.T.Helper()
@@ -143,3 +362,17 @@ func (mr *MockItemRepositoryMockRecorder) Select(ctx, id any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Select", reflect.TypeOf((*MockItemRepository)(nil).Select), ctx, id)
 }
+
+// Update mocks base method.
+func (m *MockItemRepository) Update(ctx context.Context, item *Item, version int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Update", ctx, item, version)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Update indicates an expected call of Update.
+func (mr *MockItemRepositoryMockRecorder) Update(ctx, item, version any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockItemRepository)(nil).Update), ctx, item, version)
+}
@@ -0,0 +1,309 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrSavedSearchNotFound is returned by NotificationRepository when the user has no saved search with the requested ID.
+var ErrSavedSearchNotFound = errors.New("saved search not found")
+
+const (
+	// maxSavedSearches is how many searches a user can save.
+	maxSavedSearches         = 50
+	defaultNotificationLimit = 50
+	maxNotificationLimit     = 200
+)
+
+// Notification types.
+const (
+	// NotificationSavedSearchMatch tells a user that a new item matches one of their saved searches.
+	NotificationSavedSearchMatch = "saved_search_match"
+	// NotificationNewMessage tells a user that they received a message.
+	NotificationNewMessage = "new_message"
+	// NotificationItemSold tells a seller that their item was bought.
+	NotificationItemSold = "item_sold"
+)
+
+// SavedSearch is a search a user wants to be notified about when new items match it.
+// Zero fields do not filter.
+type SavedSearch struct {
+	ID     int `json:"id"`
+	UserID int `json:"user_id"`
+	// Keyword matches item names containing it, ignoring case, as GET /search does.
+	Keyword  string `json:"keyword"`
+	Category string `json:"category"`
+	// MinPrice and MaxPrice bound the price. Items without a price only match searches without bounds.
+	MinPrice  int       `json:"min_price"`
+	MaxPrice  int       `json:"max_price"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// hasPriceRange reports whether s filters by price.
+func (s *SavedSearch) hasPriceRange() bool {
+	return s.MinPrice != 0 || s.MaxPrice != 0
+}
+
+// matches reports whether item is a result of s.
+func (s *SavedSearch) matches(item *Item) bool {
+	if s.Category != "" && s.Category != item.Category {
+		return false
+	}
+	if s.hasPriceRange() {
+		if item.Price == 0 || item.Price < s.MinPrice || (s.MaxPrice != 0 && item.Price > s.MaxPrice) {
+			return false
+		}
+	}
+	return s.Keyword == "" || strings.Contains(strings.ToLower(item.Name), strings.ToLower(s.Keyword))
+}
+
+// Notification is an entry in a user's inbox.
+type Notification struct {
+	ID     int64  `json:"id"`
+	UserID int    `json:"user_id"`
+	Type   string `json:"type"`
+	// ItemID and SavedSearchID are the item and the saved search the notification is about, if any.
+	ItemID        int `json:"item_id,omitempty"`
+	SavedSearchID int `json:"saved_search_id,omitempty"`
+	// Params are the values shown in the notification, such as item_name.
+	Params map[string]string `json:"params,omitempty"`
+	// Key identifies what the notification is about, so that it is added once per user.
+	Key       string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	// ReadAt is set once the user has read the notification.
+	ReadAt *time.Time `json:"read_at,omitempty"`
+}
+
+// NotificationQuery filters NotificationRepository.ListNotifications. Zero fields do not filter.
+type NotificationQuery struct {
+	UserID int
+	// UnreadOnly leaves out notifications already read.
+	UnreadOnly bool
+	// BeforeID returns notifications older than it, for paging.
+	BeforeID int64
+	Limit    int
+}
+
+// NotificationRepository stores saved searches and the users' notification inboxes.
+type NotificationRepository interface {
+	// CreateSavedSearch stores s, setting its ID and CreatedAt.
+	CreateSavedSearch(ctx context.Context, s *SavedSearch) error
+	// ListSavedSearches returns the saved searches of userID, oldest first.
+	ListSavedSearches(ctx context.Context, userID int) ([]*SavedSearch, error)
+	// DeleteSavedSearch returns ErrSavedSearchNotFound unless userID has a saved search with the ID.
+	DeleteSavedSearch(ctx context.Context, id, userID int) error
+	// MatchSavedSearches returns the saved searches of every user which item is a result of.
+	MatchSavedSearches(ctx context.Context, item *Item) ([]*SavedSearch, error)
+	// AddNotification stores n, setting its ID and CreatedAt, with a pending delivery for each of
+	// channels, and reports whether it was added. A notification with the Key of one already in
+	// the user's inbox is not added again.
+	AddNotification(ctx context.Context, n *Notification, channels ...string) (bool, error)
+	// ListNotifications returns the notifications matching q, newest first.
+	ListNotifications(ctx context.Context, q NotificationQuery) ([]*Notification, error)
+	// MarkNotificationsRead marks the notifications of userID up to upToID (all when zero) as
+	// read at at. It returns how many were marked.
+	MarkNotificationsRead(ctx context.Context, userID int, upToID int64, at time.Time) (int, error)
+	// UnreadNotificationCount returns how many notifications userID has not read.
+	UnreadNotificationCount(ctx context.Context, userID int) (int, error)
+
+	// GetNotificationPreferences returns the preferences of userID, or the defaults if they were never saved.
+	GetNotificationPreferences(ctx context.Context, userID int) (*NotificationPreferences, error)
+	// SaveNotificationPreferences stores p, setting its UpdatedAt.
+	SaveNotificationPreferences(ctx context.Context, p *NotificationPreferences) error
+	// PendingNotificationDeliveries returns up to limit pending deliveries due at now, oldest
+	// first, with their Notification set.
+	PendingNotificationDeliveries(ctx context.Context, now time.Time, limit int) ([]*NotificationDelivery, error)
+	// SaveNotificationDeliveryAttempt stores the status, attempts, next attempt, error and sent time of d.
+	SaveNotificationDeliveryAttempt(ctx context.Context, d *NotificationDelivery) error
+}
+
+// parseSavedSearchForm reads a saved search from the form fields keyword, category, min_price and max_price.
+func parseSavedSearchForm(r *http.Request) (*SavedSearch, error) {
+	s := &SavedSearch{
+		Keyword:  strings.TrimSpace(r.FormValue("keyword")),
+		Category: strings.TrimSpace(r.FormValue("category")),
+	}
+	if utf8.RuneCountInString(s.Keyword) > 255 {
+		return nil, errors.New("keyword is too long (max 255 chars)")
+	}
+	if len(s.Category) > 255 {
+		return nil, errors.New("category is too long (max 255 chars)")
+	}
+	var err error
+	if s.MinPrice, err = parsePrice(r, "min_price"); err != nil {
+		return nil, err
+	}
+	if s.MaxPrice, err = parsePrice(r, "max_price"); err != nil {
+		return nil, err
+	}
+	if s.MaxPrice != 0 && s.MaxPrice < s.MinPrice {
+		return nil, errors.New("max_price must not be less than min_price")
+	}
+	if s.Keyword == "" && s.Category == "" && !s.hasPriceRange() {
+		return nil, errors.New("keyword, category or a price range is required")
+	}
+	return s, nil
+}
+
+// CreateSavedSearch is a handler to save a search for POST /me/saved-searches .
+// Form fields: keyword, category, min_price and max_price; at least one is required.
+func (h *Handlers) CreateSavedSearch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, signedIn := userIDFromContext(ctx)
+	if !signedIn {
+		http.Error(w, "sign in to save searches", http.StatusUnauthorized)
+		return
+	}
+	s, err := parseSavedSearchForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	saved, err := h.notifications.ListSavedSearches(ctx, userID)
+	if err != nil {
+		http.Error(w, "failed to save search", http.StatusInternalServerError)
+		return
+	}
+	if len(saved) >= maxSavedSearches {
+		http.Error(w, fmt.Sprintf("too many saved searches (max %d)", maxSavedSearches), http.StatusConflict)
+		return
+	}
+
+	s.UserID = userID
+	if err := h.notifications.CreateSavedSearch(ctx, s); err != nil {
+		slog.Error("failed to save search", "user_id", userID, "error", err)
+		http.Error(w, "failed to save search", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(s)
+}
+
+// GetSavedSearches is a handler to return the signed-in user's saved searches for GET /me/saved-searches .
+func (h *Handlers) GetSavedSearches(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, signedIn := userIDFromContext(ctx)
+	if !signedIn {
+		http.Error(w, "sign in to see your saved searches", http.StatusUnauthorized)
+		return
+	}
+	searches, err := h.notifications.ListSavedSearches(ctx, userID)
+	if err != nil {
+		http.Error(w, "failed to get saved searches", http.StatusInternalServerError)
+		return
+	}
+	if searches == nil {
+		searches = []*SavedSearch{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"saved_searches": searches})
+}
+
+// DeleteSavedSearch is a handler to stop a saved search for DELETE /me/saved-searches/{id} .
+// Notifications it already produced stay in the inbox.
+func (h *Handlers) DeleteSavedSearch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		return
+	}
+	userID, signedIn := userIDFromContext(ctx)
+	if !signedIn {
+		http.Error(w, "sign in to delete saved searches", http.StatusUnauthorized)
+		return
+	}
+	if err := h.notifications.DeleteSavedSearch(ctx, id, userID); err != nil {
+		if errors.Is(err, ErrSavedSearchNotFound) {
+			http.Error(w, "saved search not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to delete saved search", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetNotifications is a handler to return the signed-in user's inbox, newest first, with the
+// number of unread notifications for GET /me/notifications . Filters: unread, before_id and limit.
+func (h *Handlers) GetNotifications(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, signedIn := userIDFromContext(ctx)
+	if !signedIn {
+		http.Error(w, "sign in to see your notifications", http.StatusUnauthorized)
+		return
+	}
+	q := NotificationQuery{UserID: userID, Limit: defaultNotificationLimit}
+	var err error
+	if s := r.URL.Query().Get("unread"); s != "" {
+		if q.UnreadOnly, err = strconv.ParseBool(s); err != nil {
+			http.Error(w, "unread must be a boolean", http.StatusBadRequest)
+			return
+		}
+	}
+	if s := r.URL.Query().Get("before_id"); s != "" {
+		if q.BeforeID, err = strconv.ParseInt(s, 10, 64); err != nil || q.BeforeID < 0 {
+			http.Error(w, "before_id must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+	}
+	if s := r.URL.Query().Get("limit"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil || q.Limit <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		q.Limit = min(q.Limit, maxNotificationLimit)
+	}
+
+	notifications, err := h.notifications.ListNotifications(ctx, q)
+	if err != nil {
+		http.Error(w, "failed to get notifications", http.StatusInternalServerError)
+		return
+	}
+	unread, err := h.notifications.UnreadNotificationCount(ctx, userID)
+	if err != nil {
+		http.Error(w, "failed to get notifications", http.StatusInternalServerError)
+		return
+	}
+	if notifications == nil {
+		notifications = []*Notification{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"notifications": notifications, "unread_count": unread})
+}
+
+// ReadNotifications is a handler to mark the signed-in user's notifications as read for
+// POST /me/notifications/read . The form field up_to limits it to the notifications up to that ID.
+func (h *Handlers) ReadNotifications(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, signedIn := userIDFromContext(ctx)
+	if !signedIn {
+		http.Error(w, "sign in to read notifications", http.StatusUnauthorized)
+		return
+	}
+	var upTo int64
+	if s := r.FormValue("up_to"); s != "" {
+		var err error
+		if upTo, err = strconv.ParseInt(s, 10, 64); err != nil || upTo < 0 {
+			http.Error(w, "up_to must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	n, err := h.notifications.MarkNotificationsRead(ctx, userID, upTo, time.Now().UTC())
+	if err != nil {
+		http.Error(w, "failed to mark notifications read", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"read": n})
+}
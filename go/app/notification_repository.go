@@ -0,0 +1,601 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sqlNotificationRepository stores saved searches and notifications in SQLite or PostgreSQL.
+// placeholder is the dialect's bind parameter.
+type sqlNotificationRepository struct {
+	db          *sql.DB
+	placeholder func(int) string
+}
+
+// NewNotificationRepository returns a NotificationRepository using the database opened by OpenDatabase with dsn.
+func NewNotificationRepository(db *sql.DB, dsn string) NotificationRepository {
+	d, _ := parseDSN(dsn)
+	return &sqlNotificationRepository{db: db, placeholder: d.placeholder()}
+}
+
+const savedSearchColumns = `id, user_id, keyword, category, min_price, max_price, created_at`
+
+func scanSavedSearch(row rowScanner) (*SavedSearch, error) {
+	var s SavedSearch
+	if err := row.Scan(&s.ID, &s.UserID, &s.Keyword, &s.Category, &s.MinPrice, &s.MaxPrice, &s.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// querySavedSearches returns the saved searches selected by query.
+func (r *sqlNotificationRepository) querySavedSearches(ctx context.Context, query string, args ...any) ([]*SavedSearch, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list saved searches: %w", err)
+	}
+	defer rows.Close()
+
+	var searches []*SavedSearch
+	for rows.Next() {
+		s, err := scanSavedSearch(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan saved search: %w", err)
+		}
+		searches = append(searches, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list saved searches: %w", err)
+	}
+	return searches, nil
+}
+
+// CreateSavedSearch inserts s and sets its ID and CreatedAt.
+func (r *sqlNotificationRepository) CreateSavedSearch(ctx context.Context, s *SavedSearch) error {
+	now := time.Now().UTC()
+	query := `INSERT INTO saved_searches (user_id, keyword, category, min_price, max_price, created_at)
+        VALUES (` + params(r.placeholder, 6) + `) RETURNING id`
+	if err := r.db.QueryRowContext(ctx, query, s.UserID, s.Keyword, s.Category, s.MinPrice, s.MaxPrice, now).Scan(&s.ID); err != nil {
+		return fmt.Errorf("failed to save search: %w", err)
+	}
+	s.CreatedAt = now
+	return nil
+}
+
+// ListSavedSearches returns the saved searches of userID, oldest first.
+func (r *sqlNotificationRepository) ListSavedSearches(ctx context.Context, userID int) ([]*SavedSearch, error) {
+	query := `SELECT ` + savedSearchColumns + ` FROM saved_searches WHERE user_id = ` + r.placeholder(1) + ` ORDER BY id`
+	return r.querySavedSearches(ctx, query, userID)
+}
+
+// DeleteSavedSearch removes a saved search of userID.
+func (r *sqlNotificationRepository) DeleteSavedSearch(ctx context.Context, id, userID int) error {
+	query := `DELETE FROM saved_searches WHERE id = ` + r.placeholder(1) + ` AND user_id = ` + r.placeholder(2)
+	result, err := r.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete saved search: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to delete saved search: %w", err)
+	} else if n == 0 {
+		return ErrSavedSearchNotFound
+	}
+	return nil
+}
+
+// MatchSavedSearches returns the saved searches item is a result of.
+// カテゴリと価格はインデックスで絞り込み、キーワードの部分一致だけを残った検索条件に対して調べる
+func (r *sqlNotificationRepository) MatchSavedSearches(ctx context.Context, item *Item) ([]*SavedSearch, error) {
+	query := `SELECT ` + savedSearchColumns + ` FROM saved_searches
+        WHERE category IN ('', ` + r.placeholder(1) + `)
+        AND ((min_price = 0 AND max_price = 0) OR (` + r.placeholder(2) + ` > 0 AND min_price <= ` + r.placeholder(3) + `
+            AND (max_price = 0 OR max_price >= ` + r.placeholder(4) + `)))
+        ORDER BY id`
+	candidates, err := r.querySavedSearches(ctx, query, item.Category, item.Price, item.Price, item.Price)
+	if err != nil {
+		return nil, err
+	}
+	var searches []*SavedSearch
+	for _, s := range candidates {
+		if s.matches(item) {
+			searches = append(searches, s)
+		}
+	}
+	return searches, nil
+}
+
+// encodeParams stores notification params as JSON, or NULL when there are none.
+func encodeParams(params map[string]string) (sql.NullString, error) {
+	if len(params) == 0 {
+		return sql.NullString{}, nil
+	}
+	b, err := json.Marshal(params)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("failed to encode notification params: %w", err)
+	}
+	return sql.NullString{String: string(b), Valid: true}, nil
+}
+
+const notificationColumns = `n.id, n.user_id, n.type, n.item_id, n.saved_search_id, n.params, n.dedupe_key, n.created_at, n.read_at`
+
+func scanNotification(row rowScanner, extra ...any) (*Notification, error) {
+	var n Notification
+	var itemID, searchID sql.NullInt64
+	var params sql.NullString
+	var readAt sql.NullTime
+	dest := append([]any{&n.ID, &n.UserID, &n.Type, &itemID, &searchID, &params, &n.Key, &n.CreatedAt, &readAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	n.ItemID, n.SavedSearchID = int(itemID.Int64), int(searchID.Int64)
+	if params.Valid {
+		if err := json.Unmarshal([]byte(params.String), &n.Params); err != nil {
+			return nil, fmt.Errorf("failed to decode notification params: %w", err)
+		}
+	}
+	if readAt.Valid {
+		n.ReadAt = &readAt.Time
+	}
+	return &n, nil
+}
+
+// AddNotification inserts n with its deliveries unless the user already has a notification with its key.
+func (r *sqlNotificationRepository) AddNotification(ctx context.Context, n *Notification, channels ...string) (bool, error) {
+	encoded, err := encodeParams(n.Params)
+	if err != nil {
+		return false, err
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	query := `INSERT INTO notifications (user_id, type, item_id, saved_search_id, params, dedupe_key, created_at)
+        VALUES (` + params(r.placeholder, 7) + `) ON CONFLICT (user_id, dedupe_key) DO NOTHING RETURNING id`
+	err = tx.QueryRowContext(ctx, query, n.UserID, n.Type, nullInt(int64(n.ItemID)), nullInt(int64(n.SavedSearchID)), encoded, n.Key, now).Scan(&n.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to add notification: %w", err)
+	}
+	// 通知と送信キューを同じトランザクションで書くので、受信箱にあってメールが届かないことはない
+	insert := `INSERT INTO notification_deliveries (notification_id, channel, status, attempts, next_attempt_at, created_at)
+        VALUES (` + params(r.placeholder, 6) + `)`
+	for _, channel := range channels {
+		if _, err := tx.ExecContext(ctx, insert, n.ID, channel, NotificationDeliveryPending, 0, now, now); err != nil {
+			return false, fmt.Errorf("failed to queue notification: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to add notification: %w", err)
+	}
+	n.CreatedAt = now
+	return true, nil
+}
+
+// ListNotifications returns the notifications matching q, newest first.
+func (r *sqlNotificationRepository) ListNotifications(ctx context.Context, q NotificationQuery) ([]*Notification, error) {
+	query := `SELECT ` + notificationColumns + ` FROM notifications n WHERE n.user_id = ` + r.placeholder(1)
+	args := []any{q.UserID}
+	if q.UnreadOnly {
+		query += ` AND n.read_at IS NULL`
+	}
+	if q.BeforeID != 0 {
+		args = append(args, q.BeforeID)
+		query += ` AND n.id < ` + r.placeholder(len(args))
+	}
+	query += ` ORDER BY n.id DESC`
+	if q.Limit > 0 {
+		query += ` LIMIT ` + strconv.Itoa(q.Limit)
+	}
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list notifications: %w", err)
+	}
+	defer rows.Close()
+
+	var notifications []*Notification
+	for rows.Next() {
+		n, err := scanNotification(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan notification: %w", err)
+		}
+		notifications = append(notifications, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list notifications: %w", err)
+	}
+	return notifications, nil
+}
+
+// MarkNotificationsRead marks the unread notifications of userID as read.
+func (r *sqlNotificationRepository) MarkNotificationsRead(ctx context.Context, userID int, upToID int64, at time.Time) (int, error) {
+	query := `UPDATE notifications SET read_at = ` + r.placeholder(1) + ` WHERE user_id = ` + r.placeholder(2) + ` AND read_at IS NULL`
+	args := []any{at.UTC(), userID}
+	if upToID != 0 {
+		query += ` AND id <= ` + r.placeholder(3)
+		args = append(args, upToID)
+	}
+	result, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark notifications read: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark notifications read: %w", err)
+	}
+	return int(n), nil
+}
+
+// UnreadNotificationCount returns how many notifications userID has not read.
+func (r *sqlNotificationRepository) UnreadNotificationCount(ctx context.Context, userID int) (int, error) {
+	query := `SELECT COUNT(*) FROM notifications WHERE user_id = ` + r.placeholder(1) + ` AND read_at IS NULL`
+	var n int
+	if err := r.db.QueryRowContext(ctx, query, userID).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count unread notifications: %w", err)
+	}
+	return n, nil
+}
+
+// GetNotificationPreferences returns the preferences of userID or the defaults.
+func (r *sqlNotificationRepository) GetNotificationPreferences(ctx context.Context, userID int) (*NotificationPreferences, error) {
+	query := `SELECT language, email, email_types, updated_at FROM notification_preferences WHERE user_id = ` + r.placeholder(1)
+	p := &NotificationPreferences{UserID: userID}
+	var emailTypes string
+	err := r.db.QueryRowContext(ctx, query, userID).Scan(&p.Language, &p.Email, &emailTypes, &p.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return defaultNotificationPreferences(userID), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get notification preferences: %w", err)
+	}
+	p.EmailTypes = splitList(emailTypes)
+	return p, nil
+}
+
+// SaveNotificationPreferences inserts or replaces the preferences of p.UserID.
+func (r *sqlNotificationRepository) SaveNotificationPreferences(ctx context.Context, p *NotificationPreferences) error {
+	now := time.Now().UTC()
+	query := `INSERT INTO notification_preferences (user_id, language, email, email_types, updated_at)
+        VALUES (` + params(r.placeholder, 5) + `)
+        ON CONFLICT (user_id) DO UPDATE SET language = excluded.language, email = excluded.email,
+            email_types = excluded.email_types, updated_at = excluded.updated_at`
+	if _, err := r.db.ExecContext(ctx, query, p.UserID, p.Language, p.Email, joinList(p.EmailTypes), now); err != nil {
+		return fmt.Errorf("failed to save notification preferences: %w", err)
+	}
+	p.UpdatedAt = now
+	return nil
+}
+
+// PendingNotificationDeliveries returns the pending deliveries due at now with their notifications.
+func (r *sqlNotificationRepository) PendingNotificationDeliveries(ctx context.Context, now time.Time, limit int) ([]*NotificationDelivery, error) {
+	query := `SELECT ` + notificationColumns + `, d.id, d.channel, d.status, d.attempts, d.next_attempt_at, d.last_error, d.created_at
+        FROM notification_deliveries d
+        JOIN notifications n ON n.id = d.notification_id
+        WHERE d.status = ` + r.placeholder(1) + ` AND d.next_attempt_at <= ` + r.placeholder(2) + `
+        ORDER BY d.id LIMIT ` + strconv.Itoa(limit)
+	rows, err := r.db.QueryContext(ctx, query, NotificationDeliveryPending, now.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve notification deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []*NotificationDelivery
+	for rows.Next() {
+		var d NotificationDelivery
+		n, err := scanNotification(rows, &d.ID, &d.Channel, &d.Status, &d.Attempts, &d.NextAttemptAt, &d.LastError, &d.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan notification delivery: %w", err)
+		}
+		d.NotificationID, d.Notification = n.ID, n
+		deliveries = append(deliveries, &d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve notification deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// SaveNotificationDeliveryAttempt stores the outcome of an attempt to send d.
+func (r *sqlNotificationRepository) SaveNotificationDeliveryAttempt(ctx context.Context, d *NotificationDelivery) error {
+	query := fmt.Sprintf(`UPDATE notification_deliveries SET status = %s, attempts = %s, next_attempt_at = %s, last_error = %s, sent_at = %s WHERE id = %s`,
+		r.placeholder(1), r.placeholder(2), r.placeholder(3), r.placeholder(4), r.placeholder(5), r.placeholder(6))
+	var sentAt sql.NullTime
+	if d.SentAt != nil {
+		sentAt = sql.NullTime{Time: d.SentAt.UTC(), Valid: true}
+	}
+	if _, err := r.db.ExecContext(ctx, query, d.Status, d.Attempts, d.NextAttemptAt.UTC(), d.LastError, sentAt, d.ID); err != nil {
+		return fmt.Errorf("failed to save notification delivery: %w", err)
+	}
+	return nil
+}
+
+// memoryNotificationRepository keeps saved searches and notifications in memory, for --storage=memory and tests.
+type memoryNotificationRepository struct {
+	mu                 sync.RWMutex
+	searches           []*SavedSearch
+	nextSearchID       int
+	notifications      []*Notification
+	nextNotificationID int64
+	deliveries         []*NotificationDelivery
+	nextDeliveryID     int64
+	preferences        map[int]*NotificationPreferences
+}
+
+// NewMemoryNotificationRepository returns an empty NotificationRepository kept in memory.
+func NewMemoryNotificationRepository() NotificationRepository {
+	return &memoryNotificationRepository{
+		nextSearchID:       1,
+		nextNotificationID: 1,
+		nextDeliveryID:     1,
+		preferences:        make(map[int]*NotificationPreferences),
+	}
+}
+
+func cloneNotification(n *Notification) *Notification {
+	copied := *n
+	copied.Params = maps.Clone(n.Params)
+	if n.ReadAt != nil {
+		t := *n.ReadAt
+		copied.ReadAt = &t
+	}
+	return &copied
+}
+
+// CreateSavedSearch stores s and sets its ID and CreatedAt.
+func (m *memoryNotificationRepository) CreateSavedSearch(ctx context.Context, s *SavedSearch) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to save search: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s.ID = m.nextSearchID
+	m.nextSearchID++
+	s.CreatedAt = time.Now().UTC()
+	copied := *s
+	m.searches = append(m.searches, &copied)
+	return nil
+}
+
+// ListSavedSearches returns the saved searches of userID, oldest first.
+func (m *memoryNotificationRepository) ListSavedSearches(ctx context.Context, userID int) ([]*SavedSearch, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list saved searches: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var searches []*SavedSearch
+	for _, s := range m.searches {
+		if s.UserID == userID {
+			copied := *s
+			searches = append(searches, &copied)
+		}
+	}
+	return searches, nil
+}
+
+// DeleteSavedSearch removes a saved search of userID.
+func (m *memoryNotificationRepository) DeleteSavedSearch(ctx context.Context, id, userID int) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to delete saved search: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := slices.IndexFunc(m.searches, func(s *SavedSearch) bool {
+		return s.ID == id && s.UserID == userID
+	})
+	if i < 0 {
+		return ErrSavedSearchNotFound
+	}
+	m.searches = slices.Delete(m.searches, i, i+1)
+	return nil
+}
+
+// MatchSavedSearches returns the saved searches item is a result of.
+func (m *memoryNotificationRepository) MatchSavedSearches(ctx context.Context, item *Item) ([]*SavedSearch, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list saved searches: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var searches []*SavedSearch
+	for _, s := range m.searches {
+		if s.matches(item) {
+			copied := *s
+			searches = append(searches, &copied)
+		}
+	}
+	return searches, nil
+}
+
+// AddNotification stores n with its deliveries unless the user already has a notification with its key.
+func (m *memoryNotificationRepository) AddNotification(ctx context.Context, n *Notification, channels ...string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, fmt.Errorf("failed to add notification: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stored := range m.notifications {
+		if stored.UserID == n.UserID && stored.Key == n.Key {
+			return false, nil
+		}
+	}
+	n.ID = m.nextNotificationID
+	m.nextNotificationID++
+	n.CreatedAt = time.Now().UTC()
+	m.notifications = append(m.notifications, cloneNotification(n))
+	for _, channel := range channels {
+		m.deliveries = append(m.deliveries, &NotificationDelivery{
+			ID:             m.nextDeliveryID,
+			NotificationID: n.ID,
+			Channel:        channel,
+			Status:         NotificationDeliveryPending,
+			NextAttemptAt:  n.CreatedAt,
+			CreatedAt:      n.CreatedAt,
+		})
+		m.nextDeliveryID++
+	}
+	return true, nil
+}
+
+// ListNotifications returns the notifications matching q, newest first.
+func (m *memoryNotificationRepository) ListNotifications(ctx context.Context, q NotificationQuery) ([]*Notification, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list notifications: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var notifications []*Notification
+	for _, n := range slices.Backward(m.notifications) {
+		if q.Limit > 0 && len(notifications) == q.Limit {
+			break
+		}
+		if n.UserID != q.UserID || (q.UnreadOnly && n.ReadAt != nil) || (q.BeforeID != 0 && n.ID >= q.BeforeID) {
+			continue
+		}
+		notifications = append(notifications, cloneNotification(n))
+	}
+	return notifications, nil
+}
+
+// MarkNotificationsRead marks the unread notifications of userID as read.
+func (m *memoryNotificationRepository) MarkNotificationsRead(ctx context.Context, userID int, upToID int64, at time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("failed to mark notifications read: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	for _, n := range m.notifications {
+		if n.UserID == userID && n.ReadAt == nil && (upToID == 0 || n.ID <= upToID) {
+			readAt := at.UTC()
+			n.ReadAt = &readAt
+			count++
+		}
+	}
+	return count, nil
+}
+
+// UnreadNotificationCount returns how many notifications userID has not read.
+func (m *memoryNotificationRepository) UnreadNotificationCount(ctx context.Context, userID int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("failed to count unread notifications: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	count := 0
+	for _, n := range m.notifications {
+		if n.UserID == userID && n.ReadAt == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// GetNotificationPreferences returns the preferences of userID or the defaults.
+func (m *memoryNotificationRepository) GetNotificationPreferences(ctx context.Context, userID int) (*NotificationPreferences, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get notification preferences: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	p, ok := m.preferences[userID]
+	if !ok {
+		return defaultNotificationPreferences(userID), nil
+	}
+	copied := *p
+	copied.EmailTypes = slices.Clone(p.EmailTypes)
+	return &copied, nil
+}
+
+// SaveNotificationPreferences stores p.
+func (m *memoryNotificationRepository) SaveNotificationPreferences(ctx context.Context, p *NotificationPreferences) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to save notification preferences: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p.UpdatedAt = time.Now().UTC()
+	copied := *p
+	copied.EmailTypes = slices.Clone(p.EmailTypes)
+	m.preferences[p.UserID] = &copied
+	return nil
+}
+
+// notification returns the notification with the given ID. m.mu must be held.
+func (m *memoryNotificationRepository) notification(id int64) *Notification {
+	i, ok := slices.BinarySearchFunc(m.notifications, id, func(n *Notification, id int64) int {
+		return cmp.Compare(n.ID, id)
+	})
+	if !ok {
+		return nil
+	}
+	return m.notifications[i]
+}
+
+// PendingNotificationDeliveries returns the pending deliveries due at now with their notifications.
+func (m *memoryNotificationRepository) PendingNotificationDeliveries(ctx context.Context, now time.Time, limit int) ([]*NotificationDelivery, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve notification deliveries: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var deliveries []*NotificationDelivery
+	for _, d := range m.deliveries {
+		if len(deliveries) == limit {
+			break
+		}
+		if d.Status != NotificationDeliveryPending || d.NextAttemptAt.After(now) {
+			continue
+		}
+		copied := *d
+		copied.Notification = cloneNotification(m.notification(d.NotificationID))
+		deliveries = append(deliveries, &copied)
+	}
+	return deliveries, nil
+}
+
+// SaveNotificationDeliveryAttempt stores the outcome of an attempt to send d.
+func (m *memoryNotificationRepository) SaveNotificationDeliveryAttempt(ctx context.Context, d *NotificationDelivery) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to save notification delivery: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stored := range m.deliveries {
+		if stored.ID == d.ID {
+			stored.Status, stored.Attempts, stored.LastError = d.Status, d.Attempts, d.LastError
+			stored.NextAttemptAt = d.NextAttemptAt
+			if d.SentAt != nil {
+				sentAt := d.SentAt.UTC()
+				stored.SentAt = &sentAt
+			}
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,354 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSavedSearchMatches(t *testing.T) {
+	t.Parallel()
+
+	item := &Item{Name: "Leather Jacket", Category: "fashion", Price: 5000}
+	cases := map[string]struct {
+		search SavedSearch
+		item   *Item
+		want   bool
+	}{
+		"ok: keyword ignoring case": {SavedSearch{Keyword: "jacket"}, item, true},
+		"ok: category":              {SavedSearch{Category: "fashion"}, item, true},
+		"ok: price range":           {SavedSearch{MinPrice: 5000, MaxPrice: 5000}, item, true},
+		"ok: lower bound only":      {SavedSearch{MinPrice: 1000}, item, true},
+		"ok: every filter":          {SavedSearch{Keyword: "leather", Category: "fashion", MaxPrice: 8000}, item, true},
+		"ng: other keyword":         {SavedSearch{Keyword: "coat"}, item, false},
+		"ng: other category":        {SavedSearch{Keyword: "jacket", Category: "books"}, item, false},
+		"ng: too expensive":         {SavedSearch{MaxPrice: 4999}, item, false},
+		"ng: too cheap":             {SavedSearch{MinPrice: 5001}, item, false},
+		"ng: no price":              {SavedSearch{MaxPrice: 8000}, &Item{Name: "jacket"}, false},
+	}
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if got := tt.search.matches(tt.item); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// notificationRepositories returns constructors of every NotificationRepository implementation.
+func notificationRepositories() map[string]func(t *testing.T) NotificationRepository {
+	return map[string]func(t *testing.T) NotificationRepository{
+		"sqlite": func(t *testing.T) NotificationRepository {
+			dsn := filepath.Join(t.TempDir(), "test.sqlite3")
+			db, _, err := OpenDatabase(dsn)
+			if err != nil {
+				t.Fatalf("failed to open database: %v", err)
+			}
+			t.Cleanup(func() { db.Close() })
+			return NewNotificationRepository(db, dsn)
+		},
+		"memory": func(t *testing.T) NotificationRepository {
+			return NewMemoryNotificationRepository()
+		},
+	}
+}
+
+func TestNotificationRepository(t *testing.T) {
+	t.Parallel()
+
+	const alice, bob = 1, 2
+	for name, newRepo := range notificationRepositories() {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			repo := newRepo(t)
+
+			searches := []*SavedSearch{
+				{UserID: alice, Keyword: "Jacket"},
+				{UserID: alice, Category: "fashion", MinPrice: 1000, MaxPrice: 3000},
+				{UserID: bob, Keyword: "coat", Category: "fashion"},
+				{UserID: bob, Category: "books"},
+			}
+			for _, s := range searches {
+				if err := repo.CreateSavedSearch(ctx, s); err != nil {
+					t.Fatalf("failed to save search: %v", err)
+				}
+			}
+			saved, err := repo.ListSavedSearches(ctx, alice)
+			if err != nil {
+				t.Fatalf("failed to list saved searches: %v", err)
+			}
+			if len(saved) != 2 || saved[0].ID != searches[0].ID || saved[1].MaxPrice != 3000 {
+				t.Errorf("expected alice's two searches, got %+v", saved)
+			}
+
+			matchIDs := func(item *Item) []int {
+				t.Helper()
+				matched, err := repo.MatchSavedSearches(ctx, item)
+				if err != nil {
+					t.Fatalf("failed to match saved searches: %v", err)
+				}
+				var ids []int
+				for _, s := range matched {
+					ids = append(ids, s.ID)
+				}
+				return ids
+			}
+			if diff := cmp.Diff([]int{searches[0].ID, searches[1].ID}, matchIDs(&Item{Name: "denim jacket", Category: "fashion", Price: 2000})); diff != "" {
+				t.Errorf("unexpected matches (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff([]int{searches[0].ID}, matchIDs(&Item{Name: "denim jacket", Category: "fashion"})); diff != "" {
+				t.Errorf("unexpected matches for an item without price (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff([]int{searches[2].ID}, matchIDs(&Item{Name: "Trench Coat", Category: "fashion", Price: 9000})); diff != "" {
+				t.Errorf("unexpected matches (-want +got):\n%s", diff)
+			}
+
+			if err := repo.DeleteSavedSearch(ctx, searches[3].ID, alice); !errors.Is(err, ErrSavedSearchNotFound) {
+				t.Errorf("expected ErrSavedSearchNotFound deleting another user's search, got %v", err)
+			}
+			if err := repo.DeleteSavedSearch(ctx, searches[3].ID, bob); err != nil {
+				t.Fatalf("failed to delete saved search: %v", err)
+			}
+			if got := matchIDs(&Item{Name: "novel", Category: "books"}); len(got) != 0 {
+				t.Errorf("expected the deleted search not to match, got %v", got)
+			}
+
+			var added []*Notification
+			for _, itemID := range []int{10, 11, 10, 12} {
+				n := &Notification{UserID: alice, Type: NotificationSavedSearchMatch, ItemID: itemID, Key: "item:" + strconv.Itoa(itemID)}
+				ok, err := repo.AddNotification(ctx, n)
+				if err != nil {
+					t.Fatalf("failed to add notification: %v", err)
+				}
+				if ok {
+					added = append(added, n)
+				}
+			}
+			if len(added) != 3 {
+				t.Fatalf("expected the repeated key to be added once, got %d notifications", len(added))
+			}
+			if ok, err := repo.AddNotification(ctx, &Notification{UserID: bob, Type: NotificationSavedSearchMatch, ItemID: 10, Key: "item:10"}); err != nil || !ok {
+				t.Fatalf("expected another user to get the same key, got %v, %v", ok, err)
+			}
+
+			page, err := repo.ListNotifications(ctx, NotificationQuery{UserID: alice, Limit: 2})
+			if err != nil {
+				t.Fatalf("failed to list notifications: %v", err)
+			}
+			if len(page) != 2 || page[0].ID != added[2].ID || page[1].ID != added[1].ID {
+				t.Fatalf("expected the newest two notifications, got %+v", page)
+			}
+			page, err = repo.ListNotifications(ctx, NotificationQuery{UserID: alice, BeforeID: page[1].ID})
+			if err != nil {
+				t.Fatalf("failed to list notifications: %v", err)
+			}
+			if len(page) != 1 || page[0].ID != added[0].ID || page[0].ItemID != 10 || page[0].ReadAt != nil {
+				t.Fatalf("expected the oldest notification, got %+v", page)
+			}
+
+			n, err := repo.MarkNotificationsRead(ctx, alice, added[1].ID, time.Now())
+			if err != nil {
+				t.Fatalf("failed to mark notifications read: %v", err)
+			}
+			if n != 2 {
+				t.Errorf("expected 2 notifications marked read, got %d", n)
+			}
+			unread, err := repo.ListNotifications(ctx, NotificationQuery{UserID: alice, UnreadOnly: true})
+			if err != nil {
+				t.Fatalf("failed to list notifications: %v", err)
+			}
+			if len(unread) != 1 || unread[0].ID != added[2].ID {
+				t.Errorf("expected only the newest notification unread, got %+v", unread)
+			}
+			for user, want := range map[int]int{alice: 1, bob: 1} {
+				count, err := repo.UnreadNotificationCount(ctx, user)
+				if err != nil {
+					t.Fatalf("failed to count unread notifications: %v", err)
+				}
+				if count != want {
+					t.Errorf("expected %d unread notifications for user %d, got %d", want, user, count)
+				}
+			}
+		})
+	}
+}
+
+func TestNotificationSinkSavedSearches(t *testing.T) {
+	t.Parallel()
+
+	const seller, buyer = 1, 2
+	ctx := context.Background()
+	items := NewMemoryItemRepository()
+	notifications := NewMemoryNotificationRepository()
+	for _, s := range []*SavedSearch{
+		{UserID: buyer, Keyword: "jacket"},
+		{UserID: buyer, Category: "fashion"},
+		{UserID: seller, Keyword: "jacket"},
+	} {
+		if err := notifications.CreateSavedSearch(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, item := range []*Item{
+		{Name: "jacket", Category: "fashion", SellerID: seller, Price: 3000},
+		{Name: "novel", Category: "books", SellerID: seller},
+	} {
+		if err := items.Insert(ctx, item); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// outbox から同じイベントが再送されても通知は一件になる
+	sink := NewNotificationSink(NewNotifier(notifications))
+	events, err := items.PendingEvents(ctx, time.Now(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		for _, e := range events {
+			if err := sink.Deliver(ctx, e); err != nil {
+				t.Fatalf("failed to deliver %s: %v", e.Type, err)
+			}
+		}
+	}
+
+	for user, want := range map[int][]int{buyer: {1}, seller: nil} {
+		got, err := notifications.ListNotifications(ctx, NotificationQuery{UserID: user})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var itemIDs []int
+		for _, n := range got {
+			itemIDs = append(itemIDs, n.ItemID)
+		}
+		if diff := cmp.Diff(want, itemIDs); diff != "" {
+			t.Errorf("unexpected notifications of user %d (-want +got):\n%s", user, diff)
+		}
+	}
+}
+
+func TestNotificationHandlers(t *testing.T) {
+	t.Parallel()
+
+	const alice, bob = 1, 2
+	type step struct {
+		method string
+		path   string
+		form   url.Values
+		userID int
+		status int
+	}
+
+	cases := map[string][]step{
+		"ok: save, list and delete searches": {
+			{http.MethodPost, "/me/saved-searches", url.Values{"keyword": {"jacket"}, "max_price": {"5000"}}, alice, http.StatusCreated},
+			{http.MethodPost, "/me/saved-searches", url.Values{"category": {"fashion"}}, alice, http.StatusCreated},
+			{http.MethodGet, "/me/saved-searches", nil, alice, http.StatusOK},
+			{http.MethodDelete, "/me/saved-searches/1", nil, bob, http.StatusNotFound},
+			{http.MethodDelete, "/me/saved-searches/1", nil, alice, http.StatusNoContent},
+			{http.MethodDelete, "/me/saved-searches/1", nil, alice, http.StatusNotFound},
+		},
+		"ok: read notifications": {
+			{http.MethodGet, "/me/notifications?unread=true&limit=10", nil, alice, http.StatusOK},
+			{http.MethodPost, "/me/notifications/read", url.Values{"up_to": {"5"}}, alice, http.StatusOK},
+		},
+		"ng: not signed in": {
+			{http.MethodPost, "/me/saved-searches", url.Values{"keyword": {"jacket"}}, 0, http.StatusUnauthorized},
+			{http.MethodGet, "/me/saved-searches", nil, 0, http.StatusUnauthorized},
+			{http.MethodDelete, "/me/saved-searches/1", nil, 0, http.StatusUnauthorized},
+			{http.MethodGet, "/me/notifications", nil, 0, http.StatusUnauthorized},
+			{http.MethodPost, "/me/notifications/read", nil, 0, http.StatusUnauthorized},
+		},
+		"ng: invalid searches": {
+			{http.MethodPost, "/me/saved-searches", url.Values{"keyword": {" "}}, alice, http.StatusBadRequest},
+			{http.MethodPost, "/me/saved-searches", url.Values{"keyword": {"jacket"}, "min_price": {"-1"}}, alice, http.StatusBadRequest},
+			{http.MethodPost, "/me/saved-searches", url.Values{"min_price": {"5000"}, "max_price": {"1000"}}, alice, http.StatusBadRequest},
+			{http.MethodPost, "/me/saved-searches", url.Values{"keyword": {strings.Repeat("a", 256)}}, alice, http.StatusBadRequest},
+			{http.MethodDelete, "/me/saved-searches/abc", nil, alice, http.StatusBadRequest},
+		},
+		"ng: invalid inbox queries": {
+			{http.MethodGet, "/me/notifications?unread=maybe", nil, alice, http.StatusBadRequest},
+			{http.MethodGet, "/me/notifications?before_id=-1", nil, alice, http.StatusBadRequest},
+			{http.MethodGet, "/me/notifications?limit=0", nil, alice, http.StatusBadRequest},
+			{http.MethodPost, "/me/notifications/read", url.Values{"up_to": {"x"}}, alice, http.StatusBadRequest},
+		},
+	}
+
+	for name, steps := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, mux := newNotificationServer()
+			for _, s := range steps {
+				req := httptest.NewRequest(s.method, s.path, strings.NewReader(s.form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				if s.userID != 0 {
+					req = req.WithContext(ContextWithUserID(req.Context(), s.userID))
+				}
+				rr := httptest.NewRecorder()
+				mux.ServeHTTP(rr, req)
+				if rr.Code != s.status {
+					t.Fatalf("%s %s: expected status %d, got %d: %s", s.method, s.path, s.status, rr.Code, rr.Body)
+				}
+			}
+		})
+	}
+}
+
+// newNotificationServer returns handlers with in-memory repositories and a mux routing the saved
+// search and notification endpoints.
+func newNotificationServer() (*Handlers, *http.ServeMux) {
+	h := &Handlers{itemRepo: NewMemoryItemRepository(), notifications: NewMemoryNotificationRepository()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /me/saved-searches", h.CreateSavedSearch)
+	mux.HandleFunc("GET /me/saved-searches", h.GetSavedSearches)
+	mux.HandleFunc("DELETE /me/saved-searches/{id}", h.DeleteSavedSearch)
+	mux.HandleFunc("GET /me/notifications", h.GetNotifications)
+	mux.HandleFunc("POST /me/notifications/read", h.ReadNotifications)
+	return h, mux
+}
+
+func TestGetNotificationsUnreadCount(t *testing.T) {
+	t.Parallel()
+
+	const alice = 1
+	h, mux := newNotificationServer()
+	ctx := context.Background()
+	for _, itemID := range []int{1, 2} {
+		n := &Notification{UserID: alice, Type: NotificationSavedSearchMatch, ItemID: itemID, Key: "item:" + strconv.Itoa(itemID)}
+		if _, err := h.notifications.AddNotification(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := h.notifications.MarkNotificationsRead(ctx, alice, 1, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/me/notifications", nil)
+	req = req.WithContext(ContextWithUserID(req.Context(), alice))
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	var resp struct {
+		Notifications []*Notification `json:"notifications"`
+		UnreadCount   int             `json:"unread_count"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.UnreadCount != 1 || len(resp.Notifications) != 2 || resp.Notifications[0].ReadAt != nil || resp.Notifications[1].ReadAt == nil {
+		t.Errorf("expected the newest notification unread, got %d unread in %+v", resp.UnreadCount, resp.Notifications)
+	}
+}
@@ -0,0 +1,444 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Notification channels. Every notification is kept in the inbox; the other channels are chosen
+// per notification type in NotificationPreferences.
+const (
+	ChannelInbox = "inbox"
+	ChannelEmail = "email"
+)
+
+// Notification delivery statuses.
+const (
+	NotificationDeliveryPending = "pending"
+	NotificationDeliverySent    = "sent"
+	// NotificationDeliveryDead marks deliveries which ran out of attempts or cannot be sent.
+	NotificationDeliveryDead = "dead"
+)
+
+// DefaultNotificationMaxAttempts is how many times a delivery is tried before it is given up.
+const DefaultNotificationMaxAttempts = 8
+
+// Languages of the notification templates. The first one is the default.
+var notificationLanguages = []string{"ja", "en"}
+
+// notificationTypes are the types users can choose channels for.
+var notificationTypes = []string{NotificationSavedSearchMatch, NotificationNewMessage, NotificationItemSold}
+
+// NotificationPreferences are a user's choices of how to be notified.
+type NotificationPreferences struct {
+	UserID int `json:"user_id"`
+	// Language selects the templates: "ja" or "en".
+	Language string `json:"language"`
+	// Email is the address for ChannelEmail. Nothing is emailed without one.
+	Email string `json:"email"`
+	// EmailTypes are the notification types also sent by email.
+	EmailTypes []string  `json:"email_types"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// defaultNotificationPreferences are used for users who never saved theirs.
+func defaultNotificationPreferences(userID int) *NotificationPreferences {
+	return &NotificationPreferences{UserID: userID, Language: notificationLanguages[0], EmailTypes: slices.Clone(notificationTypes)}
+}
+
+// channels returns the channels other than the inbox p enables for notifications of typ.
+func (p *NotificationPreferences) channels(typ string) []string {
+	if p.Email != "" && slices.Contains(p.EmailTypes, typ) {
+		return []string{ChannelEmail}
+	}
+	return nil
+}
+
+// NotificationDelivery is a notification to be sent through one channel.
+type NotificationDelivery struct {
+	ID             int64     `json:"id"`
+	NotificationID int64     `json:"notification_id"`
+	Channel        string    `json:"channel"`
+	Status         string    `json:"status"`
+	Attempts       int       `json:"attempts"`
+	NextAttemptAt  time.Time `json:"next_attempt_at"`
+	LastError      string    `json:"last_error,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	// SentAt is set once the channel accepted the notification.
+	SentAt *time.Time `json:"sent_at,omitempty"`
+	// Notification is the notification to send. PendingNotificationDeliveries sets it.
+	Notification *Notification `json:"-"`
+}
+
+// RenderedNotification is a notification written out in the recipient's language.
+type RenderedNotification struct {
+	Subject string
+	Body    string
+}
+
+// NotificationChannel sends notifications outside the inbox, such as by email.
+type NotificationChannel interface {
+	// Name is the channel stored with deliveries, such as ChannelEmail.
+	Name() string
+	// Send sends msg to the user of prefs. A returned error makes the delivery retried later,
+	// unless it wraps errUndeliverable.
+	Send(ctx context.Context, prefs *NotificationPreferences, msg *RenderedNotification) error
+}
+
+// errUndeliverable is wrapped by channels which can never send a notification, so that it is
+// not retried.
+var errUndeliverable = errors.New("notification cannot be delivered")
+
+// notificationTemplate is the subject and body of one notification type in one language.
+// They are executed with the Notification.
+type notificationTemplate struct {
+	subject, body *template.Template
+}
+
+func newNotificationTemplate(name, subject, body string) notificationTemplate {
+	return notificationTemplate{
+		subject: template.Must(template.New(name + " subject").Option("missingkey=zero").Parse(subject)),
+		body:    template.Must(template.New(name + " body").Option("missingkey=zero").Parse(body)),
+	}
+}
+
+// notificationTemplates are the templates by language and notification type.
+var notificationTemplates = map[string]map[string]notificationTemplate{
+	"ja": {
+		NotificationSavedSearchMatch: newNotificationTemplate("ja "+NotificationSavedSearchMatch,
+			`保存した検索条件に合う商品が出品されました`,
+			"保存した検索条件に合う商品「{{.Params.item_name}}」が出品されました。\n商品 ID: {{.ItemID}}\n"),
+		NotificationNewMessage: newNotificationTemplate("ja "+NotificationNewMessage,
+			`「{{.Params.item_name}}」についてメッセージが届きました`,
+			"「{{.Params.item_name}}」についてメッセージが届きました。\n\n{{.Params.preview}}\n"),
+		NotificationItemSold: newNotificationTemplate("ja "+NotificationItemSold,
+			`「{{.Params.item_name}}」が売れました`,
+			"出品した「{{.Params.item_name}}」が購入されました。発送の準備をお願いします。\n商品 ID: {{.ItemID}}\n"),
+	},
+	"en": {
+		NotificationSavedSearchMatch: newNotificationTemplate("en "+NotificationSavedSearchMatch,
+			`A new item matches your saved search`,
+			"\"{{.Params.item_name}}\" was just listed and matches one of your saved searches.\nItem ID: {{.ItemID}}\n"),
+		NotificationNewMessage: newNotificationTemplate("en "+NotificationNewMessage,
+			`New message about "{{.Params.item_name}}"`,
+			"You have a new message about \"{{.Params.item_name}}\".\n\n{{.Params.preview}}\n"),
+		NotificationItemSold: newNotificationTemplate("en "+NotificationItemSold,
+			`"{{.Params.item_name}}" was sold`,
+			"Your item \"{{.Params.item_name}}\" was bought. Please get it ready to ship.\nItem ID: {{.ItemID}}\n"),
+	},
+}
+
+// renderNotification writes n out in language, falling back to the default language.
+func renderNotification(language string, n *Notification) (*RenderedNotification, error) {
+	templates, ok := notificationTemplates[language]
+	if !ok {
+		templates = notificationTemplates[notificationLanguages[0]]
+	}
+	t, ok := templates[n.Type]
+	if !ok {
+		return nil, fmt.Errorf("no template for notification type %q: %w", n.Type, errUndeliverable)
+	}
+	var subject, body bytes.Buffer
+	if err := t.subject.Execute(&subject, n); err != nil {
+		return nil, fmt.Errorf("failed to render notification: %w", err)
+	}
+	if err := t.body.Execute(&body, n); err != nil {
+		return nil, fmt.Errorf("failed to render notification: %w", err)
+	}
+	return &RenderedNotification{Subject: subject.String(), Body: body.String()}, nil
+}
+
+// Notifier puts notifications in the users' inboxes and queues them for the other channels the
+// users chose. NotificationDeliverer sends the queued deliveries.
+type Notifier struct {
+	repo     NotificationRepository
+	channels map[string]bool
+}
+
+// NewNotifier returns a notifier storing notifications in repo. Deliveries are only queued for
+// channels, so that nothing is queued for a channel which is not configured.
+func NewNotifier(repo NotificationRepository, channels ...NotificationChannel) *Notifier {
+	enabled := make(map[string]bool, len(channels))
+	for _, c := range channels {
+		enabled[c.Name()] = true
+	}
+	return &Notifier{repo: repo, channels: enabled}
+}
+
+// Notify adds n to the inbox of n.UserID with its deliveries. A notification whose Key is
+// already in the inbox is neither added nor sent again.
+func (nt *Notifier) Notify(ctx context.Context, n *Notification) error {
+	prefs, err := nt.repo.GetNotificationPreferences(ctx, n.UserID)
+	if err != nil {
+		return err
+	}
+	var channels []string
+	for _, c := range prefs.channels(n.Type) {
+		if nt.channels[c] {
+			channels = append(channels, c)
+		}
+	}
+	_, err = nt.repo.AddNotification(ctx, n, channels...)
+	return err
+}
+
+// notify sends n with h.notifier, if any. Failures are logged rather than failing the request
+// which caused the notification.
+func (h *Handlers) notify(ctx context.Context, n *Notification) {
+	if h.notifier == nil {
+		return
+	}
+	if err := h.notifier.Notify(ctx, n); err != nil {
+		slog.Error("failed to notify", "user_id", n.UserID, "type", n.Type, "error", err)
+	}
+}
+
+// NotificationSink turns domain events into notifications: new items are matched against the
+// saved searches, and sellers are told when their items sell. The outbox may hand it an event
+// again; each user is notified once.
+type NotificationSink struct {
+	notifier *Notifier
+}
+
+// NewNotificationSink returns a sink notifying through notifier.
+func NewNotificationSink(notifier *Notifier) *NotificationSink {
+	return &NotificationSink{notifier: notifier}
+}
+
+// Name returns "notifications".
+func (s *NotificationSink) Name() string {
+	return "notifications"
+}
+
+// Deliver notifies the users interested in e.
+func (s *NotificationSink) Deliver(ctx context.Context, e *Event) error {
+	if e.Type != EventItemCreated && e.Type != EventItemSold {
+		return nil
+	}
+	var item Item
+	if err := json.Unmarshal(e.Payload, &item); err != nil {
+		// 壊れたイベントは何度送っても同じなので諦める
+		slog.Warn("failed to decode item event", "event_id", e.ID, "error", err)
+		return nil
+	}
+	if e.Type == EventItemSold {
+		if item.SellerID == 0 {
+			return nil
+		}
+		return s.notifier.Notify(ctx, &Notification{
+			UserID: item.SellerID,
+			Type:   NotificationItemSold,
+			ItemID: item.ID,
+			Key:    fmt.Sprintf("%s:%d", NotificationItemSold, item.ID),
+			Params: map[string]string{"item_name": item.Name},
+		})
+	}
+
+	searches, err := s.notifier.repo.MatchSavedSearches(ctx, &item)
+	if err != nil {
+		return err
+	}
+	for _, search := range searches {
+		// 自分の出品は通知しない
+		if search.UserID == item.SellerID {
+			continue
+		}
+		n := &Notification{
+			UserID:        search.UserID,
+			Type:          NotificationSavedSearchMatch,
+			ItemID:        item.ID,
+			SavedSearchID: search.ID,
+			Key:           fmt.Sprintf("%s:%d", NotificationSavedSearchMatch, item.ID),
+			Params:        map[string]string{"item_name": item.Name},
+		}
+		if err := s.notifier.Notify(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NotificationDelivererOptions configures NotificationDeliverer. Zero fields use the defaults.
+type NotificationDelivererOptions struct {
+	// BatchSize is the largest number of deliveries sent per poll. It defaults to 100.
+	BatchSize int
+	// RetryBase is the delay before the first retry, doubled on every further failure
+	// up to RetryMax. They default to thirty seconds and one hour.
+	RetryBase time.Duration
+	RetryMax  time.Duration
+	// MaxAttempts is how many failures make a delivery dead. It defaults to DefaultNotificationMaxAttempts.
+	MaxAttempts int
+	// Now returns the current time. time.Now is used when nil.
+	Now func() time.Time
+}
+
+// NotificationDeliverer sends the queued notification deliveries through their channels, in the
+// language of each recipient. Failed deliveries are retried with exponential backoff.
+type NotificationDeliverer struct {
+	repo     NotificationRepository
+	channels map[string]NotificationChannel
+	opts     NotificationDelivererOptions
+}
+
+// NewNotificationDeliverer returns a deliverer sending the deliveries in repo through channels.
+func NewNotificationDeliverer(repo NotificationRepository, channels []NotificationChannel, opts NotificationDelivererOptions) *NotificationDeliverer {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.RetryBase <= 0 {
+		opts.RetryBase = 30 * time.Second
+	}
+	if opts.RetryMax <= 0 {
+		opts.RetryMax = time.Hour
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultNotificationMaxAttempts
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	byName := make(map[string]NotificationChannel, len(channels))
+	for _, c := range channels {
+		byName[c.Name()] = c
+	}
+	return &NotificationDeliverer{repo: repo, channels: byName, opts: opts}
+}
+
+// send renders the notification of d for its recipient and sends it.
+func (nd *NotificationDeliverer) send(ctx context.Context, d *NotificationDelivery) error {
+	channel, ok := nd.channels[d.Channel]
+	if !ok {
+		return fmt.Errorf("channel %q is not configured: %w", d.Channel, errUndeliverable)
+	}
+	// 送信時の設定を使うので、キューに積んだ後で変えたアドレスや言語にも届く
+	prefs, err := nd.repo.GetNotificationPreferences(ctx, d.Notification.UserID)
+	if err != nil {
+		return err
+	}
+	msg, err := renderNotification(prefs.Language, d.Notification)
+	if err != nil {
+		return err
+	}
+	return channel.Send(ctx, prefs, msg)
+}
+
+// DeliverOnce sends the deliveries due now and returns how many were sent and failed.
+func (nd *NotificationDeliverer) DeliverOnce(ctx context.Context) (sent, failed int, err error) {
+	now := nd.opts.Now()
+	deliveries, err := nd.repo.PendingNotificationDeliveries(ctx, now, nd.opts.BatchSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, d := range deliveries {
+		err := nd.send(ctx, d)
+		d.Attempts++
+		if err == nil {
+			at := nd.opts.Now().UTC()
+			d.Status, d.LastError, d.SentAt = NotificationDeliverySent, "", &at
+			sent++
+		} else {
+			d.LastError = err.Error()
+			if d.Attempts >= nd.opts.MaxAttempts || errors.Is(err, errUndeliverable) {
+				d.Status = NotificationDeliveryDead
+				slog.Warn("gave up notification delivery", "delivery_id", d.ID, "channel", d.Channel, "error", err)
+			} else {
+				d.NextAttemptAt = now.Add(backoff(nd.opts.RetryBase, nd.opts.RetryMax, d.Attempts-1))
+			}
+			failed++
+		}
+		if err := nd.repo.SaveNotificationDeliveryAttempt(ctx, d); err != nil {
+			return sent, failed, err
+		}
+	}
+	return sent, failed, nil
+}
+
+// parsePreferencesForm sets the fields of p sent in r. Missing fields are left unchanged.
+func parsePreferencesForm(r *http.Request, p *NotificationPreferences) error {
+	if err := r.ParseMultipartForm(1 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return fmt.Errorf("invalid form")
+	}
+	if _, ok := r.Form["language"]; ok {
+		language := r.FormValue("language")
+		if !slices.Contains(notificationLanguages, language) {
+			return fmt.Errorf("language must be one of %s", strings.Join(notificationLanguages, ", "))
+		}
+		p.Language = language
+	}
+	if _, ok := r.Form["email"]; ok {
+		p.Email = ""
+		// 空にするとメールを止められる
+		if v := strings.TrimSpace(r.FormValue("email")); v != "" {
+			addr, err := mail.ParseAddress(v)
+			if err != nil {
+				return fmt.Errorf("email is invalid")
+			}
+			p.Email = addr.Address
+		}
+	}
+	if _, ok := r.Form["email_types"]; ok {
+		p.EmailTypes = formList(r, "email_types")
+		for _, typ := range p.EmailTypes {
+			if !slices.Contains(notificationTypes, typ) {
+				return fmt.Errorf("unknown notification type %q", typ)
+			}
+		}
+	}
+	return nil
+}
+
+// GetNotificationPreferences is a handler to return the signed-in user's notification preferences
+// for GET /me/notification-preferences .
+func (h *Handlers) GetNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, signedIn := userIDFromContext(ctx)
+	if !signedIn {
+		http.Error(w, "sign in to see your notification preferences", http.StatusUnauthorized)
+		return
+	}
+	prefs, err := h.notifications.GetNotificationPreferences(ctx, userID)
+	if err != nil {
+		http.Error(w, "failed to get notification preferences", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(prefs)
+}
+
+// UpdateNotificationPreferences is a handler to change the signed-in user's notification
+// preferences for PATCH /me/notification-preferences . Form fields: language, email and
+// email_types (repeated or comma separated). Only the fields sent are changed.
+func (h *Handlers) UpdateNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, signedIn := userIDFromContext(ctx)
+	if !signedIn {
+		http.Error(w, "sign in to change your notification preferences", http.StatusUnauthorized)
+		return
+	}
+	prefs, err := h.notifications.GetNotificationPreferences(ctx, userID)
+	if err != nil {
+		http.Error(w, "failed to get notification preferences", http.StatusInternalServerError)
+		return
+	}
+	if err := parsePreferencesForm(r, prefs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.notifications.SaveNotificationPreferences(ctx, prefs); err != nil {
+		slog.Error("failed to save notification preferences", "user_id", userID, "error", err)
+		http.Error(w, "failed to save notification preferences", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(prefs)
+}
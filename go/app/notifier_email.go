@@ -0,0 +1,68 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// EmailChannel sends notifications by email through an SMTP server, such as a local relay or a
+// fake server in development.
+type EmailChannel struct {
+	// Addr is the host:port of the SMTP server.
+	Addr string
+	// From is the sender address.
+	From string
+	// Auth authenticates to the server. Nil sends without authentication.
+	Auth smtp.Auth
+	// Now returns the Date of messages. time.Now is used when nil.
+	Now func() time.Time
+}
+
+// Name returns ChannelEmail.
+func (c *EmailChannel) Name() string {
+	return ChannelEmail
+}
+
+// Send emails msg to the address in prefs.
+func (c *EmailChannel) Send(ctx context.Context, prefs *NotificationPreferences, msg *RenderedNotification) error {
+	if prefs.Email == "" {
+		return fmt.Errorf("user %d has no email address: %w", prefs.UserID, errUndeliverable)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := smtp.SendMail(c.Addr, c.Auth, c.From, []string{prefs.Email}, c.message(prefs.Email, msg)); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// message builds the MIME message of msg. 日本語の件名と本文を送れるように UTF-8 でエンコードする
+func (c *EmailChannel) message(to string, msg *RenderedNotification) []byte {
+	now := time.Now
+	if c.Now != nil {
+		now = c.Now
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", c.From)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+
+	body := base64.StdEncoding.EncodeToString([]byte(strings.ReplaceAll(msg.Body, "\n", "\r\n")))
+	for len(body) > 76 {
+		b.WriteString(body[:76] + "\r\n")
+		body = body[76:]
+	}
+	b.WriteString(body + "\r\n")
+	return b.Bytes()
+}
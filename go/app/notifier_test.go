@@ -0,0 +1,432 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// startFakeSMTP serves just enough SMTP on a local port for net/smtp, and sends every message
+// it receives to the returned channel.
+func startFakeSMTP(t *testing.T) (addr string, received <-chan *mail.Message) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	messages := make(chan *mail.Message, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveFakeSMTP(conn, messages)
+		}
+	}()
+	return l.Addr().String(), messages
+}
+
+func serveFakeSMTP(conn net.Conn, messages chan<- *mail.Message) {
+	defer conn.Close()
+	c := textproto.NewConn(conn)
+	c.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		switch cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); cmd {
+		case "EHLO", "HELO":
+			c.PrintfLine("250 localhost")
+		case "DATA":
+			c.PrintfLine("354 end data with <CR><LF>.<CR><LF>")
+			msg, err := mail.ReadMessage(bufio.NewReader(c.DotReader()))
+			if err != nil {
+				c.PrintfLine("554 invalid message")
+				continue
+			}
+			messages <- msg
+			c.PrintfLine("250 OK")
+		case "QUIT":
+			c.PrintfLine("221 bye")
+			return
+		default:
+			c.PrintfLine("250 OK")
+		}
+	}
+}
+
+// readMail returns the decoded subject and body of msg.
+func readMail(t *testing.T, msg *mail.Message) (subject, body string) {
+	t.Helper()
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatalf("failed to decode subject: %v", err)
+	}
+	b, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, msg.Body))
+	if err != nil {
+		t.Fatalf("failed to decode body: %v", err)
+	}
+	return subject, string(b)
+}
+
+func TestRenderNotification(t *testing.T) {
+	t.Parallel()
+
+	n := &Notification{Type: NotificationItemSold, ItemID: 3, Params: map[string]string{"item_name": "jacket"}}
+	cases := map[string]struct {
+		language string
+		n        *Notification
+		want     string
+		wantErr  bool
+	}{
+		"ok: japanese":                 {"ja", n, "「jacket」が売れました", false},
+		"ok: english":                  {"en", n, `"jacket" was sold`, false},
+		"ok: unknown language":         {"fr", n, "「jacket」が売れました", false},
+		"ok: missing params are empty": {"en", &Notification{Type: NotificationNewMessage}, `New message about ""`, false},
+		"ng: unknown type":             {"en", &Notification{Type: "unknown"}, "", true},
+	}
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, err := renderNotification(tt.language, tt.n)
+			if tt.wantErr {
+				if !errors.Is(err, errUndeliverable) {
+					t.Errorf("expected errUndeliverable, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Subject != tt.want {
+				t.Errorf("expected subject %q, got %q", tt.want, got.Subject)
+			}
+		})
+	}
+}
+
+func TestEmailChannel(t *testing.T) {
+	t.Parallel()
+
+	addr, received := startFakeSMTP(t)
+	channel := &EmailChannel{Addr: addr, From: "noreply@example.com"}
+	prefs := &NotificationPreferences{UserID: 1, Email: "buyer@example.com"}
+	msg := &RenderedNotification{Subject: "「jacket」が売れました", Body: "出品した「jacket」が購入されました。\n" + strings.Repeat("あ", 60)}
+	if err := channel.Send(context.Background(), prefs, msg); err != nil {
+		t.Fatalf("failed to send email: %v", err)
+	}
+
+	select {
+	case m := <-received:
+		if to := m.Header.Get("To"); to != "buyer@example.com" {
+			t.Errorf("expected the user's address, got %q", to)
+		}
+		subject, body := readMail(t, m)
+		if subject != msg.Subject {
+			t.Errorf("expected subject %q, got %q", msg.Subject, subject)
+		}
+		if want := strings.ReplaceAll(msg.Body, "\n", "\r\n"); body != want {
+			t.Errorf("expected body %q, got %q", want, body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email received")
+	}
+
+	if err := channel.Send(context.Background(), &NotificationPreferences{UserID: 2}, msg); !errors.Is(err, errUndeliverable) {
+		t.Errorf("expected errUndeliverable without an address, got %v", err)
+	}
+}
+
+// failingChannel fails every send with err and counts the attempts.
+type failingChannel struct {
+	err error
+
+	mu       sync.Mutex
+	attempts int
+}
+
+func (c *failingChannel) Name() string {
+	return ChannelEmail
+}
+
+func (c *failingChannel) Send(ctx context.Context, prefs *NotificationPreferences, msg *RenderedNotification) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.attempts++
+	return c.err
+}
+
+func TestNotificationDeliverer(t *testing.T) {
+	t.Parallel()
+
+	const alice, bob = 1, 2
+	for name, newRepo := range notificationRepositories() {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			repo := newRepo(t)
+			addr, received := startFakeSMTP(t)
+			email := &EmailChannel{Addr: addr, From: "noreply@example.com"}
+			notifier := NewNotifier(repo, email)
+
+			prefs := &NotificationPreferences{UserID: alice, Language: "en", Email: "alice@example.com", EmailTypes: []string{NotificationItemSold}}
+			if err := repo.SaveNotificationPreferences(ctx, prefs); err != nil {
+				t.Fatalf("failed to save preferences: %v", err)
+			}
+			got, err := repo.GetNotificationPreferences(ctx, alice)
+			if err != nil {
+				t.Fatalf("failed to get preferences: %v", err)
+			}
+			if diff := cmp.Diff(prefs, got); diff != "" {
+				t.Errorf("unexpected preferences (-want +got):\n%s", diff)
+			}
+			if got, err := repo.GetNotificationPreferences(ctx, bob); err != nil || got.Language != "ja" || len(got.EmailTypes) != len(notificationTypes) {
+				t.Errorf("expected the default preferences, got %+v, %v", got, err)
+			}
+
+			// alice はメールで売れた通知だけを受け取り、メールアドレスのない bob は受信箱だけ
+			for _, n := range []*Notification{
+				{UserID: alice, Type: NotificationSavedSearchMatch, ItemID: 1, Key: "match:1", Params: map[string]string{"item_name": "coat"}},
+				{UserID: alice, Type: NotificationItemSold, ItemID: 2, Key: "sold:2", Params: map[string]string{"item_name": "jacket"}},
+				{UserID: alice, Type: NotificationItemSold, ItemID: 2, Key: "sold:2", Params: map[string]string{"item_name": "jacket"}},
+				{UserID: bob, Type: NotificationItemSold, ItemID: 3, Key: "sold:3"},
+			} {
+				if err := notifier.Notify(ctx, n); err != nil {
+					t.Fatalf("failed to notify: %v", err)
+				}
+			}
+			inbox, err := repo.ListNotifications(ctx, NotificationQuery{UserID: alice})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(inbox) != 2 || inbox[0].Params["item_name"] != "jacket" {
+				t.Errorf("expected both notifications in the inbox with their params, got %+v", inbox)
+			}
+
+			deliverer := NewNotificationDeliverer(repo, []NotificationChannel{email}, NotificationDelivererOptions{})
+			sent, failed, err := deliverer.DeliverOnce(ctx)
+			if err != nil {
+				t.Fatalf("failed to deliver: %v", err)
+			}
+			if sent != 1 || failed != 0 {
+				t.Fatalf("expected one email sent, got %d sent and %d failed", sent, failed)
+			}
+			select {
+			case m := <-received:
+				if subject, _ := readMail(t, m); subject != `"jacket" was sold` {
+					t.Errorf("expected the english subject, got %q", subject)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("no email received")
+			}
+			if sent, failed, err := deliverer.DeliverOnce(ctx); err != nil || sent+failed != 0 {
+				t.Errorf("expected nothing left to send, got %d sent, %d failed, %v", sent, failed, err)
+			}
+		})
+	}
+}
+
+func TestNotificationDelivererRetries(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		err          error
+		wantAttempts int
+	}{
+		"ok: retried until the last attempt": {errors.New("connection refused"), 3},
+		"ok: undeliverable is not retried":   {errUndeliverable, 1},
+	}
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			repo := NewMemoryNotificationRepository()
+			if err := repo.SaveNotificationPreferences(ctx, &NotificationPreferences{UserID: 1, Language: "ja", Email: "a@example.com", EmailTypes: notificationTypes}); err != nil {
+				t.Fatal(err)
+			}
+			channel := &failingChannel{err: tt.err}
+			if err := NewNotifier(repo, channel).Notify(ctx, &Notification{UserID: 1, Type: NotificationItemSold, Key: "sold:1"}); err != nil {
+				t.Fatal(err)
+			}
+
+			now := time.Now()
+			deliverer := NewNotificationDeliverer(repo, []NotificationChannel{channel}, NotificationDelivererOptions{
+				RetryBase:   time.Minute,
+				MaxAttempts: 3,
+				Now:         func() time.Time { return now },
+			})
+			for range 5 {
+				if _, _, err := deliverer.DeliverOnce(ctx); err != nil {
+					t.Fatal(err)
+				}
+				// 次の再送時刻まで進める
+				now = now.Add(time.Hour)
+			}
+			if channel.attempts != tt.wantAttempts {
+				t.Errorf("expected %d attempts, got %d", tt.wantAttempts, channel.attempts)
+			}
+		})
+	}
+}
+
+func TestNotificationSinkItemSold(t *testing.T) {
+	t.Parallel()
+
+	const seller = 1
+	ctx := context.Background()
+	repo := NewMemoryNotificationRepository()
+	sink := NewNotificationSink(NewNotifier(repo))
+	for _, e := range []*Event{
+		{ID: 1, Type: EventItemSold, Payload: []byte(`{"id":5,"name":"jacket","seller_id":1}`)},
+		{ID: 1, Type: EventItemSold, Payload: []byte(`{"id":5,"name":"jacket","seller_id":1}`)},
+		{ID: 2, Type: EventItemSold, Payload: []byte(`{"id":6,"name":"anonymous"}`)},
+		{ID: 3, Type: EventItemUpdated, Payload: []byte(`{"id":5,"name":"jacket","seller_id":1}`)},
+	} {
+		if err := sink.Deliver(ctx, e); err != nil {
+			t.Fatalf("failed to deliver event %d: %v", e.ID, err)
+		}
+	}
+
+	got, err := repo.ListNotifications(ctx, NotificationQuery{UserID: seller})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Type != NotificationItemSold || got[0].ItemID != 5 || got[0].Params["item_name"] != "jacket" {
+		t.Errorf("expected one item_sold notification, got %+v", got)
+	}
+}
+
+func TestSendMessageNotifiesRecipient(t *testing.T) {
+	t.Parallel()
+
+	const seller, buyer = 1, 2
+	ctx := context.Background()
+	items := NewMemoryItemRepository()
+	if err := items.Insert(ctx, &Item{Name: "jacket", Category: "fashion", SellerID: seller}); err != nil {
+		t.Fatal(err)
+	}
+	notifications := NewMemoryNotificationRepository()
+	h := &Handlers{itemRepo: items, messages: NewMemoryMessageRepository(), notifications: notifications, notifier: NewNotifier(notifications)}
+	c, err := h.messages.GetOrCreateConversation(ctx, 1, buyer, seller)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.sendMessage(ctx, c, buyer, strings.Repeat("a", messagePreviewLength+1)); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := notifications.ListNotifications(ctx, NotificationQuery{UserID: seller})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"item_name": "jacket", "preview": strings.Repeat("a", messagePreviewLength) + "…"}
+	if len(got) != 1 || got[0].Type != NotificationNewMessage {
+		t.Fatalf("expected one new_message notification for the seller, got %+v", got)
+	}
+	if diff := cmp.Diff(want, got[0].Params); diff != "" {
+		t.Errorf("unexpected params (-want +got):\n%s", diff)
+	}
+}
+
+func TestNotificationPreferencesHandlers(t *testing.T) {
+	t.Parallel()
+
+	const alice = 1
+	type step struct {
+		method string
+		form   url.Values
+		userID int
+		status int
+	}
+	cases := map[string][]step{
+		"ok: update preferences": {
+			{http.MethodGet, nil, alice, http.StatusOK},
+			{http.MethodPatch, url.Values{"language": {"en"}, "email": {"Alice <alice@example.com>"}}, alice, http.StatusOK},
+			{http.MethodPatch, url.Values{"email_types": {"item_sold,new_message"}}, alice, http.StatusOK},
+			{http.MethodPatch, url.Values{"email": {""}}, alice, http.StatusOK},
+		},
+		"ng: not signed in": {
+			{http.MethodGet, nil, 0, http.StatusUnauthorized},
+			{http.MethodPatch, url.Values{"language": {"en"}}, 0, http.StatusUnauthorized},
+		},
+		"ng: invalid preferences": {
+			{http.MethodPatch, url.Values{"language": {"fr"}}, alice, http.StatusBadRequest},
+			{http.MethodPatch, url.Values{"email": {"not an address"}}, alice, http.StatusBadRequest},
+			{http.MethodPatch, url.Values{"email_types": {"item_sold,unknown"}}, alice, http.StatusBadRequest},
+		},
+	}
+
+	for name, steps := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := &Handlers{notifications: NewMemoryNotificationRepository()}
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /me/notification-preferences", h.GetNotificationPreferences)
+			mux.HandleFunc("PATCH /me/notification-preferences", h.UpdateNotificationPreferences)
+			for _, s := range steps {
+				req := httptest.NewRequest(s.method, "/me/notification-preferences", strings.NewReader(s.form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				if s.userID != 0 {
+					req = req.WithContext(ContextWithUserID(req.Context(), s.userID))
+				}
+				rr := httptest.NewRecorder()
+				mux.ServeHTTP(rr, req)
+				if rr.Code != s.status {
+					t.Fatalf("%s %v: expected status %d, got %d: %s", s.method, s.form, s.status, rr.Code, rr.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateNotificationPreferencesKeepsOtherFields(t *testing.T) {
+	t.Parallel()
+
+	const alice = 1
+	ctx := context.Background()
+	h := &Handlers{notifications: NewMemoryNotificationRepository()}
+	for _, form := range []url.Values{
+		{"language": {"en"}, "email": {"Alice <alice@example.com>"}},
+		{"email_types": {"item_sold"}},
+	} {
+		req := httptest.NewRequest(http.MethodPatch, "/me/notification-preferences", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		h.UpdateNotificationPreferences(rr, req.WithContext(ContextWithUserID(req.Context(), alice)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+	}
+
+	got, err := h.notifications.GetNotificationPreferences(ctx, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &NotificationPreferences{UserID: alice, Language: "en", Email: "alice@example.com", EmailTypes: []string{NotificationItemSold}}
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(NotificationPreferences{}, "UpdatedAt")); diff != "" {
+		t.Errorf("unexpected preferences (-want +got):\n%s", diff)
+	}
+}
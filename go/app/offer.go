@@ -0,0 +1,481 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Offer statuses. Pending offers wait for the seller and countered offers for the buyer; the
+// others, except accepted, are final.
+const (
+	OfferPending   = "pending"
+	OfferCountered = "countered"
+	// OfferAccepted reserves the item for the buyer until ReservedUntil.
+	OfferAccepted  = "accepted"
+	OfferRejected  = "rejected"
+	OfferWithdrawn = "withdrawn"
+	OfferExpired   = "expired"
+	// OfferLapsed is an accepted offer the buyer did not check out in time.
+	OfferLapsed    = "lapsed"
+	OfferPurchased = "purchased"
+)
+
+// Offer actions, recorded in the history of an offer.
+const (
+	OfferActionCreate   = "create"
+	OfferActionAccept   = "accept"
+	OfferActionReject   = "reject"
+	OfferActionCounter  = "counter"
+	OfferActionWithdraw = "withdraw"
+	OfferActionCheckout = "checkout"
+	// OfferActionExpire is taken by the system when an offer or a reservation runs out.
+	OfferActionExpire = "expire"
+)
+
+const (
+	// DefaultOfferTTL is how long an offer or a counter offer waits for an answer.
+	DefaultOfferTTL = 48 * time.Hour
+	// DefaultCheckoutWindow is how long an accepted offer reserves the item for the buyer.
+	DefaultCheckoutWindow = 24 * time.Hour
+	// offerExpiryInterval is how often expired offers and reservations are released.
+	offerExpiryInterval = time.Minute
+)
+
+var (
+	ErrOfferNotFound = errors.New("offer not found")
+	// ErrInvalidOfferTransition is returned for actions the state of an offer does not allow,
+	// or which the actor may not take.
+	ErrInvalidOfferTransition = errors.New("invalid offer transition")
+	// ErrOfferExists is returned when the buyer already has an open offer on the item.
+	ErrOfferExists = errors.New("an offer on this item is already open")
+	// ErrOffersNotAccepted is returned for items which are not sold at a fixed price.
+	ErrOffersNotAccepted = errors.New("item does not accept offers")
+	// ErrItemReserved is returned when accepting an offer while another one reserves the item.
+	ErrItemReserved = errors.New("item is reserved for another buyer")
+	ErrItemSold     = errors.New("item has been sold")
+	// errOfferConflict makes TransitionOffer try again when the offer changed meanwhile.
+	errOfferConflict = errors.New("offer changed while updating")
+)
+
+// Offer is a price a buyer proposes for an item, and the negotiation which follows.
+type Offer struct {
+	ID       int `json:"id"`
+	ItemID   int `json:"item_id"`
+	BuyerID  int `json:"buyer_id"`
+	SellerID int `json:"seller_id"`
+	// Amount is the price currently proposed, by the buyer or by a counter offer of the seller.
+	Amount int    `json:"amount"`
+	Status string `json:"status"`
+	// ExpiresAt is when a pending or countered offer expires without an answer.
+	ExpiresAt     time.Time  `json:"expires_at"`
+	ReservedUntil *time.Time `json:"reserved_until,omitempty"`
+	// OrderID is set once the buyer checked out.
+	OrderID   int       `json:"order_id,omitempty"`
+	Version   int       `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// History is the transitions of the offer, oldest first. It is only filled by GetOffer.
+	History []*OfferEvent `json:"history,omitempty"`
+}
+
+// OfferEvent is a transition in the history of an offer.
+type OfferEvent struct {
+	ID      int64  `json:"id"`
+	OfferID int    `json:"offer_id"`
+	Action  string `json:"action"`
+	// ActorID is zero for transitions taken by the system.
+	ActorID   int       `json:"actor_id,omitempty"`
+	From      string    `json:"from,omitempty"`
+	To        string    `json:"to"`
+	Amount    int       `json:"amount"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OfferTransition is an action on an offer.
+type OfferTransition struct {
+	Action  string
+	ActorID int
+	// Amount is the price of a counter offer.
+	Amount int
+	At     time.Time
+	// Until is the new deadline: the expiry of a counter offer or the end of the checkout
+	// window of an accepted offer.
+	Until time.Time
+}
+
+// OfferQuery filters OfferRepository.ListOffers. Zero fields do not filter.
+type OfferQuery struct {
+	ItemID int
+	// UserID matches the offers the user made or received.
+	UserID int
+}
+
+// Parties to an offer.
+const (
+	offerBuyer  = "buyer"
+	offerSeller = "seller"
+	offerSystem = "system"
+)
+
+type offerStep struct {
+	from, action string
+}
+
+// offerTransitions is the state machine of offers: who may take each action in each status,
+// and the status it leads to.
+var offerTransitions = map[offerStep]struct{ by, to string }{
+	{OfferPending, OfferActionAccept}:     {offerSeller, OfferAccepted},
+	{OfferPending, OfferActionReject}:     {offerSeller, OfferRejected},
+	{OfferPending, OfferActionCounter}:    {offerSeller, OfferCountered},
+	{OfferPending, OfferActionWithdraw}:   {offerBuyer, OfferWithdrawn},
+	{OfferPending, OfferActionExpire}:     {offerSystem, OfferExpired},
+	{OfferCountered, OfferActionAccept}:   {offerBuyer, OfferAccepted},
+	{OfferCountered, OfferActionReject}:   {offerBuyer, OfferRejected},
+	{OfferCountered, OfferActionCounter}:  {offerBuyer, OfferPending},
+	{OfferCountered, OfferActionWithdraw}: {offerBuyer, OfferWithdrawn},
+	{OfferCountered, OfferActionExpire}:   {offerSystem, OfferExpired},
+	{OfferAccepted, OfferActionCheckout}:  {offerBuyer, OfferPurchased},
+	{OfferAccepted, OfferActionWithdraw}:  {offerBuyer, OfferWithdrawn},
+	{OfferAccepted, OfferActionExpire}:    {offerSystem, OfferLapsed},
+}
+
+// party returns how userID takes part in the offer. Zero is the system.
+func (o *Offer) party(userID int) string {
+	switch userID {
+	case 0:
+		return offerSystem
+	case o.BuyerID:
+		return offerBuyer
+	case o.SellerID:
+		return offerSeller
+	default:
+		return ""
+	}
+}
+
+// deadline returns when the current status runs out, or nil for final statuses.
+func (o *Offer) deadline() *time.Time {
+	switch o.Status {
+	case OfferPending, OfferCountered:
+		return &o.ExpiresAt
+	case OfferAccepted:
+		return o.ReservedUntil
+	default:
+		return nil
+	}
+}
+
+// open reports whether the offer still binds its parties at now.
+func (o *Offer) open(now time.Time) bool {
+	d := o.deadline()
+	return d != nil && now.Before(*d)
+}
+
+// apply takes the transition t and returns the event to add to the history. An invalid
+// transition leaves o unchanged and returns an error wrapping ErrInvalidOfferTransition.
+func (o *Offer) apply(t OfferTransition) (*OfferEvent, error) {
+	next, ok := offerTransitions[offerStep{o.Status, t.Action}]
+	if !ok {
+		return nil, fmt.Errorf("cannot %s an offer which is %s: %w", t.Action, o.Status, ErrInvalidOfferTransition)
+	}
+	if party := o.party(t.ActorID); party != next.by {
+		return nil, fmt.Errorf("only the %s can %s an offer which is %s: %w", next.by, t.Action, o.Status, ErrInvalidOfferTransition)
+	}
+	deadline := o.deadline()
+	if t.Action == OfferActionExpire {
+		if t.At.Before(*deadline) {
+			return nil, fmt.Errorf("offer is %s until %s: %w", o.Status, deadline.Format(time.RFC3339), ErrInvalidOfferTransition)
+		}
+	} else if !t.At.Before(*deadline) {
+		// 期限切れはジョブが反映するまで待たずに拒否する
+		return nil, fmt.Errorf("offer ran out at %s: %w", deadline.Format(time.RFC3339), ErrInvalidOfferTransition)
+	}
+
+	switch t.Action {
+	case OfferActionCounter:
+		if t.Amount <= 0 {
+			return nil, errors.New("amount must be a positive integer")
+		}
+		o.Amount = t.Amount
+		o.ExpiresAt = t.Until.UTC()
+	case OfferActionAccept:
+		until := t.Until.UTC()
+		o.ReservedUntil = &until
+	}
+	e := &OfferEvent{OfferID: o.ID, Action: t.Action, ActorID: t.ActorID, From: o.Status, To: next.to, Amount: o.Amount, CreatedAt: t.At.UTC()}
+	o.Status = next.to
+	o.UpdatedAt = e.CreatedAt
+	return e, nil
+}
+
+// checkOfferable reports an error unless item can be bought through an offer.
+func checkOfferable(item *Item) error {
+	if item.DeletedAt != nil {
+		return ErrItemNotFound
+	}
+	if item.SoldAt != nil {
+		return ErrItemSold
+	}
+	if item.ListingType == ListingAuction {
+		return ErrOffersNotAccepted
+	}
+	return nil
+}
+
+// OfferRepository stores offers with their history. Accepting an offer reserves its item and
+// checking out sells it, so it shares the database of the ItemRepository.
+type OfferRepository interface {
+	// CreateOffer stores a pending offer made at now, setting its ID, SellerID, Status and
+	// timestamps. ErrOfferExists is returned if the buyer has an open offer on the item, and
+	// ErrItemSold or ErrOffersNotAccepted if the item cannot be bought through offers.
+	CreateOffer(ctx context.Context, o *Offer, now time.Time) error
+	// GetOffer returns an offer with its history. ErrOfferNotFound is returned if it is missing.
+	GetOffer(ctx context.Context, id int) (*Offer, error)
+	// ListOffers returns the offers matching q without their history, newest first.
+	ListOffers(ctx context.Context, q OfferQuery) ([]*Offer, error)
+	// TransitionOffer takes t on an offer and records it in the history. Accepting fails with
+	// ErrItemReserved while another offer reserves the item; checking out sells the item and
+	// sets OrderID. Transitions the state of the offer does not allow wrap ErrInvalidOfferTransition.
+	TransitionOffer(ctx context.Context, id int, t OfferTransition) (*Offer, error)
+	// ExpireOffers expires the offers and releases the reservations which ran out at now, and
+	// returns how many.
+	ExpireOffers(ctx context.Context, now time.Time) (int, error)
+}
+
+// OfferExpiryJob is the periodic job expiring offers and releasing reservations.
+const OfferExpiryJob JobType[struct{}] = "offer_expiry"
+
+// offerExpiryJob returns the OfferExpiryJob handler. now is the clock deciding which offers
+// ran out.
+func offerExpiryJob(offers OfferRepository, now func() time.Time) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		n, err := offers.ExpireOffers(ctx, now())
+		if n > 0 {
+			slog.Info("expired offers", "count", n)
+		}
+		return err
+	}
+}
+
+// offerDuration returns how long offers wait for an answer.
+func (h *Handlers) offerDuration() time.Duration {
+	if h.offerTTL > 0 {
+		return h.offerTTL
+	}
+	return DefaultOfferTTL
+}
+
+// checkoutDuration returns how long accepted offers reserve the item.
+func (h *Handlers) checkoutDuration() time.Duration {
+	if h.checkoutWindow > 0 {
+		return h.checkoutWindow
+	}
+	return DefaultCheckoutWindow
+}
+
+// writeOfferError maps the errors of offer operations to responses.
+func writeOfferError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrOfferNotFound):
+		http.Error(w, "Offer not found", http.StatusNotFound)
+	case errors.Is(err, ErrItemNotFound):
+		http.Error(w, "Item not found", http.StatusNotFound)
+	case errors.Is(err, ErrInvalidOfferTransition), errors.Is(err, ErrOfferExists), errors.Is(err, ErrOffersNotAccepted),
+		errors.Is(err, ErrItemReserved), errors.Is(err, ErrItemSold):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		slog.Error("failed to update offer", "error", err)
+		http.Error(w, "failed to update offer", http.StatusInternalServerError)
+	}
+}
+
+// MakeOffer is a handler to offer a price for an item for POST /items/{id}/offers .
+// The form field amount is the price in yen. The offer expires if the seller does not answer it.
+func (h *Handlers) MakeOffer(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, signedIn := userIDFromContext(ctx)
+	if !signedIn {
+		http.Error(w, "sign in to make an offer", http.StatusUnauthorized)
+		return
+	}
+	itemID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		return
+	}
+	amount, err := parsePrice(r, "amount")
+	if err != nil || amount == 0 {
+		http.Error(w, "amount must be a positive integer", http.StatusBadRequest)
+		return
+	}
+	item, err := h.itemRepo.Select(ctx, itemID)
+	if err != nil {
+		writeOfferError(w, err)
+		return
+	}
+	if item.SellerID == userID {
+		http.Error(w, "sellers cannot make offers on their own items", http.StatusForbidden)
+		return
+	}
+
+	now := h.now()
+	offer := &Offer{ItemID: itemID, BuyerID: userID, Amount: amount, ExpiresAt: now.Add(h.offerDuration())}
+	if err := h.offers.CreateOffer(ctx, offer, now); err != nil {
+		writeOfferError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(offer)
+}
+
+// GetItemOffers is a handler to list the offers on an item, newest first, for
+// GET /items/{id}/offers . The seller sees every offer and buyers see their own.
+func (h *Handlers) GetItemOffers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, signedIn := userIDFromContext(ctx)
+	if !signedIn {
+		http.Error(w, "sign in to see offers", http.StatusUnauthorized)
+		return
+	}
+	itemID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		return
+	}
+	item, err := h.itemRepo.Select(ctx, itemID)
+	if err != nil {
+		writeOfferError(w, err)
+		return
+	}
+	q := OfferQuery{ItemID: itemID}
+	if item.SellerID != userID {
+		q.UserID = userID
+	}
+	h.writeOffers(w, r, q)
+}
+
+// GetMyOffers is a handler to list the offers the signed-in user made or received, newest
+// first, for GET /me/offers .
+func (h *Handlers) GetMyOffers(w http.ResponseWriter, r *http.Request) {
+	userID, signedIn := userIDFromContext(r.Context())
+	if !signedIn {
+		http.Error(w, "sign in to see your offers", http.StatusUnauthorized)
+		return
+	}
+	h.writeOffers(w, r, OfferQuery{UserID: userID})
+}
+
+func (h *Handlers) writeOffers(w http.ResponseWriter, r *http.Request, q OfferQuery) {
+	offers, err := h.offers.ListOffers(r.Context(), q)
+	if err != nil {
+		http.Error(w, "failed to get offers", http.StatusInternalServerError)
+		return
+	}
+	if offers == nil {
+		offers = []*Offer{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"offers": offers})
+}
+
+// offerFor returns the offer in the path if the signed-in user is its buyer or seller,
+// writing the error response otherwise. Offers of others are reported as not found.
+func (h *Handlers) offerFor(w http.ResponseWriter, r *http.Request) (*Offer, int, bool) {
+	userID, signedIn := userIDFromContext(r.Context())
+	if !signedIn {
+		http.Error(w, "sign in to see offers", http.StatusUnauthorized)
+		return nil, 0, false
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		return nil, 0, false
+	}
+	offer, err := h.offers.GetOffer(r.Context(), id)
+	if err == nil && offer.BuyerID != userID && offer.SellerID != userID {
+		err = ErrOfferNotFound
+	}
+	if err != nil {
+		writeOfferError(w, err)
+		return nil, 0, false
+	}
+	return offer, userID, true
+}
+
+// GetOffer is a handler to return an offer with its history for GET /offers/{id} .
+func (h *Handlers) GetOffer(w http.ResponseWriter, r *http.Request) {
+	offer, _, ok := h.offerFor(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(offer)
+}
+
+// AcceptOffer is a handler for POST /offers/{id}/accept . The seller accepts a pending offer or
+// the buyer a counter offer, which reserves the item for the buyer for the checkout window.
+func (h *Handlers) AcceptOffer(w http.ResponseWriter, r *http.Request) {
+	h.transitionOffer(w, r, OfferActionAccept)
+}
+
+// RejectOffer is a handler for POST /offers/{id}/reject , answering a pending or countered offer.
+func (h *Handlers) RejectOffer(w http.ResponseWriter, r *http.Request) {
+	h.transitionOffer(w, r, OfferActionReject)
+}
+
+// CounterOffer is a handler for POST /offers/{id}/counter . The form field amount is the
+// price proposed instead; the seller counters pending offers and the buyer counter offers.
+func (h *Handlers) CounterOffer(w http.ResponseWriter, r *http.Request) {
+	h.transitionOffer(w, r, OfferActionCounter)
+}
+
+// WithdrawOffer is a handler for POST /offers/{id}/withdraw , with which the buyer takes back
+// an open offer and releases the reservation of an accepted one.
+func (h *Handlers) WithdrawOffer(w http.ResponseWriter, r *http.Request) {
+	h.transitionOffer(w, r, OfferActionWithdraw)
+}
+
+// CheckoutOffer is a handler for POST /offers/{id}/checkout , with which the buyer buys the
+// item of an accepted offer at its amount within the checkout window.
+func (h *Handlers) CheckoutOffer(w http.ResponseWriter, r *http.Request) {
+	h.transitionOffer(w, r, OfferActionCheckout)
+}
+
+func (h *Handlers) transitionOffer(w http.ResponseWriter, r *http.Request, action string) {
+	offer, userID, ok := h.offerFor(w, r)
+	if !ok {
+		return
+	}
+	now := h.now()
+	t := OfferTransition{Action: action, ActorID: userID, At: now}
+	switch action {
+	case OfferActionCounter:
+		amount, err := parsePrice(r, "amount")
+		if err != nil || amount == 0 {
+			http.Error(w, "amount must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		t.Amount, t.Until = amount, now.Add(h.offerDuration())
+	case OfferActionAccept:
+		t.Until = now.Add(h.checkoutDuration())
+	}
+
+	offer, err := h.offers.TransitionOffer(r.Context(), offer.ID, t)
+	if err != nil {
+		writeOfferError(w, err)
+		return
+	}
+	if offer.OrderID != 0 {
+		itemChanged(h.itemRepo, offer.ItemID)
+	}
+	slog.Info("offer updated", "offer_id", offer.ID, "action", action, "status", offer.Status, "user_id", userID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(offer)
+}
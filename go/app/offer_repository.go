@@ -0,0 +1,591 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// sqlOfferRepository stores offers with their history in SQLite or PostgreSQL. placeholder is
+// the dialect's bind parameter.
+type sqlOfferRepository struct {
+	db          *sql.DB
+	placeholder func(int) string
+}
+
+// NewOfferRepository returns an OfferRepository using the database opened by OpenDatabase with dsn.
+func NewOfferRepository(db *sql.DB, dsn string) OfferRepository {
+	d, _ := parseDSN(dsn)
+	return &sqlOfferRepository{db: db, placeholder: d.placeholder()}
+}
+
+const offerColumns = `id, item_id, buyer_id, seller_id, amount, status, expires_at, reserved_until, order_id, version, created_at, updated_at`
+
+func scanOffer(row rowScanner) (*Offer, error) {
+	var o Offer
+	var reservedUntil sql.NullTime
+	var orderID sql.NullInt64
+	if err := row.Scan(&o.ID, &o.ItemID, &o.BuyerID, &o.SellerID, &o.Amount, &o.Status, &o.ExpiresAt, &reservedUntil,
+		&orderID, &o.Version, &o.CreatedAt, &o.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if reservedUntil.Valid {
+		o.ReservedUntil = &reservedUntil.Time
+	}
+	o.OrderID = int(orderID.Int64)
+	return &o, nil
+}
+
+// get returns an offer without its history.
+func (r *sqlOfferRepository) get(ctx context.Context, q querier, id int) (*Offer, error) {
+	o, err := scanOffer(q.QueryRowContext(ctx, `SELECT `+offerColumns+` FROM offers WHERE id = `+r.placeholder(1), id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrOfferNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get offer: %w", err)
+	}
+	return o, nil
+}
+
+func (r *sqlOfferRepository) insertOfferEvent(ctx context.Context, tx querier, e *OfferEvent) error {
+	query := `INSERT INTO offer_events (offer_id, action, actor_id, from_status, to_status, amount, created_at)
+        VALUES (` + params(r.placeholder, 7) + `) RETURNING id`
+	err := tx.QueryRowContext(ctx, query, e.OfferID, e.Action, nullInt(int64(e.ActorID)), e.From, e.To, e.Amount, e.CreatedAt).Scan(&e.ID)
+	if err != nil {
+		return fmt.Errorf("failed to record offer history: %w", err)
+	}
+	return nil
+}
+
+// CreateOffer stores a new pending offer on a fixed price item.
+func (r *sqlOfferRepository) CreateOffer(ctx context.Context, o *Offer, now time.Time) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	item, err := selectItemByID(ctx, tx, r.placeholder, o.ItemID)
+	if err != nil {
+		return err
+	}
+	if err := checkOfferable(item); err != nil {
+		return err
+	}
+	now = now.UTC()
+	var open int
+	query := fmt.Sprintf(`SELECT COUNT(*) FROM offers WHERE item_id = %s AND buyer_id = %s
+        AND ((status IN ('%s', '%s') AND expires_at > %s) OR (status = '%s' AND reserved_until > %s))`,
+		r.placeholder(1), r.placeholder(2), OfferPending, OfferCountered, r.placeholder(3), OfferAccepted, r.placeholder(4))
+	if err := tx.QueryRowContext(ctx, query, o.ItemID, o.BuyerID, now, now).Scan(&open); err != nil {
+		return fmt.Errorf("failed to check open offers: %w", err)
+	}
+	if open > 0 {
+		return ErrOfferExists
+	}
+
+	o.SellerID, o.Status, o.Version = item.SellerID, OfferPending, 1
+	o.ExpiresAt, o.ReservedUntil, o.OrderID = o.ExpiresAt.UTC(), nil, 0
+	o.CreatedAt, o.UpdatedAt = now, now
+	query = `INSERT INTO offers (item_id, buyer_id, seller_id, amount, status, expires_at, version, created_at, updated_at)
+        VALUES (` + params(r.placeholder, 9) + `) RETURNING id`
+	err = tx.QueryRowContext(ctx, query, o.ItemID, o.BuyerID, o.SellerID, o.Amount, o.Status, o.ExpiresAt, o.Version, o.CreatedAt, o.UpdatedAt).Scan(&o.ID)
+	if err != nil {
+		return fmt.Errorf("failed to insert offer: %w", err)
+	}
+	e := &OfferEvent{OfferID: o.ID, Action: OfferActionCreate, ActorID: o.BuyerID, To: o.Status, Amount: o.Amount, CreatedAt: now}
+	if err := r.insertOfferEvent(ctx, tx, e); err != nil {
+		return err
+	}
+	if err := recordChange(ctx, tx, r.placeholder, AuditCreate, AuditEntityOffer, o.ID, nil, o); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to insert offer: %w", err)
+	}
+	return nil
+}
+
+// GetOffer returns an offer with its history.
+func (r *sqlOfferRepository) GetOffer(ctx context.Context, id int) (*Offer, error) {
+	o, err := r.get(ctx, r.db, id)
+	if err != nil {
+		return nil, err
+	}
+	query := `SELECT id, offer_id, action, actor_id, from_status, to_status, amount, created_at FROM offer_events
+        WHERE offer_id = ` + r.placeholder(1) + ` ORDER BY id`
+	rows, err := r.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get offer history: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e OfferEvent
+		var actorID sql.NullInt64
+		if err := rows.Scan(&e.ID, &e.OfferID, &e.Action, &actorID, &e.From, &e.To, &e.Amount, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan offer event: %w", err)
+		}
+		e.ActorID = int(actorID.Int64)
+		o.History = append(o.History, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get offer history: %w", err)
+	}
+	return o, nil
+}
+
+// ListOffers returns the offers matching q, newest first.
+func (r *sqlOfferRepository) ListOffers(ctx context.Context, q OfferQuery) ([]*Offer, error) {
+	var conds []string
+	var args []any
+	if q.ItemID != 0 {
+		args = append(args, q.ItemID)
+		conds = append(conds, "item_id = "+r.placeholder(len(args)))
+	}
+	if q.UserID != 0 {
+		args = append(args, q.UserID, q.UserID)
+		conds = append(conds, fmt.Sprintf("(buyer_id = %s OR seller_id = %s)", r.placeholder(len(args)-1), r.placeholder(len(args))))
+	}
+	query := `SELECT ` + offerColumns + ` FROM offers`
+	if len(conds) > 0 {
+		query += ` WHERE ` + strings.Join(conds, " AND ")
+	}
+	rows, err := r.db.QueryContext(ctx, query+` ORDER BY id DESC`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list offers: %w", err)
+	}
+	defer rows.Close()
+
+	var offers []*Offer
+	for rows.Next() {
+		o, err := scanOffer(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan offer: %w", err)
+		}
+		offers = append(offers, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list offers: %w", err)
+	}
+	return offers, nil
+}
+
+// TransitionOffer takes t on an offer in one transaction with its side effects: accepting
+// reserves the item and checking out sells it. The offer is only saved if it did not change
+// since it was read; otherwise the transition is validated again.
+func (r *sqlOfferRepository) TransitionOffer(ctx context.Context, id int, t OfferTransition) (*Offer, error) {
+	for {
+		o, err := r.tryTransitionOffer(ctx, id, t)
+		if !errors.Is(err, errOfferConflict) {
+			return o, err
+		}
+	}
+}
+
+func (r *sqlOfferRepository) tryTransitionOffer(ctx context.Context, id int, t OfferTransition) (*Offer, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	o, err := r.get(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	before := *o
+	e, err := o.apply(t)
+	if err != nil {
+		return nil, err
+	}
+
+	switch t.Action {
+	case OfferActionAccept:
+		if err := r.reserveItem(ctx, tx, o, t.At); err != nil {
+			return nil, err
+		}
+	case OfferActionCheckout:
+		order, err := r.checkoutOffer(ctx, tx, o, t.At)
+		if err != nil {
+			return nil, err
+		}
+		o.OrderID = order.ID
+	}
+	if err := r.saveOffer(ctx, tx, o, e); err != nil {
+		return nil, err
+	}
+	if err := recordChange(ctx, tx, r.placeholder, AuditUpdate, AuditEntityOffer, o.ID, &before, o); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to update offer: %w", err)
+	}
+	return o, nil
+}
+
+// saveOffer writes the transition of o with its event, incrementing its version.
+func (r *sqlOfferRepository) saveOffer(ctx context.Context, tx querier, o *Offer, e *OfferEvent) error {
+	var reservedUntil sql.NullTime
+	if o.ReservedUntil != nil {
+		reservedUntil = sql.NullTime{Time: o.ReservedUntil.UTC(), Valid: true}
+	}
+	query := fmt.Sprintf(`UPDATE offers SET amount = %s, status = %s, expires_at = %s, reserved_until = %s, order_id = %s,
+        version = version + 1, updated_at = %s WHERE id = %s AND version = %s`,
+		r.placeholder(1), r.placeholder(2), r.placeholder(3), r.placeholder(4), r.placeholder(5), r.placeholder(6), r.placeholder(7), r.placeholder(8))
+	result, err := tx.ExecContext(ctx, query, o.Amount, o.Status, o.ExpiresAt.UTC(), reservedUntil, nullInt(int64(o.OrderID)),
+		o.UpdatedAt, o.ID, o.Version)
+	if err != nil {
+		return fmt.Errorf("failed to update offer: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return errOfferConflict
+	}
+	o.Version++
+	return r.insertOfferEvent(ctx, tx, e)
+}
+
+// reserveItem checks that the item of an offer being accepted at now is still for sale and
+// not reserved by another offer, releasing reservations which ran out.
+func (r *sqlOfferRepository) reserveItem(ctx context.Context, tx querier, o *Offer, now time.Time) error {
+	item, err := selectItemByID(ctx, tx, r.placeholder, o.ItemID)
+	if err != nil {
+		return err
+	}
+	if err := checkOfferable(item); err != nil {
+		return err
+	}
+	query := fmt.Sprintf(`SELECT id FROM offers WHERE item_id = %s AND status = '%s' AND id <> %s`, r.placeholder(1), OfferAccepted, r.placeholder(2))
+	var others []int
+	rows, err := tx.QueryContext(ctx, query, o.ItemID, o.ID)
+	if err != nil {
+		return fmt.Errorf("failed to check reservations: %w", err)
+	}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan offer: %w", err)
+		}
+		others = append(others, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to check reservations: %w", err)
+	}
+
+	for _, id := range others {
+		other, err := r.get(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		before := *other
+		e, err := other.apply(OfferTransition{Action: OfferActionExpire, At: now})
+		if errors.Is(err, ErrInvalidOfferTransition) {
+			return ErrItemReserved
+		}
+		if err != nil {
+			return err
+		}
+		if err := r.saveOffer(ctx, tx, other, e); err != nil {
+			return err
+		}
+		if err := recordChange(ctx, tx, r.placeholder, AuditUpdate, AuditEntityOffer, other.ID, &before, other); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkoutOffer sells the item of an accepted offer to its buyer at the agreed amount and
+// writes the ItemSold event.
+func (r *sqlOfferRepository) checkoutOffer(ctx context.Context, tx querier, o *Offer, now time.Time) (*Order, error) {
+	before, err := selectItemByID(ctx, tx, r.placeholder, o.ItemID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkOfferable(before); err != nil {
+		return nil, err
+	}
+	now = now.UTC()
+	order := &Order{ItemID: o.ItemID, SellerID: o.SellerID, BuyerID: o.BuyerID, Price: o.Amount, Status: OrderPending, CreatedAt: now}
+	query := `INSERT INTO orders (item_id, seller_id, buyer_id, price, status, created_at) VALUES (` + params(r.placeholder, 6) + `) RETURNING id`
+	if err := tx.QueryRowContext(ctx, query, order.ItemID, order.SellerID, order.BuyerID, order.Price, order.Status, order.CreatedAt).Scan(&order.ID); err != nil {
+		return nil, fmt.Errorf("failed to create order: %w", err)
+	}
+	query = fmt.Sprintf(`UPDATE items SET price = %s, sold_at = %s, version = version + 1, updated_at = %s WHERE id = %s`,
+		r.placeholder(1), r.placeholder(2), r.placeholder(3), r.placeholder(4))
+	if _, err := tx.ExecContext(ctx, query, o.Amount, now, now, o.ItemID); err != nil {
+		return nil, fmt.Errorf("failed to mark item sold: %w", err)
+	}
+	after, err := selectItemByID(ctx, tx, r.placeholder, o.ItemID)
+	if err != nil {
+		return nil, err
+	}
+	if err := recordChange(ctx, tx, r.placeholder, AuditSell, AuditEntityItem, o.ItemID, before, after); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// dueOffers returns up to limit offers or reservations which ran out at now.
+func (r *sqlOfferRepository) dueOffers(ctx context.Context, now time.Time, limit int) ([]int, error) {
+	query := fmt.Sprintf(`SELECT id FROM offers
+        WHERE (status IN ('%s', '%s') AND expires_at <= %s) OR (status = '%s' AND reserved_until <= %s)
+        ORDER BY id LIMIT %d`, OfferPending, OfferCountered, r.placeholder(1), OfferAccepted, r.placeholder(2), limit)
+	now = now.UTC()
+	rows, err := r.db.QueryContext(ctx, query, now, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expired offers: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan offer: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list expired offers: %w", err)
+	}
+	return ids, nil
+}
+
+// ExpireOffers expires the offers and releases the reservations which ran out at now.
+func (r *sqlOfferRepository) ExpireOffers(ctx context.Context, now time.Time) (int, error) {
+	ids, err := r.dueOffers(ctx, now, 100)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, id := range ids {
+		_, err := r.TransitionOffer(ctx, id, OfferTransition{Action: OfferActionExpire, At: now})
+		// 期限切れの判定と実行の間に相手が操作した交渉は飛ばす
+		if errors.Is(err, ErrInvalidOfferTransition) || errors.Is(err, ErrOfferNotFound) {
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// memoryOfferRepository keeps offers in the memory store of a memoryItemRepository, whose items
+// they reserve and sell.
+type memoryOfferRepository struct {
+	*memoryItemRepository
+}
+
+// NewMemoryOfferRepository returns the OfferRepository sharing the memory store of items, which
+// must be returned by NewMemoryItemRepository.
+func NewMemoryOfferRepository(items ItemRepository) OfferRepository {
+	return memoryOfferRepository{items.(*memoryItemRepository)}
+}
+
+// addOfferEvent appends e to the history. m.mu must be held.
+func (m memoryOfferRepository) addOfferEvent(e *OfferEvent) {
+	e.ID = m.nextOfferEventID
+	m.nextOfferEventID++
+	stored := *e
+	m.offerEvents = append(m.offerEvents, &stored)
+}
+
+// CreateOffer stores a pending offer on a fixed price item.
+func (m memoryOfferRepository) CreateOffer(ctx context.Context, o *Offer, now time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to insert offer: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	item, ok := m.items[o.ItemID]
+	if !ok {
+		return ErrItemNotFound
+	}
+	if err := checkOfferable(&item); err != nil {
+		return err
+	}
+	now = now.UTC()
+	for _, other := range m.offers {
+		if other.ItemID == o.ItemID && other.BuyerID == o.BuyerID && other.open(now) {
+			return ErrOfferExists
+		}
+	}
+	stored := *o
+	stored.ID = m.nextOfferID
+	stored.SellerID, stored.Status, stored.Version = item.SellerID, OfferPending, 1
+	stored.ExpiresAt, stored.ReservedUntil, stored.OrderID = o.ExpiresAt.UTC(), nil, 0
+	stored.CreatedAt, stored.UpdatedAt = now, now
+	stored.History = nil
+	if err := m.recordChange(ctx, AuditCreate, AuditEntityOffer, stored.ID, nil, &stored); err != nil {
+		return err
+	}
+	m.nextOfferID++
+	m.offers[stored.ID] = stored
+	m.addOfferEvent(&OfferEvent{OfferID: stored.ID, Action: OfferActionCreate, ActorID: stored.BuyerID, To: stored.Status, Amount: stored.Amount, CreatedAt: now})
+	*o = stored
+	return nil
+}
+
+// GetOffer returns an offer with its history.
+func (m memoryOfferRepository) GetOffer(ctx context.Context, id int) (*Offer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get offer: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	o, ok := m.offers[id]
+	if !ok {
+		return nil, ErrOfferNotFound
+	}
+	for _, e := range m.offerEvents {
+		if e.OfferID == id {
+			e := *e
+			o.History = append(o.History, &e)
+		}
+	}
+	return &o, nil
+}
+
+// ListOffers returns the offers matching q, newest first.
+func (m memoryOfferRepository) ListOffers(ctx context.Context, q OfferQuery) ([]*Offer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list offers: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var offers []*Offer
+	for _, o := range m.offers {
+		if (q.ItemID == 0 || o.ItemID == q.ItemID) && (q.UserID == 0 || o.BuyerID == q.UserID || o.SellerID == q.UserID) {
+			offers = append(offers, &o)
+		}
+	}
+	slices.SortFunc(offers, func(a, b *Offer) int { return cmp.Compare(b.ID, a.ID) })
+	return offers, nil
+}
+
+// TransitionOffer takes t on an offer with its side effects: accepting reserves the item and
+// checking out sells it.
+func (m memoryOfferRepository) TransitionOffer(ctx context.Context, id int, t OfferTransition) (*Offer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to update offer: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o, ok := m.offers[id]
+	if !ok {
+		return nil, ErrOfferNotFound
+	}
+	before := o
+	e, err := o.apply(t)
+	if err != nil {
+		return nil, err
+	}
+	item := m.items[o.ItemID]
+	if t.Action == OfferActionAccept || t.Action == OfferActionCheckout {
+		if err := checkOfferable(&item); err != nil {
+			return nil, err
+		}
+	}
+
+	switch t.Action {
+	case OfferActionAccept:
+		// 期限の切れた取り置きは解除し、有効な取り置きがあれば承認できない
+		type lapse struct {
+			before, after Offer
+			event         *OfferEvent
+		}
+		var lapses []lapse
+		for _, other := range m.offers {
+			if other.ItemID != o.ItemID || other.ID == o.ID || other.Status != OfferAccepted {
+				continue
+			}
+			otherBefore := other
+			oe, err := other.apply(OfferTransition{Action: OfferActionExpire, At: t.At})
+			if err != nil {
+				return nil, ErrItemReserved
+			}
+			lapses = append(lapses, lapse{otherBefore, other, oe})
+		}
+		for _, l := range lapses {
+			if err := m.saveOffer(ctx, l.before, l.after, l.event); err != nil {
+				return nil, err
+			}
+		}
+	case OfferActionCheckout:
+		now := t.At.UTC()
+		itemBefore := m.withCategory(item)
+		item.Price = o.Amount
+		item.SoldAt = &now
+		item.Version++
+		item.UpdatedAt = now
+		if err := m.recordChange(ctx, AuditSell, AuditEntityItem, item.ID, itemBefore, m.withCategory(item)); err != nil {
+			return nil, err
+		}
+		m.items[item.ID] = item
+		order := Order{ID: m.nextOrderID, ItemID: o.ItemID, SellerID: o.SellerID, BuyerID: o.BuyerID, Price: o.Amount, Status: OrderPending, CreatedAt: now}
+		m.nextOrderID++
+		m.orders = append(m.orders, &order)
+		o.OrderID = order.ID
+	}
+	if err := m.saveOffer(ctx, before, o, e); err != nil {
+		return nil, err
+	}
+	o.Version++
+	return &o, nil
+}
+
+// saveOffer stores the transition of an offer from before to after with its event.
+// m.mu must be held.
+func (m memoryOfferRepository) saveOffer(ctx context.Context, before, after Offer, e *OfferEvent) error {
+	if err := m.recordChange(ctx, AuditUpdate, AuditEntityOffer, after.ID, &before, &after); err != nil {
+		return err
+	}
+	after.Version++
+	m.offers[after.ID] = after
+	m.addOfferEvent(e)
+	return nil
+}
+
+// ExpireOffers expires the offers and releases the reservations which ran out at now.
+func (m memoryOfferRepository) ExpireOffers(ctx context.Context, now time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("failed to expire offers: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids := slices.Sorted(maps.Keys(m.offers))
+	n := 0
+	for _, id := range ids {
+		o := m.offers[id]
+		if d := o.deadline(); d == nil || now.Before(*d) {
+			continue
+		}
+		before := o
+		e, err := o.apply(OfferTransition{Action: OfferActionExpire, At: now})
+		if err != nil {
+			return n, err
+		}
+		if err := m.saveOffer(ctx, before, o, e); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,378 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestOfferApply(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	reserved := now.Add(time.Hour)
+	const buyer, seller = 2, 1
+
+	cases := map[string]struct {
+		status     string
+		transition OfferTransition
+		want       string
+		wantErr    error
+	}{
+		"ok: seller accepts": {
+			status:     OfferPending,
+			transition: OfferTransition{Action: OfferActionAccept, ActorID: seller},
+			want:       OfferAccepted,
+		},
+		"ok: seller counters": {
+			status:     OfferPending,
+			transition: OfferTransition{Action: OfferActionCounter, ActorID: seller, Amount: 900},
+			want:       OfferCountered,
+		},
+		"ok: buyer counters back": {
+			status:     OfferCountered,
+			transition: OfferTransition{Action: OfferActionCounter, ActorID: buyer, Amount: 850},
+			want:       OfferPending,
+		},
+		"ok: buyer accepts a counter offer": {
+			status:     OfferCountered,
+			transition: OfferTransition{Action: OfferActionAccept, ActorID: buyer},
+			want:       OfferAccepted,
+		},
+		"ok: buyer withdraws a reservation": {
+			status:     OfferAccepted,
+			transition: OfferTransition{Action: OfferActionWithdraw, ActorID: buyer},
+			want:       OfferWithdrawn,
+		},
+		"ok: buyer checks out": {
+			status:     OfferAccepted,
+			transition: OfferTransition{Action: OfferActionCheckout, ActorID: buyer},
+			want:       OfferPurchased,
+		},
+		"ok: system expires": {
+			status:     OfferPending,
+			transition: OfferTransition{Action: OfferActionExpire, At: reserved},
+			want:       OfferExpired,
+		},
+		"ok: system lapses a reservation": {
+			status:     OfferAccepted,
+			transition: OfferTransition{Action: OfferActionExpire, At: reserved},
+			want:       OfferLapsed,
+		},
+		"ng: buyer accepts own offer": {
+			status:     OfferPending,
+			transition: OfferTransition{Action: OfferActionAccept, ActorID: buyer},
+			wantErr:    ErrInvalidOfferTransition,
+		},
+		"ng: seller accepts own counter offer": {
+			status:     OfferCountered,
+			transition: OfferTransition{Action: OfferActionAccept, ActorID: seller},
+			wantErr:    ErrInvalidOfferTransition,
+		},
+		"ng: stranger rejects": {
+			status:     OfferPending,
+			transition: OfferTransition{Action: OfferActionReject, ActorID: 9},
+			wantErr:    ErrInvalidOfferTransition,
+		},
+		"ng: checkout before acceptance": {
+			status:     OfferPending,
+			transition: OfferTransition{Action: OfferActionCheckout, ActorID: buyer},
+			wantErr:    ErrInvalidOfferTransition,
+		},
+		"ng: rejected offers are final": {
+			status:     OfferRejected,
+			transition: OfferTransition{Action: OfferActionAccept, ActorID: seller},
+			wantErr:    ErrInvalidOfferTransition,
+		},
+		"ng: accept after expiry": {
+			status:     OfferPending,
+			transition: OfferTransition{Action: OfferActionAccept, ActorID: seller, At: reserved},
+			wantErr:    ErrInvalidOfferTransition,
+		},
+		"ng: checkout after the window": {
+			status:     OfferAccepted,
+			transition: OfferTransition{Action: OfferActionCheckout, ActorID: buyer, At: reserved},
+			wantErr:    ErrInvalidOfferTransition,
+		},
+		"ng: expire too early": {
+			status:     OfferAccepted,
+			transition: OfferTransition{Action: OfferActionExpire},
+			wantErr:    ErrInvalidOfferTransition,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			o := Offer{ID: 1, BuyerID: buyer, SellerID: seller, Amount: 800, Status: tc.status, ExpiresAt: reserved}
+			if tc.status == OfferAccepted {
+				o.ReservedUntil = &reserved
+			}
+			before := o
+			tr := tc.transition
+			if tr.At.IsZero() {
+				tr.At = now
+			}
+			tr.Until = now.Add(48 * time.Hour)
+			e, err := o.apply(tr)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if tc.wantErr != nil {
+				if diff := cmp.Diff(before, o); diff != "" {
+					t.Errorf("expected an invalid transition not to change the offer (-want +got):\n%s", diff)
+				}
+				return
+			}
+			if o.Status != tc.want {
+				t.Errorf("expected status %s, got %s", tc.want, o.Status)
+			}
+			want := &OfferEvent{OfferID: 1, Action: tr.Action, ActorID: tr.ActorID, From: tc.status, To: tc.want, Amount: o.Amount, CreatedAt: tr.At}
+			if diff := cmp.Diff(want, e); diff != "" {
+				t.Errorf("unexpected event (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestOfferHandlers(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	type step struct {
+		method string
+		path   string
+		userID int
+		amount string
+		// at is the time of the request as an offset from start.
+		at     time.Duration
+		status int
+		// wantStatus is the status of the offer in successful responses.
+		wantStatus string
+	}
+
+	cases := map[string][]step{
+		"ok: counter, accept and check out": {
+			{http.MethodPost, "/items/1/offers", 2, "8000", 0, http.StatusCreated, OfferPending},
+			{http.MethodPost, "/offers/1/counter", 1, "9000", time.Hour, http.StatusOK, OfferCountered},
+			{http.MethodPost, "/offers/1/accept", 2, "", 2 * time.Hour, http.StatusOK, OfferAccepted},
+			{http.MethodPost, "/offers/1/checkout", 2, "", 3 * time.Hour, http.StatusOK, OfferPurchased},
+			{http.MethodGet, "/offers/1", 1, "", 3 * time.Hour, http.StatusOK, OfferPurchased},
+			{http.MethodPost, "/items/1/offers", 3, "9000", 3 * time.Hour, http.StatusConflict, ""},
+		},
+		"ok: reject and withdraw": {
+			{http.MethodPost, "/items/1/offers", 2, "5000", 0, http.StatusCreated, OfferPending},
+			{http.MethodPost, "/offers/1/reject", 1, "", 0, http.StatusOK, OfferRejected},
+			{http.MethodPost, "/items/1/offers", 2, "6000", 0, http.StatusCreated, OfferPending},
+			{http.MethodPost, "/offers/2/withdraw", 2, "", 0, http.StatusOK, OfferWithdrawn},
+		},
+		"ng: reserved for another buyer": {
+			{http.MethodPost, "/items/1/offers", 2, "8000", 0, http.StatusCreated, OfferPending},
+			{http.MethodPost, "/items/1/offers", 3, "8500", 0, http.StatusCreated, OfferPending},
+			{http.MethodPost, "/offers/1/accept", 1, "", 0, http.StatusOK, OfferAccepted},
+			{http.MethodPost, "/offers/2/accept", 1, "", 0, http.StatusConflict, ""},
+			{http.MethodPost, "/offers/2/accept", 1, "", DefaultCheckoutWindow, http.StatusOK, OfferAccepted},
+		},
+		"ng: expired": {
+			{http.MethodPost, "/items/1/offers", 2, "8000", 0, http.StatusCreated, OfferPending},
+			{http.MethodPost, "/offers/1/accept", 1, "", DefaultOfferTTL, http.StatusConflict, ""},
+		},
+		"ng: invalid transitions": {
+			{http.MethodPost, "/items/1/offers", 2, "8000", 0, http.StatusCreated, OfferPending},
+			{http.MethodPost, "/offers/1/accept", 2, "", 0, http.StatusConflict, ""},
+			{http.MethodPost, "/offers/1/checkout", 2, "", 0, http.StatusConflict, ""},
+			{http.MethodPost, "/offers/1/counter", 1, "", 0, http.StatusBadRequest, ""},
+			{http.MethodPost, "/items/1/offers", 2, "8500", 0, http.StatusConflict, ""},
+		},
+		"ng: not a party": {
+			{http.MethodPost, "/items/1/offers", 2, "8000", 0, http.StatusCreated, OfferPending},
+			{http.MethodGet, "/offers/1", 3, "", 0, http.StatusNotFound, ""},
+			{http.MethodPost, "/offers/1/accept", 3, "", 0, http.StatusNotFound, ""},
+		},
+		"ng: invalid offers": {
+			{http.MethodPost, "/items/1/offers", 0, "8000", 0, http.StatusUnauthorized, ""},
+			{http.MethodPost, "/items/1/offers", 1, "8000", 0, http.StatusForbidden, ""},
+			{http.MethodPost, "/items/1/offers", 2, "0", 0, http.StatusBadRequest, ""},
+			{http.MethodPost, "/items/2/offers", 2, "500", 0, http.StatusConflict, ""},
+			{http.MethodPost, "/items/99/offers", 2, "500", 0, http.StatusNotFound, ""},
+			{http.MethodGet, "/offers/99", 2, "", 0, http.StatusNotFound, ""},
+		},
+	}
+
+	for name, steps := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			repo := NewMemoryItemRepository()
+			if err := repo.Insert(ctx, &Item{Name: "camera", Category: "camera", SellerID: 1, Price: 10000}); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.Insert(ctx, &Item{Name: "lens", Category: "camera", SellerID: 1, Price: 500, ListingType: ListingAuction,
+				Auction: &Auction{StartPrice: 500, MinIncrement: 100, EndsAt: start.Add(time.Hour)}}); err != nil {
+				t.Fatal(err)
+			}
+			clock := &fakeClock{now: start}
+			h := &Handlers{itemRepo: repo, offers: NewMemoryOfferRepository(repo), clock: clock.Now}
+			mux := http.NewServeMux()
+			mux.HandleFunc("POST /items/{id}/offers", h.MakeOffer)
+			mux.HandleFunc("GET /offers/{id}", h.GetOffer)
+			mux.HandleFunc("POST /offers/{id}/accept", h.AcceptOffer)
+			mux.HandleFunc("POST /offers/{id}/reject", h.RejectOffer)
+			mux.HandleFunc("POST /offers/{id}/counter", h.CounterOffer)
+			mux.HandleFunc("POST /offers/{id}/withdraw", h.WithdrawOffer)
+			mux.HandleFunc("POST /offers/{id}/checkout", h.CheckoutOffer)
+
+			for _, s := range steps {
+				clock.Set(start.Add(s.at))
+				form := url.Values{}
+				if s.amount != "" {
+					form.Set("amount", s.amount)
+				}
+				req := httptest.NewRequest(s.method, s.path, strings.NewReader(form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				if s.userID != 0 {
+					req = req.WithContext(ContextWithUserID(req.Context(), s.userID))
+				}
+				rr := httptest.NewRecorder()
+				mux.ServeHTTP(rr, req)
+				if rr.Code != s.status {
+					t.Fatalf("%s %s: expected status %d, got %d: %s", s.method, s.path, s.status, rr.Code, rr.Body)
+				}
+				if s.wantStatus != "" {
+					var got Offer
+					if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+						t.Fatal(err)
+					}
+					if got.Status != s.wantStatus {
+						t.Errorf("%s %s: expected offer status %s, got %+v", s.method, s.path, s.wantStatus, got)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestGetOffersVisibility(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	repo := NewMemoryItemRepository()
+	if err := repo.Insert(ctx, &Item{Name: "camera", Category: "camera", SellerID: 1, Price: 10000}); err != nil {
+		t.Fatal(err)
+	}
+	offers := NewMemoryOfferRepository(repo)
+	for _, buyerID := range []int{2, 3} {
+		if err := offers.CreateOffer(ctx, &Offer{ItemID: 1, BuyerID: buyerID, Amount: 8000, ExpiresAt: now.Add(time.Hour)}, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := &Handlers{itemRepo: repo, offers: offers}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/{id}/offers", h.GetItemOffers)
+	mux.HandleFunc("GET /me/offers", h.GetMyOffers)
+
+	cases := map[string]struct {
+		path   string
+		userID int
+		status int
+		want   []int
+	}{
+		"ok: seller sees every offer":       {"/items/1/offers", 1, http.StatusOK, []int{2, 1}},
+		"ok: buyers see their own offers":   {"/items/1/offers", 3, http.StatusOK, []int{2}},
+		"ok: no offers":                     {"/items/1/offers", 4, http.StatusOK, []int{}},
+		"ok: offers received":               {"/me/offers", 1, http.StatusOK, []int{2, 1}},
+		"ok: offers made":                   {"/me/offers", 2, http.StatusOK, []int{1}},
+		"ng: not signed in":                 {"/me/offers", 0, http.StatusUnauthorized, nil},
+		"ng: not signed in for item offers": {"/items/1/offers", 0, http.StatusUnauthorized, nil},
+		"ng: missing item":                  {"/items/99/offers", 1, http.StatusNotFound, nil},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.userID != 0 {
+				req = req.WithContext(ContextWithUserID(req.Context(), tc.userID))
+			}
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+			if rr.Code != tc.status {
+				t.Fatalf("expected status %d, got %d: %s", tc.status, rr.Code, rr.Body)
+			}
+			if tc.want == nil {
+				return
+			}
+			var resp struct {
+				Offers []*Offer `json:"offers"`
+			}
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			ids := []int{}
+			for _, o := range resp.Offers {
+				ids = append(ids, o.ID)
+			}
+			if diff := cmp.Diff(tc.want, ids); diff != "" {
+				t.Errorf("unexpected offers (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestOfferExpiryJob(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	start := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	repo := NewMemoryItemRepository()
+	if err := repo.Insert(ctx, &Item{Name: "camera", Category: "camera", SellerID: 1, Price: 10000}); err != nil {
+		t.Fatal(err)
+	}
+	offers := NewMemoryOfferRepository(repo)
+	pending := &Offer{ItemID: 1, BuyerID: 2, Amount: 8000, ExpiresAt: start.Add(time.Hour)}
+	reserved := &Offer{ItemID: 1, BuyerID: 3, Amount: 9000, ExpiresAt: start.Add(time.Hour)}
+	for _, o := range []*Offer{pending, reserved} {
+		if err := offers.CreateOffer(ctx, o, start); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := offers.TransitionOffer(ctx, reserved.ID, OfferTransition{Action: OfferActionAccept, ActorID: 1, At: start, Until: start.Add(2 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	clock := &fakeClock{now: start.Add(time.Hour)}
+	job := offerExpiryJob(offers, clock.Now)
+	status := func(id int) string {
+		t.Helper()
+		o, err := offers.GetOffer(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return o.Status
+	}
+	if err := job(ctx); err != nil {
+		t.Fatalf("failed to expire offers: %v", err)
+	}
+	if got := []string{status(pending.ID), status(reserved.ID)}; !cmp.Equal(got, []string{OfferExpired, OfferAccepted}) {
+		t.Errorf("expected only the pending offer to expire, got %v", got)
+	}
+	clock.Set(start.Add(2 * time.Hour))
+	if err := job(ctx); err != nil {
+		t.Fatalf("failed to expire offers: %v", err)
+	}
+	if got := status(reserved.ID); got != OfferLapsed {
+		t.Errorf("expected the reservation to lapse, got %s", got)
+	}
+}
@@ -0,0 +1,160 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Domain event types written to the outbox.
+const (
+	EventItemCreated     = "ItemCreated"
+	EventItemUpdated     = "ItemUpdated"
+	EventItemSold        = "ItemSold"
+	EventCategoryCreated = "CategoryCreated"
+)
+
+// Event is a domain event written to the outbox in the same transaction as the change it
+// describes, and delivered to EventSinks by EventDispatcher at least once.
+type Event struct {
+	ID        int64     `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	// Payload is the JSON snapshot of the entity after the change.
+	Payload json.RawMessage `json:"payload"`
+	// Attempts is the number of failed deliveries so far.
+	Attempts int `json:"-"`
+}
+
+// eventType returns the event emitted for an audited change, or "" for none.
+// Deletion and restoration change deleted_at, so they are item updates.
+func eventType(action, entity string) string {
+	switch {
+	case entity == AuditEntityCategory && action == AuditCreate:
+		return EventCategoryCreated
+	case entity == AuditEntityItem && action == AuditCreate:
+		return EventItemCreated
+	case entity == AuditEntityItem && (action == AuditUpdate || action == AuditDelete || action == AuditRestore):
+		return EventItemUpdated
+	case entity == AuditEntityItem && action == AuditSell:
+		return EventItemSold
+	default:
+		return ""
+	}
+}
+
+// newEvent builds the event for an audited change, or returns nil when it emits none.
+func newEvent(action, entity string, after any) (*Event, error) {
+	typ := eventType(action, entity)
+	if typ == "" {
+		return nil, nil
+	}
+	payload, err := json.Marshal(after)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode event: %w", err)
+	}
+	now := time.Now().UTC()
+	return &Event{Type: typ, CreatedAt: now, Payload: payload}, nil
+}
+
+// recordChange writes the audit entry and the domain event of a change with tx, the transaction
+// making the change. placeholder is the dialect's bind parameter.
+func recordChange(ctx context.Context, tx querier, placeholder func(int) string, action, entity string, entityID int, before, after any) error {
+	if err := writeAudit(ctx, tx, placeholder, action, entity, entityID, before, after); err != nil {
+		return err
+	}
+	e, err := newEvent(action, entity, after)
+	if err != nil || e == nil {
+		return err
+	}
+	return writeEvent(ctx, tx, placeholder, e)
+}
+
+// writeEvent appends an event to the outbox.
+func writeEvent(ctx context.Context, tx querier, placeholder func(int) string, e *Event) error {
+	query := fmt.Sprintf(`INSERT INTO outbox (created_at, event_type, payload, attempts, next_attempt_at) VALUES (%s, %s, %s, 0, %s)`,
+		placeholder(1), placeholder(2), placeholder(3), placeholder(4))
+	if _, err := tx.ExecContext(ctx, query, e.CreatedAt, e.Type, string(e.Payload), e.CreatedAt); err != nil {
+		return fmt.Errorf("failed to write event: %w", err)
+	}
+	return nil
+}
+
+// pendingEvents returns up to limit undelivered events due at now, oldest first.
+func pendingEvents(ctx context.Context, db querier, placeholder func(int) string, now time.Time, limit int) ([]*Event, error) {
+	query := `SELECT id, created_at, event_type, payload, attempts FROM outbox
+        WHERE delivered_at IS NULL AND next_attempt_at <= ` + placeholder(1) + `
+        ORDER BY id LIMIT ` + strconv.Itoa(limit)
+	rows, err := db.QueryContext(ctx, query, now.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve events: %w", err)
+	}
+	return scanEvents(rows)
+}
+
+// eventsAfter returns up to limit events with IDs after afterID, delivered or not, oldest first.
+func eventsAfter(ctx context.Context, db querier, placeholder func(int) string, afterID int64, limit int) ([]*Event, error) {
+	query := `SELECT id, created_at, event_type, payload, attempts FROM outbox
+        WHERE id > ` + placeholder(1) + ` ORDER BY id LIMIT ` + strconv.Itoa(limit)
+	rows, err := db.QueryContext(ctx, query, afterID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve events: %w", err)
+	}
+	return scanEvents(rows)
+}
+
+// scanEvents reads the events selected by pendingEvents or eventsAfter and closes rows.
+func scanEvents(rows *sql.Rows) ([]*Event, error) {
+	defer rows.Close()
+
+	var events []*Event
+	for rows.Next() {
+		var e Event
+		var payload string
+		if err := rows.Scan(&e.ID, &e.CreatedAt, &e.Type, &payload, &e.Attempts); err != nil {
+			return nil, fmt.Errorf("failed to scan event: %w", err)
+		}
+		e.Payload = json.RawMessage(payload)
+		events = append(events, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve events: %w", err)
+	}
+	return events, nil
+}
+
+// markEventDelivered records that every sink accepted an event.
+func markEventDelivered(ctx context.Context, db querier, placeholder func(int) string, id int64) error {
+	query := `UPDATE outbox SET delivered_at = ` + placeholder(1) + `, last_error = NULL WHERE id = ` + placeholder(2)
+	if _, err := db.ExecContext(ctx, query, time.Now().UTC(), id); err != nil {
+		return fmt.Errorf("failed to mark event delivered: %w", err)
+	}
+	return nil
+}
+
+// markEventFailed records a failed delivery and when to try again.
+func markEventFailed(ctx context.Context, db querier, placeholder func(int) string, id int64, next time.Time, reason string) error {
+	query := fmt.Sprintf(`UPDATE outbox SET attempts = attempts + 1, next_attempt_at = %s, last_error = %s WHERE id = %s`,
+		placeholder(1), placeholder(2), placeholder(3))
+	if _, err := db.ExecContext(ctx, query, next.UTC(), reason, id); err != nil {
+		return fmt.Errorf("failed to mark event failed: %w", err)
+	}
+	return nil
+}
+
+// pruneEvents deletes events delivered before deliveredBefore.
+func pruneEvents(ctx context.Context, db querier, placeholder func(int) string, deliveredBefore time.Time) (int, error) {
+	query := `DELETE FROM outbox WHERE delivered_at IS NOT NULL AND delivered_at < ` + placeholder(1)
+	result, err := db.ExecContext(ctx, query, deliveredBefore.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune events: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune events: %w", err)
+	}
+	return int(n), nil
+}
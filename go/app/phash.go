@@ -0,0 +1,165 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"math/bits"
+	"sort"
+)
+
+// dHash の縮小サイズ。横に 1 px 多く取り、隣り合う画素の明暗で 64 bit を作る
+const (
+	dHashWidth  = 9
+	dHashHeight = 8
+)
+
+// DefaultSimilarImageDistance is the Hamming distance under which two images are treated as the same photo.
+const DefaultSimilarImageDistance = 10
+
+// computeImageHash decodes a JPEG or PNG and returns its difference hash (dHash).
+// Unlike SHA-256, re-encoded or resized copies of a photo get hashes a few bits apart.
+func computeImageHash(data []byte) (uint64, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return dHash(img), nil
+}
+
+// dHash shrinks img to 9x8 grayscale cells and sets a bit when a cell is brighter than its right neighbour.
+func dHash(img image.Image) uint64 {
+	b := img.Bounds()
+	if b.Empty() {
+		return 0
+	}
+
+	// 各セルに入る画素の輝度を平均して縮小する
+	var sum, count [dHashHeight][dHashWidth]uint64
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		cy := (y - b.Min.Y) * dHashHeight / b.Dy()
+		for x := b.Min.X; x < b.Max.X; x++ {
+			cx := (x - b.Min.X) * dHashWidth / b.Dx()
+			r, g, bl, _ := img.At(x, y).RGBA()
+			sum[cy][cx] += (299*uint64(r) + 587*uint64(g) + 114*uint64(bl)) / 1000
+			count[cy][cx]++
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < dHashHeight; y++ {
+		for x := 0; x < dHashWidth-1; x++ {
+			hash <<= 1
+			if mean(sum[y][x], count[y][x]) > mean(sum[y][x+1], count[y][x+1]) {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+func mean(sum, count uint64) uint64 {
+	if count == 0 {
+		return 0
+	}
+	return sum / count
+}
+
+// hammingDistance returns the number of differing bits.
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// SimilarItem is an item whose image is perceptually close to another one.
+type SimilarItem struct {
+	*Item
+	Distance int `json:"distance"`
+}
+
+// ImageHash is the perceptual hash of an item's image, listed without loading the whole item.
+type ImageHash struct {
+	ItemID   int
+	SellerID int
+	Hash     uint64
+}
+
+// imageMatch is an image hash within the similarity threshold of another one.
+type imageMatch struct {
+	ImageHash
+	Distance int
+}
+
+// selectImageHashes returns the hashes of the items not in the trash, in ID order.
+// Items without a hash are left out.
+func selectImageHashes(ctx context.Context, q querier) ([]ImageHash, error) {
+	rows, err := q.QueryContext(ctx, `SELECT id, seller_id, image_hash FROM items WHERE deleted_at IS NULL AND image_hash IS NOT NULL ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list image hashes: %w", err)
+	}
+	defer rows.Close()
+
+	var hashes []ImageHash
+	for rows.Next() {
+		var h ImageHash
+		var sellerID sql.NullInt64
+		var hash int64
+		if err := rows.Scan(&h.ItemID, &sellerID, &hash); err != nil {
+			return nil, fmt.Errorf("failed to scan image hash: %w", err)
+		}
+		h.SellerID, h.Hash = int(sellerID.Int64), uint64(hash)
+		hashes = append(hashes, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list image hashes: %w", err)
+	}
+	return hashes, nil
+}
+
+// findSimilarImages returns the hashes within maxDistance of hash, closest first.
+// Zero hashes and the item excludeID are skipped.
+func findSimilarImages(hashes []ImageHash, hash uint64, maxDistance, excludeID int) []imageMatch {
+	similar := []imageMatch{}
+	if hash == 0 {
+		return similar
+	}
+	for _, h := range hashes {
+		if h.ItemID == excludeID || h.Hash == 0 {
+			continue
+		}
+		if d := hammingDistance(hash, h.Hash); d <= maxDistance {
+			similar = append(similar, imageMatch{ImageHash: h, Distance: d})
+		}
+	}
+	sort.SliceStable(similar, func(i, j int) bool {
+		return similar[i].Distance < similar[j].Distance
+	})
+	return similar
+}
+
+// DuplicateImageAction decides what AddItem does when the image matches another seller's listing.
+type DuplicateImageAction string
+
+const (
+	// DuplicateImageIgnore skips the similarity check.
+	DuplicateImageIgnore DuplicateImageAction = ""
+	// DuplicateImageWarn accepts the item and returns a warning.
+	DuplicateImageWarn DuplicateImageAction = "warn"
+	// DuplicateImageReject refuses the item with 409 Conflict.
+	DuplicateImageReject DuplicateImageAction = "reject"
+)
+
+// otherSellerImages drops the images of items listed by sellerID. Anonymous listings never match a seller.
+func otherSellerImages(matches []imageMatch, sellerID int) []imageMatch {
+	others := []imageMatch{}
+	for _, m := range matches {
+		if sellerID != 0 && m.SellerID == sellerID {
+			continue
+		}
+		others = append(others, m)
+	}
+	return others
+}
@@ -0,0 +1,187 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/mock/gomock"
+)
+
+// testPattern draws a diagonal gradient with a bright square, scaled to w x h.
+func testPattern(w, h int, inverted bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8((x*255/w + y*255/h) / 2)
+			if x > w/4 && x < w/2 && y > h/4 && y < h/2 {
+				v = 255
+			}
+			if inverted {
+				v = 255 - v
+			}
+			img.Set(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 60}); err != nil {
+		t.Fatalf("failed to encode jpeg: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestComputeImageHash(t *testing.T) {
+	t.Parallel()
+
+	original, err := computeImageHash(encodePNG(t, testPattern(320, 240, false)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := map[string]struct {
+		data        []byte
+		maxDistance int
+		minDistance int
+	}{
+		"ok: resized and re-encoded as jpeg": {
+			data:        encodeJPEG(t, testPattern(160, 120, false)),
+			maxDistance: DefaultSimilarImageDistance,
+		},
+		"ok: different image": {
+			data:        encodePNG(t, testPattern(320, 240, true)),
+			minDistance: DefaultSimilarImageDistance + 1,
+			maxDistance: 64,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := computeImageHash(tt.data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			d := hammingDistance(original, got)
+			if d < tt.minDistance || d > tt.maxDistance {
+				t.Errorf("expected distance in [%d, %d], got %d", tt.minDistance, tt.maxDistance, d)
+			}
+		})
+	}
+
+	if _, err := computeImageHash([]byte("this is not an image")); err == nil {
+		t.Errorf("expected error for undecodable image")
+	}
+}
+
+func TestAddItemDuplicateImage(t *testing.T) {
+	t.Parallel()
+
+	imageData := encodePNG(t, testPattern(320, 240, false))
+	hash, err := computeImageHash(imageData)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	existing := []ImageHash{{ItemID: 7, SellerID: 1, Hash: hash}}
+
+	cases := map[string]struct {
+		action     DuplicateImageAction
+		sellerID   int
+		setupMocks func(m *MockItemRepository)
+		code       int
+		similarIDs []int
+	}{
+		"ng: reject another seller's photo": {
+			action:   DuplicateImageReject,
+			sellerID: 2,
+			setupMocks: func(m *MockItemRepository) {
+				m.EXPECT().ImageHashes(gomock.Any()).Return(existing, nil)
+			},
+			code: http.StatusConflict,
+		},
+		"ok: warn about another seller's photo": {
+			action:   DuplicateImageWarn,
+			sellerID: 2,
+			setupMocks: func(m *MockItemRepository) {
+				m.EXPECT().ImageHashes(gomock.Any()).Return(existing, nil)
+				m.EXPECT().GetCategoryByName(gomock.Any(), "phone").Return(&Category{ID: 1, Name: "phone"}, nil)
+				m.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(nil)
+			},
+			code:       http.StatusOK,
+			similarIDs: []int{7},
+		},
+		"ok: same seller reposting": {
+			action:   DuplicateImageReject,
+			sellerID: 1,
+			setupMocks: func(m *MockItemRepository) {
+				m.EXPECT().ImageHashes(gomock.Any()).Return(existing, nil)
+				m.EXPECT().GetCategoryByName(gomock.Any(), "phone").Return(&Category{ID: 1, Name: "phone"}, nil)
+				m.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(nil)
+			},
+			code: http.StatusOK,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			mockRepo := NewMockItemRepository(ctrl)
+			tt.setupMocks(mockRepo)
+			h := &Handlers{imgDirPath: t.TempDir(), itemRepo: mockRepo, duplicateImageAction: tt.action}
+
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			writer.WriteField("name", "used iPhone 16e")
+			writer.WriteField("category", "phone")
+			part, _ := writer.CreateFormFile("image", "test.png")
+			part.Write(imageData)
+			writer.Close()
+
+			req := httptest.NewRequest("POST", "/items", body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			req = req.WithContext(ContextWithUserID(context.Background(), tt.sellerID))
+			res := httptest.NewRecorder()
+			h.AddItem(res, req)
+
+			if res.Code != tt.code {
+				t.Fatalf("expected status code %d, got %d: %s", tt.code, res.Code, res.Body.String())
+			}
+			if res.Code != http.StatusOK {
+				return
+			}
+			var got struct {
+				SimilarItemIDs []int `json:"similar_item_ids"`
+			}
+			if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if len(got.SimilarItemIDs) != len(tt.similarIDs) {
+				t.Errorf("expected similar items %v, got %v", tt.similarIDs, got.SimilarItemIDs)
+			}
+		})
+	}
+}
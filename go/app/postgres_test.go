@@ -0,0 +1,127 @@
+package app
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// PostgreSQL for tests comes from TEST_POSTGRES_DSN, or else from a throwaway cluster started
+// with the initdb / pg_ctl binaries on PATH. Without either, PostgreSQL tests are skipped,
+// unless REQUIRE_POSTGRES=true (as in CI) makes them fail instead.
+var localPostgres struct {
+	once sync.Once
+	dsn  string
+	stop func()
+	err  error
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if localPostgres.stop != nil {
+		localPostgres.stop()
+	}
+	os.Exit(code)
+}
+
+// postgresTestDSN returns the DSN of a PostgreSQL server for tests, skipping t if there is none
+// and it is not required.
+func postgresTestDSN(t *testing.T) string {
+	t.Helper()
+	if dsn := os.Getenv("TEST_POSTGRES_DSN"); dsn != "" {
+		return dsn
+	}
+	localPostgres.once.Do(startLocalPostgres)
+	if localPostgres.err != nil {
+		if os.Getenv("REQUIRE_POSTGRES") == "true" {
+			t.Fatalf("PostgreSQL is required but not available: %v", localPostgres.err)
+		}
+		t.Skipf("PostgreSQL is not available: %v", localPostgres.err)
+	}
+	return localPostgres.dsn
+}
+
+func startLocalPostgres() {
+	initdb, err := exec.LookPath("initdb")
+	if err != nil {
+		localPostgres.err = errors.New("set TEST_POSTGRES_DSN or put initdb and pg_ctl on PATH")
+		return
+	}
+	pgCtl, err := exec.LookPath("pg_ctl")
+	if err != nil {
+		localPostgres.err = errors.New("set TEST_POSTGRES_DSN or put initdb and pg_ctl on PATH")
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "pgtest-*")
+	if err != nil {
+		localPostgres.err = err
+		return
+	}
+	dataDir := filepath.Join(dir, "data")
+	if out, err := exec.Command(initdb, "-D", dataDir, "-U", "postgres", "-A", "trust", "--no-sync").CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		localPostgres.err = fmt.Errorf("initdb failed: %v: %s", err, out)
+		return
+	}
+
+	// 空いているポートを探して使う
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		os.RemoveAll(dir)
+		localPostgres.err = err
+		return
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	opts := fmt.Sprintf("-p %d -k %s -c listen_addresses=127.0.0.1 -F", port, dir)
+	if out, err := exec.Command(pgCtl, "-D", dataDir, "-l", filepath.Join(dir, "postgres.log"), "-o", opts, "-w", "start").CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		localPostgres.err = fmt.Errorf("pg_ctl start failed: %v: %s", err, out)
+		return
+	}
+
+	localPostgres.dsn = fmt.Sprintf("postgres://postgres@127.0.0.1:%d/postgres?sslmode=disable", port)
+	localPostgres.stop = func() {
+		exec.Command(pgCtl, "-D", dataDir, "-m", "immediate", "stop").Run()
+		os.RemoveAll(dir)
+	}
+}
+
+// newPostgresTestSchema creates a schema dropped after the test and returns a DSN using it.
+func newPostgresTestSchema(t *testing.T, dsn string) string {
+	t.Helper()
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("failed to connect to postgres: %v", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	schema := "test_" + hex.EncodeToString(buf)
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+	t.Cleanup(func() { admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("TEST_POSTGRES_DSN must be a postgres:// URL: %v", err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -0,0 +1,182 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 1000
+)
+
+// Profile is the public information of a user. Users who never saved theirs have an empty one.
+type Profile struct {
+	UserID      int    `json:"user_id"`
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	// AvatarName is the image file of the avatar, served by GET /images/{filename}. Empty means none.
+	AvatarName string `json:"avatar_name,omitempty"`
+	// UpdatedAt is nil until the profile is saved.
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// UserProfile is a profile with the user's activity, returned by GET /users/{id} .
+type UserProfile struct {
+	*Profile
+	// ListingCount is the number of the user's items on sale.
+	ListingCount int         `json:"listing_count"`
+	Reputation   *Reputation `json:"reputation"`
+}
+
+// ProfileRepository stores the users' profiles. Avatars are images which the ItemRepository
+// keeps while they are referenced, so it shares its database.
+type ProfileRepository interface {
+	// GetProfile returns the profile of userID, or an empty one if it was never saved.
+	GetProfile(ctx context.Context, userID int) (*Profile, error)
+	// SaveProfile inserts or replaces the profile of p.UserID, setting its UpdatedAt to now.
+	SaveProfile(ctx context.Context, p *Profile, now time.Time) error
+}
+
+// userIDFromPath returns the user ID in the path, writing the error response if it is invalid.
+func userIDFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// formField returns a form field and whether it was sent at all.
+func formField(r *http.Request, key string) (string, bool) {
+	v := r.FormValue(key) // フォームを解析する
+	_, ok := r.Form[key]
+	return v, ok
+}
+
+// userProfile returns the profile of userID with the number of items on sale and the reputation.
+func (h *Handlers) userProfile(ctx context.Context, userID int) (*UserProfile, error) {
+	p, err := h.profiles.GetProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	items, err := h.itemRepo.Find(ctx, ItemQuery{SellerID: userID})
+	if err != nil {
+		return nil, err
+	}
+	up := &UserProfile{Profile: p}
+	for _, item := range items {
+		if item.SoldAt == nil {
+			up.ListingCount++
+		}
+	}
+	if up.Reputation, err = h.reviews.Reputation(ctx, userID); err != nil {
+		return nil, err
+	}
+	return up, nil
+}
+
+// GetUserProfile is a handler to return the public profile of a user for GET /users/{id} .
+func (h *Handlers) GetUserProfile(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	up, err := h.userProfile(r.Context(), userID)
+	if err != nil {
+		slog.Error("failed to get profile", "user_id", userID, "error", err)
+		http.Error(w, "failed to get profile", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(up)
+}
+
+// GetUserItems is a handler to return the items a user listed for GET /users/{id}/items .
+// It takes the same parameters as GET /items .
+func (h *Handlers) GetUserItems(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	h.writeItems(w, r, ItemQuery{SellerID: userID})
+}
+
+// UpdateMyProfile is a handler to edit the signed-in user's profile for PATCH /me .
+// Only the fields sent are changed: display_name, bio, and the avatar as an image file or the
+// upload_id of a completed resumable upload, validated like the image of POST /items .
+func (h *Handlers) UpdateMyProfile(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, signedIn := userIDFromContext(ctx)
+	if !signedIn {
+		http.Error(w, "sign in to edit your profile", http.StatusUnauthorized)
+		return
+	}
+	p, err := h.profiles.GetProfile(ctx, userID)
+	if err != nil {
+		http.Error(w, "failed to get profile", http.StatusInternalServerError)
+		return
+	}
+
+	// 空文字で消せるように、送られてきたかどうかで判定する
+	if v, ok := formField(r, "display_name"); ok {
+		p.DisplayName = strings.TrimSpace(v)
+		if utf8.RuneCountInString(p.DisplayName) > maxDisplayNameLength {
+			http.Error(w, fmt.Sprintf("display_name is too long (max %d chars)", maxDisplayNameLength), http.StatusBadRequest)
+			return
+		}
+	}
+	if v, ok := formField(r, "bio"); ok {
+		p.Bio = strings.TrimSpace(v)
+		if utf8.RuneCountInString(p.Bio) > maxBioLength {
+			http.Error(w, fmt.Sprintf("bio is too long (max %d chars)", maxBioLength), http.StatusBadRequest)
+			return
+		}
+	}
+
+	image, uploadID, err := parseImage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if uploadID != "" {
+		if image, err = h.readUploadedImage(uploadID); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if image != nil {
+		if p.AvatarName, err = h.storeImage(image); err != nil {
+			slog.Error("failed to store avatar", "error", err)
+			http.Error(w, "failed to store avatar", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := h.profiles.SaveProfile(ctx, p, h.now()); err != nil {
+		slog.Error("failed to save profile", "user_id", userID, "error", err)
+		http.Error(w, "failed to save profile", http.StatusInternalServerError)
+		return
+	}
+	if uploadID != "" {
+		if err := h.uploads.Delete(uploadID); err != nil {
+			slog.Warn("failed to delete consumed upload", "upload_id", uploadID, "error", err)
+		}
+	}
+
+	up, err := h.userProfile(ctx, userID)
+	if err != nil {
+		http.Error(w, "failed to get profile", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(up)
+}
@@ -0,0 +1,132 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// sqlProfileRepository stores profiles in SQLite or PostgreSQL. placeholder is the dialect's bind
+// parameter.
+type sqlProfileRepository struct {
+	db          *sql.DB
+	placeholder func(int) string
+}
+
+// NewProfileRepository returns a ProfileRepository using the database opened by OpenDatabase with dsn.
+func NewProfileRepository(db *sql.DB, dsn string) ProfileRepository {
+	d, _ := parseDSN(dsn)
+	return &sqlProfileRepository{db: db, placeholder: d.placeholder()}
+}
+
+// GetProfile returns the profile of userID, or an empty one if it was never saved.
+func (r *sqlProfileRepository) GetProfile(ctx context.Context, userID int) (*Profile, error) {
+	return r.get(ctx, r.db, userID)
+}
+
+// get returns the profile of userID. q may be a transaction.
+func (r *sqlProfileRepository) get(ctx context.Context, q querier, userID int) (*Profile, error) {
+	p := &Profile{UserID: userID}
+	var avatarName sql.NullString
+	var updatedAt time.Time
+	query := `SELECT display_name, bio, avatar_name, updated_at FROM profiles WHERE user_id = ` + r.placeholder(1)
+	err := q.QueryRowContext(ctx, query, userID).Scan(&p.DisplayName, &p.Bio, &avatarName, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get profile: %w", err)
+	}
+	p.AvatarName, p.UpdatedAt = avatarName.String, &updatedAt
+	return p, nil
+}
+
+// SaveProfile inserts or replaces the profile of p.UserID and records it in the audit log.
+func (r *sqlProfileRepository) SaveProfile(ctx context.Context, p *Profile, now time.Time) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := r.get(ctx, tx, p.UserID)
+	if err != nil {
+		return err
+	}
+	now = now.UTC()
+	query := `INSERT INTO profiles (user_id, display_name, bio, avatar_name, updated_at) VALUES (` + params(r.placeholder, 5) + `)
+        ON CONFLICT (user_id) DO UPDATE SET display_name = excluded.display_name, bio = excluded.bio,
+        avatar_name = excluded.avatar_name, updated_at = excluded.updated_at`
+	avatarName := sql.NullString{String: p.AvatarName, Valid: p.AvatarName != ""}
+	if _, err := tx.ExecContext(ctx, query, p.UserID, p.DisplayName, p.Bio, avatarName, now); err != nil {
+		return fmt.Errorf("failed to save profile: %w", err)
+	}
+	after := *p
+	after.UpdatedAt = &now
+	action := AuditUpdate
+	if before.UpdatedAt == nil {
+		action, before = AuditCreate, nil
+	}
+	if err := recordChange(ctx, tx, r.placeholder, action, AuditEntityProfile, p.UserID, before, &after); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to save profile: %w", err)
+	}
+	p.UpdatedAt = &now
+	return nil
+}
+
+// memoryProfileRepository keeps profiles in the memory store of a memoryItemRepository, which
+// keeps their avatars while they are referenced.
+type memoryProfileRepository struct {
+	*memoryItemRepository
+}
+
+// NewMemoryProfileRepository returns the ProfileRepository sharing the memory store of items,
+// which must be returned by NewMemoryItemRepository.
+func NewMemoryProfileRepository(items ItemRepository) ProfileRepository {
+	return memoryProfileRepository{items.(*memoryItemRepository)}
+}
+
+// GetProfile returns the profile of userID, or an empty one if it was never saved.
+func (m memoryProfileRepository) GetProfile(ctx context.Context, userID int) (*Profile, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get profile: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	p, ok := m.profiles[userID]
+	if !ok {
+		return &Profile{UserID: userID}, nil
+	}
+	profile := *p
+	return &profile, nil
+}
+
+// SaveProfile inserts or replaces the profile of p.UserID.
+func (m memoryProfileRepository) SaveProfile(ctx context.Context, p *Profile, now time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to save profile: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now = now.UTC()
+	after := *p
+	after.UpdatedAt = &now
+	// 新規作成の before は nil にする
+	action, before := AuditCreate, any(nil)
+	if stored, ok := m.profiles[p.UserID]; ok {
+		action, before = AuditUpdate, stored
+	}
+	if err := m.recordChange(ctx, action, AuditEntityProfile, p.UserID, before, &after); err != nil {
+		return err
+	}
+	m.profiles[p.UserID] = &after
+	p.UpdatedAt = &now
+	return nil
+}
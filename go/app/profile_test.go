@@ -0,0 +1,268 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUpdateMyProfile(t *testing.T) {
+	t.Parallel()
+
+	avatar := encodePNG(t, testPattern(64, 64, false))
+	cases := map[string]struct {
+		userID int
+		fields map[string]string
+		image  []byte
+		status int
+		// want is the profile after the request when it succeeds.
+		want *Profile
+	}{
+		"ok: name and bio": {
+			userID: 1,
+			fields: map[string]string{"display_name": " たろう ", "bio": "カメラ好き"},
+			status: http.StatusOK,
+			want:   &Profile{UserID: 1, DisplayName: "たろう", Bio: "カメラ好き", AvatarName: "old.jpg"},
+		},
+		"ok: clear bio only": {
+			userID: 1,
+			fields: map[string]string{"bio": ""},
+			status: http.StatusOK,
+			want:   &Profile{UserID: 1, DisplayName: "old name", AvatarName: "old.jpg"},
+		},
+		"ok: avatar": {
+			userID: 1,
+			image:  avatar,
+			status: http.StatusOK,
+			want:   &Profile{UserID: 1, DisplayName: "old name", Bio: "old bio", AvatarName: "stored"},
+		},
+		"ng: not signed in": {
+			fields: map[string]string{"display_name": "たろう"},
+			status: http.StatusUnauthorized,
+		},
+		"ng: display name too long": {
+			userID: 1,
+			fields: map[string]string{"display_name": strings.Repeat("あ", maxDisplayNameLength+1)},
+			status: http.StatusBadRequest,
+		},
+		"ng: avatar is not an image": {
+			userID: 1,
+			image:  []byte("not an image"),
+			status: http.StatusBadRequest,
+		},
+		"ng: invalid upload id": {
+			userID: 1,
+			fields: map[string]string{"upload_id": "../etc"},
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			repo := NewMemoryItemRepository()
+			profiles := NewMemoryProfileRepository(repo)
+			if err := profiles.SaveProfile(ctx, &Profile{UserID: 1, DisplayName: "old name", Bio: "old bio", AvatarName: "old.jpg"}, time.Now()); err != nil {
+				t.Fatal(err)
+			}
+			dir := t.TempDir()
+			h := &Handlers{imgDirPath: dir, itemRepo: repo, reviews: NewMemoryReviewRepository(repo), profiles: profiles}
+
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			for key, value := range tt.fields {
+				writer.WriteField(key, value)
+			}
+			if tt.image != nil {
+				part, err := writer.CreateFormFile("image", "avatar.png")
+				if err != nil {
+					t.Fatal(err)
+				}
+				part.Write(tt.image)
+			}
+			writer.Close()
+
+			req := httptest.NewRequest(http.MethodPatch, "/me", body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			if tt.userID != 0 {
+				req = req.WithContext(ContextWithUserID(req.Context(), tt.userID))
+			}
+			rr := httptest.NewRecorder()
+			h.UpdateMyProfile(rr, req)
+			if rr.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, rr.Code, rr.Body)
+			}
+			if tt.want == nil {
+				return
+			}
+
+			got, err := profiles.GetProfile(ctx, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// アバターは保存された画像のファイル名になる
+			if tt.want.AvatarName == "stored" {
+				if _, err := os.Stat(filepath.Join(dir, got.AvatarName)); err != nil || !storedImagePattern.MatchString(got.AvatarName) {
+					t.Errorf("expected the avatar to be stored, got %q: %v", got.AvatarName, err)
+				}
+				tt.want.AvatarName = got.AvatarName
+			}
+			tt.want.UpdatedAt = got.UpdatedAt
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected profile (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGetUserProfile(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	start := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	// 出品者 1 の商品 1 はオークションで売れ、商品 2 は出品中
+	repo := newOrderRepo(t, start)
+	if err := repo.Insert(ctx, &Item{Name: "lens", Category: "camera", SellerID: 1, Price: 500}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Insert(ctx, &Item{Name: "tripod", Category: "camera", SellerID: 2, Price: 800}); err != nil {
+		t.Fatal(err)
+	}
+	reviews := NewMemoryReviewRepository(repo)
+	if _, err := NewMemoryAuctionRepository(repo).CompleteOrder(ctx, 1, start); err != nil {
+		t.Fatal(err)
+	}
+	if err := reviews.AddReview(ctx, &Review{OrderID: 1, ReviewerID: 2, Rating: RatingGood}, start, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	profiles := NewMemoryProfileRepository(repo)
+	if err := profiles.SaveProfile(ctx, &Profile{UserID: 1, DisplayName: "たろう", AvatarName: "a.jpg"}, start); err != nil {
+		t.Fatal(err)
+	}
+	h := &Handlers{itemRepo: repo, reviews: reviews, profiles: profiles}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}", h.GetUserProfile)
+
+	cases := map[string]struct {
+		path   string
+		status int
+		want   *UserProfile
+	}{
+		"ok: seller": {"/users/1", http.StatusOK, &UserProfile{
+			Profile:      &Profile{UserID: 1, DisplayName: "たろう", AvatarName: "a.jpg"},
+			ListingCount: 1,
+			Reputation:   &Reputation{Good: 1, Total: 1},
+		}},
+		"ok: never saved a profile": {"/users/3", http.StatusOK, &UserProfile{
+			Profile:    &Profile{UserID: 3},
+			Reputation: &Reputation{},
+		}},
+		"ng: invalid id": {"/users/abc", http.StatusBadRequest, nil},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			if rr.Code != tc.status {
+				t.Fatalf("expected status %d, got %d: %s", tc.status, rr.Code, rr.Body)
+			}
+			if tc.want == nil {
+				return
+			}
+			var got UserProfile
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			got.UpdatedAt = nil
+			if diff := cmp.Diff(tc.want, &got); diff != "" {
+				t.Errorf("unexpected profile (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGetUserItems(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	repo := NewMemoryItemRepository()
+	for _, item := range []*Item{
+		{Name: "camera", Category: "camera", SellerID: 1},
+		{Name: "tripod", Category: "camera", SellerID: 2},
+		{Name: "lens", Category: "camera", SellerID: 1},
+		{Name: "bag", Category: "camera", SellerID: 1},
+	} {
+		if err := repo.Insert(ctx, item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := NewMemoryLikeRepository(repo).Like(ctx, 3, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Delete(ctx, 4, 0); err != nil {
+		t.Fatal(err)
+	}
+	h := &Handlers{itemRepo: repo, adminUserIDs: map[int]bool{9: true}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}/items", h.GetUserItems)
+
+	cases := map[string]struct {
+		path   string
+		userID int
+		status int
+		want   []string
+	}{
+		"ok: seller's items":        {"/users/1/items", 0, http.StatusOK, []string{"camera", "lens"}},
+		"ok: popular first":         {"/users/1/items?sort=popular", 0, http.StatusOK, []string{"lens", "camera"}},
+		"ok: admin sees deleted":    {"/users/1/items?include_deleted=true", 9, http.StatusOK, []string{"camera", "lens", "bag"}},
+		"ok: no items":              {"/users/3/items", 0, http.StatusOK, []string{}},
+		"ng: invalid sort":          {"/users/1/items?sort=price", 0, http.StatusBadRequest, nil},
+		"ng: deleted for non-admin": {"/users/1/items?include_deleted=true", 1, http.StatusForbidden, nil},
+		"ng: invalid id":            {"/users/abc/items", 0, http.StatusBadRequest, nil},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.userID != 0 {
+				req = req.WithContext(ContextWithUserID(req.Context(), tc.userID))
+			}
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+			if rr.Code != tc.status {
+				t.Fatalf("expected status %d, got %d: %s", tc.status, rr.Code, rr.Body)
+			}
+			if tc.want == nil {
+				return
+			}
+			var resp struct {
+				Items []*Item `json:"items"`
+			}
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			names := []string{}
+			for _, item := range resp.Items {
+				names = append(names, item.Name)
+			}
+			if diff := cmp.Diff(tc.want, names); diff != "" {
+				t.Errorf("unexpected items (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package app_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"mercari-build-training/app"
+	"mercari-build-training/app/repotest"
+)
+
+// openRepositories opens the database at dsn for the contract tests.
+func openRepositories(t *testing.T, dsn string) repotest.Repositories {
+	t.Helper()
+
+	db, repo, err := app.OpenDatabase(dsn)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return repotest.Repositories{
+		Items:    repo,
+		Comments: app.NewCommentRepository(db, dsn),
+		Likes:    app.NewLikeRepository(db, dsn),
+		Auctions: app.NewAuctionRepository(db, dsn),
+		Offers:   app.NewOfferRepository(db, dsn),
+		Reviews:  app.NewReviewRepository(db, dsn),
+		Profiles: app.NewProfileRepository(db, dsn),
+	}
+}
+
+// memoryRepositories returns repositories sharing an empty memory store.
+func memoryRepositories() repotest.Repositories {
+	repo := app.NewMemoryItemRepository()
+	return repotest.Repositories{
+		Items:    repo,
+		Comments: app.NewMemoryCommentRepository(repo),
+		Likes:    app.NewMemoryLikeRepository(repo),
+		Auctions: app.NewMemoryAuctionRepository(repo),
+		Offers:   app.NewMemoryOfferRepository(repo),
+		Reviews:  app.NewMemoryReviewRepository(repo),
+		Profiles: app.NewMemoryProfileRepository(repo),
+	}
+}
+
+func TestSQLiteItemRepository(t *testing.T) {
+	t.Parallel()
+
+	repotest.Run(t, func(t *testing.T) repotest.Repositories {
+		// 並行書き込みのテストは OpenDatabase が設定する busy_timeout に頼っている
+		return openRepositories(t, filepath.Join(t.TempDir(), "test.sqlite3"))
+	})
+}
+
+func TestMemoryItemRepository(t *testing.T) {
+	t.Parallel()
+
+	repotest.Run(t, func(t *testing.T) repotest.Repositories {
+		return memoryRepositories()
+	})
+}
+
+func TestPostgresItemRepository(t *testing.T) {
+	t.Parallel()
+
+	dsn := app.PostgresTestDSN(t)
+	repotest.Run(t, func(t *testing.T) repotest.Repositories {
+		return openRepositories(t, app.NewPostgresTestSchema(t, dsn))
+	})
+}
+
+func TestCachedMemoryItemRepository(t *testing.T) {
+	t.Parallel()
+
+	// キャッシュを挟んでも同じ振る舞いになることを確認する
+	// 他のリポジトリはキャッシュを通らないので、商品のテストだけを実行する
+	repotest.Run(t, func(t *testing.T) repotest.Repositories {
+		return repotest.Repositories{Items: app.NewCachingItemRepository(app.NewMemoryItemRepository(), app.CacheOptions{})}
+	})
+}
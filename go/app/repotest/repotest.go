@@ -0,0 +1,1580 @@
+// Package repotest is a contract test suite for app.ItemRepository implementations and the
+// repositories sharing their store.
+//
+// A backend proves it behaves like the SQLite repositories by running:
+//
+//	func TestMyItemRepository(t *testing.T) {
+//		repotest.Run(t, func(t *testing.T) repotest.Repositories {
+//			return newMyRepositories(t)
+//		})
+//	}
+package repotest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"mercari-build-training/app"
+)
+
+// Repositories are the repositories of one store. The others share the items of Items.
+// Tests of a nil repository are skipped, as when Items is wrapped in a cache the others bypass.
+type Repositories struct {
+	Items    app.ItemRepository
+	Comments app.CommentRepository
+	Likes    app.LikeRepository
+	Auctions app.AuctionRepository
+	Offers   app.OfferRepository
+	Reviews  app.ReviewRepository
+	Profiles app.ProfileRepository
+}
+
+// Factory returns empty repositories. It is called once per subtest, possibly in parallel,
+// and should register any cleanup with t.Cleanup.
+type Factory func(t *testing.T) Repositories
+
+// concurrency is the number of goroutines used by the concurrency tests.
+const concurrency = 16
+
+// Run checks that the repositories returned by newRepos follow the ItemRepository contract
+// and those of the repositories sharing its store.
+func Run(t *testing.T, newRepos Factory) {
+	t.Helper()
+
+	itemTests := map[string]func(t *testing.T, repo app.ItemRepository){
+		"insert and select":           testInsertAndSelect,
+		"not found":                   testNotFound,
+		"insert creates category":     testInsertCreatesCategory,
+		"categories":                  testCategories,
+		"list":                        testList,
+		"search":                      testSearch,
+		"update":                      testUpdate,
+		"image references":            testImageReferences,
+		"image hashes":                testImageHashes,
+		"find":                        testFind,
+		"soft delete and restore":     testSoftDelete,
+		"conditional delete":          testConditionalDelete,
+		"purge":                       testPurge,
+		"audit log":                   testAuditLog,
+		"outbox":                      testOutbox,
+		"concurrent inserts":          testConcurrentInserts,
+		"concurrent updates":          testConcurrentUpdates,
+		"context cancellation":        testContextCancellation,
+		"returned items are not live": testReturnedItemsAreCopies,
+	}
+	for name, test := range itemTests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			test(t, newRepos(t).Items)
+		})
+	}
+
+	tests := map[string]func(t *testing.T, r Repositories){
+		"comments":         testComments,
+		"likes":            testLikes,
+		"concurrent likes": testConcurrentLikes,
+		"auctions":         testAuctions,
+		"offers":           testOffers,
+		"reviews":          testReviews,
+		"profiles":         testProfiles,
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			test(t, newRepos(t))
+		})
+	}
+}
+
+var ignoreTimestamps = cmpopts.IgnoreFields(app.Item{}, "UpdatedAt")
+
+func mustInsert(t *testing.T, repo app.ItemRepository, item *app.Item) *app.Item {
+	t.Helper()
+	if err := repo.Insert(context.Background(), item); err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+	return item
+}
+
+func names(items []*app.Item) []string {
+	names := []string{}
+	for _, item := range items {
+		names = append(names, item.Name)
+	}
+	return names
+}
+
+func testInsertAndSelect(t *testing.T, repo app.ItemRepository) {
+	ctx := context.Background()
+
+	item := mustInsert(t, repo, &app.Item{Name: "jacket", Category: "fashion", ImageName: "a.jpg", SellerID: 3, ImageHash: 1 << 63, Price: 4800})
+	if item.ID == 0 {
+		t.Errorf("expected Insert to set the ID")
+	}
+	if item.Version != 1 {
+		t.Errorf("expected version 1, got %d", item.Version)
+	}
+	if item.UpdatedAt.IsZero() {
+		t.Errorf("expected Insert to set updated_at")
+	}
+
+	second := mustInsert(t, repo, &app.Item{Name: "coat", Category: "fashion"})
+	if second.ID <= item.ID {
+		t.Errorf("expected increasing IDs, got %d after %d", second.ID, item.ID)
+	}
+
+	got, err := repo.Select(ctx, item.ID)
+	if err != nil {
+		t.Fatalf("failed to select item: %v", err)
+	}
+	want := &app.Item{ID: item.ID, Name: "jacket", Category: "fashion", CategoryID: item.CategoryID, ImageName: "a.jpg", SellerID: 3, ImageHash: 1 << 63, Version: 1, Price: 4800, ListingType: app.ListingFixed}
+	if diff := cmp.Diff(want, got, ignoreTimestamps); diff != "" {
+		t.Errorf("unexpected item (-want +got):\n%s", diff)
+	}
+	if got.UpdatedAt.IsZero() {
+		t.Errorf("expected updated_at to be stored")
+	}
+}
+
+func testNotFound(t *testing.T, repo app.ItemRepository) {
+	ctx := context.Background()
+
+	if _, err := repo.Select(ctx, 404); !errors.Is(err, app.ErrItemNotFound) {
+		t.Errorf("Select: expected ErrItemNotFound, got %v", err)
+	}
+	if err := repo.Update(ctx, &app.Item{ID: 404, Name: "x", Category: "c"}, 0); !errors.Is(err, app.ErrItemNotFound) {
+		t.Errorf("Update: expected ErrItemNotFound, got %v", err)
+	}
+	if _, err := repo.GetCategoryByName(ctx, "missing"); !errors.Is(err, app.ErrCategoryNotFound) {
+		t.Errorf("GetCategoryByName: expected ErrCategoryNotFound, got %v", err)
+	}
+}
+
+func testInsertCreatesCategory(t *testing.T, repo app.ItemRepository) {
+	ctx := context.Background()
+
+	first := mustInsert(t, repo, &app.Item{Name: "jacket", Category: "fashion"})
+	second := mustInsert(t, repo, &app.Item{Name: "coat", Category: "fashion"})
+	if first.CategoryID == 0 || first.CategoryID != second.CategoryID {
+		t.Errorf("expected both items in the same category, got %d and %d", first.CategoryID, second.CategoryID)
+	}
+
+	category, err := repo.GetCategoryByName(ctx, "fashion")
+	if err != nil {
+		t.Fatalf("expected Insert to create the category: %v", err)
+	}
+	if category.ID != first.CategoryID {
+		t.Errorf("expected category ID %d, got %d", first.CategoryID, category.ID)
+	}
+
+	categories, err := repo.GetCategories(ctx)
+	if err != nil {
+		t.Fatalf("failed to get categories: %v", err)
+	}
+	if diff := cmp.Diff([]app.Category{*category}, categories); diff != "" {
+		t.Errorf("unexpected categories (-want +got):\n%s", diff)
+	}
+}
+
+func testCategories(t *testing.T, repo app.ItemRepository) {
+	ctx := context.Background()
+
+	created, err := repo.InsertCategory(ctx, "phone")
+	if err != nil {
+		t.Fatalf("failed to insert category: %v", err)
+	}
+	if created.ID == 0 || created.Name != "phone" {
+		t.Errorf("unexpected category: %+v", created)
+	}
+	got, err := repo.GetCategoryByName(ctx, "phone")
+	if err != nil {
+		t.Fatalf("failed to get category: %v", err)
+	}
+	if diff := cmp.Diff(created, got); diff != "" {
+		t.Errorf("unexpected category (-want +got):\n%s", diff)
+	}
+
+	// 既存のカテゴリを Insert で使っても増えない
+	item := mustInsert(t, repo, &app.Item{Name: "iPhone", Category: "phone"})
+	if item.CategoryID != created.ID {
+		t.Errorf("expected category ID %d, got %d", created.ID, item.CategoryID)
+	}
+
+	// カテゴリ名は大文字小文字を区別する
+	if _, err := repo.GetCategoryByName(ctx, "Phone"); !errors.Is(err, app.ErrCategoryNotFound) {
+		t.Errorf("expected ErrCategoryNotFound for a different case, got %v", err)
+	}
+	if _, err := repo.InsertCategory(ctx, "phone"); err == nil {
+		t.Errorf("expected an error when inserting a duplicate category")
+	}
+}
+
+func testList(t *testing.T, repo app.ItemRepository) {
+	ctx := context.Background()
+
+	items, err := repo.List(ctx)
+	if err != nil {
+		t.Fatalf("failed to list items: %v", err)
+	}
+	if len(items) != 0 {
+		t.Errorf("expected no items, got %d", len(items))
+	}
+
+	for _, name := range []string{"b", "a", "c"} {
+		mustInsert(t, repo, &app.Item{Name: name, Category: "x"})
+	}
+	items, err = repo.List(ctx)
+	if err != nil {
+		t.Fatalf("failed to list items: %v", err)
+	}
+	// 追加した順 (ID 順) に返す
+	if diff := cmp.Diff([]string{"b", "a", "c"}, names(items)); diff != "" {
+		t.Errorf("unexpected items (-want +got):\n%s", diff)
+	}
+	for _, item := range items {
+		if item.Category != "x" {
+			t.Errorf("expected List to join the category name, got %q", item.Category)
+		}
+	}
+}
+
+func testSearch(t *testing.T, repo app.ItemRepository) {
+	ctx := context.Background()
+
+	for _, item := range []*app.Item{
+		{Name: "used iPhone", Category: "phone"},
+		{Name: "MacBook Pro", Category: "laptop"},
+		{Name: "iphone case", Category: "accessory"},
+		{Name: "100% cotton shirt", Category: "fashion"},
+		{Name: "1000 piece puzzle", Category: "toy"},
+		{Name: "snake_case mug", Category: "kitchen"},
+		{Name: "snakeXcase mug", Category: "kitchen"},
+	} {
+		mustInsert(t, repo, item)
+	}
+
+	cases := map[string]struct {
+		keyword string
+		want    []string
+	}{
+		"substring":                {"Phon", []string{"used iPhone", "iphone case"}},
+		"case insensitive":         {"IPHONE", []string{"used iPhone", "iphone case"}},
+		"prefix":                   {"Mac", []string{"MacBook Pro"}},
+		"category is not searched": {"laptop", []string{}},
+		"no match":                 {"android", []string{}},
+		"percent is literal":       {"100%", []string{"100% cotton shirt"}},
+		"underscore is literal":    {"snake_case", []string{"snake_case mug"}},
+	}
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			items, err := repo.Search(ctx, tt.keyword)
+			if err != nil {
+				t.Fatalf("failed to search items: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, names(items)); diff != "" {
+				t.Errorf("unexpected result for %q (-want +got):\n%s", tt.keyword, diff)
+			}
+		})
+	}
+}
+
+func testUpdate(t *testing.T, repo app.ItemRepository) {
+	ctx := context.Background()
+
+	item := mustInsert(t, repo, &app.Item{Name: "jacket", Category: "fashion", ImageName: "a.jpg"})
+
+	item.Name, item.Category = "coat", "outer"
+	if err := repo.Update(ctx, item, 1); err != nil {
+		t.Fatalf("failed to update item: %v", err)
+	}
+	if item.Version != 2 {
+		t.Errorf("expected version 2, got %d", item.Version)
+	}
+	got, err := repo.Select(ctx, item.ID)
+	if err != nil {
+		t.Fatalf("failed to select item: %v", err)
+	}
+	if diff := cmp.Diff(item, got, ignoreTimestamps); diff != "" {
+		t.Errorf("unexpected item after update (-want +got):\n%s", diff)
+	}
+	if _, err := repo.GetCategoryByName(ctx, "outer"); err != nil {
+		t.Errorf("expected Update to create the category: %v", err)
+	}
+
+	stale := &app.Item{ID: item.ID, Name: "parka", Category: "outer"}
+	if err := repo.Update(ctx, stale, 1); !errors.Is(err, app.ErrVersionConflict) {
+		t.Errorf("expected ErrVersionConflict, got %v", err)
+	}
+	// version 0 は無条件に更新する
+	if err := repo.Update(ctx, stale, 0); err != nil {
+		t.Errorf("unexpected error for an unconditional update: %v", err)
+	}
+	if stale.Version != 3 {
+		t.Errorf("expected version 3, got %d", stale.Version)
+	}
+}
+
+func testImageReferences(t *testing.T, repo app.ItemRepository) {
+	ctx := context.Background()
+
+	for _, image := range []string{"a.jpg", "a.jpg", "b.jpg"} {
+		mustInsert(t, repo, &app.Item{Name: "x", Category: "c", ImageName: image})
+	}
+	refs, err := repo.ImageReferences(ctx)
+	if err != nil {
+		t.Fatalf("failed to count references: %v", err)
+	}
+	if diff := cmp.Diff(map[string]int{"a.jpg": 2, "b.jpg": 1}, refs); diff != "" {
+		t.Errorf("unexpected references (-want +got):\n%s", diff)
+	}
+}
+
+func testImageHashes(t *testing.T, repo app.ItemRepository) {
+	ctx := context.Background()
+
+	photo := mustInsert(t, repo, &app.Item{Name: "camera", Category: "c", SellerID: 7, ImageHash: 1 << 63})
+	mustInsert(t, repo, &app.Item{Name: "no hash", Category: "c", SellerID: 7})
+	anonymous := mustInsert(t, repo, &app.Item{Name: "lens", Category: "c", ImageHash: 0xff})
+	deleted := mustInsert(t, repo, &app.Item{Name: "tripod", Category: "c", SellerID: 8, ImageHash: 0xf0})
+	if err := repo.Delete(ctx, deleted.ID, 0); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+
+	hashes, err := repo.ImageHashes(ctx)
+	if err != nil {
+		t.Fatalf("failed to list image hashes: %v", err)
+	}
+	want := []app.ImageHash{
+		{ItemID: photo.ID, SellerID: 7, Hash: 1 << 63},
+		{ItemID: anonymous.ID, Hash: 0xff},
+	}
+	if diff := cmp.Diff(want, hashes); diff != "" {
+		t.Errorf("unexpected image hashes (-want +got):\n%s", diff)
+	}
+}
+
+func testFind(t *testing.T, repo app.ItemRepository) {
+	ctx := context.Background()
+
+	jacket := mustInsert(t, repo, &app.Item{Name: "jacket", Category: "fashion"})
+	mustInsert(t, repo, &app.Item{Name: "Down Jacket", Category: "fashion", SellerID: 7})
+	mustInsert(t, repo, &app.Item{Name: "camera", Category: "electronics", SellerID: 7})
+
+	tests := map[string]struct {
+		q    app.ItemQuery
+		want []string
+	}{
+		"all":             {q: app.ItemQuery{}, want: []string{"jacket", "Down Jacket", "camera"}},
+		"by id":           {q: app.ItemQuery{ID: jacket.ID}, want: []string{"jacket"}},
+		"by keyword":      {q: app.ItemQuery{Keyword: "jacket"}, want: []string{"jacket", "Down Jacket"}},
+		"by id and word":  {q: app.ItemQuery{ID: jacket.ID, Keyword: "camera"}, want: []string{}},
+		"missing id":      {q: app.ItemQuery{ID: 9999}, want: []string{}},
+		"by seller":       {q: app.ItemQuery{SellerID: 7}, want: []string{"Down Jacket", "camera"}},
+		"by seller, word": {q: app.ItemQuery{SellerID: 7, Keyword: "camera"}, want: []string{"camera"}},
+		"include deleted": {q: app.ItemQuery{IncludeDeleted: true}, want: []string{"jacket", "Down Jacket", "camera"}},
+	}
+	for name, tt := range tests {
+		got, err := repo.Find(ctx, tt.q)
+		if err != nil {
+			t.Fatalf("%s: failed to find items: %v", name, err)
+		}
+		if diff := cmp.Diff(tt.want, names(got)); diff != "" {
+			t.Errorf("%s: unexpected items (-want +got):\n%s", name, diff)
+		}
+	}
+}
+
+func testConditionalDelete(t *testing.T, repo app.ItemRepository) {
+	ctx := context.Background()
+
+	item := mustInsert(t, repo, &app.Item{Name: "jacket", Category: "fashion"})
+	if err := repo.Delete(ctx, item.ID, item.Version+1); !errors.Is(err, app.ErrVersionConflict) {
+		t.Errorf("expected ErrVersionConflict when deleting another version, got %v", err)
+	}
+	if err := repo.Delete(ctx, item.ID, item.Version); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+	if err := repo.Restore(ctx, item.ID, item.Version); !errors.Is(err, app.ErrVersionConflict) {
+		t.Errorf("expected ErrVersionConflict when restoring the version before deletion, got %v", err)
+	}
+	if err := repo.Restore(ctx, item.ID, item.Version+1); err != nil {
+		t.Fatalf("failed to restore item: %v", err)
+	}
+	restored, err := repo.Select(ctx, item.ID)
+	if err != nil {
+		t.Fatalf("failed to select item: %v", err)
+	}
+	if restored.Version != item.Version+2 {
+		t.Errorf("expected version %d, got %d", item.Version+2, restored.Version)
+	}
+}
+
+func testSoftDelete(t *testing.T, repo app.ItemRepository) {
+	ctx := context.Background()
+
+	kept := mustInsert(t, repo, &app.Item{Name: "jacket", Category: "fashion", ImageName: "a.jpg"})
+	deleted := mustInsert(t, repo, &app.Item{Name: "jacket 2", Category: "fashion", ImageName: "b.jpg"})
+
+	if err := repo.Delete(ctx, deleted.ID, 0); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+	if err := repo.Delete(ctx, deleted.ID, 0); !errors.Is(err, app.ErrItemNotFound) {
+		t.Errorf("expected ErrItemNotFound when deleting twice, got %v", err)
+	}
+	if err := repo.Delete(ctx, 9999, 0); !errors.Is(err, app.ErrItemNotFound) {
+		t.Errorf("expected ErrItemNotFound for a missing item, got %v", err)
+	}
+
+	// 削除した商品は通常の読み取りには出てこない
+	if _, err := repo.Select(ctx, deleted.ID); !errors.Is(err, app.ErrItemNotFound) {
+		t.Errorf("expected ErrItemNotFound from Select, got %v", err)
+	}
+	list, err := repo.List(ctx)
+	if err != nil {
+		t.Fatalf("failed to list items: %v", err)
+	}
+	if diff := cmp.Diff([]string{"jacket"}, names(list)); diff != "" {
+		t.Errorf("unexpected items from List (-want +got):\n%s", diff)
+	}
+	found, err := repo.Search(ctx, "jacket")
+	if err != nil {
+		t.Fatalf("failed to search items: %v", err)
+	}
+	if diff := cmp.Diff([]string{"jacket"}, names(found)); diff != "" {
+		t.Errorf("unexpected items from Search (-want +got):\n%s", diff)
+	}
+	if err := repo.Update(ctx, &app.Item{ID: deleted.ID, Name: "x", Category: "fashion"}, 0); !errors.Is(err, app.ErrItemNotFound) {
+		t.Errorf("expected ErrItemNotFound from Update, got %v", err)
+	}
+
+	all, err := repo.Find(ctx, app.ItemQuery{IncludeDeleted: true})
+	if err != nil {
+		t.Fatalf("failed to find items: %v", err)
+	}
+	if diff := cmp.Diff([]string{"jacket", "jacket 2"}, names(all)); diff != "" {
+		t.Errorf("unexpected items including deleted (-want +got):\n%s", diff)
+	}
+	if all[0].DeletedAt != nil || all[1].DeletedAt == nil {
+		t.Errorf("expected only the deleted item to have DeletedAt, got %v and %v", all[0].DeletedAt, all[1].DeletedAt)
+	}
+	// 復元できるように画像の参照は残す
+	refs, err := repo.ImageReferences(ctx)
+	if err != nil {
+		t.Fatalf("failed to count image references: %v", err)
+	}
+	if refs["b.jpg"] != 1 {
+		t.Errorf("expected deleted items to keep their image referenced, got %v", refs)
+	}
+
+	if err := repo.Restore(ctx, deleted.ID, 0); err != nil {
+		t.Fatalf("failed to restore item: %v", err)
+	}
+	if err := repo.Restore(ctx, kept.ID, 0); !errors.Is(err, app.ErrItemNotFound) {
+		t.Errorf("expected ErrItemNotFound when restoring an item not in the trash, got %v", err)
+	}
+	restored, err := repo.Select(ctx, deleted.ID)
+	if err != nil {
+		t.Fatalf("failed to select restored item: %v", err)
+	}
+	if restored.DeletedAt != nil {
+		t.Errorf("expected DeletedAt to be cleared, got %v", restored.DeletedAt)
+	}
+	// 削除と復元で ETag が変わるように version を上げる
+	if restored.Version != 3 {
+		t.Errorf("expected version 3 after delete and restore, got %d", restored.Version)
+	}
+}
+
+func testPurge(t *testing.T, repo app.ItemRepository) {
+	ctx := context.Background()
+
+	kept := mustInsert(t, repo, &app.Item{Name: "jacket", Category: "fashion"})
+	recent := mustInsert(t, repo, &app.Item{Name: "camera", Category: "electronics"})
+	if err := repo.Delete(ctx, recent.ID, 0); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+
+	purged, err := repo.Purge(ctx, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("failed to purge items: %v", err)
+	}
+	if len(purged) != 0 {
+		t.Errorf("expected items deleted within the retention to be kept, got %v", names(purged))
+	}
+
+	purged, err = repo.Purge(ctx, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("failed to purge items: %v", err)
+	}
+	if diff := cmp.Diff([]string{"camera"}, names(purged)); diff != "" {
+		t.Errorf("unexpected purged items (-want +got):\n%s", diff)
+	}
+	all, err := repo.Find(ctx, app.ItemQuery{IncludeDeleted: true})
+	if err != nil {
+		t.Fatalf("failed to find items: %v", err)
+	}
+	if diff := cmp.Diff([]string{kept.Name}, names(all)); diff != "" {
+		t.Errorf("unexpected items after purge (-want +got):\n%s", diff)
+	}
+	if err := repo.Restore(ctx, recent.ID, 0); !errors.Is(err, app.ErrItemNotFound) {
+		t.Errorf("expected purged items not to be restorable, got %v", err)
+	}
+}
+
+func testAuditLog(t *testing.T, repo app.ItemRepository) {
+	ctx := app.ContextWithRequestID(app.ContextWithUserID(context.Background(), 7), "req-1")
+
+	item := mustInsert(t, repo, &app.Item{Name: "jacket", Category: "fashion"})
+	if _, err := repo.InsertCategory(context.Background(), "toys"); err != nil {
+		t.Fatalf("failed to insert category: %v", err)
+	}
+	item.Name = "coat"
+	if err := repo.Update(ctx, item, 0); err != nil {
+		t.Fatalf("failed to update item: %v", err)
+	}
+	if err := repo.Delete(ctx, item.ID, 0); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+	// 失敗した書き込みは記録しない
+	if err := repo.Delete(ctx, item.ID, 0); !errors.Is(err, app.ErrItemNotFound) {
+		t.Fatalf("expected ErrItemNotFound, got %v", err)
+	}
+
+	type entry struct {
+		ActorID        int
+		Action, Entity string
+		EntityID       int
+		RequestID      string
+		HasBefore      bool
+		HasAfter       bool
+		ChangedName    bool
+	}
+	summarize := func(entries []*app.AuditEntry) []entry {
+		got := []entry{}
+		for _, e := range entries {
+			_, changedName := e.Diff["name"]
+			got = append(got, entry{e.ActorID, e.Action, e.Entity, e.EntityID, e.RequestID, len(e.Before) > 0, len(e.After) > 0, changedName})
+		}
+		return got
+	}
+
+	all, err := repo.ListAudit(ctx, app.AuditQuery{})
+	if err != nil {
+		t.Fatalf("failed to list audit log: %v", err)
+	}
+	toys, err := repo.GetCategoryByName(ctx, "toys")
+	if err != nil {
+		t.Fatalf("failed to get category: %v", err)
+	}
+	want := []entry{
+		{7, app.AuditDelete, app.AuditEntityItem, item.ID, "req-1", true, true, false},
+		{7, app.AuditUpdate, app.AuditEntityItem, item.ID, "req-1", true, true, true},
+		{0, app.AuditCreate, app.AuditEntityCategory, toys.ID, "", false, true, true},
+		{0, app.AuditCreate, app.AuditEntityItem, item.ID, "", false, true, true},
+		{0, app.AuditCreate, app.AuditEntityCategory, item.CategoryID, "", false, true, true},
+	}
+	if diff := cmp.Diff(want, summarize(all)); diff != "" {
+		t.Fatalf("unexpected audit log (-want +got):\n%s", diff)
+	}
+
+	var before, after string
+	if err := json.Unmarshal(all[1].Diff["name"].Before, &before); err != nil {
+		t.Fatalf("failed to decode diff: %v", err)
+	}
+	if err := json.Unmarshal(all[1].Diff["name"].After, &after); err != nil {
+		t.Fatalf("failed to decode diff: %v", err)
+	}
+	if before != "jacket" || after != "coat" {
+		t.Errorf("expected name to change from jacket to coat, got %q to %q", before, after)
+	}
+
+	filters := map[string]struct {
+		q    app.AuditQuery
+		want []entry
+	}{
+		"actor":  {q: app.AuditQuery{ActorID: 7}, want: want[:2]},
+		"action": {q: app.AuditQuery{Action: app.AuditCreate, Entity: app.AuditEntityCategory}, want: []entry{want[2], want[4]}},
+		"entity": {q: app.AuditQuery{Entity: app.AuditEntityItem, EntityID: item.ID}, want: []entry{want[0], want[1], want[3]}},
+		"limit":  {q: app.AuditQuery{Limit: 2}, want: want[:2]},
+		"paging": {q: app.AuditQuery{BeforeID: all[1].ID, Limit: 2}, want: want[2:4]},
+		"since":  {q: app.AuditQuery{Since: time.Now().Add(time.Hour)}, want: []entry{}},
+		"until":  {q: app.AuditQuery{Until: time.Now().Add(time.Hour)}, want: want},
+	}
+	for name, tt := range filters {
+		got, err := repo.ListAudit(ctx, tt.q)
+		if err != nil {
+			t.Fatalf("%s: failed to list audit log: %v", name, err)
+		}
+		if diff := cmp.Diff(tt.want, summarize(got)); diff != "" {
+			t.Errorf("%s: unexpected audit log (-want +got):\n%s", name, diff)
+		}
+	}
+}
+
+func testOutbox(t *testing.T, repo app.ItemRepository) {
+	ctx := context.Background()
+
+	item := mustInsert(t, repo, &app.Item{Name: "jacket", Category: "fashion"})
+	item.Name = "coat"
+	if err := repo.Update(ctx, item, 0); err != nil {
+		t.Fatalf("failed to update item: %v", err)
+	}
+	// 失敗した書き込みはイベントを出さない
+	if err := repo.Update(ctx, &app.Item{ID: item.ID + 100, Name: "ghost", Category: "fashion"}, 0); err == nil {
+		t.Fatal("expected updating a missing item to fail")
+	}
+
+	types := func(events []*app.Event) []string {
+		got := []string{}
+		for _, e := range events {
+			got = append(got, e.Type)
+		}
+		return got
+	}
+	now := time.Now()
+	events, err := repo.PendingEvents(ctx, now, 10)
+	if err != nil {
+		t.Fatalf("failed to get pending events: %v", err)
+	}
+	want := []string{app.EventCategoryCreated, app.EventItemCreated, app.EventItemUpdated}
+	if diff := cmp.Diff(want, types(events)); diff != "" {
+		t.Fatalf("unexpected events (-want +got):\n%s", diff)
+	}
+	var payload app.Item
+	if err := json.Unmarshal(events[2].Payload, &payload); err != nil {
+		t.Fatalf("failed to decode payload: %v", err)
+	}
+	if payload.ID != item.ID || payload.Name != "coat" {
+		t.Errorf("expected the payload to be the updated item, got %+v", payload)
+	}
+
+	// 失敗したイベントは next まで配信対象にならない
+	if err := repo.MarkEventDelivered(ctx, events[0].ID); err != nil {
+		t.Fatalf("failed to mark event delivered: %v", err)
+	}
+	if err := repo.MarkEventFailed(ctx, events[1].ID, now.Add(time.Minute), "unavailable"); err != nil {
+		t.Fatalf("failed to mark event failed: %v", err)
+	}
+	events, err = repo.PendingEvents(ctx, now, 10)
+	if err != nil {
+		t.Fatalf("failed to get pending events: %v", err)
+	}
+	if diff := cmp.Diff([]string{app.EventItemUpdated}, types(events)); diff != "" {
+		t.Errorf("unexpected events after delivery (-want +got):\n%s", diff)
+	}
+	events, err = repo.PendingEvents(ctx, now.Add(time.Minute), 1)
+	if err != nil {
+		t.Fatalf("failed to get pending events: %v", err)
+	}
+	if len(events) != 1 || events[0].Type != app.EventItemCreated || events[0].Attempts != 1 {
+		t.Errorf("expected the failed event to be due again with 1 attempt, got %+v", events)
+	}
+
+	// 配信済みかどうかに関わらず ID 順に読める
+	all, err := repo.EventsAfter(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("failed to get events: %v", err)
+	}
+	if diff := cmp.Diff(want, types(all)); diff != "" {
+		t.Errorf("unexpected events (-want +got):\n%s", diff)
+	}
+	events, err = repo.EventsAfter(ctx, all[0].ID, 1)
+	if err != nil {
+		t.Fatalf("failed to get events: %v", err)
+	}
+	if len(events) != 1 || events[0].ID != all[1].ID {
+		t.Errorf("expected the event after %d, got %+v", all[0].ID, events)
+	}
+
+	n, err := repo.PruneEvents(ctx, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("failed to prune events: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 pruned event, got %d", n)
+	}
+}
+
+func testComments(t *testing.T, r Repositories) {
+	if r.Comments == nil {
+		t.Skip("no CommentRepository")
+	}
+	ctx := context.Background()
+	repo, comments := r.Items, r.Comments
+
+	item := mustInsert(t, repo, &app.Item{Name: "jacket", Category: "fashion"})
+	other := mustInsert(t, repo, &app.Item{Name: "coat", Category: "fashion"})
+	var added []*app.Comment
+	for i, userID := range []int{1, 2, 1} {
+		c := &app.Comment{ItemID: item.ID, UserID: userID, Body: fmt.Sprintf("question %d", i)}
+		if err := comments.AddComment(ctx, c); err != nil {
+			t.Fatalf("failed to add comment: %v", err)
+		}
+		added = append(added, c)
+	}
+	if added[0].ID == 0 || added[0].CreatedAt.IsZero() {
+		t.Errorf("expected AddComment to set ID and CreatedAt, got %+v", added[0])
+	}
+	if err := comments.AddComment(ctx, &app.Comment{ItemID: 9999, UserID: 1, Body: "hello"}); !errors.Is(err, app.ErrItemNotFound) {
+		t.Errorf("expected ErrItemNotFound for a missing item, got %v", err)
+	}
+
+	// コメント数は Select・List・Search の結果に含まれる
+	got, err := repo.Select(ctx, item.ID)
+	if err != nil {
+		t.Fatalf("failed to select item: %v", err)
+	}
+	if got.CommentCount != 3 {
+		t.Errorf("expected 3 comments from Select, got %d", got.CommentCount)
+	}
+	list, err := repo.List(ctx)
+	if err != nil {
+		t.Fatalf("failed to list items: %v", err)
+	}
+	if len(list) != 2 || list[0].CommentCount != 3 || list[1].CommentCount != 0 {
+		t.Errorf("unexpected comment counts from List: %+v", list)
+	}
+	found, err := repo.Search(ctx, "jacket")
+	if err != nil {
+		t.Fatalf("failed to search items: %v", err)
+	}
+	if len(found) != 1 || found[0].CommentCount != 3 {
+		t.Errorf("unexpected comment counts from Search: %+v", found)
+	}
+
+	page, err := comments.ListComments(ctx, item.ID, 0, 2)
+	if err != nil {
+		t.Fatalf("failed to list comments: %v", err)
+	}
+	if len(page) != 2 || page[0].ID != added[0].ID || page[1].ID != added[1].ID {
+		t.Fatalf("expected the oldest two comments, got %+v", page)
+	}
+	page, err = comments.ListComments(ctx, item.ID, page[1].ID, 2)
+	if err != nil {
+		t.Fatalf("failed to list comments: %v", err)
+	}
+	if len(page) != 1 || page[0].Body != "question 2" {
+		t.Errorf("expected the last comment, got %+v", page)
+	}
+	if page, _ := comments.ListComments(ctx, other.ID, 0, 10); len(page) != 0 {
+		t.Errorf("expected no comments on the other item, got %+v", page)
+	}
+
+	// 同じ人が何度通報しても 1 件
+	for _, reporter := range []int{3, 3, 4} {
+		if err := comments.ReportComment(ctx, &app.CommentReport{CommentID: added[1].ID, ReporterID: reporter, Reason: "spam"}); err != nil {
+			t.Fatalf("failed to report comment: %v", err)
+		}
+	}
+	if err := comments.ReportComment(ctx, &app.CommentReport{CommentID: 9999, ReporterID: 3}); !errors.Is(err, app.ErrCommentNotFound) {
+		t.Errorf("expected ErrCommentNotFound when reporting a missing comment, got %v", err)
+	}
+	reported, err := comments.GetComment(ctx, added[1].ID)
+	if err != nil {
+		t.Fatalf("failed to get comment: %v", err)
+	}
+	if reported.ReportCount != 2 || reported.UserID != 2 {
+		t.Errorf("expected a comment by user 2 with 2 reports, got %+v", reported)
+	}
+
+	if err := comments.DeleteComment(ctx, added[1].ID); err != nil {
+		t.Fatalf("failed to delete comment: %v", err)
+	}
+	if _, err := comments.GetComment(ctx, added[1].ID); !errors.Is(err, app.ErrCommentNotFound) {
+		t.Errorf("expected ErrCommentNotFound after deletion, got %v", err)
+	}
+	if err := comments.DeleteComment(ctx, added[1].ID); !errors.Is(err, app.ErrCommentNotFound) {
+		t.Errorf("expected ErrCommentNotFound when deleting twice, got %v", err)
+	}
+	if got, _ := repo.Select(ctx, item.ID); got.CommentCount != 2 {
+		t.Errorf("expected 2 comments after deletion, got %d", got.CommentCount)
+	}
+
+	entries, err := repo.ListAudit(ctx, app.AuditQuery{Entity: app.AuditEntityComment})
+	if err != nil {
+		t.Fatalf("failed to list audit log: %v", err)
+	}
+	if len(entries) != 4 || entries[0].Action != app.AuditDelete || entries[0].EntityID != added[1].ID {
+		t.Errorf("expected 3 creations and a deletion of comments in the audit log, got %+v", entries)
+	}
+
+	// 削除された商品にはコメントできない
+	if err := repo.Delete(ctx, other.ID, 0); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+	if err := comments.AddComment(ctx, &app.Comment{ItemID: other.ID, UserID: 1, Body: "hello"}); !errors.Is(err, app.ErrItemNotFound) {
+		t.Errorf("expected ErrItemNotFound for a deleted item, got %v", err)
+	}
+}
+
+func testLikes(t *testing.T, r Repositories) {
+	if r.Likes == nil {
+		t.Skip("no LikeRepository")
+	}
+	ctx := context.Background()
+	repo, likes := r.Items, r.Likes
+
+	jacket := mustInsert(t, repo, &app.Item{Name: "jacket", Category: "fashion"})
+	coat := mustInsert(t, repo, &app.Item{Name: "coat", Category: "fashion"})
+
+	like := func(itemID, userID, want int) {
+		t.Helper()
+		n, err := likes.Like(ctx, itemID, userID)
+		if err != nil {
+			t.Fatalf("failed to like item: %v", err)
+		}
+		if n != want {
+			t.Errorf("expected %d likes, got %d", want, n)
+		}
+	}
+	like(coat.ID, 1, 1)
+	like(jacket.ID, 1, 1)
+	like(jacket.ID, 2, 2)
+	// 二度目のいいねは数えない
+	like(jacket.ID, 2, 2)
+	if _, err := likes.Like(ctx, 9999, 1); !errors.Is(err, app.ErrItemNotFound) {
+		t.Errorf("expected ErrItemNotFound for a missing item, got %v", err)
+	}
+
+	got, err := repo.Select(ctx, jacket.ID)
+	if err != nil {
+		t.Fatalf("failed to select item: %v", err)
+	}
+	if got.LikeCount != 2 || got.Version != 1 {
+		t.Errorf("expected 2 likes without a new version, got %+v", got)
+	}
+	list, err := repo.List(ctx)
+	if err != nil {
+		t.Fatalf("failed to list items: %v", err)
+	}
+	if len(list) != 2 || list[0].LikeCount != 2 || list[1].LikeCount != 1 {
+		t.Errorf("unexpected like counts from List: %+v", list)
+	}
+	found, err := repo.Search(ctx, "coat")
+	if err != nil {
+		t.Fatalf("failed to search items: %v", err)
+	}
+	if len(found) != 1 || found[0].LikeCount != 1 {
+		t.Errorf("unexpected like counts from Search: %+v", found)
+	}
+
+	liked, err := likes.LikedItems(ctx, 1)
+	if err != nil {
+		t.Fatalf("failed to get liked items: %v", err)
+	}
+	if diff := cmp.Diff([]string{"jacket", "coat"}, names(liked)); diff != "" {
+		t.Errorf("expected the most recently liked first (-want +got):\n%s", diff)
+	}
+
+	for _, want := range []int{1, 1} {
+		n, err := likes.Unlike(ctx, jacket.ID, 2)
+		if err != nil {
+			t.Fatalf("failed to unlike item: %v", err)
+		}
+		if n != want {
+			t.Errorf("expected %d likes after unliking, got %d", want, n)
+		}
+	}
+
+	// 削除された商品はいいね一覧に出ない
+	if err := repo.Delete(ctx, coat.ID, 0); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+	liked, err = likes.LikedItems(ctx, 1)
+	if err != nil {
+		t.Fatalf("failed to get liked items: %v", err)
+	}
+	if diff := cmp.Diff([]string{"jacket"}, names(liked)); diff != "" {
+		t.Errorf("unexpected liked items after deletion (-want +got):\n%s", diff)
+	}
+	if _, err := likes.Like(ctx, coat.ID, 2); !errors.Is(err, app.ErrItemNotFound) {
+		t.Errorf("expected ErrItemNotFound for a deleted item, got %v", err)
+	}
+
+	entries, err := repo.ListAudit(ctx, app.AuditQuery{Entity: app.AuditEntityLike})
+	if err != nil {
+		t.Fatalf("failed to list audit log: %v", err)
+	}
+	if len(entries) != 4 || entries[0].Action != app.AuditDelete {
+		t.Errorf("expected 3 likes and an unlike in the audit log, got %+v", entries)
+	}
+}
+
+func testAuctions(t *testing.T, r Repositories) {
+	if r.Auctions == nil {
+		t.Skip("no AuctionRepository")
+	}
+	ctx := context.Background()
+	repo, auctions := r.Items, r.Auctions
+	start := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	endsAt := start.Add(time.Hour)
+
+	item := mustInsert(t, repo, &app.Item{Name: "camera", Category: "camera", SellerID: 1, Price: 1000, ListingType: app.ListingAuction,
+		Auction: &app.Auction{StartPrice: 1000, MinIncrement: 100, EndsAt: endsAt}})
+	unsold := mustInsert(t, repo, &app.Item{Name: "lens", Category: "camera", SellerID: 1, Price: 500, ListingType: app.ListingAuction,
+		Auction: &app.Auction{StartPrice: 500, MinIncrement: 100, EndsAt: endsAt}})
+	fixed := mustInsert(t, repo, &app.Item{Name: "tripod", Category: "camera", SellerID: 1, Price: 800})
+	if _, err := auctions.GetAuction(ctx, fixed.ID); !errors.Is(err, app.ErrAuctionNotFound) {
+		t.Errorf("expected ErrAuctionNotFound for a fixed price item, got %v", err)
+	}
+
+	bid := func(bidderID, amount int, at time.Time) (*app.Auction, error) {
+		t.Helper()
+		return auctions.PlaceBid(ctx, &app.Bid{ItemID: item.ID, BidderID: bidderID, Amount: amount}, at)
+	}
+	if _, err := bid(2, 900, start); !errors.Is(err, app.ErrBidTooLow) {
+		t.Errorf("expected ErrBidTooLow below the start price, got %v", err)
+	}
+	if _, err := bid(2, 1000, start); err != nil {
+		t.Fatalf("failed to place bid: %v", err)
+	}
+	if _, err := bid(3, 1050, start.Add(time.Minute)); !errors.Is(err, app.ErrBidTooLow) {
+		t.Errorf("expected ErrBidTooLow below the minimum increment, got %v", err)
+	}
+	// 終了 1 分前の入札で終了時刻が延びる
+	a, err := bid(3, 1100, endsAt.Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("failed to place bid: %v", err)
+	}
+	extended := endsAt.Add(-time.Minute).Add(app.AuctionExtensionWindow)
+	if !a.EndsAt.Equal(extended) || a.HighestBid != 1100 || a.HighestBidderID != 3 || a.BidCount != 2 {
+		t.Errorf("unexpected auction after a late bid: %+v", a)
+	}
+	if _, err := bid(2, 1200, extended); !errors.Is(err, app.ErrAuctionEnded) {
+		t.Errorf("expected ErrAuctionEnded, got %v", err)
+	}
+	if _, err := auctions.PlaceBid(ctx, &app.Bid{ItemID: fixed.ID, BidderID: 2, Amount: 1000}, start); !errors.Is(err, app.ErrAuctionNotFound) {
+		t.Errorf("expected ErrAuctionNotFound for a fixed price item, got %v", err)
+	}
+
+	got, err := repo.Select(ctx, item.ID)
+	if err != nil {
+		t.Fatalf("failed to select item: %v", err)
+	}
+	if got.Price != 1100 || got.Version != 3 || got.ListingType != app.ListingAuction {
+		t.Errorf("expected the highest bid as the price with a new version per bid, got %+v", got)
+	}
+	bids, err := auctions.ListBids(ctx, item.ID, 10)
+	if err != nil {
+		t.Fatalf("failed to list bids: %v", err)
+	}
+	if len(bids) != 2 || bids[0].Amount != 1100 || bids[1].Amount != 1000 || bids[0].ID <= bids[1].ID {
+		t.Errorf("expected the bids newest first, got %+v", bids)
+	}
+
+	// 延長された終了時刻までは締めない
+	ended, err := auctions.EndedAuctions(ctx, endsAt, 10)
+	if err != nil {
+		t.Fatalf("failed to list ended auctions: %v", err)
+	}
+	if diff := cmp.Diff([]int{unsold.ID}, ended); diff != "" {
+		t.Errorf("unexpected ended auctions (-want +got):\n%s", diff)
+	}
+	if order, err := auctions.CloseAuction(ctx, item.ID, endsAt); err != nil || order != nil {
+		t.Errorf("expected a running auction not to close, got %+v, %v", order, err)
+	}
+	if order, err := auctions.CloseAuction(ctx, unsold.ID, endsAt); err != nil || order != nil {
+		t.Errorf("expected an auction without bids to close without an order, got %+v, %v", order, err)
+	}
+	order, err := auctions.CloseAuction(ctx, item.ID, extended)
+	if err != nil {
+		t.Fatalf("failed to close auction: %v", err)
+	}
+	want := &app.Order{ItemID: item.ID, SellerID: 1, BuyerID: 3, Price: 1100, Status: app.OrderPending}
+	if diff := cmp.Diff(want, order, cmpopts.IgnoreFields(app.Order{}, "ID", "CreatedAt")); diff != "" {
+		t.Errorf("unexpected order (-want +got):\n%s", diff)
+	}
+	// 二度目は何もしない
+	if again, err := auctions.CloseAuction(ctx, item.ID, extended); err != nil || again != nil {
+		t.Errorf("expected closing twice to have no effect, got %+v, %v", again, err)
+	}
+	if ended, err := auctions.EndedAuctions(ctx, extended, 10); err != nil || len(ended) != 0 {
+		t.Errorf("expected no auction left to close, got %v, %v", ended, err)
+	}
+
+	sold, err := repo.Select(ctx, item.ID)
+	if err != nil {
+		t.Fatalf("failed to select item: %v", err)
+	}
+	if sold.SoldAt == nil {
+		t.Errorf("expected the item to be sold, got %+v", sold)
+	}
+	for _, userID := range []int{1, 3} {
+		orders, err := auctions.ListOrders(ctx, userID)
+		if err != nil {
+			t.Fatalf("failed to list orders: %v", err)
+		}
+		if len(orders) != 1 || orders[0].ID != order.ID {
+			t.Errorf("expected user %d to see the order, got %+v", userID, orders)
+		}
+	}
+	if orders, err := auctions.ListOrders(ctx, 2); err != nil || len(orders) != 0 {
+		t.Errorf("expected the outbid user to have no orders, got %+v, %v", orders, err)
+	}
+
+	events, err := repo.PendingEvents(ctx, time.Now().Add(time.Minute), 100)
+	if err != nil {
+		t.Fatalf("failed to get pending events: %v", err)
+	}
+	var soldEvents []*app.Event
+	for _, e := range events {
+		if e.Type == app.EventItemSold {
+			soldEvents = append(soldEvents, e)
+		}
+	}
+	if len(soldEvents) != 1 {
+		t.Fatalf("expected 1 ItemSold event, got %d", len(soldEvents))
+	}
+	var payload app.Item
+	if err := json.Unmarshal(soldEvents[0].Payload, &payload); err != nil {
+		t.Fatalf("failed to decode payload: %v", err)
+	}
+	if payload.ID != item.ID || payload.SoldAt == nil || payload.Auction == nil || payload.Auction.HighestBidderID != 3 {
+		t.Errorf("expected the sold item with its auction as the payload, got %+v", payload)
+	}
+}
+
+func testOffers(t *testing.T, r Repositories) {
+	if r.Auctions == nil || r.Offers == nil {
+		t.Skip("no AuctionRepository or OfferRepository")
+	}
+	ctx := context.Background()
+	repo, auctions, offers := r.Items, r.Auctions, r.Offers
+	start := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	ttl, window := 48*time.Hour, 24*time.Hour
+
+	item := mustInsert(t, repo, &app.Item{Name: "camera", Category: "camera", SellerID: 1, Price: 10000})
+	auction := mustInsert(t, repo, &app.Item{Name: "lens", Category: "camera", SellerID: 1, Price: 500, ListingType: app.ListingAuction,
+		Auction: &app.Auction{StartPrice: 500, MinIncrement: 100, EndsAt: start.Add(time.Hour)}})
+
+	offer := func(buyerID, amount int, at time.Time) *app.Offer {
+		t.Helper()
+		o := &app.Offer{ItemID: item.ID, BuyerID: buyerID, Amount: amount, ExpiresAt: at.Add(ttl)}
+		if err := offers.CreateOffer(ctx, o, at); err != nil {
+			t.Fatalf("failed to create offer: %v", err)
+		}
+		return o
+	}
+	transition := func(id int, tr app.OfferTransition) *app.Offer {
+		t.Helper()
+		o, err := offers.TransitionOffer(ctx, id, tr)
+		if err != nil {
+			t.Fatalf("failed to %s offer: %v", tr.Action, err)
+		}
+		return o
+	}
+
+	first := offer(2, 8000, start)
+	if first.ID == 0 || first.SellerID != 1 || first.Status != app.OfferPending {
+		t.Errorf("unexpected offer: %+v", first)
+	}
+	if err := offers.CreateOffer(ctx, &app.Offer{ItemID: item.ID, BuyerID: 2, Amount: 8500, ExpiresAt: start.Add(ttl)}, start); !errors.Is(err, app.ErrOfferExists) {
+		t.Errorf("expected ErrOfferExists for a second open offer, got %v", err)
+	}
+	if err := offers.CreateOffer(ctx, &app.Offer{ItemID: auction.ID, BuyerID: 2, Amount: 500, ExpiresAt: start.Add(ttl)}, start); !errors.Is(err, app.ErrOffersNotAccepted) {
+		t.Errorf("expected ErrOffersNotAccepted for an auction, got %v", err)
+	}
+	if err := offers.CreateOffer(ctx, &app.Offer{ItemID: 9999, BuyerID: 2, Amount: 500, ExpiresAt: start.Add(ttl)}, start); !errors.Is(err, app.ErrItemNotFound) {
+		t.Errorf("expected ErrItemNotFound, got %v", err)
+	}
+
+	// 売り手の逆提案を買い手が承認する
+	at := start.Add(time.Hour)
+	if _, err := offers.TransitionOffer(ctx, first.ID, app.OfferTransition{Action: app.OfferActionAccept, ActorID: 2, At: at}); !errors.Is(err, app.ErrInvalidOfferTransition) {
+		t.Errorf("expected the buyer not to accept their own offer, got %v", err)
+	}
+	countered := transition(first.ID, app.OfferTransition{Action: app.OfferActionCounter, ActorID: 1, Amount: 9000, At: at, Until: at.Add(ttl)})
+	if countered.Status != app.OfferCountered || countered.Amount != 9000 || !countered.ExpiresAt.Equal(at.Add(ttl)) {
+		t.Errorf("unexpected countered offer: %+v", countered)
+	}
+	at = at.Add(time.Hour)
+	accepted := transition(first.ID, app.OfferTransition{Action: app.OfferActionAccept, ActorID: 2, At: at, Until: at.Add(window)})
+	if accepted.Status != app.OfferAccepted || accepted.ReservedUntil == nil || !accepted.ReservedUntil.Equal(at.Add(window)) {
+		t.Errorf("unexpected accepted offer: %+v", accepted)
+	}
+
+	// 取り置き中は他の交渉を承認できない
+	second := offer(3, 9500, at)
+	if _, err := offers.TransitionOffer(ctx, second.ID, app.OfferTransition{Action: app.OfferActionAccept, ActorID: 1, At: at, Until: at.Add(window)}); !errors.Is(err, app.ErrItemReserved) {
+		t.Errorf("expected ErrItemReserved, got %v", err)
+	}
+	// 取り置きが切れたら承認できる
+	at = at.Add(window)
+	if _, err := offers.TransitionOffer(ctx, first.ID, app.OfferTransition{Action: app.OfferActionCheckout, ActorID: 2, At: at}); !errors.Is(err, app.ErrInvalidOfferTransition) {
+		t.Errorf("expected checking out after the window to fail, got %v", err)
+	}
+	transition(second.ID, app.OfferTransition{Action: app.OfferActionAccept, ActorID: 1, At: at, Until: at.Add(window)})
+	lapsed, err := offers.GetOffer(ctx, first.ID)
+	if err != nil {
+		t.Fatalf("failed to get offer: %v", err)
+	}
+	if lapsed.Status != app.OfferLapsed {
+		t.Errorf("expected the first reservation to lapse, got %+v", lapsed)
+	}
+	steps := []string{}
+	for _, e := range lapsed.History {
+		steps = append(steps, e.Action+":"+e.From+">"+e.To)
+	}
+	wantSteps := []string{"create:>pending", "counter:pending>countered", "accept:countered>accepted", "expire:accepted>lapsed"}
+	if diff := cmp.Diff(wantSteps, steps); diff != "" {
+		t.Errorf("unexpected history (-want +got):\n%s", diff)
+	}
+	if lapsed.History[1].ActorID != 1 || lapsed.History[1].Amount != 9000 || lapsed.History[3].ActorID != 0 {
+		t.Errorf("unexpected history entries: %+v, %+v", lapsed.History[1], lapsed.History[3])
+	}
+
+	bought := transition(second.ID, app.OfferTransition{Action: app.OfferActionCheckout, ActorID: 3, At: at.Add(time.Minute)})
+	if bought.Status != app.OfferPurchased || bought.OrderID == 0 {
+		t.Errorf("unexpected purchased offer: %+v", bought)
+	}
+	orders, err := auctions.ListOrders(ctx, 3)
+	if err != nil {
+		t.Fatalf("failed to list orders: %v", err)
+	}
+	if len(orders) != 1 || orders[0].ID != bought.OrderID || orders[0].Price != 9500 || orders[0].SellerID != 1 {
+		t.Errorf("unexpected orders: %+v", orders)
+	}
+	sold, err := repo.Select(ctx, item.ID)
+	if err != nil {
+		t.Fatalf("failed to select item: %v", err)
+	}
+	if sold.SoldAt == nil || sold.Price != 9500 {
+		t.Errorf("expected the item to be sold at the offer, got %+v", sold)
+	}
+	if err := offers.CreateOffer(ctx, &app.Offer{ItemID: item.ID, BuyerID: 4, Amount: 9000, ExpiresAt: at.Add(ttl)}, at); !errors.Is(err, app.ErrItemSold) {
+		t.Errorf("expected ErrItemSold, got %v", err)
+	}
+
+	events, err := repo.PendingEvents(ctx, time.Now().Add(time.Minute), 100)
+	if err != nil {
+		t.Fatalf("failed to get pending events: %v", err)
+	}
+	soldEvents := 0
+	for _, e := range events {
+		if e.Type == app.EventItemSold {
+			soldEvents++
+		}
+	}
+	if soldEvents != 1 {
+		t.Errorf("expected 1 ItemSold event, got %d", soldEvents)
+	}
+
+	list, err := offers.ListOffers(ctx, app.OfferQuery{ItemID: item.ID})
+	if err != nil {
+		t.Fatalf("failed to list offers: %v", err)
+	}
+	if len(list) != 2 || list[0].ID != second.ID || list[0].History != nil {
+		t.Errorf("expected the offers newest first without history, got %+v", list)
+	}
+	if list, err := offers.ListOffers(ctx, app.OfferQuery{UserID: 2}); err != nil || len(list) != 1 || list[0].ID != first.ID {
+		t.Errorf("expected the buyer to see their offer, got %+v, %v", list, err)
+	}
+
+	// 返事のない交渉は期限切れになる
+	other := mustInsert(t, repo, &app.Item{Name: "bag", Category: "fashion", SellerID: 1, Price: 3000})
+	stale := &app.Offer{ItemID: other.ID, BuyerID: 2, Amount: 2000, ExpiresAt: start.Add(ttl)}
+	if err := offers.CreateOffer(ctx, stale, start); err != nil {
+		t.Fatalf("failed to create offer: %v", err)
+	}
+	if n, err := offers.ExpireOffers(ctx, start.Add(ttl-time.Second)); err != nil || n != 0 {
+		t.Errorf("expected no offer to expire yet, got %d, %v", n, err)
+	}
+	if n, err := offers.ExpireOffers(ctx, start.Add(ttl)); err != nil || n != 1 {
+		t.Errorf("expected 1 expired offer, got %d, %v", n, err)
+	}
+	expired, err := offers.GetOffer(ctx, stale.ID)
+	if err != nil {
+		t.Fatalf("failed to get offer: %v", err)
+	}
+	if expired.Status != app.OfferExpired {
+		t.Errorf("expected the offer to expire, got %+v", expired)
+	}
+	if _, err := offers.TransitionOffer(ctx, stale.ID, app.OfferTransition{Action: app.OfferActionAccept, ActorID: 1, At: start.Add(ttl)}); !errors.Is(err, app.ErrInvalidOfferTransition) {
+		t.Errorf("expected accepting an expired offer to fail, got %v", err)
+	}
+	if _, err := offers.GetOffer(ctx, 9999); !errors.Is(err, app.ErrOfferNotFound) {
+		t.Errorf("expected ErrOfferNotFound, got %v", err)
+	}
+}
+
+func testConcurrentInserts(t *testing.T, repo app.ItemRepository) {
+	ctx := context.Background()
+
+	// 同じ新規カテゴリへの同時追加でも、カテゴリは 1 つだけ作られる
+	var wg sync.WaitGroup
+	errs := make(chan error, concurrency)
+	ids := make(chan int, concurrency)
+	for n := 0; n < concurrency; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			item := &app.Item{Name: fmt.Sprintf("item %d", n), Category: "new"}
+			if err := repo.Insert(ctx, item); err != nil {
+				errs <- err
+				return
+			}
+			ids <- item.ID
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	close(ids)
+
+	for err := range errs {
+		t.Errorf("concurrent insert failed: %v", err)
+	}
+	seen := map[int]bool{}
+	for id := range ids {
+		if seen[id] {
+			t.Errorf("ID %d assigned twice", id)
+		}
+		seen[id] = true
+	}
+
+	categories, err := repo.GetCategories(ctx)
+	if err != nil {
+		t.Fatalf("failed to get categories: %v", err)
+	}
+	if len(categories) != 1 {
+		t.Errorf("expected 1 category, got %+v", categories)
+	}
+	items, err := repo.List(ctx)
+	if err != nil {
+		t.Fatalf("failed to list items: %v", err)
+	}
+	if len(items) != concurrency {
+		t.Errorf("expected %d items, got %d", concurrency, len(items))
+	}
+}
+
+func testConcurrentUpdates(t *testing.T, repo app.ItemRepository) {
+	ctx := context.Background()
+
+	item := mustInsert(t, repo, &app.Item{Name: "jacket", Category: "fashion"})
+
+	// 同じバージョンを元にした更新は 1 つだけ成功する
+	var wg sync.WaitGroup
+	results := make(chan error, concurrency)
+	for n := 0; n < concurrency; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- repo.Update(ctx, &app.Item{ID: item.ID, Name: fmt.Sprintf("edit %d", n), Category: "fashion"}, item.Version)
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	succeeded := 0
+	for err := range results {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, app.ErrVersionConflict):
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("expected exactly 1 update to succeed, got %d", succeeded)
+	}
+
+	got, err := repo.Select(ctx, item.ID)
+	if err != nil {
+		t.Fatalf("failed to select item: %v", err)
+	}
+	if got.Version != 2 {
+		t.Errorf("expected version 2, got %d", got.Version)
+	}
+}
+
+func testConcurrentLikes(t *testing.T, r Repositories) {
+	if r.Likes == nil {
+		t.Skip("no LikeRepository")
+	}
+	ctx := context.Background()
+	repo, likes := r.Items, r.Likes
+
+	item := mustInsert(t, repo, &app.Item{Name: "jacket", Category: "fashion"})
+
+	// 全員が 2 回ずつ同時にいいねしても、数はユーザー数と一致する
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*concurrency)
+	for n := 0; n < 2*concurrency; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := likes.Like(ctx, item.ID, n%concurrency+1); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("failed to like item: %v", err)
+	}
+
+	got, err := repo.Select(ctx, item.ID)
+	if err != nil {
+		t.Fatalf("failed to select item: %v", err)
+	}
+	if got.LikeCount != concurrency {
+		t.Errorf("expected %d likes, got %d", concurrency, got.LikeCount)
+	}
+}
+
+func testContextCancellation(t *testing.T, repo app.ItemRepository) {
+	item := mustInsert(t, repo, &app.Item{Name: "jacket", Category: "fashion"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calls := map[string]func() error{
+		"Insert": func() error { return repo.Insert(ctx, &app.Item{Name: "coat", Category: "fashion"}) },
+		"List":   func() error { _, err := repo.List(ctx); return err },
+		"Select": func() error { _, err := repo.Select(ctx, item.ID); return err },
+		"Search": func() error { _, err := repo.Search(ctx, "jacket"); return err },
+		"Update": func() error { return repo.Update(ctx, &app.Item{ID: item.ID, Name: "x", Category: "fashion"}, 0) },
+		"GetCategories": func() error {
+			_, err := repo.GetCategories(ctx)
+			return err
+		},
+		"GetCategoryByName": func() error {
+			_, err := repo.GetCategoryByName(ctx, "fashion")
+			return err
+		},
+		"InsertCategory": func() error {
+			_, err := repo.InsertCategory(ctx, "phone")
+			return err
+		},
+		"ImageReferences": func() error {
+			_, err := repo.ImageReferences(ctx)
+			return err
+		},
+		"ImageHashes": func() error {
+			_, err := repo.ImageHashes(ctx)
+			return err
+		},
+	}
+	for name, call := range calls {
+		if err := call(); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: expected context.Canceled, got %v", name, err)
+		}
+	}
+
+	// キャンセルされた呼び出しは何も変更しない
+	items, err := repo.List(context.Background())
+	if err != nil {
+		t.Fatalf("failed to list items: %v", err)
+	}
+	if diff := cmp.Diff([]string{"jacket"}, names(items)); diff != "" {
+		t.Errorf("unexpected items after cancelled calls (-want +got):\n%s", diff)
+	}
+}
+
+func testReturnedItemsAreCopies(t *testing.T, repo app.ItemRepository) {
+	ctx := context.Background()
+
+	item := mustInsert(t, repo, &app.Item{Name: "jacket", Category: "fashion"})
+	item.Name = "changed by caller"
+
+	got, err := repo.Select(ctx, item.ID)
+	if err != nil {
+		t.Fatalf("failed to select item: %v", err)
+	}
+	got.Name = "changed again"
+
+	again, err := repo.Select(ctx, item.ID)
+	if err != nil {
+		t.Fatalf("failed to select item: %v", err)
+	}
+	if again.Name != "jacket" {
+		t.Errorf("expected the stored item to be unaffected by callers, got %q", again.Name)
+	}
+}
+
+func testReviews(t *testing.T, r Repositories) {
+	if r.Auctions == nil || r.Reviews == nil {
+		t.Skip("no AuctionRepository or ReviewRepository")
+	}
+	ctx := context.Background()
+	repo, auctions, reviews := r.Items, r.Auctions, r.Reviews
+	start := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	endsAt := start.Add(time.Hour)
+	window := 24 * time.Hour
+
+	item := mustInsert(t, repo, &app.Item{Name: "camera", Category: "camera", SellerID: 1, Price: 1000, ListingType: app.ListingAuction,
+		Auction: &app.Auction{StartPrice: 1000, MinIncrement: 100, EndsAt: endsAt}})
+	if _, err := auctions.PlaceBid(ctx, &app.Bid{ItemID: item.ID, BidderID: 2, Amount: 1000}, start); err != nil {
+		t.Fatalf("failed to place bid: %v", err)
+	}
+	order, err := auctions.CloseAuction(ctx, item.ID, endsAt)
+	if err != nil || order == nil {
+		t.Fatalf("failed to close auction: %+v, %v", order, err)
+	}
+	if _, err := auctions.GetOrder(ctx, order.ID+100); !errors.Is(err, app.ErrOrderNotFound) {
+		t.Errorf("expected ErrOrderNotFound, got %v", err)
+	}
+
+	review := func(reviewerID int, rating string, at time.Time) (*app.Review, error) {
+		t.Helper()
+		r := &app.Review{OrderID: order.ID, ReviewerID: reviewerID, Rating: rating, Comment: "thanks"}
+		return r, reviews.AddReview(ctx, r, at, window)
+	}
+	if _, err := review(2, app.RatingGood, endsAt); !errors.Is(err, app.ErrOrderNotCompleted) {
+		t.Errorf("expected ErrOrderNotCompleted before completion, got %v", err)
+	}
+
+	completedAt := endsAt.Add(time.Hour)
+	completed, err := auctions.CompleteOrder(ctx, order.ID, completedAt)
+	if err != nil {
+		t.Fatalf("failed to complete order: %v", err)
+	}
+	if completed.Status != app.OrderCompleted || completed.CompletedAt == nil || !completed.CompletedAt.Equal(completedAt) {
+		t.Errorf("unexpected completed order: %+v", completed)
+	}
+	if _, err := auctions.CompleteOrder(ctx, order.ID, completedAt); !errors.Is(err, app.ErrOrderNotPending) {
+		t.Errorf("expected ErrOrderNotPending on the second completion, got %v", err)
+	}
+	got, err := auctions.GetOrder(ctx, order.ID)
+	if err != nil {
+		t.Fatalf("failed to get order: %v", err)
+	}
+	if diff := cmp.Diff(completed, got, cmpopts.EquateApproxTime(time.Second)); diff != "" {
+		t.Errorf("unexpected order (-want +got):\n%s", diff)
+	}
+
+	if _, err := review(5, app.RatingGood, completedAt); !errors.Is(err, app.ErrOrderNotFound) {
+		t.Errorf("expected ErrOrderNotFound for a user outside the order, got %v", err)
+	}
+	byBuyer, err := review(2, app.RatingGood, completedAt.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("failed to add review: %v", err)
+	}
+	want := &app.Review{OrderID: order.ID, ItemID: item.ID, ReviewerID: 2, RevieweeID: 1, Role: app.ReviewerBuyer, Rating: app.RatingGood, Comment: "thanks"}
+	if diff := cmp.Diff(want, byBuyer, cmpopts.IgnoreFields(app.Review{}, "ID", "CreatedAt")); diff != "" || byBuyer.ID == 0 {
+		t.Errorf("unexpected review (-want +got):\n%s", diff)
+	}
+	if _, err := review(2, app.RatingBad, completedAt.Add(time.Minute)); !errors.Is(err, app.ErrAlreadyReviewed) {
+		t.Errorf("expected ErrAlreadyReviewed, got %v", err)
+	}
+	// 期間が過ぎると出品者は評価できない
+	if _, err := review(1, app.RatingBad, completedAt.Add(window)); !errors.Is(err, app.ErrReviewWindowClosed) {
+		t.Errorf("expected ErrReviewWindowClosed, got %v", err)
+	}
+	bySeller, err := review(1, app.RatingNormal, completedAt.Add(window-time.Second))
+	if err != nil {
+		t.Fatalf("failed to add review: %v", err)
+	}
+	if bySeller.Role != app.ReviewerSeller || bySeller.RevieweeID != 2 {
+		t.Errorf("unexpected review by the seller: %+v", bySeller)
+	}
+
+	list, err := reviews.ListReviews(ctx, app.ReviewQuery{OrderID: order.ID})
+	if err != nil {
+		t.Fatalf("failed to list reviews: %v", err)
+	}
+	if len(list) != 2 || list[0].ID != bySeller.ID || list[1].ID != byBuyer.ID {
+		t.Errorf("expected the reviews newest first, got %+v", list)
+	}
+	for name, tc := range map[string]struct {
+		q    app.ReviewQuery
+		want []int
+	}{
+		"reviewee":  {q: app.ReviewQuery{RevieweeID: 1}, want: []int{byBuyer.ID}},
+		"rating":    {q: app.ReviewQuery{Rating: app.RatingNormal}, want: []int{bySeller.ID}},
+		"before id": {q: app.ReviewQuery{OrderID: order.ID, BeforeID: bySeller.ID}, want: []int{byBuyer.ID}},
+		"limit":     {q: app.ReviewQuery{OrderID: order.ID, Limit: 1}, want: []int{bySeller.ID}},
+	} {
+		list, err := reviews.ListReviews(ctx, tc.q)
+		if err != nil {
+			t.Fatalf("%s: failed to list reviews: %v", name, err)
+		}
+		var ids []int
+		for _, r := range list {
+			ids = append(ids, r.ID)
+		}
+		if diff := cmp.Diff(tc.want, ids); diff != "" {
+			t.Errorf("%s: unexpected reviews (-want +got):\n%s", name, diff)
+		}
+	}
+
+	for userID, want := range map[int]*app.Reputation{
+		1: {Good: 1, Total: 1},
+		2: {Normal: 1, Total: 1},
+		3: {},
+	} {
+		got, err := reviews.Reputation(ctx, userID)
+		if err != nil {
+			t.Fatalf("failed to get reputation: %v", err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("unexpected reputation of user %d (-want +got):\n%s", userID, diff)
+		}
+	}
+}
+
+func testProfiles(t *testing.T, r Repositories) {
+	if r.Profiles == nil {
+		t.Skip("no ProfileRepository")
+	}
+	repo, profiles := r.Items, r.Profiles
+	ctx := context.Background()
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	got, err := profiles.GetProfile(ctx, 1)
+	if err != nil {
+		t.Fatalf("failed to get profile: %v", err)
+	}
+	if diff := cmp.Diff(&app.Profile{UserID: 1}, got); diff != "" {
+		t.Errorf("expected an empty profile before saving (-want +got):\n%s", diff)
+	}
+
+	p := &app.Profile{UserID: 1, DisplayName: "たろう", Bio: "カメラ好き", AvatarName: "a.jpg"}
+	if err := profiles.SaveProfile(ctx, p, now); err != nil {
+		t.Fatalf("failed to save profile: %v", err)
+	}
+	if p.UpdatedAt == nil || !p.UpdatedAt.Equal(now) {
+		t.Errorf("expected UpdatedAt to be set, got %v", p.UpdatedAt)
+	}
+	got, err = profiles.GetProfile(ctx, 1)
+	if err != nil {
+		t.Fatalf("failed to get profile: %v", err)
+	}
+	if diff := cmp.Diff(p, got, cmpopts.EquateApproxTime(time.Second)); diff != "" {
+		t.Errorf("unexpected profile (-want +got):\n%s", diff)
+	}
+
+	// アバターも画像の参照に数える
+	mustInsert(t, repo, &app.Item{Name: "x", Category: "c", ImageName: "a.jpg"})
+	refs, err := repo.ImageReferences(ctx)
+	if err != nil {
+		t.Fatalf("failed to count image references: %v", err)
+	}
+	if diff := cmp.Diff(map[string]int{"a.jpg": 2}, refs); diff != "" {
+		t.Errorf("unexpected references (-want +got):\n%s", diff)
+	}
+
+	p.AvatarName, p.Bio = "", ""
+	if err := profiles.SaveProfile(ctx, p, now.Add(time.Hour)); err != nil {
+		t.Fatalf("failed to update profile: %v", err)
+	}
+	got, err = profiles.GetProfile(ctx, 1)
+	if err != nil {
+		t.Fatalf("failed to get profile: %v", err)
+	}
+	if got.DisplayName != "たろう" || got.Bio != "" || got.AvatarName != "" || !got.UpdatedAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("unexpected updated profile: %+v", got)
+	}
+	if refs, err = repo.ImageReferences(ctx); err != nil || refs["a.jpg"] != 1 {
+		t.Errorf("expected the old avatar not to be referenced, got %v, %v", refs, err)
+	}
+}
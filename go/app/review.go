@@ -0,0 +1,301 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Review ratings.
+const (
+	RatingGood   = "good"
+	RatingNormal = "normal"
+	RatingBad    = "bad"
+)
+
+// Roles of reviewers in the order they review.
+const (
+	ReviewerBuyer  = "buyer"
+	ReviewerSeller = "seller"
+)
+
+const (
+	// DefaultReviewWindow is how long after an order completes its parties may review each other.
+	DefaultReviewWindow    = 14 * 24 * time.Hour
+	maxReviewCommentLength = 1000
+	defaultReviewLimit     = 50
+	maxReviewLimit         = 200
+)
+
+var (
+	ErrOrderNotFound = errors.New("order not found")
+	// ErrOrderNotPending is returned when completing an order which is already completed.
+	ErrOrderNotPending = errors.New("order is not pending")
+	// ErrOrderNotCompleted is returned when reviewing an order before the buyer received the item.
+	ErrOrderNotCompleted  = errors.New("order is not completed")
+	ErrReviewWindowClosed = errors.New("review window has closed")
+	ErrAlreadyReviewed    = errors.New("order has already been reviewed by this user")
+)
+
+// Review is the rating one party of a completed order gives the other.
+type Review struct {
+	ID         int `json:"id"`
+	OrderID    int `json:"order_id"`
+	ItemID     int `json:"item_id"`
+	ReviewerID int `json:"reviewer_id"`
+	RevieweeID int `json:"reviewee_id"`
+	// Role is whether the reviewer was the buyer or the seller.
+	Role      string    `json:"role"`
+	Rating    string    `json:"rating"`
+	Comment   string    `json:"comment"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ReviewQuery filters ReviewRepository.ListReviews. Zero fields do not filter.
+type ReviewQuery struct {
+	OrderID    int
+	RevieweeID int
+	Rating     string
+	// BeforeID returns the reviews older than this ID, for paging.
+	BeforeID int
+	Limit    int
+}
+
+// Reputation is the number of reviews a user received by rating.
+type Reputation struct {
+	Good   int `json:"good"`
+	Normal int `json:"normal"`
+	Bad    int `json:"bad"`
+	Total  int `json:"total"`
+}
+
+// add counts a review with rating.
+func (rep *Reputation) add(rating string, n int) {
+	switch rating {
+	case RatingGood:
+		rep.Good += n
+	case RatingNormal:
+		rep.Normal += n
+	case RatingBad:
+		rep.Bad += n
+	}
+	rep.Total += n
+}
+
+// reviewDeadline returns when the parties of a completed order can no longer review each other,
+// or nil if the order is not completed.
+func (o *Order) reviewDeadline(window time.Duration) *time.Time {
+	if o.Status != OrderCompleted || o.CompletedAt == nil {
+		return nil
+	}
+	deadline := o.CompletedAt.Add(window)
+	return &deadline
+}
+
+// prepareReview checks that the reviewer of r may review the order at now, and sets the
+// reviewee, the role and the item of r. Users outside the order get ErrOrderNotFound.
+func (o *Order) prepareReview(r *Review, now time.Time, window time.Duration) error {
+	switch r.ReviewerID {
+	case o.BuyerID:
+		r.Role, r.RevieweeID = ReviewerBuyer, o.SellerID
+	case o.SellerID:
+		r.Role, r.RevieweeID = ReviewerSeller, o.BuyerID
+	default:
+		return ErrOrderNotFound
+	}
+	deadline := o.reviewDeadline(window)
+	if deadline == nil {
+		return ErrOrderNotCompleted
+	}
+	if !now.Before(*deadline) {
+		return fmt.Errorf("reviews closed at %s: %w", deadline.Format(time.RFC3339), ErrReviewWindowClosed)
+	}
+	r.ItemID = o.ItemID
+	return nil
+}
+
+// ReviewRepository stores the reviews the parties of completed orders give each other. Reviews
+// rate the orders of the AuctionRepository, so it shares the database of the ItemRepository.
+type ReviewRepository interface {
+	// AddReview stores r, setting its ID, RevieweeID, Role, ItemID and CreatedAt, if its reviewer
+	// is a party of the completed order and it completed less than window before now. Each party
+	// reviews an order once; ErrAlreadyReviewed is returned for a second review.
+	AddReview(ctx context.Context, r *Review, now time.Time, window time.Duration) error
+	// ListReviews returns the reviews matching q, newest first.
+	ListReviews(ctx context.Context, q ReviewQuery) ([]*Review, error)
+	// Reputation counts the reviews userID received by rating.
+	Reputation(ctx context.Context, userID int) (*Reputation, error)
+}
+
+// reviewDuration returns how long after an order completes its parties may review each other.
+func (h *Handlers) reviewDuration() time.Duration {
+	if h.reviewWindow > 0 {
+		return h.reviewWindow
+	}
+	return DefaultReviewWindow
+}
+
+// writeOrderError maps the errors of order operations to responses.
+func writeOrderError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrOrderNotFound):
+		http.Error(w, "Order not found", http.StatusNotFound)
+	case errors.Is(err, ErrOrderNotPending), errors.Is(err, ErrOrderNotCompleted), errors.Is(err, ErrReviewWindowClosed),
+		errors.Is(err, ErrAlreadyReviewed):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		slog.Error("failed to update order", "error", err)
+		http.Error(w, "failed to update order", http.StatusInternalServerError)
+	}
+}
+
+// orderFor returns the order in the path if the signed-in user is its buyer or seller,
+// writing the error response otherwise. Orders of others are reported as not found.
+func (h *Handlers) orderFor(w http.ResponseWriter, r *http.Request) (*Order, int, bool) {
+	userID, signedIn := userIDFromContext(r.Context())
+	if !signedIn {
+		http.Error(w, "sign in to see orders", http.StatusUnauthorized)
+		return nil, 0, false
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		return nil, 0, false
+	}
+	order, err := h.auctions.GetOrder(r.Context(), id)
+	if err == nil && order.BuyerID != userID && order.SellerID != userID {
+		err = ErrOrderNotFound
+	}
+	if err != nil {
+		writeOrderError(w, err)
+		return nil, 0, false
+	}
+	return order, userID, true
+}
+
+// GetOrder is a handler to return an order with its reviews for GET /orders/{id} .
+// review_deadline is when the parties of a completed order can no longer review each other.
+func (h *Handlers) GetOrder(w http.ResponseWriter, r *http.Request) {
+	order, _, ok := h.orderFor(w, r)
+	if !ok {
+		return
+	}
+	reviews, err := h.reviews.ListReviews(r.Context(), ReviewQuery{OrderID: order.ID})
+	if err != nil {
+		http.Error(w, "failed to get reviews", http.StatusInternalServerError)
+		return
+	}
+	if reviews == nil {
+		reviews = []*Review{}
+	}
+	resp := map[string]interface{}{"order": order, "reviews": reviews}
+	if deadline := order.reviewDeadline(h.reviewDuration()); deadline != nil {
+		resp["review_deadline"] = deadline
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// CompleteOrder is a handler for POST /orders/{id}/complete , with which the buyer confirms
+// receiving the item. It opens the review window.
+func (h *Handlers) CompleteOrder(w http.ResponseWriter, r *http.Request) {
+	order, userID, ok := h.orderFor(w, r)
+	if !ok {
+		return
+	}
+	if order.BuyerID != userID {
+		http.Error(w, "only the buyer can complete an order", http.StatusForbidden)
+		return
+	}
+	order, err := h.auctions.CompleteOrder(r.Context(), order.ID, h.now())
+	if err != nil {
+		writeOrderError(w, err)
+		return
+	}
+	slog.Info("order completed", "order_id", order.ID, "user_id", userID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(order)
+}
+
+// AddReview is a handler for POST /orders/{id}/reviews , with which a party of a completed
+// order rates the other once within the review window. The form fields are rating (good,
+// normal or bad) and an optional comment.
+func (h *Handlers) AddReview(w http.ResponseWriter, r *http.Request) {
+	order, userID, ok := h.orderFor(w, r)
+	if !ok {
+		return
+	}
+	review := &Review{OrderID: order.ID, ReviewerID: userID, Rating: r.FormValue("rating"), Comment: strings.TrimSpace(r.FormValue("comment"))}
+	switch review.Rating {
+	case RatingGood, RatingNormal, RatingBad:
+	default:
+		http.Error(w, "rating must be good, normal or bad", http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(review.Comment) > maxReviewCommentLength {
+		http.Error(w, fmt.Sprintf("comment is too long (max %d chars)", maxReviewCommentLength), http.StatusBadRequest)
+		return
+	}
+	if err := h.reviews.AddReview(r.Context(), review, h.now(), h.reviewDuration()); err != nil {
+		writeOrderError(w, err)
+		return
+	}
+	slog.Info("review added", "review_id", review.ID, "order_id", order.ID, "user_id", userID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(review)
+}
+
+// GetUserReviews is a handler to return the reviews a user received, newest first, with their
+// reputation for GET /users/{id}/reviews . Filters: rating, before_id and limit.
+func (h *Handlers) GetUserReviews(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := userIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	v := r.URL.Query()
+	q := ReviewQuery{RevieweeID: userID, Rating: v.Get("rating"), Limit: defaultReviewLimit}
+	var err error
+	switch q.Rating {
+	case "", RatingGood, RatingNormal, RatingBad:
+	default:
+		http.Error(w, "rating must be good, normal or bad", http.StatusBadRequest)
+		return
+	}
+	if s := v.Get("before_id"); s != "" {
+		if q.BeforeID, err = strconv.Atoi(s); err != nil || q.BeforeID <= 0 {
+			http.Error(w, "before_id must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+	if s := v.Get("limit"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil || q.Limit <= 0 || q.Limit > maxReviewLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxReviewLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	reviews, err := h.reviews.ListReviews(ctx, q)
+	if err != nil {
+		http.Error(w, "failed to get reviews", http.StatusInternalServerError)
+		return
+	}
+	if reviews == nil {
+		reviews = []*Review{}
+	}
+	rep, err := h.reviews.Reputation(ctx, userID)
+	if err != nil {
+		http.Error(w, "failed to get reputation", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"reviews": reviews, "reputation": rep})
+}
@@ -0,0 +1,211 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sqlReviewRepository stores reviews in SQLite or PostgreSQL. placeholder is the dialect's bind
+// parameter.
+type sqlReviewRepository struct {
+	db          *sql.DB
+	placeholder func(int) string
+}
+
+// NewReviewRepository returns a ReviewRepository using the database opened by OpenDatabase with dsn.
+func NewReviewRepository(db *sql.DB, dsn string) ReviewRepository {
+	d, _ := parseDSN(dsn)
+	return &sqlReviewRepository{db: db, placeholder: d.placeholder()}
+}
+
+// AddReview stores rev if its reviewer may still review the order at now.
+func (r *sqlReviewRepository) AddReview(ctx context.Context, rev *Review, now time.Time, window time.Duration) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	order, err := selectOrder(ctx, tx, r.placeholder, rev.OrderID)
+	if err != nil {
+		return err
+	}
+	if err := order.prepareReview(rev, now, window); err != nil {
+		return err
+	}
+	rev.CreatedAt = now.UTC()
+	query := `INSERT INTO reviews (order_id, item_id, reviewer_id, reviewee_id, role, rating, comment, created_at)
+        VALUES (` + params(r.placeholder, 8) + `) ON CONFLICT (order_id, reviewer_id) DO NOTHING RETURNING id`
+	err = tx.QueryRowContext(ctx, query, rev.OrderID, rev.ItemID, rev.ReviewerID, rev.RevieweeID, rev.Role, rev.Rating, rev.Comment, rev.CreatedAt).Scan(&rev.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrAlreadyReviewed
+	}
+	if err != nil {
+		return fmt.Errorf("failed to insert review: %w", err)
+	}
+	if err := recordChange(ctx, tx, r.placeholder, AuditCreate, AuditEntityReview, rev.ID, nil, rev); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to insert review: %w", err)
+	}
+	return nil
+}
+
+// ListReviews returns the reviews matching q, newest first.
+func (r *sqlReviewRepository) ListReviews(ctx context.Context, q ReviewQuery) ([]*Review, error) {
+	var conds []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, cond+" "+r.placeholder(len(args)))
+	}
+	if q.OrderID != 0 {
+		add("order_id =", q.OrderID)
+	}
+	if q.RevieweeID != 0 {
+		add("reviewee_id =", q.RevieweeID)
+	}
+	if q.Rating != "" {
+		add("rating =", q.Rating)
+	}
+	if q.BeforeID != 0 {
+		add("id <", q.BeforeID)
+	}
+	query := `SELECT id, order_id, item_id, reviewer_id, reviewee_id, role, rating, comment, created_at FROM reviews`
+	if len(conds) > 0 {
+		query += ` WHERE ` + strings.Join(conds, " AND ")
+	}
+	query += ` ORDER BY id DESC`
+	if q.Limit > 0 {
+		query += ` LIMIT ` + strconv.Itoa(q.Limit)
+	}
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list reviews: %w", err)
+	}
+	defer rows.Close()
+
+	var reviews []*Review
+	for rows.Next() {
+		var rev Review
+		if err := rows.Scan(&rev.ID, &rev.OrderID, &rev.ItemID, &rev.ReviewerID, &rev.RevieweeID, &rev.Role, &rev.Rating, &rev.Comment, &rev.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan review: %w", err)
+		}
+		reviews = append(reviews, &rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list reviews: %w", err)
+	}
+	return reviews, nil
+}
+
+// Reputation counts the reviews userID received by rating.
+func (r *sqlReviewRepository) Reputation(ctx context.Context, userID int) (*Reputation, error) {
+	query := `SELECT rating, COUNT(*) FROM reviews WHERE reviewee_id = ` + r.placeholder(1) + ` GROUP BY rating`
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reputation: %w", err)
+	}
+	defer rows.Close()
+
+	var rep Reputation
+	for rows.Next() {
+		var rating string
+		var n int
+		if err := rows.Scan(&rating, &n); err != nil {
+			return nil, fmt.Errorf("failed to scan reputation: %w", err)
+		}
+		rep.add(rating, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get reputation: %w", err)
+	}
+	return &rep, nil
+}
+
+// memoryReviewRepository keeps reviews in the memory store of a memoryItemRepository, along
+// with the orders they review.
+type memoryReviewRepository struct {
+	*memoryItemRepository
+}
+
+// NewMemoryReviewRepository returns the ReviewRepository sharing the memory store of items,
+// which must be returned by NewMemoryItemRepository.
+func NewMemoryReviewRepository(items ItemRepository) ReviewRepository {
+	return memoryReviewRepository{items.(*memoryItemRepository)}
+}
+
+// AddReview stores a review of a completed order.
+func (m memoryReviewRepository) AddReview(ctx context.Context, r *Review, now time.Time, window time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to insert review: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o := m.order(r.OrderID)
+	if o == nil {
+		return ErrOrderNotFound
+	}
+	stored := *r
+	if err := o.prepareReview(&stored, now, window); err != nil {
+		return err
+	}
+	for _, other := range m.reviews {
+		if other.OrderID == stored.OrderID && other.ReviewerID == stored.ReviewerID {
+			return ErrAlreadyReviewed
+		}
+	}
+	stored.ID = m.nextReviewID
+	stored.CreatedAt = now.UTC()
+	if err := m.recordChange(ctx, AuditCreate, AuditEntityReview, stored.ID, nil, &stored); err != nil {
+		return err
+	}
+	m.nextReviewID++
+	m.reviews = append(m.reviews, &stored)
+	*r = stored
+	return nil
+}
+
+// ListReviews returns the reviews matching q, newest first.
+func (m memoryReviewRepository) ListReviews(ctx context.Context, q ReviewQuery) ([]*Review, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list reviews: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var reviews []*Review
+	for i := len(m.reviews) - 1; i >= 0 && (q.Limit <= 0 || len(reviews) < q.Limit); i-- {
+		r := *m.reviews[i]
+		if (q.OrderID != 0 && r.OrderID != q.OrderID) || (q.RevieweeID != 0 && r.RevieweeID != q.RevieweeID) ||
+			(q.Rating != "" && r.Rating != q.Rating) || (q.BeforeID != 0 && r.ID >= q.BeforeID) {
+			continue
+		}
+		reviews = append(reviews, &r)
+	}
+	return reviews, nil
+}
+
+// Reputation counts the reviews userID received by rating.
+func (m memoryReviewRepository) Reputation(ctx context.Context, userID int) (*Reputation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get reputation: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var rep Reputation
+	for _, r := range m.reviews {
+		if r.RevieweeID == userID {
+			rep.add(r.Rating, 1)
+		}
+	}
+	return &rep, nil
+}
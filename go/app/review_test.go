@@ -0,0 +1,234 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// newOrderRepo returns a repository with order 1 of item 1 sold by user 1 to user 2 at end.
+func newOrderRepo(t *testing.T, end time.Time) ItemRepository {
+	t.Helper()
+
+	ctx := context.Background()
+	repo := NewMemoryItemRepository()
+	if err := repo.Insert(ctx, &Item{Name: "camera", Category: "camera", SellerID: 1, Price: 1000, ListingType: ListingAuction,
+		Auction: &Auction{StartPrice: 1000, MinIncrement: 100, EndsAt: end}}); err != nil {
+		t.Fatal(err)
+	}
+	auctions := NewMemoryAuctionRepository(repo)
+	if _, err := auctions.PlaceBid(ctx, &Bid{ItemID: 1, BidderID: 2, Amount: 1000}, end.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auctions.CloseAuction(ctx, 1, end); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func TestReviewHandlers(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	type step struct {
+		method string
+		path   string
+		userID int
+		rating string
+		// at is the time of the request as an offset from start.
+		at     time.Duration
+		status int
+	}
+
+	cases := map[string][]step{
+		"ok: complete and review each other": {
+			{http.MethodPost, "/orders/1/complete", 2, "", 0, http.StatusOK},
+			{http.MethodPost, "/orders/1/reviews", 2, RatingGood, time.Hour, http.StatusCreated},
+			{http.MethodPost, "/orders/1/reviews", 1, RatingNormal, DefaultReviewWindow - time.Second, http.StatusCreated},
+			{http.MethodGet, "/orders/1", 1, "", DefaultReviewWindow, http.StatusOK},
+		},
+		"ng: review before completion": {
+			{http.MethodPost, "/orders/1/reviews", 2, RatingGood, 0, http.StatusConflict},
+		},
+		"ng: only the buyer completes": {
+			{http.MethodPost, "/orders/1/complete", 1, "", 0, http.StatusForbidden},
+			{http.MethodPost, "/orders/1/complete", 2, "", 0, http.StatusOK},
+			{http.MethodPost, "/orders/1/complete", 2, "", 0, http.StatusConflict},
+		},
+		"ng: review twice": {
+			{http.MethodPost, "/orders/1/complete", 2, "", 0, http.StatusOK},
+			{http.MethodPost, "/orders/1/reviews", 2, RatingGood, 0, http.StatusCreated},
+			{http.MethodPost, "/orders/1/reviews", 2, RatingBad, 0, http.StatusConflict},
+		},
+		"ng: review window closed": {
+			{http.MethodPost, "/orders/1/complete", 2, "", 0, http.StatusOK},
+			{http.MethodPost, "/orders/1/reviews", 1, RatingGood, DefaultReviewWindow, http.StatusConflict},
+		},
+		"ng: invalid reviews": {
+			{http.MethodPost, "/orders/1/complete", 2, "", 0, http.StatusOK},
+			{http.MethodPost, "/orders/1/reviews", 0, RatingGood, 0, http.StatusUnauthorized},
+			{http.MethodPost, "/orders/1/reviews", 2, "excellent", 0, http.StatusBadRequest},
+			{http.MethodPost, "/orders/1/reviews", 3, RatingGood, 0, http.StatusNotFound},
+			{http.MethodPost, "/orders/99/reviews", 2, RatingGood, 0, http.StatusNotFound},
+			{http.MethodGet, "/orders/1", 3, "", 0, http.StatusNotFound},
+		},
+	}
+
+	for name, steps := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			repo := newOrderRepo(t, start)
+			clock := &fakeClock{now: start}
+			h := &Handlers{itemRepo: repo, auctions: NewMemoryAuctionRepository(repo), reviews: NewMemoryReviewRepository(repo), clock: clock.Now}
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /orders/{id}", h.GetOrder)
+			mux.HandleFunc("POST /orders/{id}/complete", h.CompleteOrder)
+			mux.HandleFunc("POST /orders/{id}/reviews", h.AddReview)
+
+			for _, s := range steps {
+				clock.Set(start.Add(s.at))
+				form := url.Values{}
+				if s.rating != "" {
+					form.Set("rating", s.rating)
+				}
+				req := httptest.NewRequest(s.method, s.path, strings.NewReader(form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				if s.userID != 0 {
+					req = req.WithContext(ContextWithUserID(req.Context(), s.userID))
+				}
+				rr := httptest.NewRecorder()
+				mux.ServeHTTP(rr, req)
+				if rr.Code != s.status {
+					t.Fatalf("%s %s: expected status %d, got %d: %s", s.method, s.path, s.status, rr.Code, rr.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestGetOrderResponse(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	start := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	repo := newOrderRepo(t, start)
+	auctions, reviews := NewMemoryAuctionRepository(repo), NewMemoryReviewRepository(repo)
+	if _, err := auctions.CompleteOrder(ctx, 1, start); err != nil {
+		t.Fatal(err)
+	}
+	if err := reviews.AddReview(ctx, &Review{OrderID: 1, ReviewerID: 2, Rating: RatingGood, Comment: "fast shipping"}, start, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	h := &Handlers{itemRepo: repo, auctions: auctions, reviews: reviews, reviewWindow: time.Hour}
+	req := httptest.NewRequest(http.MethodGet, "/orders/1", nil)
+	req.SetPathValue("id", "1")
+	req = req.WithContext(ContextWithUserID(req.Context(), 1))
+	rr := httptest.NewRecorder()
+	h.GetOrder(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body)
+	}
+
+	var resp struct {
+		Order          Order     `json:"order"`
+		Reviews        []*Review `json:"reviews"`
+		ReviewDeadline time.Time `json:"review_deadline"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Order.Status != OrderCompleted || !resp.ReviewDeadline.Equal(start.Add(time.Hour)) {
+		t.Errorf("unexpected order: %+v", resp)
+	}
+	if len(resp.Reviews) != 1 || resp.Reviews[0].RevieweeID != 1 || resp.Reviews[0].Role != ReviewerBuyer || resp.Reviews[0].Comment != "fast shipping" {
+		t.Errorf("unexpected reviews: %+v", resp.Reviews)
+	}
+}
+
+func TestGetUserReviews(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	start := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	repo := NewMemoryItemRepository()
+	auctions, reviews := NewMemoryAuctionRepository(repo), NewMemoryReviewRepository(repo)
+	// 出品者 1 が 3 件売り、購入者 2, 3, 4 から評価される
+	for i, rating := range []string{RatingGood, RatingGood, RatingBad} {
+		itemID := i + 1
+		if err := repo.Insert(ctx, &Item{Name: "camera", Category: "camera", SellerID: 1, Price: 1000, ListingType: ListingAuction,
+			Auction: &Auction{StartPrice: 1000, MinIncrement: 100, EndsAt: start}}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := auctions.PlaceBid(ctx, &Bid{ItemID: itemID, BidderID: itemID + 1, Amount: 1000}, start.Add(-time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		order, err := auctions.CloseAuction(ctx, itemID, start)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := auctions.CompleteOrder(ctx, order.ID, start); err != nil {
+			t.Fatal(err)
+		}
+		if err := reviews.AddReview(ctx, &Review{OrderID: order.ID, ReviewerID: itemID + 1, Rating: rating}, start, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := &Handlers{itemRepo: repo, reviews: reviews}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}/reviews", h.GetUserReviews)
+
+	cases := map[string]struct {
+		path           string
+		status         int
+		want           []int
+		wantReputation *Reputation
+	}{
+		"ok: all reviews":     {"/users/1/reviews", http.StatusOK, []int{3, 2, 1}, &Reputation{Good: 2, Bad: 1, Total: 3}},
+		"ok: rating":          {"/users/1/reviews?rating=good", http.StatusOK, []int{2, 1}, &Reputation{Good: 2, Bad: 1, Total: 3}},
+		"ok: paging":          {"/users/1/reviews?before_id=3&limit=1", http.StatusOK, []int{2}, &Reputation{Good: 2, Bad: 1, Total: 3}},
+		"ok: no reviews":      {"/users/2/reviews", http.StatusOK, []int{}, &Reputation{}},
+		"ng: invalid rating":  {"/users/1/reviews?rating=excellent", http.StatusBadRequest, nil, nil},
+		"ng: invalid limit":   {"/users/1/reviews?limit=0", http.StatusBadRequest, nil, nil},
+		"ng: invalid user id": {"/users/abc/reviews", http.StatusBadRequest, nil, nil},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			if rr.Code != tc.status {
+				t.Fatalf("expected status %d, got %d: %s", tc.status, rr.Code, rr.Body)
+			}
+			if tc.want == nil {
+				return
+			}
+			var resp struct {
+				Reviews    []*Review   `json:"reviews"`
+				Reputation *Reputation `json:"reputation"`
+			}
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			ids := []int{}
+			for _, r := range resp.Reviews {
+				ids = append(ids, r.ID)
+			}
+			if diff := cmp.Diff(tc.want, ids); diff != "" {
+				t.Errorf("unexpected reviews (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.wantReputation, resp.Reputation); diff != "" {
+				t.Errorf("unexpected reputation (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"context"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
@@ -10,9 +11,14 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"net/netip"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -20,11 +26,69 @@ import (
 type Server struct {
 	// Port is the port number to listen on.
 	Port string
+	// Storage selects where items are kept: StorageDatabase (default) or StorageMemory.
+	Storage string
+	// DatabaseDSN selects the database. postgres:// URLs use PostgreSQL, anything else is a SQLite file path.
+	DatabaseDSN string
 	// ImageDirPath is the path to the directory storing images.
 	ImageDirPath string
-	DB           *sql.DB
+	// UploadDirPath is the path to the directory storing resumable uploads in progress.
+	UploadDirPath string
+	// ImageGCInterval is how often unreferenced images are deleted. Zero disables the background job.
+	ImageGCInterval time.Duration
+	// ImageGCGracePeriod is how old an unreferenced image must be before it is deleted.
+	ImageGCGracePeriod time.Duration
+	// DuplicateImageAction decides what happens when a new image resembles another seller's listing.
+	DuplicateImageAction DuplicateImageAction
+	// SimilarImageDistance is the largest Hamming distance treated as the same photo.
+	SimilarImageDistance int
+	// AdminUserIDs are the users allowed to see and restore deleted items.
+	AdminUserIDs []int
+	// UserAuth decides whether the X-User-ID header set by the front proxy is believed. Without a
+	// secret or TrustHeader every request is anonymous.
+	UserAuth UserAuth
+	// TrashPurgeInterval is how often deleted items older than TrashRetention are purged.
+	// Zero disables the background job.
+	TrashPurgeInterval time.Duration
+	// TrashRetention is how long deleted items stay restorable.
+	TrashRetention time.Duration
+	// CacheTTL enables the read-through cache in front of the database. Zero disables it.
+	CacheTTL time.Duration
+	// CacheSize is the maximum number of cached results.
+	CacheSize int
+	// EventSinks receive the domain events written to the outbox. Without sinks events are
+	// marked delivered and pruned.
+	EventSinks []EventSink
+	// EventPollInterval is how often the outbox is read. Zero uses one second.
+	EventPollInterval time.Duration
+	// WebSocketOrigins are the other origins, such as the web frontend, allowed to open GET /ws/messages.
+	WebSocketOrigins []string
+	// SMTPAddr is the host:port of the SMTP server notifications are emailed through. Empty disables email.
+	SMTPAddr string
+	// MailFrom is the sender address of notification emails.
+	MailFrom string
+	// OfferTTL is how long price offers wait for an answer. Zero uses DefaultOfferTTL.
+	OfferTTL time.Duration
+	// CheckoutWindow is how long an accepted offer reserves the item for the buyer.
+	// Zero uses DefaultCheckoutWindow.
+	CheckoutWindow time.Duration
+	// ReviewWindow is how long after an order completes its parties may review each other.
+	// Zero uses DefaultReviewWindow.
+	ReviewWindow time.Duration
+	// JobWorkers is how many background jobs run at once. Zero uses the JobWorker default.
+	JobWorkers int
+	// ShutdownTimeout is how long requests and jobs in progress may take to finish after
+	// SIGINT or SIGTERM. Zero uses DefaultShutdownTimeout.
+	ShutdownTimeout time.Duration
+	DB              *sql.DB
 }
 
+// DefaultShutdownTimeout is used when Server.ShutdownTimeout is zero.
+const DefaultShutdownTimeout = 30 * time.Second
+
+// defaultPollInterval is how often the outbox and the delivery queues are read.
+const defaultPollInterval = time.Second
+
 // Run is a method to start the server.
 // This method returns 0 if the server started successfully, and 1 otherwise.
 // サーバーを立ち上げる：Run関数で指定
@@ -33,22 +97,180 @@ func (s Server) Run() int {
 	logger := slog.New(slog.NewJSONHandler(os.Stderr, nil))
 	slog.SetDefault(logger)
 
-	// STEP 5-1: set up the database connection
-	db, err := sql.Open("sqlite3", "db/mercari.sqlite3")
-	if err != nil {
-		slog.Error("failed to connect to database", "error", err)
+	// 画像とアップロードを保存できないまま起動しないように、先にディレクトリを確かめる
+	if err := checkWritableDir("image", s.ImageDirPath); err != nil {
+		slog.Error("invalid image directory", "error", err)
+		return 1
+	}
+	if err := os.MkdirAll(s.UploadDirPath, 0755); err != nil {
+		slog.Error("failed to create upload directory", "error", err)
+		return 1
+	}
+	if err := checkWritableDir("upload", s.UploadDirPath); err != nil {
+		slog.Error("invalid upload directory", "error", err)
 		return 1
 	}
-	defer db.Close()
 
-	if err := SetupDatabase(db); err != nil {
-		slog.Error("failed to setup database", "error", err)
+	// STEP 5-1: set up the database connection
+	var itemRepo ItemRepository
+	var cache *CachingItemRepository
+	var comments CommentRepository
+	var likes LikeRepository
+	var auctions AuctionRepository
+	var offers OfferRepository
+	var reviews ReviewRepository
+	var profiles ProfileRepository
+	var webhooks WebhookRepository
+	var messages MessageRepository
+	var notifications NotificationRepository
+	var jobs JobRepository
+	switch s.Storage {
+	case StorageMemory:
+		// DB も CGO も不要だが、再起動するとデータは消える
+		slog.Warn("using in-memory storage; items are lost when the server stops")
+		itemRepo = NewMemoryItemRepository()
+		comments = NewMemoryCommentRepository(itemRepo)
+		likes = NewMemoryLikeRepository(itemRepo)
+		auctions = NewMemoryAuctionRepository(itemRepo)
+		offers = NewMemoryOfferRepository(itemRepo)
+		reviews = NewMemoryReviewRepository(itemRepo)
+		profiles = NewMemoryProfileRepository(itemRepo)
+		webhooks = NewMemoryWebhookRepository(itemRepo)
+		messages = NewMemoryMessageRepository()
+		notifications = NewMemoryNotificationRepository()
+		jobs = NewMemoryJobRepository()
+	case "", StorageDatabase:
+		dsn := s.DatabaseDSN
+		if dsn == "" {
+			dsn = DefaultDatabaseDSN
+		}
+		db, repo, err := OpenDatabase(dsn)
+		if err != nil {
+			slog.Error("failed to setup database", "error", err)
+			return 1
+		}
+		defer db.Close()
+		itemRepo = repo
+		comments = NewCommentRepository(db, dsn)
+		likes = NewLikeRepository(db, dsn)
+		auctions = NewAuctionRepository(db, dsn)
+		offers = NewOfferRepository(db, dsn)
+		reviews = NewReviewRepository(db, dsn)
+		profiles = NewProfileRepository(db, dsn)
+		webhooks = NewWebhookRepository(db, dsn)
+		messages = NewMessageRepository(db, dsn)
+		notifications = NewNotificationRepository(db, dsn)
+		jobs = NewJobRepository(db, dsn)
+		if s.CacheTTL > 0 {
+			cache = NewCachingItemRepository(repo, CacheOptions{TTL: s.CacheTTL, MaxEntries: s.CacheSize})
+			itemRepo = cache
+		}
+	default:
+		slog.Error("unknown storage", "storage", s.Storage)
 		return 1
 	}
 
-	// set up handlers
-	itemRepo := NewItemRepository(db)
-	h := &Handlers{imgDirPath: s.ImageDirPath, itemRepo: itemRepo}
+	// set up resumable uploads
+	uploads := newUploadStore(s.UploadDirPath)
+
+	// set up background jobs
+	// 複数のサーバーで同じ DB を使っても、各ジョブは一度だけ実行される
+	worker := NewJobWorker(jobs, JobWorkerOptions{Concurrency: s.JobWorkers})
+	if s.ImageGCInterval > 0 {
+		HandleEvery(worker, ImageGCJob, s.ImageGCInterval, imageGCJob(itemRepo, s.ImageDirPath, s.ImageGCGracePeriod))
+	}
+	if s.TrashPurgeInterval > 0 {
+		HandleEvery(worker, TrashPurgeJob, s.TrashPurgeInterval, trashPurgeJob(itemRepo, s.ImageDirPath, s.TrashRetention, time.Now))
+	}
+	HandleEvery(worker, EventPruneJob, eventPruneInterval, eventPruneJob(itemRepo, DefaultEventRetention, time.Now))
+	// 終了したオークションを締めて落札者の注文を作る
+	HandleEvery(worker, AuctionCloseJob, auctionCloseInterval, auctionCloseJob(auctions, itemRepo, time.Now))
+	// 返事のない値下げ交渉と購入されなかった取り置きを期限切れにする
+	HandleEvery(worker, OfferExpiryJob, offerExpiryInterval, offerExpiryJob(offers, time.Now))
+	HandlePoll(worker, "upload_sweep", time.Hour, uploads.sweepExpired)
+
+	// 商品の追加などで outbox に書かれたイベントを、条件に合う Webhook 購読の配信キューに積む
+	// 配信済みの印は全サーバーで共有されるので、ライブフィードの bus は各サーバーが outbox を ID 順に読んで埋める
+	bus := NewEventBus(DefaultStreamHistory)
+	// 受信箱以外の通知チャネル。メールは SMTP サーバーが指定されたときだけ送る
+	var channels []NotificationChannel
+	if s.SMTPAddr != "" {
+		channels = append(channels, &EmailChannel{Addr: s.SMTPAddr, From: s.MailFrom})
+	}
+	notifier := NewNotifier(notifications, channels...)
+	// 新着商品を保存された検索条件と照合し、売れた商品を出品者に知らせる
+	sinks := append([]EventSink{NewWebhookSubscriptionSink(webhooks), NewNotificationSink(notifier)}, s.EventSinks...)
+	// outbox と配信キューは毎秒読むので、ジョブにせずワーカーから直接呼ぶ
+	pollInterval := s.EventPollInterval
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+	dispatcher := NewEventDispatcher(itemRepo, sinks, EventDispatcherOptions{})
+	HandlePoll(worker, "event_dispatch", pollInterval, func(ctx context.Context) error {
+		_, _, err := dispatcher.DispatchOnce(ctx)
+		return err
+	})
+	tailer := NewEventTailer(itemRepo, bus, EventTailerOptions{})
+	HandlePoll(worker, "event_tail", pollInterval, func(ctx context.Context) error {
+		_, err := tailer.TailOnce(ctx)
+		return err
+	})
+	webhookDeliverer := NewWebhookDeliverer(webhooks, WebhookDelivererOptions{})
+	HandlePoll(worker, "webhook_delivery", defaultPollInterval, func(ctx context.Context) error {
+		_, _, err := webhookDeliverer.DeliverOnce(ctx)
+		return err
+	})
+	notificationDeliverer := NewNotificationDeliverer(notifications, channels, NotificationDelivererOptions{})
+	HandlePoll(worker, "notification_delivery", defaultPollInterval, func(ctx context.Context) error {
+		_, _, err := notificationDeliverer.DeliverOnce(ctx)
+		return err
+	})
+	// 同じ DB を使う他のサーバーに接続しているユーザーにも、メッセージなどのフレームを届ける
+	hub := newMessageHub(messages)
+	HandlePoll(worker, "message_events", pollInterval, hub.tailOnce)
+	HandleEvery(worker, MessageEventPruneJob, messageEventPruneInterval, messageEventPruneJob(messages, messageEventRetention, time.Now))
+
+	// background jobs stop when Run returns
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	workerDone := make(chan struct{})
+	go func() {
+		defer close(workerDone)
+		worker.Run(ctx)
+	}()
+
+	if s.UserAuth.Secret == "" && !s.UserAuth.TrustHeader {
+		slog.Warn("no user auth secret is set; X-User-ID is ignored and every request is anonymous")
+	}
+	admins := make(map[int]bool, len(s.AdminUserIDs))
+	for _, id := range s.AdminUserIDs {
+		admins[id] = true
+	}
+	h := &Handlers{
+		imgDirPath:           s.ImageDirPath,
+		itemRepo:             itemRepo,
+		comments:             comments,
+		likes:                likes,
+		auctions:             auctions,
+		offers:               offers,
+		reviews:              reviews,
+		profiles:             profiles,
+		uploads:              uploads,
+		duplicateImageAction: s.DuplicateImageAction,
+		similarImageDistance: s.SimilarImageDistance,
+		adminUserIDs:         admins,
+		webhooks:             webhooks,
+		events:               bus,
+		messages:             messages,
+		notifications:        notifications,
+		notifier:             notifier,
+		jobs:                 jobs,
+		hub:                  hub,
+		wsOrigins:            s.WebSocketOrigins,
+		offerTTL:             s.OfferTTL,
+		checkoutWindow:       s.CheckoutWindow,
+		reviewWindow:         s.ReviewWindow,
+	}
 
 	// set up routes
 	mux := http.NewServeMux()
@@ -56,25 +278,181 @@ func (s Server) Run() int {
 	mux.HandleFunc("GET /items", h.GetItems)     // 一覧を返すエンドポイント
 	mux.HandleFunc("POST /items", h.AddItem)     // POST /itemsが呼ばれたらAddItemを呼び出す
 	mux.HandleFunc("GET /items/{id}", h.GetItem) // 商品を取得する(パスに含まれるデータを取得するにはこの形がいい)
+	// 追加・更新された商品を SSE で流す
+	mux.HandleFunc("GET /items/stream", h.StreamItems)
+	mux.HandleFunc("PATCH /items/{id}", h.UpdateItem)
+	mux.HandleFunc("DELETE /items/{id}", h.DeleteItem)
+	mux.HandleFunc("POST /items/{id}/restore", h.RestoreItem)
+	mux.HandleFunc("GET /admin/audit", h.GetAuditLog)
+	mux.HandleFunc("GET /admin/jobs", h.GetJobs)
+	mux.HandleFunc("GET /admin/jobs/{id}", h.GetJob)
+	mux.HandleFunc("POST /admin/jobs/{id}/retry", h.RetryJob)
+	mux.HandleFunc("POST /webhooks", h.CreateWebhook)
+	mux.HandleFunc("GET /webhooks", h.GetWebhooks)
+	mux.HandleFunc("GET /webhooks/dead-letters", h.GetWebhookDeadLetters)
+	mux.HandleFunc("GET /webhooks/{id}", h.GetWebhook)
+	mux.HandleFunc("PATCH /webhooks/{id}", h.UpdateWebhook)
+	mux.HandleFunc("DELETE /webhooks/{id}", h.DeleteWebhook)
+	mux.HandleFunc("GET /webhooks/{id}/deliveries", h.GetWebhookDeliveries)
+	mux.HandleFunc("POST /webhooks/{id}/deliveries/{delivery_id}/redeliver", h.RedeliverWebhook)
+	mux.HandleFunc("GET /items/{id}/similar-images", h.GetSimilarImages)
+	mux.HandleFunc("POST /items/{id}/comments", h.AddComment)
+	mux.HandleFunc("GET /items/{id}/comments", h.GetComments)
+	mux.HandleFunc("DELETE /items/{id}/comments/{comment_id}", h.DeleteComment)
+	mux.HandleFunc("POST /items/{id}/comments/{comment_id}/report", h.ReportComment)
+	mux.HandleFunc("POST /items/{id}/like", h.LikeItem)
+	mux.HandleFunc("DELETE /items/{id}/like", h.UnlikeItem)
+	mux.HandleFunc("GET /me/likes", h.GetMyLikes)
+	mux.HandleFunc("POST /items/{id}/bids", h.PlaceBid)
+	mux.HandleFunc("GET /items/{id}/bids", h.GetBids)
+	mux.HandleFunc("GET /me/orders", h.GetMyOrders)
+	mux.HandleFunc("GET /orders/{id}", h.GetOrder)
+	mux.HandleFunc("POST /orders/{id}/complete", h.CompleteOrder)
+	mux.HandleFunc("POST /orders/{id}/reviews", h.AddReview)
+	mux.HandleFunc("GET /users/{id}/reviews", h.GetUserReviews)
+	mux.HandleFunc("GET /users/{id}", h.GetUserProfile)
+	mux.HandleFunc("GET /users/{id}/items", h.GetUserItems)
+	mux.HandleFunc("PATCH /me", h.UpdateMyProfile)
+	mux.HandleFunc("POST /items/{id}/offers", h.MakeOffer)
+	mux.HandleFunc("GET /items/{id}/offers", h.GetItemOffers)
+	mux.HandleFunc("GET /me/offers", h.GetMyOffers)
+	mux.HandleFunc("GET /offers/{id}", h.GetOffer)
+	mux.HandleFunc("POST /offers/{id}/accept", h.AcceptOffer)
+	mux.HandleFunc("POST /offers/{id}/reject", h.RejectOffer)
+	mux.HandleFunc("POST /offers/{id}/counter", h.CounterOffer)
+	mux.HandleFunc("POST /offers/{id}/withdraw", h.WithdrawOffer)
+	mux.HandleFunc("POST /offers/{id}/checkout", h.CheckoutOffer)
+	mux.HandleFunc("POST /me/saved-searches", h.CreateSavedSearch)
+	mux.HandleFunc("GET /me/saved-searches", h.GetSavedSearches)
+	mux.HandleFunc("DELETE /me/saved-searches/{id}", h.DeleteSavedSearch)
+	mux.HandleFunc("GET /me/notifications", h.GetNotifications)
+	mux.HandleFunc("POST /me/notifications/read", h.ReadNotifications)
+	mux.HandleFunc("GET /me/notification-preferences", h.GetNotificationPreferences)
+	mux.HandleFunc("PATCH /me/notification-preferences", h.UpdateNotificationPreferences)
+	mux.HandleFunc("POST /items/{id}/conversations", h.StartConversation)
+	mux.HandleFunc("GET /conversations", h.GetConversations)
+	mux.HandleFunc("GET /conversations/{id}/messages", h.GetMessages)
+	mux.HandleFunc("POST /conversations/{id}/messages", h.PostMessage)
+	mux.HandleFunc("POST /conversations/{id}/read", h.ReadMessages)
+	mux.HandleFunc("GET /ws/messages", h.MessagesSocket)
 	mux.HandleFunc("GET /images/{filename}", h.GetImage)
 	mux.HandleFunc("GET /search", h.SearchItems) // 検索エンドポイント
+	// tus 1.0 のレジューム可能アップロード
+	mux.HandleFunc("OPTIONS /uploads", h.UploadOptions)
+	mux.HandleFunc("POST /uploads", h.CreateUpload)
+	mux.HandleFunc("HEAD /uploads/{id}", h.HeadUpload)
+	mux.HandleFunc("PATCH /uploads/{id}", h.PatchUpload)
+	mux.HandleFunc("DELETE /uploads/{id}", h.DeleteUpload)
+	if cache != nil {
+		// キャッシュのヒット率を確認するためのエンドポイント
+		mux.HandleFunc("GET /debug/cache", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(cache.Stats())
+		})
+	}
 
 	// start the server
 	// サーバーを立てる
+	srv := &http.Server{Addr: ":" + s.Port, Handler: withRequestID(withUser(mux, s.UserAuth))}
+	// Shutdown は実行中のリクエストを待つので、終わらない SSE のストリームを先に閉じる
+	srv.RegisterOnShutdown(bus.Close)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
 	slog.Info("http server started on", "port", s.Port)
-	err = http.ListenAndServe(":"+s.Port, mux)
-	if err != nil {
+
+	// SIGINT か SIGTERM を受けたら新しいリクエストとジョブの受け付けをやめ、実行中のものを待つ
+	stop, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	select {
+	case err := <-serveErr:
 		slog.Error("failed to start server: ", "error", err)
 		return 1
+	case <-stop.Done():
+	}
+	slog.Info("shutting down")
+	timeout := s.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), timeout)
+	defer cancelShutdown()
+	cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("failed to shut down http server", "error", err)
+	}
+	select {
+	case <-workerDone:
+	case <-shutdownCtx.Done():
+		// 残ったジョブは可視性タイムアウトの後に他のワーカーか次の起動で再実行される
+		slog.Warn("background jobs still running at shutdown")
 	}
 
 	return 0
 }
 
+// Storage backends selectable with Server.Storage.
+const (
+	StorageDatabase = "db"
+	StorageMemory   = "memory"
+)
+
+// defaultImageCacheControl is sent when GetImage substitutes the default image.
+const defaultImageCacheControl = "public, max-age=60"
+
 type Handlers struct {
 	// imgDirPath is the path to the directory storing images.
 	imgDirPath string
 	itemRepo   ItemRepository
+	// uploads stores resumable uploads which AddItem can reference by ID.
+	uploads *uploadStore
+	// duplicateImageAction and similarImageDistance control the perceptual duplicate check in AddItem.
+	duplicateImageAction DuplicateImageAction
+	similarImageDistance int
+	// adminUserIDs are the users allowed to see and restore deleted items.
+	adminUserIDs map[int]bool
+	// comments stores the public comments on items.
+	comments CommentRepository
+	// likes stores the users' likes of items.
+	likes LikeRepository
+	// auctions stores the auctions with their bids and the orders of sold items.
+	auctions AuctionRepository
+	// offers stores the offers on items with their history.
+	offers OfferRepository
+	// reviews stores the reviews of completed orders.
+	reviews ReviewRepository
+	// profiles stores the users' profiles.
+	profiles ProfileRepository
+	// webhooks stores the partners' webhook subscriptions and their deliveries.
+	webhooks WebhookRepository
+	// lookupIP resolves the hosts of webhook URLs. Nil uses net.DefaultResolver.
+	lookupIP func(ctx context.Context, host string) ([]netip.Addr, error)
+	// events receives the domain events from the outbox for GET /items/stream.
+	events *EventBus
+	// streamHeartbeat is how often idle streams send a comment. Zero uses defaultStreamHeartbeat.
+	streamHeartbeat time.Duration
+	// messages stores the conversations between buyers and sellers.
+	messages MessageRepository
+	// hub pushes messages, typing indicators and read receipts to GET /ws/messages connections.
+	hub *messageHub
+	// wsOrigins are the cross-origin pages allowed to open GET /ws/messages.
+	wsOrigins []string
+	// notifications stores the users' saved searches, notification inboxes and preferences.
+	notifications NotificationRepository
+	// notifier notifies users of new messages. Nil disables the notifications.
+	notifier *Notifier
+	// jobs is the background job queue admins inspect at /admin/jobs.
+	jobs JobRepository
+	// clock returns the current time for auctions and offers. Nil uses time.Now.
+	clock func() time.Time
+	// offerTTL and checkoutWindow are how long offers wait for an answer and accepted offers
+	// reserve the item. Zero uses DefaultOfferTTL and DefaultCheckoutWindow.
+	offerTTL       time.Duration
+	checkoutWindow time.Duration
+	// reviewWindow is how long after an order completes its parties may review each other.
+	// Zero uses DefaultReviewWindow.
+	reviewWindow time.Duration
 }
 
 type HelloResponse struct {
@@ -88,13 +466,42 @@ func (s *Handlers) Hello(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handlers) GetItems(w http.ResponseWriter, r *http.Request) {
+	h.writeItems(w, r, ItemQuery{})
+}
+
+// writeItems writes the items of q with the parameters of GET /items : include_deleted (admins
+// only) and sort. Every item is returned in one response, with an ETag for the list.
+func (h *Handlers) writeItems(w http.ResponseWriter, r *http.Request, q ItemQuery) {
 	ctx := r.Context()
+	includeDeleted, ok := h.includeDeleted(w, r)
+	if !ok {
+		return
+	}
+	q.IncludeDeleted = includeDeleted
+
 	// `items` テーブルと `categories` テーブルを `JOIN` してデータを取得
-	items, err := h.itemRepo.List(ctx)
+	// 条件のない一覧はキャッシュされる List を使う
+	var items []*Item
+	var err error
+	if q == (ItemQuery{}) {
+		items, err = h.itemRepo.List(ctx)
+	} else {
+		items, err = h.itemRepo.Find(ctx, q)
+	}
 	if err != nil {
 		http.Error(w, "failed to get items", http.StatusInternalServerError)
 		return
 	}
+	// sort=popular ならいいねの多い順に並べる
+	if err := sortItems(items, r.URL.Query().Get("sort")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// 一覧の中身が変わっていなければ 304 を返す
+	if writeNotModified(w, r, itemsETag(items)) {
+		return
+	}
 
 	// JSON レスポンスを返す
 	resp := map[string]interface{}{"items": items}
@@ -114,11 +521,21 @@ func (s *Handlers) GetItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	includeDeleted, ok := s.includeDeleted(w, r)
+	if !ok {
+		return
+	}
+
 	// リポジトリからIdを使って商品をselectする
 	// Listに対してselectを作る
-	item, err := s.itemRepo.Select(ctx, id)
+	var item *Item
+	if includeDeleted {
+		item, err = s.selectIncludingDeleted(ctx, id)
+	} else {
+		item, err = s.itemRepo.Select(ctx, id)
+	}
 	if err != nil {
-		if errors.Is(err, errItemNotFound) {
+		if errors.Is(err, ErrItemNotFound) {
 			http.Error(w, "Item not found", http.StatusNotFound)
 			return
 		}
@@ -126,16 +543,107 @@ func (s *Handlers) GetItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if writeNotModified(w, r, itemETag(item)) {
+		return
+	}
+	// 入札ごとに version が上がるので ETag はオークションの状態も表す
+	if item.ListingType == ListingAuction {
+		if item.Auction, err = s.auctions.GetAuction(ctx, id); err != nil {
+			http.Error(w, "failed to get auction", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// selectした商品を返す
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode(item)
 }
 
+// UpdateItem is a handler to edit the name and category of an item for PATCH /items/{id} .
+// Sellers can edit their own items and admins any item. If-Match is required (428 without it)
+// and the update is refused with 412 if the item changed since it was read.
+func (s *Handlers) UpdateItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		return
+	}
+
+	userID, signedIn := userIDFromContext(ctx)
+	if !signedIn {
+		http.Error(w, "sign in to edit items", http.StatusUnauthorized)
+		return
+	}
+	item, err := s.itemRepo.Select(ctx, id)
+	if err != nil {
+		if errors.Is(err, ErrItemNotFound) {
+			http.Error(w, "Item not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to get item", http.StatusInternalServerError)
+		return
+	}
+	// 出品者のいない商品は管理者だけが編集できる
+	if (item.SellerID == 0 || item.SellerID != userID) && !s.isAdmin(ctx) {
+		http.Error(w, "only the seller can edit this item", http.StatusForbidden)
+		return
+	}
+	if !requireIfMatch(w, r) || !checkIfMatch(w, r, item) {
+		return
+	}
+
+	// 送られてきた項目だけ更新する
+	if name := r.FormValue("name"); name != "" {
+		if len(name) > 255 {
+			http.Error(w, "name is too long (max 255 chars)", http.StatusBadRequest)
+			return
+		}
+		item.Name = name
+	}
+	if category := r.FormValue("category"); category != "" {
+		if len(category) > 255 {
+			http.Error(w, "category is too long (max 255 chars)", http.StatusBadRequest)
+			return
+		}
+		item.Category = category
+	}
+
+	// 読んだバージョンのままであることを条件に更新する
+	if err := s.itemRepo.Update(ctx, item, item.Version); err != nil {
+		switch {
+		case errors.Is(err, ErrVersionConflict):
+			http.Error(w, "item has been modified", http.StatusPreconditionFailed)
+		case errors.Is(err, ErrItemNotFound):
+			http.Error(w, "Item not found", http.StatusNotFound)
+		default:
+			slog.Error("failed to update item", "id", id, "error", err)
+			http.Error(w, "failed to update item", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", itemETag(item))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(item)
+}
+
 // AddItemRequestは以下の情報を受け取れる
 type AddItemRequest struct {
 	Name     string `form:"name"`
 	Category string `form:"category"` // STEP 4-2: add a category field
 	Image    []byte `form:"image"`    // STEP 4-4: add an image field  受け取った画像ファイルを構造体にそのまま載せる
+	// UploadID references a completed resumable upload used instead of Image.
+	UploadID string `form:"upload_id"`
+	// Price is optional. Zero means no price.
+	Price int `form:"price"`
+	// ListingType is ListingFixed (the default when empty) or ListingAuction.
+	ListingType string `form:"listing_type"`
+	// StartPrice, MinIncrement and EndsAt are the terms of an auction.
+	StartPrice   int       `form:"start_price"`
+	MinIncrement int       `form:"min_increment"`
+	EndsAt       time.Time `form:"ends_at"`
 }
 
 // parseAddItemRequest parses and validates the request to add an item.
@@ -160,40 +668,90 @@ func parseAddItemRequest(r *http.Request) (*AddItemRequest, error) {
 		return nil, errors.New("category is too long (max 255 chars)")
 	}
 
+	price, err := parsePrice(r, "price")
+	if err != nil {
+		return nil, err
+	}
+	req.Price = price
+	if err := parseAuctionTerms(r, req); err != nil {
+		return nil, err
+	}
+
+	req.Image, req.UploadID, err = parseImage(r)
+	if err != nil {
+		return nil, err
+	}
+	if req.Image == nil && req.UploadID == "" {
+		return nil, errors.New("image is required")
+	}
+	return req, nil
+}
+
+// parseImage reads the image of a form: the ID of a completed resumable upload in upload_id, or
+// a JPEG or PNG file in image. Both are empty when neither is sent.
+func parseImage(r *http.Request) (image []byte, uploadID string, err error) {
+	// レジューム可能アップロードを参照する場合は画像本体を受け取らない
+	if uploadID := r.FormValue("upload_id"); uploadID != "" {
+		if !uploadIDPattern.MatchString(uploadID) {
+			return nil, "", errors.New("upload_id is invalid")
+		}
+		return nil, uploadID, nil
+	}
+
 	// STEP 4-4: add an image field
 	// リクエストで受け取った画像がFormFile("image")に入る
 	uploadedFile, _, err := r.FormFile("image")
 	if err != nil {
-		return nil, errors.New("image is required")
+		return nil, "", nil
 	}
 	defer uploadedFile.Close()
 
 	imageData, err := io.ReadAll(uploadedFile)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read file: %w", err)
+		return nil, "", fmt.Errorf("failed to read file: %w", err)
 	}
 
+	if err := validateImage(imageData); err != nil {
+		return nil, "", err
+	}
+	return imageData, "", nil
+}
+
+// parsePrice reads a non-negative price from a form field. Missing fields are zero.
+func parsePrice(r *http.Request, key string) (int, error) {
+	v := r.FormValue(key)
+	if v == "" {
+		return 0, nil
+	}
+	price, err := strconv.Atoi(v)
+	if err != nil || price < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", key)
+	}
+	return price, nil
+}
+
+// validateImage checks that image is a non-empty JPEG or PNG.
+func validateImage(imageData []byte) error {
 	// **空の画像データのチェック**
 	if len(imageData) == 0 {
-		return nil, errors.New("image file is empty")
+		return errors.New("image file is empty")
 	}
 
 	// **MIMEタイプを `http.DetectContentType` で取得**
-	contentType := http.DetectContentType(imageData[:512]) // 最初の 512 バイトから MIME を判定
+	contentType := http.DetectContentType(imageData) // 最初の 512 バイトから MIME を判定
 	validMimeTypes := map[string]bool{
 		"image/jpeg": true,
 		"image/png":  true,
 	}
 
 	if !validMimeTypes[contentType] {
-		return nil, fmt.Errorf("invalid image format (must be JPEG or PNG, got %s)", contentType)
+		return fmt.Errorf("invalid image format (must be JPEG or PNG, got %s)", contentType)
 	}
-
-	req.Image = imageData
-	return req, nil
+	return nil
 }
 
 // AddItem is a handler to add a new item for POST /items .
+// The ItemCreated event is written with the item, and reaches webhook subscribers from the outbox.
 // 直接乗せた画像ファイルを変更
 func (s *Handlers) AddItem(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -204,6 +762,50 @@ func (s *Handlers) AddItem(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	sellerID, signedIn := userIDFromContext(ctx)
+	if req.ListingType == ListingAuction {
+		// 落札者の注文に出品者が必要なのでオークションはサインインが必要
+		if !signedIn {
+			http.Error(w, "sign in to list an auction", http.StatusUnauthorized)
+			return
+		}
+		if err := checkAuctionEnd(req.EndsAt, s.now()); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// 完了済みのアップロードから画像を取り出す
+	if req.UploadID != "" {
+		req.Image, err = s.readUploadedImage(req.UploadID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// 見た目が同じ画像を検出するための知覚ハッシュ (デコードできない画像は 0 のまま)
+	imageHash, err := computeImageHash(req.Image)
+	if err != nil {
+		slog.Warn("failed to compute image hash", "error", err)
+	}
+
+	// 他の出品者の画像と酷似していたら警告または拒否する
+	var duplicates []imageMatch
+	if s.duplicateImageAction != DuplicateImageIgnore && imageHash != 0 {
+		hashes, err := s.itemRepo.ImageHashes(ctx)
+		if err != nil {
+			slog.Error("failed to list image hashes for duplicate check", "error", err)
+			http.Error(w, "failed to check duplicate images", http.StatusInternalServerError)
+			return
+		}
+		duplicates = otherSellerImages(findSimilarImages(hashes, imageHash, s.maxImageDistance(), 0), sellerID)
+		if len(duplicates) > 0 && s.duplicateImageAction == DuplicateImageReject {
+			slog.Warn("Rejected item with duplicate image", "similar_item_id", duplicates[0].ItemID)
+			http.Error(w, "image is too similar to another seller's listing", http.StatusConflict)
+			return
+		}
+	}
 
 	// STEP 4-4: uncomment on adding an implementation to store an image
 	// storeImageを呼び出すと画像ファイルを保存してファイル名を返す
@@ -233,8 +835,16 @@ func (s *Handlers) AddItem(w http.ResponseWriter, r *http.Request) {
 		// STEP 4-2: add a category field
 		Category: req.Category,
 		// STEP 4-4: add an image field
-		ImageName:  fileName,
-		CategoryID: category.ID,
+		ImageName:   fileName,
+		CategoryID:  category.ID,
+		SellerID:    sellerID,
+		ImageHash:   imageHash,
+		Price:       req.Price,
+		ListingType: req.ListingType,
+	}
+	if req.ListingType == ListingAuction {
+		item.Price = req.StartPrice
+		item.Auction = &Auction{StartPrice: req.StartPrice, MinIncrement: req.MinIncrement, EndsAt: req.EndsAt}
 	}
 
 	// STEP 4-2: add an implementation to store an image
@@ -249,14 +859,103 @@ func (s *Handlers) AddItem(w http.ResponseWriter, r *http.Request) {
 
 	slog.Info("Item successfully stored", "id", item.ID)
 
+	// 商品に使ったアップロードは不要になるので削除する
+	if req.UploadID != "" {
+		if err := s.uploads.Delete(req.UploadID); err != nil {
+			slog.Warn("failed to delete consumed upload", "upload_id", req.UploadID, "error", err)
+		}
+	}
+
 	// JSONレスポンスを返す
 	resp := map[string]interface{}{
 		"id":      item.ID,
 		"message": "item received: " + item.Name, // curlコマンドのPOSTで返って実行結果を増やしたいのであればここで付け足す
 	}
+	if len(duplicates) > 0 {
+		ids := make([]int, 0, len(duplicates))
+		for _, d := range duplicates {
+			ids = append(ids, d.ItemID)
+		}
+		resp["warnings"] = []string{"image is similar to another seller's listing"}
+		resp["similar_item_ids"] = ids
+	}
 	json.NewEncoder(w).Encode(resp)
 }
 
+// maxImageDistance returns the configured similarity threshold.
+func (s *Handlers) maxImageDistance() int {
+	if s.similarImageDistance > 0 {
+		return s.similarImageDistance
+	}
+	return DefaultSimilarImageDistance
+}
+
+// GetSimilarImages is a handler to list items with a perceptually similar image for GET /items/{id}/similar-images .
+func (s *Handlers) GetSimilarImages(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		return
+	}
+	maxDistance := s.maxImageDistance()
+	if v := r.URL.Query().Get("max_distance"); v != "" {
+		maxDistance, err = strconv.Atoi(v)
+		if err != nil || maxDistance < 0 || maxDistance > 64 {
+			http.Error(w, "max_distance must be an integer between 0 and 64", http.StatusBadRequest)
+			return
+		}
+	}
+
+	item, err := s.itemRepo.Select(ctx, id)
+	if err != nil {
+		if errors.Is(err, ErrItemNotFound) {
+			http.Error(w, "Item not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to get item", http.StatusInternalServerError)
+		return
+	}
+	hashes, err := s.itemRepo.ImageHashes(ctx)
+	if err != nil {
+		http.Error(w, "failed to get items", http.StatusInternalServerError)
+		return
+	}
+
+	// 近い画像の商品だけを読み込む
+	similar := []SimilarItem{}
+	for _, m := range findSimilarImages(hashes, item.ImageHash, maxDistance, item.ID) {
+		similarItem, err := s.itemRepo.Select(ctx, m.ItemID)
+		if errors.Is(err, ErrItemNotFound) {
+			continue // 読み込むまでの間に削除された
+		}
+		if err != nil {
+			http.Error(w, "failed to get items", http.StatusInternalServerError)
+			return
+		}
+		similar = append(similar, SimilarItem{Item: similarItem, Distance: m.Distance})
+	}
+
+	resp := map[string]interface{}{"items": similar}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// readUploadedImage returns the validated image of a completed resumable upload.
+func (s *Handlers) readUploadedImage(uploadID string) ([]byte, error) {
+	if s.uploads == nil {
+		return nil, errUploadNotFound
+	}
+	data, err := s.uploads.Read(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateImage(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
 // storeImage stores an image and returns the file path and an error if any.
 // this method calculates the hash sum of the image as a file name to avoid the duplication of a same file
 // and stores it in the image directory.
@@ -276,7 +975,12 @@ func (s *Handlers) storeImage(image []byte) (string, error) {
 
 	// - check if the image already exists
 	// 画像がすでにある場合のハンドリング
+	// 更新時刻を進めて、参照される前に画像GCで消されないようにする
 	if _, err := os.Stat(imgPath); err == nil {
+		now := time.Now()
+		if err := os.Chtimes(imgPath, now, now); err != nil {
+			return "", fmt.Errorf("failed to touch image: %w", err)
+		}
 		return fileName, nil
 	}
 	// - store image
@@ -290,16 +994,36 @@ func (s *Handlers) storeImage(image []byte) (string, error) {
 }
 
 // GetImage is a handler to return an image for GET /images/{filename} .
-// If the specified image is not found, it returns the default image.
+// If the specified image is not found, or its name was not made by storeImage, it returns the default image.
 func (s *Handlers) GetImage(w http.ResponseWriter, r *http.Request) {
 	fileName := r.PathValue("filename")
+
+	// 画像ディレクトリの外を読まないように区切り文字を含む名前は拒否する
+	if fileName == "" || fileName == "." || fileName == ".." || strings.ContainsAny(fileName, `/\`) {
+		http.Error(w, "invalid image file name", http.StatusBadRequest)
+		return
+	}
+	// 代替画像は後から本物に置き換わり得るので、CDN に長くキャッシュさせない
+	if fileName == defaultImageName {
+		w.Header().Set("Cache-Control", defaultImageCacheControl)
+		http.ServeFile(w, r, filepath.Join(s.imgDirPath, defaultImageName))
+		return
+	}
 	imgPath := filepath.Join(s.imgDirPath, fileName)
 
 	// when the image is not found, it returns the default image without an error.
-	if _, err := os.Stat(imgPath); os.IsNotExist(err) {
-		imgPath = filepath.Join(s.imgDirPath, "default.jpg")
+	// storeImage の命名より前の image_name も代替画像にする
+	if _, err := os.Stat(imgPath); !storedImagePattern.MatchString(fileName) || os.IsNotExist(err) {
+		w.Header().Set("Cache-Control", defaultImageCacheControl)
+		w.Header().Set("X-Image-Fallback", defaultImageName)
+		http.ServeFile(w, r, filepath.Join(s.imgDirPath, defaultImageName))
+		return
 	}
 
+	// ファイル名が内容のハッシュなので、同じ名前の内容は変わらない
+	// ETag が設定されていれば http.ServeFile が If-None-Match を見て 304 を返す
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", `"`+strings.TrimSuffix(fileName, filepath.Ext(fileName))+`"`)
 	http.ServeFile(w, r, imgPath)
 }
 
@@ -314,8 +1038,19 @@ func (h *Handlers) SearchItems(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	includeDeleted, ok := h.includeDeleted(w, r)
+	if !ok {
+		return
+	}
+
 	// リポジトリで検索
-	items, err := h.itemRepo.Search(ctx, keyword)
+	var items []*Item
+	var err error
+	if includeDeleted {
+		items, err = h.itemRepo.Find(ctx, ItemQuery{Keyword: keyword, IncludeDeleted: true})
+	} else {
+		items, err = h.itemRepo.Search(ctx, keyword)
+	}
 	if err != nil {
 		http.Error(w, "failed to search items", http.StatusInternalServerError)
 		return
@@ -2,6 +2,7 @@ package app
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -58,6 +59,35 @@ func TestParseAddItemRequest(t *testing.T) {
 				err: false,
 			},
 		},
+		"ok: with price": {
+			args: map[string]string{
+				"name":     "jacket",
+				"category": "fashion",
+				"price":    "3000",
+			},
+			imageData: dummyImageData,
+			wants: wants{
+				req: &AddItemRequest{
+					Name:     "jacket",
+					Category: "fashion",
+					Image:    dummyImageData,
+					Price:    3000,
+				},
+				err: false,
+			},
+		},
+		"ng: negative price": {
+			args: map[string]string{
+				"name":     "jacket",
+				"category": "fashion",
+				"price":    "-1",
+			},
+			imageData: dummyImageData,
+			wants: wants{
+				req: nil,
+				err: true,
+			},
+		},
 		"ng: empty request": {
 			args:      map[string]string{},
 			imageData: nil,
@@ -424,6 +454,268 @@ func TestAddItem(t *testing.T) {
 	}
 }
 
+func TestGetImage(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	hash := strings.Repeat("a", 64)
+	if err := os.WriteFile(filepath.Join(dir, hash+".jpg"), []byte("stored"), 0644); err != nil {
+		t.Fatalf("failed to write image: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, defaultImageName), []byte("default"), 0644); err != nil {
+		t.Fatalf("failed to write default image: %v", err)
+	}
+
+	type wants struct {
+		code         int
+		body         string
+		cacheControl string
+		fallback     string
+	}
+	cases := map[string]struct {
+		filename    string
+		ifNoneMatch string
+		wants
+	}{
+		"ok: stored image": {
+			filename: hash + ".jpg",
+			wants: wants{
+				code:         http.StatusOK,
+				body:         "stored",
+				cacheControl: "public, max-age=31536000, immutable",
+			},
+		},
+		"ok: not modified": {
+			filename:    hash + ".jpg",
+			ifNoneMatch: `"` + hash + `"`,
+			wants: wants{
+				code:         http.StatusNotModified,
+				cacheControl: "public, max-age=31536000, immutable",
+			},
+		},
+		"ok: missing image falls back to default": {
+			filename: strings.Repeat("b", 64) + ".jpg",
+			wants: wants{
+				code:         http.StatusOK,
+				body:         "default",
+				cacheControl: defaultImageCacheControl,
+				fallback:     defaultImageName,
+			},
+		},
+		"ng: path traversal": {
+			filename: "..%2Fdb%2Fmercari.sqlite3",
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+		"ok: default image": {
+			filename: defaultImageName,
+			wants: wants{
+				code:         http.StatusOK,
+				body:         "default",
+				cacheControl: defaultImageCacheControl,
+			},
+		},
+		"ok: legacy file name falls back to default": {
+			filename: "legacy.jpg",
+			wants: wants{
+				code:         http.StatusOK,
+				body:         "default",
+				cacheControl: defaultImageCacheControl,
+				fallback:     defaultImageName,
+			},
+		},
+		"ok: unknown extension falls back to default": {
+			filename: hash + ".gif",
+			wants: wants{
+				code:         http.StatusOK,
+				body:         "default",
+				cacheControl: defaultImageCacheControl,
+				fallback:     defaultImageName,
+			},
+		},
+		"ng: backslash": {
+			filename: "..%5Cdb%5Cmercari.sqlite3",
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+	}
+
+	h := &Handlers{imgDirPath: dir}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /images/{filename}", h.GetImage)
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest("GET", "/images/"+tt.filename, nil)
+			if tt.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			res := httptest.NewRecorder()
+			mux.ServeHTTP(res, req)
+
+			if res.Code != tt.wants.code {
+				t.Errorf("expected status code %d, got %d", tt.wants.code, res.Code)
+			}
+			if tt.wants.body != "" && res.Body.String() != tt.wants.body {
+				t.Errorf("expected body %q, got %q", tt.wants.body, res.Body.String())
+			}
+			if got := res.Header().Get("Cache-Control"); tt.wants.cacheControl != "" && got != tt.wants.cacheControl {
+				t.Errorf("expected Cache-Control %q, got %q", tt.wants.cacheControl, got)
+			}
+			if got := res.Header().Get("X-Image-Fallback"); got != tt.wants.fallback {
+				t.Errorf("expected X-Image-Fallback %q, got %q", tt.wants.fallback, got)
+			}
+		})
+	}
+}
+
+func TestGetItemConditional(t *testing.T) {
+	t.Parallel()
+
+	item := &Item{ID: 1, Name: "jacket", Category: "fashion", Version: 3}
+
+	cases := map[string]struct {
+		ifNoneMatch string
+		code        int
+	}{
+		"ok: no validator": {
+			code: http.StatusOK,
+		},
+		"ok: current etag": {
+			ifNoneMatch: `"item-1-v3"`,
+			code:        http.StatusNotModified,
+		},
+		"ok: weak current etag": {
+			ifNoneMatch: `"other", W/"item-1-v3"`,
+			code:        http.StatusNotModified,
+		},
+		"ok: stale etag": {
+			ifNoneMatch: `"item-1-v2"`,
+			code:        http.StatusOK,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			mockRepo := NewMockItemRepository(ctrl)
+			mockRepo.EXPECT().Select(gomock.Any(), 1).Return(item, nil)
+			h := &Handlers{itemRepo: mockRepo}
+
+			req := httptest.NewRequest("GET", "/items/1", nil)
+			req.SetPathValue("id", "1")
+			if tt.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			res := httptest.NewRecorder()
+			h.GetItem(res, req)
+
+			if res.Code != tt.code {
+				t.Errorf("expected status code %d, got %d", tt.code, res.Code)
+			}
+			if got := res.Header().Get("ETag"); got != `"item-1-v3"` {
+				t.Errorf("unexpected ETag %q", got)
+			}
+		})
+	}
+}
+
+func TestUpdateItem(t *testing.T) {
+	t.Parallel()
+
+	// 商品 1 は出品者 1 のもの。ユーザー 9 は管理者
+	cases := map[string]struct {
+		userID     int
+		ifMatch    string
+		setupMocks func(m *MockItemRepository)
+		code       int
+	}{
+		"ok: owner with matching If-Match": {
+			userID:  1,
+			ifMatch: `"item-1-v3"`,
+			setupMocks: func(m *MockItemRepository) {
+				m.EXPECT().Update(gomock.Any(), gomock.Any(), 3).DoAndReturn(func(_ context.Context, item *Item, _ int) error {
+					item.Version++
+					return nil
+				})
+			},
+			code: http.StatusOK,
+		},
+		"ok: admin": {
+			userID:  9,
+			ifMatch: `"item-1-v3"`,
+			setupMocks: func(m *MockItemRepository) {
+				m.EXPECT().Update(gomock.Any(), gomock.Any(), 3).Return(nil)
+			},
+			code: http.StatusOK,
+		},
+		"ng: anonymous": {
+			ifMatch: `"item-1-v3"`,
+			code:    http.StatusUnauthorized,
+		},
+		"ng: other seller": {
+			userID:  2,
+			ifMatch: `"item-1-v3"`,
+			code:    http.StatusForbidden,
+		},
+		"ng: without If-Match": {
+			userID: 1,
+			code:   http.StatusPreconditionRequired,
+		},
+		"ng: stale If-Match": {
+			userID:  1,
+			ifMatch: `"item-1-v2"`,
+			code:    http.StatusPreconditionFailed,
+		},
+		"ng: concurrent update": {
+			userID:  1,
+			ifMatch: `"item-1-v3"`,
+			setupMocks: func(m *MockItemRepository) {
+				m.EXPECT().Update(gomock.Any(), gomock.Any(), 3).Return(ErrVersionConflict)
+			},
+			code: http.StatusPreconditionFailed,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			mockRepo := NewMockItemRepository(ctrl)
+			if tt.userID != 0 {
+				mockRepo.EXPECT().Select(gomock.Any(), 1).Return(&Item{ID: 1, Name: "jacket", Category: "fashion", SellerID: 1, Version: 3}, nil)
+			}
+			if tt.setupMocks != nil {
+				tt.setupMocks(mockRepo)
+			}
+			h := &Handlers{itemRepo: mockRepo, adminUserIDs: map[int]bool{9: true}}
+
+			req := httptest.NewRequest("PATCH", "/items/1", strings.NewReader("name=coat"))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.SetPathValue("id", "1")
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			if tt.userID != 0 {
+				req = req.WithContext(ContextWithUserID(req.Context(), tt.userID))
+			}
+			res := httptest.NewRecorder()
+			h.UpdateItem(res, req)
+
+			if res.Code != tt.code {
+				t.Errorf("expected status code %d, got %d: %s", tt.code, res.Code, res.Body.String())
+			}
+		})
+	}
+}
+
 // STEP 6-4: uncomment this test
 func TestAddItemE2e(t *testing.T) {
 	if testing.Short() {
@@ -616,22 +908,8 @@ func setupDB(t *testing.T) (db *sql.DB, closers []func(), e error) {
 		db.Close()
 	})
 
-	// TODO: replace it with real SQL statements.
-	cmd := `
-	CREATE TABLE IF NOT EXISTS categories (
-				id INTEGER PRIMARY KEY AUTOINCREMENT,
-				name VARCHAR(255) NOT NULL
-			);
-			CREATE TABLE IF NOT EXISTS items (
-				id INTEGER PRIMARY KEY,
-				name VARCHAR(255) NOT NULL,
-				category_id INTEGER,
-				image_name VARCHAR(255) NOT NULL,
-				FOREIGN KEY (category_id) REFERENCES categories(id)
-			);
-		`
-	_, err = db.Exec(cmd)
-	if err != nil {
+	// set up tables with the schema the server uses
+	if err := setupDatabase(db, dialectSQLite); err != nil {
 		return nil, nil, err
 	}
 
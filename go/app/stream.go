@@ -0,0 +1,145 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultStreamHistory is how many events GET /items/stream can replay after a reconnect.
+	DefaultStreamHistory = 256
+	// defaultStreamHeartbeat is how often an idle stream sends a comment, so that proxies keep it open.
+	defaultStreamHeartbeat = 15 * time.Second
+	// streamBuffer is how many events a client may fall behind before it is disconnected.
+	streamBuffer = 64
+)
+
+// streamFilter selects the item events sent to a GET /items/stream client.
+type streamFilter struct {
+	category string
+	// keyword matches names containing it, ignoring ASCII case as Search does.
+	keyword        string
+	includeDeleted bool
+}
+
+// item returns the item an event is about when the client wants it.
+func (f streamFilter) item(e *Event) (*Item, bool) {
+	if e.Type != EventItemCreated && e.Type != EventItemUpdated && e.Type != EventItemSold {
+		return nil, false
+	}
+	var item Item
+	if err := json.Unmarshal(e.Payload, &item); err != nil {
+		return nil, false
+	}
+	if item.DeletedAt != nil && !f.includeDeleted {
+		return nil, false
+	}
+	if f.category != "" && item.Category != f.category {
+		return nil, false
+	}
+	if f.keyword != "" && !strings.Contains(asciiLower(item.Name), asciiLower(f.keyword)) {
+		return nil, false
+	}
+	return &item, true
+}
+
+// writeStreamEvent writes e in the text/event-stream format.
+func writeStreamEvent(w http.ResponseWriter, e *Event, item *Item) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
+
+// StreamItems is a handler to send item changes as Server-Sent Events for GET /items/stream .
+// Filters: category and keyword. Clients resume with the Last-Event-ID header (or the
+// last_event_id parameter) from the events the server still remembers. Clients too slow to keep
+// up are disconnected and can resume the same way.
+func (h *Handlers) StreamItems(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	include, ok := h.includeDeleted(w, r)
+	if !ok {
+		return
+	}
+	filter := streamFilter{
+		category:       r.URL.Query().Get("category"),
+		keyword:        r.URL.Query().Get("keyword"),
+		includeDeleted: include,
+	}
+	var lastID int64
+	if v := r.Header.Get("Last-Event-ID"); v != "" || r.URL.Query().Has("last_event_id") {
+		if v == "" {
+			v = r.URL.Query().Get("last_event_id")
+		}
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id < 0 {
+			http.Error(w, "Last-Event-ID must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		lastID = id
+	}
+
+	// 再接続でなければ過去のイベントは送らない
+	replay, events, cancel := h.events.Subscribe(lastID, streamBuffer)
+	defer cancel()
+	if lastID == 0 {
+		replay = nil
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// nginx などのプロキシにバッファさせない
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	// EventSource が再接続するまでの待ち時間 (ミリ秒)
+	fmt.Fprint(w, "retry: 3000\n\n")
+	for _, e := range replay {
+		if item, ok := filter.item(e); ok {
+			if err := writeStreamEvent(w, e, item); err != nil {
+				return
+			}
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := h.streamHeartbeat
+	if heartbeat <= 0 {
+		heartbeat = defaultStreamHeartbeat
+	}
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				// 追いつけないクライアントやサーバーの終了時は切断し、Last-Event-ID で再開してもらう
+				return
+			}
+			item, ok := filter.item(e)
+			if !ok {
+				continue
+			}
+			if err := writeStreamEvent(w, e, item); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openStream connects to GET /items/stream and returns a reader positioned after the retry field,
+// by which time the handler has subscribed.
+func openStream(t *testing.T, srv *httptest.Server, query, lastEventID string) *bufio.Reader {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/items/stream"+query, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected Content-Type %q", ct)
+	}
+	r := bufio.NewReader(resp.Body)
+	if line, _ := r.ReadString('\n'); !strings.HasPrefix(line, "retry:") {
+		t.Fatalf("expected the retry field first, got %q", line)
+	}
+	r.ReadString('\n')
+	return r
+}
+
+// readBlock reads the lines up to the next blank line.
+func readBlock(t *testing.T, r *bufio.Reader) []string {
+	t.Helper()
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("stream ended: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+func itemEvent(id int64, typ string, item Item) *Event {
+	payload, _ := json.Marshal(item)
+	return &Event{ID: id, Type: typ, Payload: payload}
+}
+
+func TestStreamItems(t *testing.T) {
+	t.Parallel()
+
+	newServer := func(t *testing.T) (*EventBus, *httptest.Server) {
+		bus := NewEventBus(DefaultStreamHistory)
+		h := &Handlers{events: bus, streamHeartbeat: time.Hour}
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /items/stream", h.StreamItems)
+		srv := httptest.NewServer(mux)
+		t.Cleanup(srv.Close)
+		return bus, srv
+	}
+	ctx := context.Background()
+
+	t.Run("ok: filtered by category and keyword", func(t *testing.T) {
+		t.Parallel()
+		bus, srv := newServer(t)
+		r := openStream(t, srv, "?category=fashion&keyword=JACK", "")
+
+		bus.Deliver(ctx, itemEvent(1, EventItemCreated, Item{ID: 1, Name: "camera", Category: "electronics"}))
+		bus.Deliver(ctx, &Event{ID: 2, Type: EventCategoryCreated, Payload: json.RawMessage(`{"id":3,"name":"fashion"}`)})
+		bus.Deliver(ctx, itemEvent(3, EventItemUpdated, Item{ID: 2, Name: "coat", Category: "fashion"}))
+		bus.Deliver(ctx, itemEvent(4, EventItemCreated, Item{ID: 3, Name: "Jacket", Category: "fashion"}))
+
+		got := readBlock(t, r)
+		if len(got) != 3 || got[0] != "id: 4" || got[1] != "event: ItemCreated" || !strings.Contains(got[2], `"name":"Jacket"`) {
+			t.Errorf("unexpected event %q", got)
+		}
+	})
+
+	t.Run("ok: resumes after Last-Event-ID", func(t *testing.T) {
+		t.Parallel()
+		bus, srv := newServer(t)
+		for i := range 3 {
+			bus.Deliver(ctx, itemEvent(int64(i+1), EventItemCreated, Item{ID: i + 1, Name: "jacket", Category: "fashion"}))
+		}
+		r := openStream(t, srv, "", "1")
+		for _, want := range []string{"id: 2", "id: 3"} {
+			if got := readBlock(t, r); got[0] != want {
+				t.Errorf("expected %q, got %q", want, got)
+			}
+		}
+	})
+
+	t.Run("ok: heartbeat while idle", func(t *testing.T) {
+		t.Parallel()
+		bus := NewEventBus(0)
+		h := &Handlers{events: bus, streamHeartbeat: 10 * time.Millisecond}
+		srv := httptest.NewServer(http.HandlerFunc(h.StreamItems))
+		t.Cleanup(srv.Close)
+		r := openStream(t, srv, "", "")
+		if got := readBlock(t, r); len(got) != 1 || got[0] != ": heartbeat" {
+			t.Errorf("expected a heartbeat comment, got %q", got)
+		}
+	})
+
+	t.Run("ng: invalid Last-Event-ID", func(t *testing.T) {
+		t.Parallel()
+		_, srv := newServer(t)
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/items/stream", nil)
+		req.Header.Set("Last-Event-ID", "abc")
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected status %d, got %d", http.StatusBadRequest, resp.StatusCode)
+		}
+	})
+}
+
+// stalledWriter is a ResponseWriter whose event writes block until release is closed,
+// as if the client stopped reading.
+type stalledWriter struct {
+	header  http.Header
+	release chan struct{}
+}
+
+func (w *stalledWriter) Header() http.Header { return w.header }
+func (w *stalledWriter) WriteHeader(int)     {}
+func (w *stalledWriter) Flush()              {}
+
+func (w *stalledWriter) Write(p []byte) (int, error) {
+	if strings.HasPrefix(string(p), "id:") {
+		<-w.release
+	}
+	return len(p), nil
+}
+
+func TestStreamItemsDisconnectsSlowClients(t *testing.T) {
+	t.Parallel()
+
+	bus := NewEventBus(0)
+	h := &Handlers{events: bus, streamHeartbeat: time.Hour}
+	w := &stalledWriter{header: http.Header{}, release: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		h.StreamItems(w, httptest.NewRequest(http.MethodGet, "/items/stream", nil))
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		bus.mu.Lock()
+		n := len(bus.subs)
+		bus.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the handler did not subscribe")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// 書き込みが止まっている間に、バッファを超える数のイベントを配っても Deliver は待たない
+	for i := range streamBuffer + 2 {
+		payload, _ := json.Marshal(Item{ID: i + 1, Name: "jacket"})
+		if err := bus.Deliver(context.Background(), &Event{ID: int64(i + 1), Type: EventItemCreated, Payload: payload}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bus.mu.Lock()
+	n := len(bus.subs)
+	bus.mu.Unlock()
+	if n != 0 {
+		t.Errorf("expected the slow client to be unsubscribed, %d subscribers left", n)
+	}
+
+	close(w.release)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the stream of the slow client to end")
+	}
+}
+
+func TestStreamItemsShutdown(t *testing.T) {
+	t.Parallel()
+
+	bus := NewEventBus(DefaultStreamHistory)
+	h := &Handlers{events: bus, streamHeartbeat: time.Hour}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/stream", h.StreamItems)
+	srv := httptest.NewUnstartedServer(mux)
+	srv.Config.RegisterOnShutdown(bus.Close)
+	srv.Start()
+	t.Cleanup(srv.Close)
+	r := openStream(t, srv, "", "")
+
+	// ストリームが開いたままでも Shutdown はすぐに終わる
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Config.Shutdown(ctx); err != nil {
+		t.Fatalf("failed to shut down with a stream open: %v", err)
+	}
+	if _, err := r.ReadString('\n'); err == nil {
+		t.Errorf("expected the stream to end")
+	}
+}
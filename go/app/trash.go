@@ -0,0 +1,264 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// DefaultTrashRetention is how long deleted items stay restorable before they are purged.
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+// TrashPurgeResult is the outcome of a PurgeTrash run.
+type TrashPurgeResult struct {
+	// Purged lists the items removed for good.
+	Purged []*Item
+	// RemovedImages lists the image files deleted because no other item uses them.
+	RemovedImages []string
+}
+
+// PurgeTrash removes items deleted more than retention before now, together with the image
+// files no remaining item references. Images written within DefaultImageGCGracePeriod are kept,
+// since an item being added may be about to use them; the image GC removes them later.
+func PurgeTrash(ctx context.Context, repo ItemRepository, imgDir string, retention time.Duration, now time.Time) (*TrashPurgeResult, error) {
+	purged, err := repo.Purge(ctx, now.Add(-retention))
+	if err != nil {
+		return nil, err
+	}
+	result := &TrashPurgeResult{Purged: purged}
+	if len(purged) == 0 {
+		return result, nil
+	}
+
+	// 同じ画像を使う商品が残っていれば消さない
+	refs, err := repo.ImageReferences(ctx)
+	if err != nil {
+		return result, err
+	}
+	cutoff := now.Add(-DefaultImageGCGracePeriod)
+	for _, item := range purged {
+		name := item.ImageName
+		if refs[name] > 0 || name == defaultImageName || !storedImagePattern.MatchString(name) {
+			continue
+		}
+		// 複数の商品が同じ画像を使っていた場合に二重に消さない
+		refs[name] = 1
+
+		path := filepath.Join(imgDir, name)
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to stat image: %w", err)
+		}
+		if info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return result, fmt.Errorf("failed to remove image: %w", err)
+		}
+		result.RemovedImages = append(result.RemovedImages, name)
+	}
+	return result, nil
+}
+
+// TrashPurgeJob is the periodic job purging items deleted longer ago than the retention.
+const TrashPurgeJob JobType[struct{}] = "trash_purge"
+
+// trashPurgeJob returns the TrashPurgeJob handler purging the trash and the images in imgDir.
+func trashPurgeJob(repo ItemRepository, imgDir string, retention time.Duration, now func() time.Time) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		result, err := PurgeTrash(ctx, repo, imgDir, retention, now())
+		if err != nil {
+			return fmt.Errorf("failed to purge deleted items: %w", err)
+		}
+		if len(result.Purged) > 0 {
+			slog.Info("deleted items purged", "count", len(result.Purged), "images", len(result.RemovedImages))
+		}
+		return nil
+	}
+}
+
+// includeDeleted reports whether r asks for deleted items with include_deleted=true.
+// Only admins may ask; otherwise an error is written and ok is false.
+func (h *Handlers) includeDeleted(w http.ResponseWriter, r *http.Request) (include, ok bool) {
+	v := r.URL.Query().Get("include_deleted")
+	if v == "" {
+		return false, true
+	}
+	include, err := strconv.ParseBool(v)
+	if err != nil {
+		http.Error(w, "include_deleted must be a boolean", http.StatusBadRequest)
+		return false, false
+	}
+	if include && !h.isAdmin(r.Context()) {
+		http.Error(w, "only admins can see deleted items", http.StatusForbidden)
+		return false, false
+	}
+	return include, true
+}
+
+// selectIncludingDeleted returns an item whether or not it is in the trash.
+func (h *Handlers) selectIncludingDeleted(ctx context.Context, id int) (*Item, error) {
+	items, err := h.itemRepo.Find(ctx, ItemQuery{ID: id, IncludeDeleted: true})
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, ErrItemNotFound
+	}
+	return items[0], nil
+}
+
+// checkDeletable returns an error unless item may be moved to the trash: sold items, items
+// reserved by an accepted offer and auctions with bids are being bought.
+func (h *Handlers) checkDeletable(ctx context.Context, item *Item) error {
+	if item.SoldAt != nil {
+		return ErrItemSold
+	}
+	if item.ListingType == ListingAuction {
+		a, err := h.auctions.GetAuction(ctx, item.ID)
+		if err != nil {
+			return err
+		}
+		if a.ClosedAt == nil && a.BidCount > 0 {
+			return ErrAuctionHasBids
+		}
+		return nil
+	}
+	offers, err := h.offers.ListOffers(ctx, OfferQuery{ItemID: item.ID})
+	if err != nil {
+		return err
+	}
+	now := h.now()
+	for _, o := range offers {
+		if o.Status == OfferAccepted && o.open(now) {
+			return ErrItemReserved
+		}
+	}
+	return nil
+}
+
+// DeleteItem is a handler to move an item to the trash for DELETE /items/{id} .
+// Sellers can delete their own items and admins any item. When If-Match is sent, the item is
+// only deleted if it did not change since it was read (412 otherwise). Items being bought, which
+// are sold, reserved by an accepted offer or auctions with bids, cannot be deleted (409).
+func (h *Handlers) DeleteItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		return
+	}
+
+	userID, signedIn := userIDFromContext(ctx)
+	if !signedIn {
+		http.Error(w, "sign in to delete items", http.StatusUnauthorized)
+		return
+	}
+	item, err := h.itemRepo.Select(ctx, id)
+	if err != nil {
+		if errors.Is(err, ErrItemNotFound) {
+			http.Error(w, "Item not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to get item", http.StatusInternalServerError)
+		return
+	}
+	// 出品者のいない商品は管理者だけが削除できる
+	if (item.SellerID == 0 || item.SellerID != userID) && !h.isAdmin(ctx) {
+		http.Error(w, "only the seller can delete this item", http.StatusForbidden)
+		return
+	}
+	if !checkIfMatch(w, r, item) {
+		return
+	}
+	if err := h.checkDeletable(ctx, item); err != nil {
+		switch {
+		case errors.Is(err, ErrItemSold), errors.Is(err, ErrItemReserved), errors.Is(err, ErrAuctionHasBids):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			slog.Error("failed to check item before deleting", "id", id, "error", err)
+			http.Error(w, "failed to delete item", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := h.itemRepo.Delete(ctx, id, ifMatchVersion(r, item)); err != nil {
+		switch {
+		case errors.Is(err, ErrVersionConflict):
+			http.Error(w, "item has been modified", http.StatusPreconditionFailed)
+		case errors.Is(err, ErrItemNotFound):
+			http.Error(w, "Item not found", http.StatusNotFound)
+		default:
+			slog.Error("failed to delete item", "id", id, "error", err)
+			http.Error(w, "failed to delete item", http.StatusInternalServerError)
+		}
+		return
+	}
+	slog.Info("item moved to trash", "id", id, "user_id", userID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RestoreItem is a handler to take an item out of the trash for POST /items/{id}/restore .
+// Only admins can restore items. If-Match is checked against the item in the trash as for DeleteItem.
+func (h *Handlers) RestoreItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		return
+	}
+	if !h.isAdmin(ctx) {
+		http.Error(w, "only admins can restore items", http.StatusForbidden)
+		return
+	}
+
+	trashed, err := h.selectIncludingDeleted(ctx, id)
+	if err == nil && trashed.DeletedAt == nil {
+		err = ErrItemNotFound
+	}
+	if err != nil {
+		if errors.Is(err, ErrItemNotFound) {
+			http.Error(w, "Item not found in trash", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to get item", http.StatusInternalServerError)
+		return
+	}
+	if !checkIfMatch(w, r, trashed) {
+		return
+	}
+
+	if err := h.itemRepo.Restore(ctx, id, ifMatchVersion(r, trashed)); err != nil {
+		switch {
+		case errors.Is(err, ErrVersionConflict):
+			http.Error(w, "item has been modified", http.StatusPreconditionFailed)
+		case errors.Is(err, ErrItemNotFound):
+			http.Error(w, "Item not found in trash", http.StatusNotFound)
+		default:
+			slog.Error("failed to restore item", "id", id, "error", err)
+			http.Error(w, "failed to restore item", http.StatusInternalServerError)
+		}
+		return
+	}
+	item, err := h.itemRepo.Select(ctx, id)
+	if err != nil {
+		http.Error(w, "failed to get item", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", itemETag(item))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(item)
+}
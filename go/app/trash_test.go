@@ -0,0 +1,319 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPurgeTrash(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	repo := NewMemoryItemRepository()
+	old := time.Now().Add(-2 * DefaultImageGCGracePeriod)
+
+	shared := "1111111111111111111111111111111111111111111111111111111111111111.jpg"
+	only := "2222222222222222222222222222222222222222222222222222222222222222.jpg"
+	for _, name := range []string{shared, only, defaultImageName} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("image"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, old, old)
+	}
+
+	items := []*Item{
+		{Name: "kept", Category: "fashion", ImageName: shared},
+		{Name: "shares an image", Category: "fashion", ImageName: shared},
+		{Name: "own image", Category: "fashion", ImageName: only},
+		{Name: "default image", Category: "fashion", ImageName: defaultImageName},
+	}
+	for _, item := range items {
+		if err := repo.Insert(ctx, item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, item := range items[1:] {
+		if err := repo.Delete(ctx, item.ID, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 保持期間内なら何も消さない
+	result, err := PurgeTrash(ctx, repo, dir, time.Hour, time.Now())
+	if err != nil {
+		t.Fatalf("PurgeTrash failed: %v", err)
+	}
+	if len(result.Purged) != 0 {
+		t.Errorf("expected nothing to be purged within the retention, got %d items", len(result.Purged))
+	}
+
+	result, err = PurgeTrash(ctx, repo, dir, time.Hour, time.Now().Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("PurgeTrash failed: %v", err)
+	}
+	if len(result.Purged) != 3 {
+		t.Errorf("expected 3 purged items, got %d", len(result.Purged))
+	}
+	if diff := cmp.Diff([]string{only}, result.RemovedImages); diff != "" {
+		t.Errorf("unexpected removed images (-want +got):\n%s", diff)
+	}
+	for _, name := range []string{shared, defaultImageName} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected %s to be kept: %v", name, err)
+		}
+	}
+}
+
+func TestDeleteAndRestoreItem(t *testing.T) {
+	t.Parallel()
+
+	const (
+		seller = 1
+		other  = 2
+		admin  = 3
+	)
+	type step struct {
+		method string
+		path   string
+		userID int
+		status int
+	}
+
+	cases := map[string][]step{
+		"ok: seller deletes and admin restores": {
+			{http.MethodDelete, "/items/1", seller, http.StatusNoContent},
+			{http.MethodGet, "/items/1", seller, http.StatusNotFound},
+			{http.MethodGet, "/items/1?include_deleted=true", admin, http.StatusOK},
+			{http.MethodPost, "/items/1/restore", admin, http.StatusOK},
+			{http.MethodGet, "/items/1", seller, http.StatusOK},
+		},
+		"ng: other users cannot delete": {
+			{http.MethodDelete, "/items/1", other, http.StatusForbidden},
+			{http.MethodDelete, "/items/1", 0, http.StatusUnauthorized},
+		},
+		"ok: admin deletes any item": {
+			{http.MethodDelete, "/items/1", admin, http.StatusNoContent},
+			{http.MethodDelete, "/items/1", admin, http.StatusNotFound},
+		},
+		"ng: only admins see and restore deleted items": {
+			{http.MethodDelete, "/items/1", seller, http.StatusNoContent},
+			{http.MethodGet, "/items?include_deleted=true", seller, http.StatusForbidden},
+			{http.MethodGet, "/search?keyword=jacket&include_deleted=true", other, http.StatusForbidden},
+			{http.MethodPost, "/items/1/restore", seller, http.StatusForbidden},
+			{http.MethodPost, "/items/2/restore", admin, http.StatusNotFound},
+		},
+	}
+
+	for name, steps := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			repo := NewMemoryItemRepository()
+			ctx := context.Background()
+			repo.Insert(ctx, &Item{Name: "jacket", Category: "fashion", SellerID: seller})
+			repo.Insert(ctx, &Item{Name: "camera", Category: "electronics", SellerID: seller})
+
+			h := &Handlers{itemRepo: repo, offers: NewMemoryOfferRepository(repo), adminUserIDs: map[int]bool{admin: true}}
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /items", h.GetItems)
+			mux.HandleFunc("GET /items/{id}", h.GetItem)
+			mux.HandleFunc("DELETE /items/{id}", h.DeleteItem)
+			mux.HandleFunc("POST /items/{id}/restore", h.RestoreItem)
+			mux.HandleFunc("GET /search", h.SearchItems)
+
+			for _, s := range steps {
+				req := httptest.NewRequest(s.method, s.path, nil)
+				if s.userID != 0 {
+					req = req.WithContext(ContextWithUserID(req.Context(), s.userID))
+				}
+				rr := httptest.NewRecorder()
+				mux.ServeHTTP(rr, req)
+				if rr.Code != s.status {
+					t.Errorf("%s %s: expected status %d, got %d: %s", s.method, s.path, s.status, rr.Code, rr.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteAndRestoreItemIfMatch(t *testing.T) {
+	t.Parallel()
+
+	const (
+		seller = 1
+		admin  = 3
+	)
+	type step struct {
+		method  string
+		path    string
+		userID  int
+		ifMatch string
+		status  int
+	}
+
+	// 商品 1 は削除で v2、復元で v3 になる
+	cases := map[string][]step{
+		"ok: matching If-Match": {
+			{http.MethodDelete, "/items/1", seller, `"item-1-v1"`, http.StatusNoContent},
+			{http.MethodPost, "/items/1/restore", admin, `"item-1-v2"`, http.StatusOK},
+		},
+		"ng: stale If-Match on delete": {
+			{http.MethodDelete, "/items/1", seller, `"item-1-v0"`, http.StatusPreconditionFailed},
+			{http.MethodGet, "/items/1", seller, "", http.StatusOK},
+		},
+		"ng: stale If-Match on restore": {
+			{http.MethodDelete, "/items/1", seller, "", http.StatusNoContent},
+			{http.MethodPost, "/items/1/restore", admin, `"item-1-v1"`, http.StatusPreconditionFailed},
+			{http.MethodGet, "/items/1", seller, "", http.StatusNotFound},
+		},
+	}
+
+	for name, steps := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			repo := NewMemoryItemRepository()
+			repo.Insert(context.Background(), &Item{Name: "jacket", Category: "fashion", SellerID: seller})
+
+			h := &Handlers{itemRepo: repo, offers: NewMemoryOfferRepository(repo), adminUserIDs: map[int]bool{admin: true}}
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /items/{id}", h.GetItem)
+			mux.HandleFunc("DELETE /items/{id}", h.DeleteItem)
+			mux.HandleFunc("POST /items/{id}/restore", h.RestoreItem)
+
+			for _, s := range steps {
+				req := httptest.NewRequest(s.method, s.path, nil)
+				req = req.WithContext(ContextWithUserID(req.Context(), s.userID))
+				if s.ifMatch != "" {
+					req.Header.Set("If-Match", s.ifMatch)
+				}
+				rr := httptest.NewRecorder()
+				mux.ServeHTTP(rr, req)
+				if rr.Code != s.status {
+					t.Errorf("%s %s: expected status %d, got %d: %s", s.method, s.path, s.status, rr.Code, rr.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteItemBeingBought(t *testing.T) {
+	t.Parallel()
+
+	const seller, buyer = 1, 2
+	start := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	// 商品 1 は定額、商品 2 は 1 時間後に終わるオークション
+	cases := map[string]struct {
+		setup  func(t *testing.T, auctions AuctionRepository, offers OfferRepository)
+		path   string
+		status int
+	}{
+		"ok: pending offer": {
+			setup: func(t *testing.T, _ AuctionRepository, offers OfferRepository) {
+				mustCreateOffer(t, offers, start.Add(-time.Hour), start.Add(time.Hour))
+			},
+			path:   "/items/1",
+			status: http.StatusNoContent,
+		},
+		"ok: lapsed reservation": {
+			setup: func(t *testing.T, _ AuctionRepository, offers OfferRepository) {
+				o := mustCreateOffer(t, offers, start.Add(-3*time.Hour), start)
+				if _, err := offers.TransitionOffer(context.Background(), o.ID, OfferTransition{Action: OfferActionAccept, ActorID: seller,
+					At: start.Add(-2 * time.Hour), Until: start.Add(-time.Hour)}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			path:   "/items/1",
+			status: http.StatusNoContent,
+		},
+		"ng: accepted offer": {
+			setup: func(t *testing.T, _ AuctionRepository, offers OfferRepository) {
+				o := mustCreateOffer(t, offers, start.Add(-time.Hour), start.Add(time.Hour))
+				if _, err := offers.TransitionOffer(context.Background(), o.ID, OfferTransition{Action: OfferActionAccept, ActorID: seller,
+					At: start, Until: start.Add(time.Hour)}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			path:   "/items/1",
+			status: http.StatusConflict,
+		},
+		"ng: sold": {
+			setup: func(t *testing.T, _ AuctionRepository, offers OfferRepository) {
+				ctx := context.Background()
+				o := mustCreateOffer(t, offers, start.Add(-time.Hour), start.Add(time.Hour))
+				if _, err := offers.TransitionOffer(ctx, o.ID, OfferTransition{Action: OfferActionAccept, ActorID: seller,
+					At: start, Until: start.Add(time.Hour)}); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := offers.TransitionOffer(ctx, o.ID, OfferTransition{Action: OfferActionCheckout, ActorID: buyer, At: start}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			path:   "/items/1",
+			status: http.StatusConflict,
+		},
+		"ok: auction without bids": {
+			path:   "/items/2",
+			status: http.StatusNoContent,
+		},
+		"ng: auction with bids": {
+			setup: func(t *testing.T, auctions AuctionRepository, _ OfferRepository) {
+				if _, err := auctions.PlaceBid(context.Background(), &Bid{ItemID: 2, BidderID: buyer, Amount: 1000}, start); err != nil {
+					t.Fatal(err)
+				}
+			},
+			path:   "/items/2",
+			status: http.StatusConflict,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			repo := NewMemoryItemRepository()
+			if err := repo.Insert(ctx, &Item{Name: "camera", Category: "camera", SellerID: seller, Price: 10000}); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.Insert(ctx, &Item{Name: "lens", Category: "camera", SellerID: seller, ListingType: ListingAuction,
+				Auction: &Auction{StartPrice: 1000, MinIncrement: 100, EndsAt: start.Add(time.Hour)}}); err != nil {
+				t.Fatal(err)
+			}
+			auctions, offers := NewMemoryAuctionRepository(repo), NewMemoryOfferRepository(repo)
+			if tc.setup != nil {
+				tc.setup(t, auctions, offers)
+			}
+			h := &Handlers{itemRepo: repo, auctions: auctions, offers: offers, clock: func() time.Time { return start }}
+
+			req := httptest.NewRequest(http.MethodDelete, tc.path, nil)
+			req.SetPathValue("id", tc.path[len("/items/"):])
+			req = req.WithContext(ContextWithUserID(req.Context(), seller))
+			rr := httptest.NewRecorder()
+			h.DeleteItem(rr, req)
+			if rr.Code != tc.status {
+				t.Fatalf("expected status %d, got %d: %s", tc.status, rr.Code, rr.Body)
+			}
+		})
+	}
+}
+
+// mustCreateOffer stores an offer of 8000 yen by user 2 on item 1, made at createdAt and
+// expiring at expiresAt.
+func mustCreateOffer(t *testing.T, offers OfferRepository, createdAt, expiresAt time.Time) *Offer {
+	t.Helper()
+	o := &Offer{ItemID: 1, BuyerID: 2, Amount: 8000, ExpiresAt: expiresAt}
+	if err := offers.CreateOffer(context.Background(), o, createdAt); err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
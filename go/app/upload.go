@@ -0,0 +1,439 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tus 1.0 のレジューム可能アップロード
+// https://tus.io/protocols/resumable-upload
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,expiration"
+
+	// defaultMaxUploadSize is the largest upload accepted by POST /uploads.
+	defaultMaxUploadSize = 32 << 20
+	// defaultUploadTTL is how long an upload is kept after its last PATCH.
+	defaultUploadTTL = 24 * time.Hour
+)
+
+var (
+	errUploadNotFound   = errors.New("upload not found")
+	errUploadIncomplete = errors.New("upload is not complete")
+	errUploadOffset     = errors.New("upload offset mismatch")
+	errUploadTooLarge   = errors.New("upload exceeds declared length")
+
+	uploadIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+)
+
+// uploadInfo is the metadata persisted next to the partial upload data.
+type uploadInfo struct {
+	ID        string    `json:"id"`
+	Length    int64     `json:"length"`
+	Offset    int64     `json:"offset"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// uploadStore keeps partial uploads on disk as {id}.bin / {id}.info pairs.
+type uploadStore struct {
+	dir     string
+	maxSize int64
+	ttl     time.Duration
+	now     func() time.Time
+
+	// mu guards locks only. Each upload has its own lock, so that a slow PATCH holds up
+	// nothing but the requests for the same upload.
+	mu    sync.Mutex
+	locks map[string]*uploadLock
+}
+
+// uploadLock serializes the writes to one upload. refs counts the holders and waiters, so that
+// the lock is forgotten when nobody uses it.
+type uploadLock struct {
+	sync.Mutex
+	refs int
+}
+
+// newUploadStore creates an uploadStore rooted at dir.
+func newUploadStore(dir string) *uploadStore {
+	return &uploadStore{
+		dir:     dir,
+		maxSize: defaultMaxUploadSize,
+		ttl:     defaultUploadTTL,
+		now:     time.Now,
+		locks:   make(map[string]*uploadLock),
+	}
+}
+
+// lock takes the lock of upload id and returns the function releasing it.
+func (u *uploadStore) lock(id string) func() {
+	u.mu.Lock()
+	l, ok := u.locks[id]
+	if !ok {
+		l = &uploadLock{}
+		u.locks[id] = l
+	}
+	l.refs++
+	u.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		u.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(u.locks, id)
+		}
+		u.mu.Unlock()
+	}
+}
+
+func (u *uploadStore) dataPath(id string) string {
+	return filepath.Join(u.dir, id+".bin")
+}
+
+func (u *uploadStore) infoPath(id string) string {
+	return filepath.Join(u.dir, id+".info")
+}
+
+func (u *uploadStore) readInfo(id string) (*uploadInfo, error) {
+	if !uploadIDPattern.MatchString(id) {
+		return nil, errUploadNotFound
+	}
+	b, err := os.ReadFile(u.infoPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errUploadNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload info: %w", err)
+	}
+	var info uploadInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		return nil, fmt.Errorf("failed to decode upload info: %w", err)
+	}
+	if u.now().After(info.ExpiresAt) {
+		return nil, errUploadNotFound
+	}
+	return &info, nil
+}
+
+func (u *uploadStore) writeInfo(info *uploadInfo) error {
+	b, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("failed to encode upload info: %w", err)
+	}
+	// 書きかけのメタデータを Get が読まないように、一時ファイルを置き換える
+	tmp := u.infoPath(info.ID) + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return fmt.Errorf("failed to write upload info: %w", err)
+	}
+	if err := os.Rename(tmp, u.infoPath(info.ID)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write upload info: %w", err)
+	}
+	return nil
+}
+
+// Create starts a new upload of the given length.
+func (u *uploadStore) Create(length int64) (*uploadInfo, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("failed to generate upload id: %w", err)
+	}
+	info := &uploadInfo{
+		ID:        hex.EncodeToString(buf),
+		Length:    length,
+		ExpiresAt: u.now().Add(u.ttl),
+	}
+
+	unlock := u.lock(info.ID)
+	defer unlock()
+
+	if err := os.WriteFile(u.dataPath(info.ID), nil, 0644); err != nil {
+		return nil, fmt.Errorf("failed to create upload: %w", err)
+	}
+	if err := u.writeInfo(info); err != nil {
+		os.Remove(u.dataPath(info.ID))
+		return nil, err
+	}
+	return info, nil
+}
+
+// Get returns the current state of an upload. It does not wait for a PATCH in progress, so a
+// client can check the offset while its previous connection is still being read.
+func (u *uploadStore) Get(id string) (*uploadInfo, error) {
+	return u.readInfo(id)
+}
+
+// Append writes a chunk at offset and returns the updated upload state.
+func (u *uploadStore) Append(id string, offset int64, chunk io.Reader) (*uploadInfo, error) {
+	if !uploadIDPattern.MatchString(id) {
+		return nil, errUploadNotFound
+	}
+	unlock := u.lock(id)
+	defer unlock()
+
+	info, err := u.readInfo(id)
+	if err != nil {
+		return nil, err
+	}
+	if info.Offset != offset {
+		return info, errUploadOffset
+	}
+
+	f, err := os.OpenFile(u.dataPath(id), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload: %w", err)
+	}
+	defer f.Close()
+
+	// 宣言された長さを 1 バイトでも超えたらエラーにする
+	remaining := info.Length - info.Offset
+	n, err := io.Copy(f, io.LimitReader(chunk, remaining+1))
+	if n > remaining {
+		f.Truncate(info.Offset)
+		return info, errUploadTooLarge
+	}
+	// 途中で切断されても受信できた分はオフセットに反映する
+	info.Offset += n
+	info.ExpiresAt = u.now().Add(u.ttl)
+	if werr := u.writeInfo(info); werr != nil {
+		return nil, werr
+	}
+	if err != nil {
+		return info, fmt.Errorf("failed to write chunk: %w", err)
+	}
+	return info, nil
+}
+
+// Read returns the data of a completed upload.
+func (u *uploadStore) Read(id string) ([]byte, error) {
+	if !uploadIDPattern.MatchString(id) {
+		return nil, errUploadNotFound
+	}
+	unlock := u.lock(id)
+	defer unlock()
+
+	info, err := u.readInfo(id)
+	if err != nil {
+		return nil, err
+	}
+	if info.Offset != info.Length {
+		return nil, errUploadIncomplete
+	}
+	data, err := os.ReadFile(u.dataPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload: %w", err)
+	}
+	return data, nil
+}
+
+// Delete removes an upload and its metadata.
+func (u *uploadStore) Delete(id string) error {
+	if !uploadIDPattern.MatchString(id) {
+		return errUploadNotFound
+	}
+
+	unlock := u.lock(id)
+	defer unlock()
+
+	if err := os.Remove(u.infoPath(id)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return errUploadNotFound
+		}
+		return fmt.Errorf("failed to delete upload: %w", err)
+	}
+	os.Remove(u.dataPath(id))
+	return nil
+}
+
+// Sweep deletes uploads whose expiration time has passed and returns how many were removed.
+func (u *uploadStore) Sweep() (int, error) {
+	entries, err := os.ReadDir(u.dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read upload dir: %w", err)
+	}
+	removed := 0
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".info")
+		if !ok || !uploadIDPattern.MatchString(id) {
+			continue
+		}
+		if u.sweep(id) {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// sweep deletes upload id if it has expired. A PATCH in progress is waited for, since it
+// extends the expiration time.
+func (u *uploadStore) sweep(id string) bool {
+	unlock := u.lock(id)
+	defer unlock()
+
+	if _, err := u.readInfo(id); !errors.Is(err, errUploadNotFound) {
+		return false
+	}
+	os.Remove(u.infoPath(id))
+	os.Remove(u.dataPath(id))
+	return true
+}
+
+// sweepExpired removes the expired uploads. Every server polls it, since each has its own directory.
+func (u *uploadStore) sweepExpired(ctx context.Context) error {
+	n, err := u.Sweep()
+	if err != nil {
+		return fmt.Errorf("failed to sweep expired uploads: %w", err)
+	}
+	if n > 0 {
+		slog.Info("expired uploads removed", "count", n)
+	}
+	return nil
+}
+
+// setTusHeaders sets the headers every tus response must carry.
+func setTusHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// checkTusResumable rejects requests from clients speaking another protocol version.
+func checkTusResumable(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+func setUploadExpires(w http.ResponseWriter, info *uploadInfo) {
+	w.Header().Set("Upload-Expires", info.ExpiresAt.UTC().Format(http.TimeFormat))
+}
+
+// UploadOptions is a handler to advertise tus capabilities for OPTIONS /uploads .
+func (s *Handlers) UploadOptions(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(s.uploads.maxSize, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateUpload is a handler to start a resumable upload for POST /uploads .
+func (s *Handlers) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		http.Error(w, "Upload-Length must be a positive integer", http.StatusBadRequest)
+		return
+	}
+	if length > s.uploads.maxSize {
+		http.Error(w, "upload is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	info, err := s.uploads.Create(length)
+	if err != nil {
+		slog.Error("failed to create upload", "error", err)
+		http.Error(w, "failed to create upload", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("Upload created", "upload_id", info.ID, "length", info.Length)
+	w.Header().Set("Location", "/uploads/"+info.ID)
+	setUploadExpires(w, info)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// HeadUpload is a handler to return the current offset for HEAD /uploads/{id} .
+func (s *Handlers) HeadUpload(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	info, err := s.uploads.Get(r.PathValue("id"))
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(info.Length, 10))
+	setUploadExpires(w, info)
+	w.WriteHeader(http.StatusOK)
+}
+
+// PatchUpload is a handler to append a chunk for PATCH /uploads/{id} .
+func (s *Handlers) PatchUpload(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+	if !checkTusResumable(w, r) {
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Upload-Offset must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+
+	info, err := s.uploads.Append(r.PathValue("id"), offset, r.Body)
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	setUploadExpires(w, info)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteUpload is a handler to terminate an upload for DELETE /uploads/{id} .
+func (s *Handlers) DeleteUpload(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	if err := s.uploads.Delete(r.PathValue("id")); err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeUploadError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errUploadNotFound):
+		http.Error(w, "upload not found", http.StatusNotFound)
+	case errors.Is(err, errUploadOffset):
+		http.Error(w, "Upload-Offset does not match current offset", http.StatusConflict)
+	case errors.Is(err, errUploadTooLarge):
+		http.Error(w, "chunk exceeds Upload-Length", http.StatusRequestEntityTooLarge)
+	default:
+		slog.Error("upload failed", "error", err)
+		http.Error(w, "upload failed", http.StatusInternalServerError)
+	}
+}
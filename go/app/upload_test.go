@@ -0,0 +1,266 @@
+package app
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/mock/gomock"
+)
+
+func newTusRequest(method, target string, body []byte) *http.Request {
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	req.Header.Set("Tus-Resumable", tusVersion)
+	return req
+}
+
+func TestResumableUpload(t *testing.T) {
+	t.Parallel()
+
+	dummyImageData, err := loadTestImage()
+	if err != nil {
+		t.Fatalf("failed to load test image: %v", err)
+	}
+
+	h := &Handlers{uploads: newUploadStore(t.TempDir())}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /uploads", h.CreateUpload)
+	mux.HandleFunc("HEAD /uploads/{id}", h.HeadUpload)
+	mux.HandleFunc("PATCH /uploads/{id}", h.PatchUpload)
+	mux.HandleFunc("DELETE /uploads/{id}", h.DeleteUpload)
+
+	// creation
+	req := newTusRequest("POST", "/uploads", nil)
+	req.Header.Set("Upload-Length", strconv.Itoa(len(dummyImageData)))
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d, got %d", http.StatusCreated, res.Code)
+	}
+	location := res.Header().Get("Location")
+	if !strings.HasPrefix(location, "/uploads/") {
+		t.Fatalf("unexpected Location header: %q", location)
+	}
+
+	patch := func(offset int, chunk []byte) *httptest.ResponseRecorder {
+		req := newTusRequest("PATCH", location, chunk)
+		req.Header.Set("Content-Type", "application/offset+octet-stream")
+		req.Header.Set("Upload-Offset", strconv.Itoa(offset))
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		return res
+	}
+	head := func() string {
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, newTusRequest("HEAD", location, nil))
+		if res.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, res.Code)
+		}
+		return res.Header().Get("Upload-Offset")
+	}
+
+	// first chunk, then the connection "drops"
+	half := len(dummyImageData) / 2
+	if res := patch(0, dummyImageData[:half]); res.Code != http.StatusNoContent {
+		t.Fatalf("expected status code %d, got %d", http.StatusNoContent, res.Code)
+	}
+	if got := head(); got != strconv.Itoa(half) {
+		t.Errorf("expected offset %d, got %s", half, got)
+	}
+
+	// resuming from a stale offset is rejected
+	if res := patch(0, dummyImageData); res.Code != http.StatusConflict {
+		t.Errorf("expected status code %d, got %d", http.StatusConflict, res.Code)
+	}
+
+	// resume from the reported offset
+	if res := patch(half, dummyImageData[half:]); res.Code != http.StatusNoContent {
+		t.Fatalf("expected status code %d, got %d", http.StatusNoContent, res.Code)
+	}
+	if got := head(); got != strconv.Itoa(len(dummyImageData)) {
+		t.Errorf("expected offset %d, got %s", len(dummyImageData), got)
+	}
+
+	got, err := h.uploads.Read(strings.TrimPrefix(location, "/uploads/"))
+	if err != nil {
+		t.Fatalf("failed to read upload: %v", err)
+	}
+	if !bytes.Equal(got, dummyImageData) {
+		t.Errorf("uploaded data mismatch")
+	}
+
+	// termination
+	res = httptest.NewRecorder()
+	mux.ServeHTTP(res, newTusRequest("DELETE", location, nil))
+	if res.Code != http.StatusNoContent {
+		t.Errorf("expected status code %d, got %d", http.StatusNoContent, res.Code)
+	}
+	res = httptest.NewRecorder()
+	mux.ServeHTTP(res, newTusRequest("HEAD", location, nil))
+	if res.Code != http.StatusNotFound {
+		t.Errorf("expected status code %d, got %d", http.StatusNotFound, res.Code)
+	}
+}
+
+func TestUploadStoreSweep(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)
+	u := newUploadStore(t.TempDir())
+	u.now = func() time.Time { return now }
+
+	info, err := u.Create(10)
+	if err != nil {
+		t.Fatalf("failed to create upload: %v", err)
+	}
+
+	if n, _ := u.Sweep(); n != 0 {
+		t.Errorf("expected no uploads to be swept, got %d", n)
+	}
+
+	now = now.Add(defaultUploadTTL + time.Second)
+	if n, _ := u.Sweep(); n != 1 {
+		t.Errorf("expected 1 upload to be swept, got %d", n)
+	}
+	if _, err := u.Get(info.ID); err != errUploadNotFound {
+		t.Errorf("expected errUploadNotFound, got %v", err)
+	}
+}
+
+func TestUploadStoreSlowAppend(t *testing.T) {
+	t.Parallel()
+
+	u := newUploadStore(t.TempDir())
+	slow, err := u.Create(10)
+	if err != nil {
+		t.Fatalf("failed to create upload: %v", err)
+	}
+	other, err := u.Create(3)
+	if err != nil {
+		t.Fatalf("failed to create upload: %v", err)
+	}
+
+	// 回線の遅いクライアントの PATCH が本文を送り終えないまま止まっている
+	body, writer := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := u.Append(slow.ID, 0, body)
+		done <- err
+	}()
+	writer.Write([]byte("abc"))
+
+	// 他のアップロードは待たされない
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		if _, err := u.Append(other.ID, 0, strings.NewReader("xyz")); err != nil {
+			t.Errorf("failed to append: %v", err)
+		}
+		if _, err := u.Read(other.ID); err != nil {
+			t.Errorf("failed to read: %v", err)
+		}
+		if _, err := u.Create(1); err != nil {
+			t.Errorf("failed to create upload: %v", err)
+		}
+		if _, err := u.Get(slow.ID); err != nil {
+			t.Errorf("failed to get the upload being written: %v", err)
+		}
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("other uploads waited for a slow PATCH")
+	}
+
+	writer.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	info, err := u.Get(slow.ID)
+	if err != nil || info.Offset != 3 {
+		t.Errorf("expected offset 3 after the slow PATCH, got %+v, %v", info, err)
+	}
+}
+
+func TestAddItemWithUploadID(t *testing.T) {
+	t.Parallel()
+
+	dummyImageData, err := loadTestImage()
+	if err != nil {
+		t.Fatalf("failed to load test image: %v", err)
+	}
+
+	uploads := newUploadStore(t.TempDir())
+	complete, err := uploads.Create(int64(len(dummyImageData)))
+	if err != nil {
+		t.Fatalf("failed to create upload: %v", err)
+	}
+	if _, err := uploads.Append(complete.ID, 0, bytes.NewReader(dummyImageData)); err != nil {
+		t.Fatalf("failed to append upload: %v", err)
+	}
+	partial, err := uploads.Create(int64(len(dummyImageData)))
+	if err != nil {
+		t.Fatalf("failed to create upload: %v", err)
+	}
+
+	cases := map[string]struct {
+		uploadID   string
+		setupMocks func(m *MockItemRepository)
+		code       int
+	}{
+		"ok: completed upload": {
+			uploadID: complete.ID,
+			setupMocks: func(m *MockItemRepository) {
+				m.EXPECT().GetCategoryByName(gomock.Any(), "phone").Return(&Category{ID: 1, Name: "phone"}, nil)
+				m.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(nil)
+			},
+			code: http.StatusOK,
+		},
+		"ng: incomplete upload": {
+			uploadID: partial.ID,
+			code:     http.StatusBadRequest,
+		},
+		"ng: unknown upload": {
+			uploadID: strings.Repeat("0", 32),
+			code:     http.StatusBadRequest,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mockRepo := NewMockItemRepository(ctrl)
+			if tt.setupMocks != nil {
+				tt.setupMocks(mockRepo)
+			}
+			h := &Handlers{imgDirPath: t.TempDir(), itemRepo: mockRepo, uploads: uploads}
+
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			writer.WriteField("name", "used iPhone 16e")
+			writer.WriteField("category", "phone")
+			writer.WriteField("upload_id", tt.uploadID)
+			writer.Close()
+
+			req := httptest.NewRequest("POST", "/items", body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			res := httptest.NewRecorder()
+			h.AddItem(res, req)
+
+			if res.Code != tt.code {
+				t.Errorf("expected status code %d, got %d: %s", tt.code, res.Code, res.Body.String())
+			}
+		})
+	}
+
+	// the consumed upload is removed
+	if _, err := uploads.Get(complete.ID); err != errUploadNotFound {
+		t.Errorf("expected consumed upload to be deleted, got %v", err)
+	}
+}
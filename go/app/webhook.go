@@ -0,0 +1,445 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrWebhookNotFound is returned by WebhookRepository when no subscription has the requested ID.
+	ErrWebhookNotFound = errors.New("webhook subscription not found")
+	// ErrWebhookDeliveryNotFound is returned by WebhookRepository when no delivery has the requested ID.
+	ErrWebhookDeliveryNotFound = errors.New("webhook delivery not found")
+)
+
+// Webhook delivery statuses.
+const (
+	WebhookPending   = "pending"
+	WebhookSucceeded = "succeeded"
+	// WebhookDead marks deliveries which failed DefaultWebhookMaxAttempts times. They stay in the
+	// dead-letter list until they are redelivered by hand.
+	WebhookDead = "dead"
+)
+
+// WebhookSubscription asks for the domain events matching its filters to be POSTed to URL.
+type WebhookSubscription struct {
+	ID      int    `json:"id"`
+	OwnerID int    `json:"owner_id"`
+	URL     string `json:"url"`
+	// Secret signs the deliveries. It is only returned when the subscription is created.
+	Secret string `json:"secret,omitempty"`
+	// EventTypes and Categories filter the events. Empty lists match every event.
+	EventTypes []string  `json:"event_types"`
+	Categories []string  `json:"categories"`
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// matches reports whether an event of eventType about category is wanted.
+func (s *WebhookSubscription) matches(eventType, category string) bool {
+	if !s.Active {
+		return false
+	}
+	if len(s.EventTypes) > 0 && !slices.Contains(s.EventTypes, eventType) {
+		return false
+	}
+	return len(s.Categories) == 0 || slices.Contains(s.Categories, category)
+}
+
+// WebhookDelivery is one event to be POSTed to one subscription.
+type WebhookDelivery struct {
+	ID             int64  `json:"id"`
+	SubscriptionID int    `json:"subscription_id"`
+	EventID        int64  `json:"event_id"`
+	EventType      string `json:"event_type"`
+	// Payload is the request body: the Event encoded as JSON.
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	// ResponseStatus is the HTTP status of the last attempt. Zero means no response was received.
+	ResponseStatus int        `json:"response_status,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+}
+
+// WebhookDeliveryQuery filters WebhookRepository.ListDeliveries. Zero fields do not filter.
+type WebhookDeliveryQuery struct {
+	SubscriptionID int
+	// OwnerID returns the deliveries of every subscription of the user.
+	OwnerID int
+	Status  string
+	// BeforeID returns deliveries older than this ID, for paging.
+	BeforeID int64
+	// Limit caps the number of deliveries. It defaults to defaultAuditLimit.
+	Limit int
+}
+
+// WebhookRepository stores webhook subscriptions and their deliveries.
+type WebhookRepository interface {
+	CreateSubscription(ctx context.Context, s *WebhookSubscription) error
+	// GetSubscription returns ErrWebhookNotFound when there is no such subscription.
+	GetSubscription(ctx context.Context, id int) (*WebhookSubscription, error)
+	// ListSubscriptions returns the subscriptions of ownerID, or every subscription when it is zero, in ID order.
+	ListSubscriptions(ctx context.Context, ownerID int) ([]*WebhookSubscription, error)
+	// UpdateSubscription saves the URL, filters and active flag of s.
+	UpdateSubscription(ctx context.Context, s *WebhookSubscription) error
+	// DeleteSubscription deletes a subscription together with its deliveries.
+	DeleteSubscription(ctx context.Context, id int) error
+
+	// EnqueueDelivery schedules d. Enqueueing an event for a subscription twice is a no-op,
+	// so that events redelivered by the outbox are posted once.
+	EnqueueDelivery(ctx context.Context, d *WebhookDelivery) error
+	// PendingDeliveries returns up to limit pending deliveries of active subscriptions due at now, oldest first.
+	PendingDeliveries(ctx context.Context, now time.Time, limit int) ([]*WebhookDelivery, error)
+	// SaveDeliveryAttempt saves the status, attempts, next attempt, response and error of d.
+	SaveDeliveryAttempt(ctx context.Context, d *WebhookDelivery) error
+	// ListDeliveries returns the deliveries matching q, newest first.
+	ListDeliveries(ctx context.Context, q WebhookDeliveryQuery) ([]*WebhookDelivery, error)
+	// GetDelivery returns ErrWebhookDeliveryNotFound when there is no such delivery.
+	GetDelivery(ctx context.Context, id int64) (*WebhookDelivery, error)
+	// Redeliver makes a delivery pending again with a fresh set of attempts, due at now.
+	Redeliver(ctx context.Context, id int64, now time.Time) error
+}
+
+// newWebhookSecret returns a random secret for signing deliveries.
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// formList returns the values of a form field given repeatedly or comma separated.
+func formList(r *http.Request, key string) []string {
+	list := []string{}
+	for _, v := range r.Form[key] {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" && !slices.Contains(list, s) {
+				list = append(list, s)
+			}
+		}
+	}
+	return list
+}
+
+// parseWebhookForm sets the fields of s sent in r. Missing fields are left unchanged.
+// The url must resolve to public addresses only.
+func (h *Handlers) parseWebhookForm(r *http.Request, s *WebhookSubscription) error {
+	if err := r.ParseMultipartForm(1 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return fmt.Errorf("invalid form")
+	}
+	if _, ok := r.Form["url"]; ok {
+		u, err := url.Parse(r.FormValue("url"))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("url must be an absolute http or https URL")
+		}
+		if err := checkWebhookHost(r.Context(), h.lookupIP, u.Hostname()); err != nil {
+			if errors.Is(err, errNonPublicAddress) {
+				return fmt.Errorf("url must point to a public address")
+			}
+			return fmt.Errorf("url host could not be resolved")
+		}
+		s.URL = u.String()
+	}
+	if _, ok := r.Form["event_types"]; ok {
+		s.EventTypes = formList(r, "event_types")
+		for _, typ := range s.EventTypes {
+			if !slices.Contains([]string{EventItemCreated, EventItemUpdated, EventItemSold, EventCategoryCreated}, typ) {
+				return fmt.Errorf("unknown event type %q", typ)
+			}
+		}
+	}
+	if _, ok := r.Form["categories"]; ok {
+		s.Categories = formList(r, "categories")
+	}
+	if v := r.FormValue("active"); v != "" {
+		active, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("active must be a boolean")
+		}
+		s.Active = active
+	}
+	return nil
+}
+
+// webhookFor returns the subscription in the path if the signed-in user owns it or is an admin.
+// Otherwise an error is written and ok is false.
+func (h *Handlers) webhookFor(w http.ResponseWriter, r *http.Request) (sub *WebhookSubscription, ok bool) {
+	ctx := r.Context()
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		return nil, false
+	}
+	userID, signedIn := userIDFromContext(ctx)
+	if !signedIn {
+		http.Error(w, "sign in to manage webhooks", http.StatusUnauthorized)
+		return nil, false
+	}
+	sub, err = h.webhooks.GetSubscription(ctx, id)
+	if err != nil {
+		if errors.Is(err, ErrWebhookNotFound) {
+			http.Error(w, "webhook not found", http.StatusNotFound)
+			return nil, false
+		}
+		http.Error(w, "failed to get webhook", http.StatusInternalServerError)
+		return nil, false
+	}
+	if sub.OwnerID != userID && !h.isAdmin(ctx) {
+		http.Error(w, "only the owner can manage this webhook", http.StatusForbidden)
+		return nil, false
+	}
+	return sub, true
+}
+
+// writeWebhook writes sub without its secret.
+func writeWebhook(w http.ResponseWriter, status int, sub *WebhookSubscription) {
+	copied := *sub
+	copied.Secret = ""
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(copied)
+}
+
+// CreateWebhook is a handler to subscribe to domain events for POST /webhooks .
+// Form fields: url, event_types and categories (repeated or comma separated) and secret.
+// A secret is generated when none is given; the response is the only place it is returned.
+func (h *Handlers) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, signedIn := userIDFromContext(ctx)
+	if !signedIn {
+		http.Error(w, "sign in to manage webhooks", http.StatusUnauthorized)
+		return
+	}
+
+	sub := &WebhookSubscription{OwnerID: userID, EventTypes: []string{}, Categories: []string{}, Active: true}
+	if err := h.parseWebhookForm(r, sub); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if sub.URL == "" {
+		http.Error(w, "url is required", http.StatusBadRequest)
+		return
+	}
+	sub.Secret = r.FormValue("secret")
+	if sub.Secret == "" {
+		secret, err := newWebhookSecret()
+		if err != nil {
+			http.Error(w, "failed to create webhook", http.StatusInternalServerError)
+			return
+		}
+		sub.Secret = secret
+	}
+
+	if err := h.webhooks.CreateSubscription(ctx, sub); err != nil {
+		slog.Error("failed to create webhook", "error", err)
+		http.Error(w, "failed to create webhook", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("webhook created", "id", sub.ID, "user_id", userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(sub)
+}
+
+// GetWebhooks is a handler to return the signed-in user's webhooks for GET /webhooks .
+// Admins see every webhook.
+func (h *Handlers) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, signedIn := userIDFromContext(ctx)
+	if !signedIn {
+		http.Error(w, "sign in to manage webhooks", http.StatusUnauthorized)
+		return
+	}
+	if h.isAdmin(ctx) {
+		userID = 0
+	}
+
+	subs, err := h.webhooks.ListSubscriptions(ctx, userID)
+	if err != nil {
+		http.Error(w, "failed to get webhooks", http.StatusInternalServerError)
+		return
+	}
+	list := []WebhookSubscription{}
+	for _, sub := range subs {
+		sub.Secret = ""
+		list = append(list, *sub)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"webhooks": list})
+}
+
+// GetWebhook is a handler to return a webhook for GET /webhooks/{id} .
+func (h *Handlers) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.webhookFor(w, r)
+	if !ok {
+		return
+	}
+	writeWebhook(w, http.StatusOK, sub)
+}
+
+// UpdateWebhook is a handler to change a webhook for PATCH /webhooks/{id} .
+// Form fields: url, event_types, categories and active. Only the fields sent are changed.
+func (h *Handlers) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.webhookFor(w, r)
+	if !ok {
+		return
+	}
+	if err := h.parseWebhookForm(r, sub); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.webhooks.UpdateSubscription(r.Context(), sub); err != nil {
+		if errors.Is(err, ErrWebhookNotFound) {
+			http.Error(w, "webhook not found", http.StatusNotFound)
+			return
+		}
+		slog.Error("failed to update webhook", "id", sub.ID, "error", err)
+		http.Error(w, "failed to update webhook", http.StatusInternalServerError)
+		return
+	}
+	writeWebhook(w, http.StatusOK, sub)
+}
+
+// DeleteWebhook is a handler to unsubscribe for DELETE /webhooks/{id} .
+// Pending deliveries are dropped with the subscription.
+func (h *Handlers) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.webhookFor(w, r)
+	if !ok {
+		return
+	}
+	if err := h.webhooks.DeleteSubscription(r.Context(), sub.ID); err != nil && !errors.Is(err, ErrWebhookNotFound) {
+		slog.Error("failed to delete webhook", "id", sub.ID, "error", err)
+		http.Error(w, "failed to delete webhook", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseDeliveryQuery reads the status, before_id and limit filters of the delivery lists.
+func parseDeliveryQuery(r *http.Request) (WebhookDeliveryQuery, error) {
+	v := r.URL.Query()
+	q := WebhookDeliveryQuery{Status: v.Get("status")}
+	if q.Status != "" && q.Status != WebhookPending && q.Status != WebhookSucceeded && q.Status != WebhookDead {
+		return q, fmt.Errorf("status must be pending, succeeded or dead")
+	}
+	if s := v.Get("before_id"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 0 {
+			return q, fmt.Errorf("before_id must be a non-negative integer")
+		}
+		q.BeforeID = n
+	}
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return q, fmt.Errorf("limit must be a non-negative integer")
+		}
+		q.Limit = n
+	}
+	return q, nil
+}
+
+// writeDeliveries writes the deliveries matching q.
+func (h *Handlers) writeDeliveries(w http.ResponseWriter, r *http.Request, q WebhookDeliveryQuery) {
+	deliveries, err := h.webhooks.ListDeliveries(r.Context(), q)
+	if err != nil {
+		http.Error(w, "failed to get deliveries", http.StatusInternalServerError)
+		return
+	}
+	if deliveries == nil {
+		deliveries = []*WebhookDelivery{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"deliveries": deliveries})
+}
+
+// GetWebhookDeliveries is a handler to return the delivery log of a webhook, newest first, for
+// GET /webhooks/{id}/deliveries . Filters: status, before_id and limit.
+func (h *Handlers) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.webhookFor(w, r)
+	if !ok {
+		return
+	}
+	q, err := parseDeliveryQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.SubscriptionID = sub.ID
+	h.writeDeliveries(w, r, q)
+}
+
+// GetWebhookDeadLetters is a handler to return the deliveries which ran out of attempts across
+// the signed-in user's webhooks for GET /webhooks/dead-letters . Admins see every webhook.
+func (h *Handlers) GetWebhookDeadLetters(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, signedIn := userIDFromContext(ctx)
+	if !signedIn {
+		http.Error(w, "sign in to manage webhooks", http.StatusUnauthorized)
+		return
+	}
+	q, err := parseDeliveryQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.Status = WebhookDead
+	if !h.isAdmin(ctx) {
+		q.OwnerID = userID
+	}
+	h.writeDeliveries(w, r, q)
+}
+
+// RedeliverWebhook is a handler to retry a delivery right away with a fresh set of attempts for
+// POST /webhooks/{id}/deliveries/{delivery_id}/redeliver .
+func (h *Handlers) RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sub, ok := h.webhookFor(w, r)
+	if !ok {
+		return
+	}
+	deliveryID, err := strconv.ParseInt(r.PathValue("delivery_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "delivery_id must be an integer", http.StatusBadRequest)
+		return
+	}
+	d, err := h.webhooks.GetDelivery(ctx, deliveryID)
+	if err != nil && !errors.Is(err, ErrWebhookDeliveryNotFound) {
+		http.Error(w, "failed to get delivery", http.StatusInternalServerError)
+		return
+	}
+	if d == nil || d.SubscriptionID != sub.ID {
+		http.Error(w, "delivery not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.webhooks.Redeliver(ctx, d.ID, time.Now()); err != nil {
+		slog.Error("failed to redeliver webhook", "delivery_id", d.ID, "error", err)
+		http.Error(w, "failed to redeliver", http.StatusInternalServerError)
+		return
+	}
+	if d, err = h.webhooks.GetDelivery(ctx, d.ID); err != nil {
+		http.Error(w, "failed to get delivery", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(d)
+}
@@ -0,0 +1,85 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// errNonPublicAddress rejects webhook URLs pointing into our own network, so that partners
+// cannot make the server call internal services or the cloud metadata endpoint.
+var errNonPublicAddress = errors.New("webhook address is not public")
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), private like RFC 1918.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// isPublicAddr reports whether webhooks may be delivered to addr. Loopback, private (RFC 1918,
+// RFC 4193), link-local (including 169.254.169.254), multicast and unspecified addresses are not public.
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsValid() && !addr.IsLoopback() && !addr.IsPrivate() && !addr.IsLinkLocalUnicast() &&
+		!addr.IsLinkLocalMulticast() && !addr.IsInterfaceLocalMulticast() && !addr.IsMulticast() &&
+		!addr.IsUnspecified() && !sharedAddressSpace.Contains(addr)
+}
+
+// checkWebhookHost resolves host with lookup and returns errNonPublicAddress unless every
+// address is public. Nil lookup uses net.DefaultResolver.
+func checkWebhookHost(ctx context.Context, lookup func(ctx context.Context, host string) ([]netip.Addr, error), host string) error {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if !isPublicAddr(addr) {
+			return errNonPublicAddress
+		}
+		return nil
+	}
+	if lookup == nil {
+		lookup = func(ctx context.Context, host string) ([]netip.Addr, error) {
+			return net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		}
+	}
+	addrs, err := lookup(ctx, host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve webhook host: %w", err)
+	}
+	for _, addr := range addrs {
+		if !isPublicAddr(addr) {
+			return errNonPublicAddress
+		}
+	}
+	return nil
+}
+
+// refuseNonPublicAddr is a net.Dialer Control function refusing connections to non-public
+// addresses. It runs after name resolution, so a host re-pointed at an internal address
+// after the subscription was checked is refused too.
+func refuseNonPublicAddr(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("failed to parse webhook address: %w", err)
+	}
+	if !isPublicAddr(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", errNonPublicAddress, addrPort.Addr())
+	}
+	return nil
+}
+
+// newWebhookClient returns the client delivering webhooks. It only connects to public
+// addresses and does not follow redirects, which could otherwise lead it anywhere.
+func newWebhookClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: refuseNonPublicAddr}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// プロキシを経由すると接続先の検査をすり抜けるので使わない
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
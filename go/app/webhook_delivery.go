@@ -0,0 +1,245 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers sent with every webhook delivery.
+const (
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// DefaultWebhookMaxAttempts is how many times a delivery is tried before it becomes a dead letter.
+const DefaultWebhookMaxAttempts = 8
+
+// SignWebhook returns the WebhookSignatureHeader value for body sent at t:
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<unix seconds>.<body>" keyed with secret>".
+// Signing the timestamp lets receivers reject replayed requests.
+func SignWebhook(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + webhookMAC(secret, ts, body)
+}
+
+func webhookMAC(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature checks a WebhookSignatureHeader value made by SignWebhook, and that it
+// was signed within tolerance of now.
+func VerifyWebhookSignature(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var ts, sig string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(part, "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sig = v
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sig == "" {
+		return errors.New("malformed webhook signature")
+	}
+	if d := now.Sub(time.Unix(unix, 0)); d > tolerance || d < -tolerance {
+		return errors.New("webhook signature has expired")
+	}
+	if !hmac.Equal([]byte(sig), []byte(webhookMAC(secret, ts, body))) {
+		return errors.New("webhook signature does not match")
+	}
+	return nil
+}
+
+// WebhookSubscriptionSink fans domain events out to the matching webhook subscriptions by
+// enqueueing a delivery for each. The outbox may hand it an event again; the delivery is
+// enqueued once.
+type WebhookSubscriptionSink struct {
+	repo WebhookRepository
+	now  func() time.Time
+}
+
+// NewWebhookSubscriptionSink returns a sink enqueueing deliveries in repo.
+func NewWebhookSubscriptionSink(repo WebhookRepository) *WebhookSubscriptionSink {
+	return &WebhookSubscriptionSink{repo: repo, now: time.Now}
+}
+
+// Name returns "webhook subscriptions".
+func (s *WebhookSubscriptionSink) Name() string {
+	return "webhook subscriptions"
+}
+
+// eventCategory returns the category an event is about: the category of an item, or the name
+// of a new category.
+func eventCategory(e *Event) string {
+	var payload struct {
+		Category string `json:"category"`
+		Name     string `json:"name"`
+	}
+	json.Unmarshal(e.Payload, &payload)
+	if e.Type == EventCategoryCreated {
+		return payload.Name
+	}
+	return payload.Category
+}
+
+// Deliver enqueues e for every subscription wanting it.
+func (s *WebhookSubscriptionSink) Deliver(ctx context.Context, e *Event) error {
+	subs, err := s.repo.ListSubscriptions(ctx, 0)
+	if err != nil {
+		return err
+	}
+	category := eventCategory(e)
+	var body []byte
+	for _, sub := range subs {
+		if !sub.matches(e.Type, category) {
+			continue
+		}
+		if body == nil {
+			if body, err = json.Marshal(e); err != nil {
+				return fmt.Errorf("failed to encode event: %w", err)
+			}
+		}
+		now := s.now().UTC()
+		d := &WebhookDelivery{
+			SubscriptionID: sub.ID,
+			EventID:        e.ID,
+			EventType:      e.Type,
+			Payload:        body,
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+		}
+		if err := s.repo.EnqueueDelivery(ctx, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WebhookDelivererOptions configures WebhookDeliverer. Zero fields use the defaults.
+type WebhookDelivererOptions struct {
+	// BatchSize is the largest number of deliveries sent per poll. It defaults to 100.
+	BatchSize int
+	// RetryBase is the delay before the first retry, doubled on every further failure
+	// up to RetryMax. They default to ten seconds and one hour.
+	RetryBase time.Duration
+	RetryMax  time.Duration
+	// MaxAttempts is how many failures make a delivery a dead letter. It defaults to DefaultWebhookMaxAttempts.
+	MaxAttempts int
+	// Client sends the requests. When nil, a client with a ten second timeout is used which
+	// only connects to public addresses and does not follow redirects.
+	Client *http.Client
+	// Now returns the current time. time.Now is used when nil.
+	Now func() time.Time
+}
+
+// WebhookDeliverer POSTs pending webhook deliveries to their subscriptions, signed with the
+// subscription's secret. Failed deliveries are retried with exponential backoff until they run
+// out of attempts and become dead letters.
+type WebhookDeliverer struct {
+	repo WebhookRepository
+	opts WebhookDelivererOptions
+}
+
+// NewWebhookDeliverer returns a deliverer sending the deliveries in repo.
+func NewWebhookDeliverer(repo WebhookRepository, opts WebhookDelivererOptions) *WebhookDeliverer {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.RetryBase <= 0 {
+		opts.RetryBase = 10 * time.Second
+	}
+	if opts.RetryMax <= 0 {
+		opts.RetryMax = time.Hour
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultWebhookMaxAttempts
+	}
+	if opts.Client == nil {
+		opts.Client = newWebhookClient(10 * time.Second)
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &WebhookDeliverer{repo: repo, opts: opts}
+}
+
+// DeliverOnce sends the deliveries due now and returns how many succeeded and failed.
+func (w *WebhookDeliverer) DeliverOnce(ctx context.Context) (succeeded, failed int, err error) {
+	now := w.opts.Now()
+	deliveries, err := w.repo.PendingDeliveries(ctx, now, w.opts.BatchSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, d := range deliveries {
+		sub, err := w.repo.GetSubscription(ctx, d.SubscriptionID)
+		if errors.Is(err, ErrWebhookNotFound) {
+			continue
+		}
+		if err != nil {
+			return succeeded, failed, err
+		}
+
+		d.ResponseStatus, err = w.post(ctx, sub, d)
+		d.Attempts++
+		if err == nil {
+			at := w.opts.Now().UTC()
+			d.Status, d.LastError, d.DeliveredAt = WebhookSucceeded, "", &at
+			succeeded++
+		} else {
+			d.LastError = err.Error()
+			if d.Attempts >= w.opts.MaxAttempts {
+				d.Status = WebhookDead
+				slog.Warn("webhook delivery moved to dead letters", "delivery_id", d.ID, "subscription_id", sub.ID, "error", err)
+			} else {
+				d.NextAttemptAt = now.Add(backoff(w.opts.RetryBase, w.opts.RetryMax, d.Attempts-1))
+			}
+			failed++
+		}
+		if err := w.repo.SaveDeliveryAttempt(ctx, d); err != nil {
+			return succeeded, failed, err
+		}
+	}
+	return succeeded, failed, nil
+}
+
+// post sends d to sub and returns the response status.
+func (w *WebhookDeliverer) post(ctx context.Context, sub *WebhookSubscription, d *WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "mercari-build-training-webhooks")
+	req.Header.Set("X-Event-ID", strconv.FormatInt(d.EventID, 10))
+	req.Header.Set("X-Event-Type", d.EventType)
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatInt(d.ID, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(sub.Secret, w.opts.Now(), d.Payload))
+
+	resp, err := w.opts.Client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to post webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
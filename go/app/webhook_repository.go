@@ -0,0 +1,630 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sqlWebhookRepository stores webhooks in SQLite or PostgreSQL. placeholder is the dialect's bind parameter.
+type sqlWebhookRepository struct {
+	db          *sql.DB
+	placeholder func(int) string
+}
+
+// NewWebhookRepository returns a WebhookRepository using the database opened by OpenDatabase with dsn.
+func NewWebhookRepository(db *sql.DB, dsn string) WebhookRepository {
+	d, _ := parseDSN(dsn)
+	return &sqlWebhookRepository{db: db, placeholder: d.placeholder()}
+}
+
+// params returns the first n bind parameters separated by commas.
+func params(placeholder func(int) string, n int) string {
+	list := make([]string, n)
+	for i := range list {
+		list[i] = placeholder(i + 1)
+	}
+	return strings.Join(list, ", ")
+}
+
+// joinList stores a filter list as a comma separated string.
+func joinList(list []string) string {
+	return strings.Join(list, ",")
+}
+
+// splitList reads a list stored by joinList. It never returns nil, so that it is encoded as [].
+func splitList(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+const webhookSubscriptionColumns = `id, owner_id, url, secret, event_types, categories, active, created_at, updated_at`
+
+func scanWebhookSubscription(row interface{ Scan(...any) error }) (*WebhookSubscription, error) {
+	var s WebhookSubscription
+	var eventTypes, categories string
+	if err := row.Scan(&s.ID, &s.OwnerID, &s.URL, &s.Secret, &eventTypes, &categories, &s.Active, &s.CreatedAt, &s.UpdatedAt); err != nil {
+		return nil, err
+	}
+	s.EventTypes = splitList(eventTypes)
+	s.Categories = splitList(categories)
+	return &s, nil
+}
+
+// auditedSubscription returns the snapshot of s recorded in the audit log, without its secret.
+func auditedSubscription(s *WebhookSubscription) *WebhookSubscription {
+	copied := cloneSubscription(s)
+	copied.Secret = ""
+	return copied
+}
+
+// CreateSubscription inserts s, sets its ID and timestamps and records it in the audit log.
+func (r *sqlWebhookRepository) CreateSubscription(ctx context.Context, s *WebhookSubscription) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	query := `INSERT INTO webhook_subscriptions (owner_id, url, secret, event_types, categories, active, created_at, updated_at)
+        VALUES (` + params(r.placeholder, 8) + `) RETURNING id`
+	var id int
+	err = tx.QueryRowContext(ctx, query, s.OwnerID, s.URL, s.Secret, joinList(s.EventTypes), joinList(s.Categories), s.Active, now, now).Scan(&id)
+	if err != nil {
+		return fmt.Errorf("failed to create webhook subscription: %w", err)
+	}
+	after := auditedSubscription(s)
+	after.ID, after.CreatedAt, after.UpdatedAt = id, now, now
+	if err := recordChange(ctx, tx, r.placeholder, AuditCreate, AuditEntityWebhook, id, nil, after); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to create webhook subscription: %w", err)
+	}
+	s.ID, s.CreatedAt, s.UpdatedAt = id, now, now
+	return nil
+}
+
+// GetSubscription returns a subscription by ID.
+func (r *sqlWebhookRepository) GetSubscription(ctx context.Context, id int) (*WebhookSubscription, error) {
+	return r.get(ctx, r.db, id)
+}
+
+// get returns a subscription by ID. q may be a transaction.
+func (r *sqlWebhookRepository) get(ctx context.Context, q querier, id int) (*WebhookSubscription, error) {
+	query := `SELECT ` + webhookSubscriptionColumns + ` FROM webhook_subscriptions WHERE id = ` + r.placeholder(1)
+	s, err := scanWebhookSubscription(q.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook subscription: %w", err)
+	}
+	return s, nil
+}
+
+// ListSubscriptions returns the subscriptions of ownerID, or all of them.
+func (r *sqlWebhookRepository) ListSubscriptions(ctx context.Context, ownerID int) ([]*WebhookSubscription, error) {
+	query := `SELECT ` + webhookSubscriptionColumns + ` FROM webhook_subscriptions`
+	var args []any
+	if ownerID != 0 {
+		query += ` WHERE owner_id = ` + r.placeholder(1)
+		args = append(args, ownerID)
+	}
+	rows, err := r.db.QueryContext(ctx, query+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	var subs []*WebhookSubscription
+	for rows.Next() {
+		s, err := scanWebhookSubscription(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook subscription: %w", err)
+		}
+		subs = append(subs, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list webhook subscriptions: %w", err)
+	}
+	return subs, nil
+}
+
+// UpdateSubscription saves the URL, filters and active flag of s and records the change in the
+// audit log.
+func (r *sqlWebhookRepository) UpdateSubscription(ctx context.Context, s *WebhookSubscription) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := r.get(ctx, tx, s.ID)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	query := fmt.Sprintf(`UPDATE webhook_subscriptions SET url = %s, event_types = %s, categories = %s, active = %s, updated_at = %s WHERE id = %s`,
+		r.placeholder(1), r.placeholder(2), r.placeholder(3), r.placeholder(4), r.placeholder(5), r.placeholder(6))
+	result, err := tx.ExecContext(ctx, query, s.URL, joinList(s.EventTypes), joinList(s.Categories), s.Active, now, s.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook subscription: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrWebhookNotFound
+	}
+	after := auditedSubscription(s)
+	after.OwnerID, after.CreatedAt, after.UpdatedAt = before.OwnerID, before.CreatedAt, now
+	if err := recordChange(ctx, tx, r.placeholder, AuditUpdate, AuditEntityWebhook, s.ID, auditedSubscription(before), after); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to update webhook subscription: %w", err)
+	}
+	s.UpdatedAt = now
+	return nil
+}
+
+// DeleteSubscription deletes a subscription and its deliveries and records it in the audit log.
+func (r *sqlWebhookRepository) DeleteSubscription(ctx context.Context, id int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := r.get(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE subscription_id = `+r.placeholder(1), id); err != nil {
+		return fmt.Errorf("failed to delete webhook deliveries: %w", err)
+	}
+	result, err := tx.ExecContext(ctx, `DELETE FROM webhook_subscriptions WHERE id = `+r.placeholder(1), id)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook subscription: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrWebhookNotFound
+	}
+	if err := recordChange(ctx, tx, r.placeholder, AuditDelete, AuditEntityWebhook, id, auditedSubscription(before), nil); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to delete webhook subscription: %w", err)
+	}
+	return nil
+}
+
+// EnqueueDelivery schedules d unless its event is already scheduled for the subscription.
+func (r *sqlWebhookRepository) EnqueueDelivery(ctx context.Context, d *WebhookDelivery) error {
+	query := `INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload, status, attempts, next_attempt_at, created_at)
+        VALUES (` + params(r.placeholder, 8) + `) ON CONFLICT (subscription_id, event_id) DO NOTHING`
+	_, err := r.db.ExecContext(ctx, query, d.SubscriptionID, d.EventID, d.EventType, string(d.Payload), WebhookPending, 0, d.NextAttemptAt.UTC(), d.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to enqueue webhook delivery: %w", err)
+	}
+	return nil
+}
+
+const webhookDeliveryColumns = `d.id, d.subscription_id, d.event_id, d.event_type, d.payload, d.status, d.attempts,
+        d.next_attempt_at, d.response_status, d.last_error, d.created_at, d.delivered_at`
+
+func scanWebhookDelivery(row interface{ Scan(...any) error }) (*WebhookDelivery, error) {
+	var d WebhookDelivery
+	var payload string
+	var responseStatus sql.NullInt64
+	var lastError sql.NullString
+	var deliveredAt sql.NullTime
+	if err := row.Scan(&d.ID, &d.SubscriptionID, &d.EventID, &d.EventType, &payload, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &responseStatus, &lastError, &d.CreatedAt, &deliveredAt); err != nil {
+		return nil, err
+	}
+	d.Payload = json.RawMessage(payload)
+	d.ResponseStatus = int(responseStatus.Int64)
+	d.LastError = lastError.String
+	if deliveredAt.Valid {
+		d.DeliveredAt = &deliveredAt.Time
+	}
+	return &d, nil
+}
+
+// queryDeliveries runs a query selecting webhookDeliveryColumns.
+func (r *sqlWebhookRepository) queryDeliveries(ctx context.Context, query string, args ...any) ([]*WebhookDelivery, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []*WebhookDelivery
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// PendingDeliveries returns the pending deliveries of active subscriptions due at now.
+func (r *sqlWebhookRepository) PendingDeliveries(ctx context.Context, now time.Time, limit int) ([]*WebhookDelivery, error) {
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries d
+        JOIN webhook_subscriptions s ON s.id = d.subscription_id
+        WHERE d.status = ` + r.placeholder(1) + ` AND d.next_attempt_at <= ` + r.placeholder(2) + ` AND s.active
+        ORDER BY d.id LIMIT ` + strconv.Itoa(limit)
+	return r.queryDeliveries(ctx, query, WebhookPending, now.UTC())
+}
+
+// SaveDeliveryAttempt saves the outcome of a delivery attempt.
+func (r *sqlWebhookRepository) SaveDeliveryAttempt(ctx context.Context, d *WebhookDelivery) error {
+	var deliveredAt sql.NullTime
+	if d.DeliveredAt != nil {
+		deliveredAt = sql.NullTime{Time: d.DeliveredAt.UTC(), Valid: true}
+	}
+	query := fmt.Sprintf(`UPDATE webhook_deliveries SET status = %s, attempts = %s, next_attempt_at = %s, response_status = %s, last_error = %s, delivered_at = %s WHERE id = %s`,
+		r.placeholder(1), r.placeholder(2), r.placeholder(3), r.placeholder(4), r.placeholder(5), r.placeholder(6), r.placeholder(7))
+	_, err := r.db.ExecContext(ctx, query, d.Status, d.Attempts, d.NextAttemptAt.UTC(), nullInt(int64(d.ResponseStatus)),
+		sql.NullString{String: d.LastError, Valid: d.LastError != ""}, deliveredAt, d.ID)
+	if err != nil {
+		return fmt.Errorf("failed to save webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// ListDeliveries returns the deliveries matching q, newest first.
+func (r *sqlWebhookRepository) ListDeliveries(ctx context.Context, q WebhookDeliveryQuery) ([]*WebhookDelivery, error) {
+	var conds []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, cond+" "+r.placeholder(len(args)))
+	}
+	if q.SubscriptionID != 0 {
+		add("d.subscription_id =", q.SubscriptionID)
+	}
+	if q.OwnerID != 0 {
+		add("s.owner_id =", q.OwnerID)
+	}
+	if q.Status != "" {
+		add("d.status =", q.Status)
+	}
+	if q.BeforeID != 0 {
+		add("d.id <", q.BeforeID)
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries d
+        JOIN webhook_subscriptions s ON s.id = d.subscription_id
+        ` + where + ` ORDER BY d.id DESC LIMIT ` + strconv.Itoa(AuditQuery{Limit: q.Limit}.limit())
+	return r.queryDeliveries(ctx, query, args...)
+}
+
+// GetDelivery returns a delivery by ID.
+func (r *sqlWebhookRepository) GetDelivery(ctx context.Context, id int64) (*WebhookDelivery, error) {
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries d WHERE d.id = ` + r.placeholder(1)
+	d, err := scanWebhookDelivery(r.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrWebhookDeliveryNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook delivery: %w", err)
+	}
+	return d, nil
+}
+
+// Redeliver makes a delivery pending again, due at now.
+func (r *sqlWebhookRepository) Redeliver(ctx context.Context, id int64, now time.Time) error {
+	query := fmt.Sprintf(`UPDATE webhook_deliveries SET status = %s, attempts = 0, next_attempt_at = %s, delivered_at = NULL WHERE id = %s`,
+		r.placeholder(1), r.placeholder(2), r.placeholder(3))
+	result, err := r.db.ExecContext(ctx, query, WebhookPending, now.UTC(), id)
+	if err != nil {
+		return fmt.Errorf("failed to redeliver webhook delivery: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrWebhookDeliveryNotFound
+	}
+	return nil
+}
+
+// memoryWebhookRepository keeps webhooks in memory, for --storage=memory and tests. Changes to
+// subscriptions are recorded in the audit log of items.
+type memoryWebhookRepository struct {
+	items          *memoryItemRepository
+	mu             sync.RWMutex
+	subs           []*WebhookSubscription
+	deliveries     []*WebhookDelivery
+	nextSubID      int
+	nextDeliveryID int64
+}
+
+// NewMemoryWebhookRepository returns an empty WebhookRepository kept in memory, auditing into the
+// memory store of items, which must be returned by NewMemoryItemRepository.
+func NewMemoryWebhookRepository(items ItemRepository) WebhookRepository {
+	return &memoryWebhookRepository{items: items.(*memoryItemRepository), nextSubID: 1, nextDeliveryID: 1}
+}
+
+// recordChange appends the audit entry of a change to a subscription. m.mu must be held for writing.
+func (m *memoryWebhookRepository) recordChange(ctx context.Context, action string, id int, before, after *WebhookSubscription) error {
+	m.items.mu.Lock()
+	defer m.items.mu.Unlock()
+
+	var b, a any
+	if before != nil {
+		b = auditedSubscription(before)
+	}
+	if after != nil {
+		a = auditedSubscription(after)
+	}
+	return m.items.recordChange(ctx, action, AuditEntityWebhook, id, b, a)
+}
+
+func cloneSubscription(s *WebhookSubscription) *WebhookSubscription {
+	copied := *s
+	copied.EventTypes = append([]string{}, s.EventTypes...)
+	copied.Categories = append([]string{}, s.Categories...)
+	return &copied
+}
+
+func cloneDelivery(d *WebhookDelivery) *WebhookDelivery {
+	copied := *d
+	copied.Payload = slices.Clone(d.Payload)
+	if d.DeliveredAt != nil {
+		t := *d.DeliveredAt
+		copied.DeliveredAt = &t
+	}
+	return &copied
+}
+
+// subscription returns the subscription with the given ID. m.mu must be held.
+func (m *memoryWebhookRepository) subscription(id int) *WebhookSubscription {
+	for _, s := range m.subs {
+		if s.ID == id {
+			return s
+		}
+	}
+	return nil
+}
+
+// delivery returns the delivery with the given ID. m.mu must be held.
+func (m *memoryWebhookRepository) delivery(id int64) *WebhookDelivery {
+	for _, d := range m.deliveries {
+		if d.ID == id {
+			return d
+		}
+	}
+	return nil
+}
+
+// CreateSubscription stores s and sets its ID and timestamps.
+func (m *memoryWebhookRepository) CreateSubscription(ctx context.Context, s *WebhookSubscription) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to create webhook subscription: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := cloneSubscription(s)
+	stored.ID = m.nextSubID
+	stored.CreatedAt = time.Now().UTC()
+	stored.UpdatedAt = stored.CreatedAt
+	if err := m.recordChange(ctx, AuditCreate, stored.ID, nil, stored); err != nil {
+		return err
+	}
+	m.nextSubID++
+	m.subs = append(m.subs, stored)
+	s.ID, s.CreatedAt, s.UpdatedAt = stored.ID, stored.CreatedAt, stored.UpdatedAt
+	return nil
+}
+
+// GetSubscription returns a subscription by ID.
+func (m *memoryWebhookRepository) GetSubscription(ctx context.Context, id int) (*WebhookSubscription, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get webhook subscription: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s := m.subscription(id)
+	if s == nil {
+		return nil, ErrWebhookNotFound
+	}
+	return cloneSubscription(s), nil
+}
+
+// ListSubscriptions returns the subscriptions of ownerID, or all of them.
+func (m *memoryWebhookRepository) ListSubscriptions(ctx context.Context, ownerID int) ([]*WebhookSubscription, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list webhook subscriptions: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var subs []*WebhookSubscription
+	for _, s := range m.subs {
+		if ownerID == 0 || s.OwnerID == ownerID {
+			subs = append(subs, cloneSubscription(s))
+		}
+	}
+	return subs, nil
+}
+
+// UpdateSubscription saves the URL, filters and active flag of s.
+func (m *memoryWebhookRepository) UpdateSubscription(ctx context.Context, s *WebhookSubscription) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to update webhook subscription: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := m.subscription(s.ID)
+	if stored == nil {
+		return ErrWebhookNotFound
+	}
+	updated := cloneSubscription(s)
+	updated.OwnerID, updated.Secret, updated.CreatedAt = stored.OwnerID, stored.Secret, stored.CreatedAt
+	updated.UpdatedAt = time.Now().UTC()
+	if err := m.recordChange(ctx, AuditUpdate, s.ID, stored, updated); err != nil {
+		return err
+	}
+	*stored = *updated
+	s.UpdatedAt = updated.UpdatedAt
+	return nil
+}
+
+// DeleteSubscription deletes a subscription and its deliveries.
+func (m *memoryWebhookRepository) DeleteSubscription(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to delete webhook subscription: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := m.subscription(id)
+	if stored == nil {
+		return ErrWebhookNotFound
+	}
+	if err := m.recordChange(ctx, AuditDelete, id, stored, nil); err != nil {
+		return err
+	}
+	m.subs = slices.DeleteFunc(m.subs, func(s *WebhookSubscription) bool { return s.ID == id })
+	m.deliveries = slices.DeleteFunc(m.deliveries, func(d *WebhookDelivery) bool { return d.SubscriptionID == id })
+	return nil
+}
+
+// EnqueueDelivery schedules d unless its event is already scheduled for the subscription.
+func (m *memoryWebhookRepository) EnqueueDelivery(ctx context.Context, d *WebhookDelivery) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to enqueue webhook delivery: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.subscription(d.SubscriptionID) == nil {
+		return fmt.Errorf("failed to enqueue webhook delivery: %w", ErrWebhookNotFound)
+	}
+	for _, stored := range m.deliveries {
+		if stored.SubscriptionID == d.SubscriptionID && stored.EventID == d.EventID {
+			return nil
+		}
+	}
+	stored := cloneDelivery(d)
+	stored.ID = m.nextDeliveryID
+	m.nextDeliveryID++
+	stored.Status = WebhookPending
+	stored.Attempts = 0
+	m.deliveries = append(m.deliveries, stored)
+	return nil
+}
+
+// PendingDeliveries returns the pending deliveries of active subscriptions due at now.
+func (m *memoryWebhookRepository) PendingDeliveries(ctx context.Context, now time.Time, limit int) ([]*WebhookDelivery, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve webhook deliveries: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var deliveries []*WebhookDelivery
+	for _, d := range m.deliveries {
+		if len(deliveries) == limit {
+			break
+		}
+		if s := m.subscription(d.SubscriptionID); s != nil && s.Active && d.Status == WebhookPending && !d.NextAttemptAt.After(now) {
+			deliveries = append(deliveries, cloneDelivery(d))
+		}
+	}
+	return deliveries, nil
+}
+
+// SaveDeliveryAttempt saves the outcome of a delivery attempt.
+func (m *memoryWebhookRepository) SaveDeliveryAttempt(ctx context.Context, d *WebhookDelivery) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to save webhook delivery: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := m.delivery(d.ID)
+	if stored == nil {
+		return nil
+	}
+	saved := cloneDelivery(d)
+	stored.Status, stored.Attempts, stored.NextAttemptAt = saved.Status, saved.Attempts, saved.NextAttemptAt
+	stored.ResponseStatus, stored.LastError, stored.DeliveredAt = saved.ResponseStatus, saved.LastError, saved.DeliveredAt
+	return nil
+}
+
+// ListDeliveries returns the deliveries matching q, newest first.
+func (m *memoryWebhookRepository) ListDeliveries(ctx context.Context, q WebhookDeliveryQuery) ([]*WebhookDelivery, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve webhook deliveries: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	limit := AuditQuery{Limit: q.Limit}.limit()
+	var deliveries []*WebhookDelivery
+	for _, d := range slices.Backward(m.deliveries) {
+		if len(deliveries) == limit {
+			break
+		}
+		s := m.subscription(d.SubscriptionID)
+		switch {
+		case q.SubscriptionID != 0 && d.SubscriptionID != q.SubscriptionID,
+			q.OwnerID != 0 && (s == nil || s.OwnerID != q.OwnerID),
+			q.Status != "" && d.Status != q.Status,
+			q.BeforeID != 0 && d.ID >= q.BeforeID:
+			continue
+		}
+		deliveries = append(deliveries, cloneDelivery(d))
+	}
+	return deliveries, nil
+}
+
+// GetDelivery returns a delivery by ID.
+func (m *memoryWebhookRepository) GetDelivery(ctx context.Context, id int64) (*WebhookDelivery, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get webhook delivery: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	d := m.delivery(id)
+	if d == nil {
+		return nil, ErrWebhookDeliveryNotFound
+	}
+	return cloneDelivery(d), nil
+}
+
+// Redeliver makes a delivery pending again, due at now.
+func (m *memoryWebhookRepository) Redeliver(ctx context.Context, id int64, now time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to redeliver webhook delivery: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d := m.delivery(id)
+	if d == nil {
+		return ErrWebhookDeliveryNotFound
+	}
+	d.Status, d.Attempts, d.NextAttemptAt, d.DeliveredAt = WebhookPending, 0, now, nil
+	return nil
+}
@@ -0,0 +1,432 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWebhookRepository(t *testing.T) {
+	t.Parallel()
+
+	// items は監査ログを確かめるために使う
+	repos := map[string]func(t *testing.T) (WebhookRepository, ItemRepository){
+		"sqlite": func(t *testing.T) (WebhookRepository, ItemRepository) {
+			dsn := filepath.Join(t.TempDir(), "test.sqlite3")
+			db, items, err := OpenDatabase(dsn)
+			if err != nil {
+				t.Fatalf("failed to open database: %v", err)
+			}
+			t.Cleanup(func() { db.Close() })
+			return NewWebhookRepository(db, dsn), items
+		},
+		"memory": func(t *testing.T) (WebhookRepository, ItemRepository) {
+			items := NewMemoryItemRepository()
+			return NewMemoryWebhookRepository(items), items
+		},
+	}
+
+	for name, newRepo := range repos {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			repo, items := newRepo(t)
+			now := time.Now().UTC().Truncate(time.Second)
+
+			mine := &WebhookSubscription{OwnerID: 1, URL: "https://example.com/a", Secret: "s", EventTypes: []string{EventItemCreated}, Categories: []string{}, Active: true}
+			theirs := &WebhookSubscription{OwnerID: 2, URL: "https://example.com/b", Secret: "s", EventTypes: []string{}, Categories: []string{"toys"}, Active: true}
+			for _, s := range []*WebhookSubscription{mine, theirs} {
+				if err := repo.CreateSubscription(ctx, s); err != nil {
+					t.Fatalf("failed to create subscription: %v", err)
+				}
+			}
+
+			subs, err := repo.ListSubscriptions(ctx, 1)
+			if err != nil {
+				t.Fatalf("failed to list subscriptions: %v", err)
+			}
+			if len(subs) != 1 || subs[0].ID != mine.ID || subs[0].Secret != "s" {
+				t.Fatalf("expected only the owner's subscription, got %+v", subs)
+			}
+
+			mine.Categories = []string{"fashion", "toys"}
+			if err := repo.UpdateSubscription(ctx, mine); err != nil {
+				t.Fatalf("failed to update subscription: %v", err)
+			}
+			got, err := repo.GetSubscription(ctx, mine.ID)
+			if err != nil {
+				t.Fatalf("failed to get subscription: %v", err)
+			}
+			if diff := cmp.Diff([]string{"fashion", "toys"}, got.Categories); diff != "" {
+				t.Errorf("unexpected categories (-want +got):\n%s", diff)
+			}
+
+			// 同じイベントを二度積んでも配信は一つ
+			for _, d := range []*WebhookDelivery{
+				{SubscriptionID: mine.ID, EventID: 1, EventType: EventItemCreated, Payload: json.RawMessage(`{"id":1}`), NextAttemptAt: now, CreatedAt: now},
+				{SubscriptionID: mine.ID, EventID: 1, EventType: EventItemCreated, Payload: json.RawMessage(`{"id":1}`), NextAttemptAt: now, CreatedAt: now},
+				{SubscriptionID: theirs.ID, EventID: 1, EventType: EventItemCreated, Payload: json.RawMessage(`{"id":1}`), NextAttemptAt: now, CreatedAt: now},
+			} {
+				if err := repo.EnqueueDelivery(ctx, d); err != nil {
+					t.Fatalf("failed to enqueue delivery: %v", err)
+				}
+			}
+			theirs.Active = false
+			if err := repo.UpdateSubscription(ctx, theirs); err != nil {
+				t.Fatalf("failed to update subscription: %v", err)
+			}
+			pending, err := repo.PendingDeliveries(ctx, now, 10)
+			if err != nil {
+				t.Fatalf("failed to get pending deliveries: %v", err)
+			}
+			if len(pending) != 1 || pending[0].SubscriptionID != mine.ID {
+				t.Fatalf("expected one pending delivery for the active subscription, got %+v", pending)
+			}
+
+			d := pending[0]
+			d.Status, d.Attempts, d.ResponseStatus, d.LastError = WebhookDead, 3, http.StatusBadGateway, "webhook responded with 502"
+			if err := repo.SaveDeliveryAttempt(ctx, d); err != nil {
+				t.Fatalf("failed to save delivery: %v", err)
+			}
+			dead, err := repo.ListDeliveries(ctx, WebhookDeliveryQuery{OwnerID: 1, Status: WebhookDead})
+			if err != nil {
+				t.Fatalf("failed to list deliveries: %v", err)
+			}
+			if len(dead) != 1 || dead[0].Attempts != 3 || dead[0].ResponseStatus != http.StatusBadGateway {
+				t.Fatalf("unexpected dead letters: %+v", dead)
+			}
+			if string(dead[0].Payload) != `{"id":1}` {
+				t.Errorf("unexpected payload %s", dead[0].Payload)
+			}
+
+			if err := repo.Redeliver(ctx, d.ID, now); err != nil {
+				t.Fatalf("failed to redeliver: %v", err)
+			}
+			d, err = repo.GetDelivery(ctx, d.ID)
+			if err != nil {
+				t.Fatalf("failed to get delivery: %v", err)
+			}
+			if d.Status != WebhookPending || d.Attempts != 0 {
+				t.Errorf("expected a fresh pending delivery, got %+v", d)
+			}
+
+			if err := repo.DeleteSubscription(ctx, mine.ID); err != nil {
+				t.Fatalf("failed to delete subscription: %v", err)
+			}
+			if _, err := repo.GetDelivery(ctx, d.ID); !errors.Is(err, ErrWebhookDeliveryNotFound) {
+				t.Errorf("expected deliveries to be deleted with the subscription, got %v", err)
+			}
+			if _, err := repo.GetSubscription(ctx, mine.ID); !errors.Is(err, ErrWebhookNotFound) {
+				t.Errorf("expected ErrWebhookNotFound, got %v", err)
+			}
+
+			entries, err := items.ListAudit(ctx, AuditQuery{Entity: AuditEntityWebhook, EntityID: mine.ID})
+			if err != nil {
+				t.Fatalf("failed to list audit entries: %v", err)
+			}
+			var actions []string
+			for _, e := range entries {
+				actions = append(actions, e.Action)
+				if strings.Contains(string(e.Before)+string(e.After), `"secret"`) {
+					t.Errorf("expected the secret to be redacted, got before %s after %s", e.Before, e.After)
+				}
+			}
+			if diff := cmp.Diff([]string{AuditDelete, AuditUpdate, AuditCreate}, actions); diff != "" {
+				t.Errorf("unexpected audit actions (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// webhookReceiver is an httptest server recording verified deliveries.
+type webhookReceiver struct {
+	*httptest.Server
+	mu     sync.Mutex
+	events []Event
+	status int
+}
+
+func newWebhookReceiver(t *testing.T, secret string) *webhookReceiver {
+	r := &webhookReceiver{status: http.StatusOK}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		if err := VerifyWebhookSignature(secret, req.Header.Get(WebhookSignatureHeader), body, time.Minute, time.Now()); err != nil {
+			t.Errorf("invalid signature: %v", err)
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.status == http.StatusOK {
+			var e Event
+			json.Unmarshal(body, &e)
+			r.events = append(r.events, e)
+		}
+		w.WriteHeader(r.status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *webhookReceiver) types() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	got := []string{}
+	for _, e := range r.events {
+		got = append(got, e.Type)
+	}
+	return got
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	items := NewMemoryItemRepository()
+	webhooks := NewMemoryWebhookRepository(items)
+	receiver := newWebhookReceiver(t, "secret")
+	sub := &WebhookSubscription{OwnerID: 1, URL: receiver.URL, Secret: "secret", EventTypes: []string{EventItemCreated}, Categories: []string{"fashion"}, Active: true}
+	if err := webhooks.CreateSubscription(ctx, sub); err != nil {
+		t.Fatal(err)
+	}
+
+	// 配信は積まれた時点で期限になるので、時計を少し進めておく
+	now := time.Now().Add(time.Second)
+	dispatcher := NewEventDispatcher(items, []EventSink{NewWebhookSubscriptionSink(webhooks)}, EventDispatcherOptions{})
+	deliverer := NewWebhookDeliverer(webhooks, WebhookDelivererOptions{
+		RetryBase:   time.Second,
+		MaxAttempts: 2,
+		Client:      receiver.Client(),
+		Now:         func() time.Time { return now },
+	})
+	run := func() {
+		t.Helper()
+		if _, _, err := dispatcher.DispatchOnce(ctx); err != nil {
+			t.Fatalf("DispatchOnce failed: %v", err)
+		}
+		if _, _, err := deliverer.DeliverOnce(ctx); err != nil {
+			t.Fatalf("DeliverOnce failed: %v", err)
+		}
+	}
+
+	// カテゴリとイベントの種類が合うものだけ届く
+	jacket := &Item{Name: "jacket", Category: "fashion"}
+	items.Insert(ctx, jacket)
+	items.Insert(ctx, &Item{Name: "camera", Category: "electronics"})
+	jacket.Name = "coat"
+	items.Update(ctx, jacket, 0)
+	run()
+	if diff := cmp.Diff([]string{EventItemCreated}, receiver.types()); diff != "" {
+		t.Fatalf("unexpected deliveries (-want +got):\n%s", diff)
+	}
+
+	// 失敗し続けると dead letter になる
+	receiver.mu.Lock()
+	receiver.status = http.StatusInternalServerError
+	receiver.mu.Unlock()
+	items.Insert(ctx, &Item{Name: "shirt", Category: "fashion"})
+	run()
+	now = now.Add(time.Second)
+	run()
+	dead, err := webhooks.ListDeliveries(ctx, WebhookDeliveryQuery{Status: WebhookDead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].Attempts != 2 || dead[0].ResponseStatus != http.StatusInternalServerError {
+		t.Fatalf("expected one dead letter after 2 attempts, got %+v", dead)
+	}
+
+	receiver.mu.Lock()
+	receiver.status = http.StatusOK
+	receiver.mu.Unlock()
+	if err := webhooks.Redeliver(ctx, dead[0].ID, now); err != nil {
+		t.Fatal(err)
+	}
+	run()
+	if diff := cmp.Diff([]string{EventItemCreated, EventItemCreated}, receiver.types()); diff != "" {
+		t.Errorf("unexpected deliveries after redelivery (-want +got):\n%s", diff)
+	}
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	body := []byte(`{"id":1}`)
+	cases := map[string]struct {
+		header  string
+		wantErr bool
+	}{
+		"ok: signed now":        {header: SignWebhook("secret", now, body)},
+		"ng: other secret":      {header: SignWebhook("other", now, body), wantErr: true},
+		"ng: replayed too late": {header: SignWebhook("secret", now.Add(-time.Hour), body), wantErr: true},
+		"ng: malformed":         {header: "v1=abc", wantErr: true},
+	}
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			err := VerifyWebhookSignature("secret", tt.header, body, 5*time.Minute, now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestIsPublicAddr(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]bool{
+		"93.184.215.14":   true,
+		"2606:2800::1":    true,
+		"127.0.0.1":       false,
+		"::1":             false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"fe80::1":         false,
+		"fd00::1":         false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"::ffff:10.0.0.1": false,
+	}
+	for addr, want := range cases {
+		t.Run(addr, func(t *testing.T) {
+			t.Parallel()
+			if got := isPublicAddr(netip.MustParseAddr(addr)); got != want {
+				t.Errorf("expected %v, got %v", want, got)
+			}
+		})
+	}
+}
+
+func TestWebhookClient(t *testing.T) {
+	t.Parallel()
+
+	// テスト用のサーバーはループバックで待ち受けるので接続を拒否される
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(receiver.Close)
+	client := newWebhookClient(time.Second)
+	if _, err := client.Get(receiver.URL); !errors.Is(err, errNonPublicAddress) {
+		t.Errorf("expected errNonPublicAddress, got %v", err)
+	}
+	if err := client.CheckRedirect(nil, nil); !errors.Is(err, http.ErrUseLastResponse) {
+		t.Errorf("expected redirects not to be followed, got %v", err)
+	}
+}
+
+// fakeLookupIP resolves example.com to a public address and internal.example to a private one.
+func fakeLookupIP(_ context.Context, host string) ([]netip.Addr, error) {
+	switch host {
+	case "example.com":
+		return []netip.Addr{netip.MustParseAddr("93.184.215.14")}, nil
+	case "internal.example":
+		return []netip.Addr{netip.MustParseAddr("93.184.215.14"), netip.MustParseAddr("10.0.0.5")}, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func TestWebhookHandlers(t *testing.T) {
+	t.Parallel()
+
+	const (
+		owner = 1
+		other = 2
+		admin = 3
+	)
+	type step struct {
+		method string
+		path   string
+		form   url.Values
+		userID int
+		status int
+	}
+
+	cases := map[string][]step{
+		"ok: owner manages a webhook": {
+			{http.MethodPost, "/webhooks", url.Values{"url": {"https://example.com/hook"}, "event_types": {"ItemCreated,ItemSold"}, "categories": {"fashion"}}, owner, http.StatusCreated},
+			{http.MethodGet, "/webhooks/1", nil, owner, http.StatusOK},
+			{http.MethodPatch, "/webhooks/1", url.Values{"active": {"false"}}, owner, http.StatusOK},
+			{http.MethodGet, "/webhooks/1/deliveries?status=dead", nil, owner, http.StatusOK},
+			{http.MethodGet, "/webhooks/dead-letters", nil, owner, http.StatusOK},
+			{http.MethodDelete, "/webhooks/1", nil, owner, http.StatusNoContent},
+			{http.MethodGet, "/webhooks/1", nil, owner, http.StatusNotFound},
+		},
+		"ng: invalid subscriptions": {
+			{http.MethodPost, "/webhooks", url.Values{"url": {"https://example.com/hook"}}, 0, http.StatusUnauthorized},
+			{http.MethodPost, "/webhooks", url.Values{"url": {"ftp://example.com"}}, owner, http.StatusBadRequest},
+			{http.MethodPost, "/webhooks", url.Values{}, owner, http.StatusBadRequest},
+			{http.MethodPost, "/webhooks", url.Values{"url": {"https://example.com"}, "event_types": {"ItemEaten"}}, owner, http.StatusBadRequest},
+			{http.MethodPost, "/webhooks", url.Values{"url": {"https://unknown.example/hook"}}, owner, http.StatusBadRequest},
+		},
+		"ng: non-public addresses": {
+			{http.MethodPost, "/webhooks", url.Values{"url": {"http://127.0.0.1:8080/hook"}}, owner, http.StatusBadRequest},
+			{http.MethodPost, "/webhooks", url.Values{"url": {"http://169.254.169.254/latest/meta-data"}}, owner, http.StatusBadRequest},
+			{http.MethodPost, "/webhooks", url.Values{"url": {"http://[::1]/hook"}}, owner, http.StatusBadRequest},
+			{http.MethodPost, "/webhooks", url.Values{"url": {"https://internal.example/hook"}}, owner, http.StatusBadRequest},
+			{http.MethodPost, "/webhooks", url.Values{"url": {"https://example.com/hook"}}, owner, http.StatusCreated},
+			{http.MethodPatch, "/webhooks/1", url.Values{"url": {"http://192.168.0.1/hook"}}, owner, http.StatusBadRequest},
+		},
+		"ng: only the owner and admins": {
+			{http.MethodPost, "/webhooks", url.Values{"url": {"https://example.com/hook"}}, owner, http.StatusCreated},
+			{http.MethodGet, "/webhooks/1", nil, other, http.StatusForbidden},
+			{http.MethodDelete, "/webhooks/1", nil, other, http.StatusForbidden},
+			{http.MethodPost, "/webhooks/1/deliveries/1/redeliver", nil, other, http.StatusForbidden},
+			{http.MethodPost, "/webhooks/1/deliveries/1/redeliver", nil, owner, http.StatusNotFound},
+			{http.MethodGet, "/webhooks/1", nil, admin, http.StatusOK},
+		},
+	}
+
+	for name, steps := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := &Handlers{webhooks: NewMemoryWebhookRepository(NewMemoryItemRepository()), adminUserIDs: map[int]bool{admin: true}, lookupIP: fakeLookupIP}
+			mux := http.NewServeMux()
+			mux.HandleFunc("POST /webhooks", h.CreateWebhook)
+			mux.HandleFunc("GET /webhooks", h.GetWebhooks)
+			mux.HandleFunc("GET /webhooks/dead-letters", h.GetWebhookDeadLetters)
+			mux.HandleFunc("GET /webhooks/{id}", h.GetWebhook)
+			mux.HandleFunc("PATCH /webhooks/{id}", h.UpdateWebhook)
+			mux.HandleFunc("DELETE /webhooks/{id}", h.DeleteWebhook)
+			mux.HandleFunc("GET /webhooks/{id}/deliveries", h.GetWebhookDeliveries)
+			mux.HandleFunc("POST /webhooks/{id}/deliveries/{delivery_id}/redeliver", h.RedeliverWebhook)
+
+			for _, s := range steps {
+				req := httptest.NewRequest(s.method, s.path, strings.NewReader(s.form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				if s.userID != 0 {
+					req = req.WithContext(ContextWithUserID(req.Context(), s.userID))
+				}
+				rr := httptest.NewRecorder()
+				mux.ServeHTTP(rr, req)
+				if rr.Code != s.status {
+					t.Fatalf("%s %s: expected status %d, got %d: %s", s.method, s.path, s.status, rr.Code, rr.Body)
+				}
+
+				// secret は作成時だけ返す
+				if s.method == http.MethodPost && s.status == http.StatusCreated {
+					var sub WebhookSubscription
+					json.NewDecoder(rr.Body).Decode(&sub)
+					if !strings.HasPrefix(sub.Secret, "whsec_") {
+						t.Errorf("expected a generated secret, got %q", sub.Secret)
+					}
+				} else if strings.Contains(rr.Body.String(), "whsec_") {
+					t.Errorf("%s %s: the secret must not be returned", s.method, s.path)
+				}
+			}
+		})
+	}
+}
@@ -1,20 +1,97 @@
 package main
 
 import (
+	"flag"
 	"mercari-build-training/app"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
-	port         = "9000"
-	imageDirPath = "images"
+	port            = "9000"
+	imageDirPath    = "images"
+	uploadDirPath   = "uploads"
+	imageGCInterval = 24 * time.Hour
+	// 他のプロセスによる書き込みは最大 cacheTTL 遅れて見える
+	cacheTTL  = 5 * time.Second
+	cacheSize = 1024
+	// 削除から DefaultTrashRetention 経った商品を完全に削除する間隔
+	trashPurgeInterval = time.Hour
 )
 
 func main() {
 	// This is the entry point of the application.
-	// You don't need to modify this function.
+	// --storage=memory なら DB も CGO も使わずに起動できる
+	storage := flag.String("storage", app.StorageDatabase, "where to keep items: db or memory")
+	// パスはすべてフラグで変えられるので、どのディレクトリからでも起動できる
+	// DATABASE_URL に postgres:// を指定すると PostgreSQL を使う
+	dsn := flag.String("db", envOr("DATABASE_URL", app.DefaultDatabaseDSN), "SQLite database path or postgres:// URL")
+	imageDir := flag.String("images", imageDirPath, "path to the image directory")
+	uploadDir := flag.String("uploads", uploadDirPath, "path to the directory for resumable uploads")
+	eventWebhook := flag.String("event-webhook", "", "URL to POST domain events to")
+	eventLog := flag.String("event-log", "", "path of an NDJSON file to append domain events to")
+	// 開発中は MailHog などのローカルの SMTP サーバーを指定する
+	smtpAddr := flag.String("smtp", envOr("SMTP_ADDR", ""), "host:port of the SMTP server to email notifications through")
+	flag.Parse()
+
+	var sinks []app.EventSink
+	if *eventWebhook != "" {
+		sinks = append(sinks, &app.WebhookSink{URL: *eventWebhook, Client: &http.Client{Timeout: 10 * time.Second}})
+	}
+	if *eventLog != "" {
+		sinks = append(sinks, app.NewFileSink(*eventLog))
+	}
+
 	os.Exit(app.Server{
-		Port:         port,
-		ImageDirPath: imageDirPath,
+		Port:               port,
+		Storage:            *storage,
+		ImageDirPath:       *imageDir,
+		UploadDirPath:      *uploadDir,
+		ImageGCInterval:    imageGCInterval,
+		ImageGCGracePeriod: app.DefaultImageGCGracePeriod,
+		DatabaseDSN:        *dsn,
+		CacheTTL:           cacheTTL,
+		CacheSize:          cacheSize,
+		// ADMIN_USER_IDS=1,2 のように管理者を指定する
+		AdminUserIDs: adminUserIDs(os.Getenv("ADMIN_USER_IDS")),
+		// フロントのプロキシが X-User-ID を USER_AUTH_SECRET で署名する
+		// TRUST_USER_ID_HEADER=true はプロキシを通さないローカル開発用
+		UserAuth: app.UserAuth{
+			Secret:      os.Getenv("USER_AUTH_SECRET"),
+			TrustHeader: os.Getenv("TRUST_USER_ID_HEADER") == "true",
+		},
+		TrashPurgeInterval: trashPurgeInterval,
+		TrashRetention:     app.DefaultTrashRetention,
+		EventSinks:         sinks,
+		// フロントエンドから WebSocket を開けるようにする
+		WebSocketOrigins: []string{envOr("FRONT_URL", "http://localhost:3000")},
+		// 他の出品者の写真の転載は警告に留める
+		DuplicateImageAction: app.DuplicateImageWarn,
+		SMTPAddr:             *smtpAddr,
+		MailFrom:             envOr("MAIL_FROM", "noreply@localhost"),
 	}.Run())
 }
+
+// envOr returns the environment variable key, or def when it is unset.
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// adminUserIDs parses a comma separated list of user IDs, ignoring invalid entries.
+func adminUserIDs(v string) []int {
+	var ids []int
+	for _, s := range strings.Split(v, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || id <= 0 {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
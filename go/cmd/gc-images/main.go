@@ -0,0 +1,48 @@
+// Command gc-images deletes image files which no item references.
+//
+//	go run ./cmd/gc-images -dry-run
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"mercari-build-training/app"
+	"os"
+)
+
+func main() {
+	dsn := flag.String("db", app.DefaultDatabaseDSN, "SQLite database path or postgres:// URL")
+	imageDirPath := flag.String("images", "images", "path to the image directory")
+	grace := flag.Duration("grace", app.DefaultImageGCGracePeriod, "keep unreferenced images younger than this")
+	dryRun := flag.Bool("dry-run", false, "list the images to delete without deleting them")
+	flag.Parse()
+
+	os.Exit(run(*dsn, *imageDirPath, app.ImageGCOptions{GracePeriod: *grace, DryRun: *dryRun}))
+}
+
+func run(dsn, imageDirPath string, opts app.ImageGCOptions) int {
+	db, itemRepo, err := app.OpenDatabase(dsn)
+	if err != nil {
+		slog.Error("failed to setup database", "error", err)
+		return 1
+	}
+	defer db.Close()
+
+	result, err := app.CollectImageGarbage(context.Background(), itemRepo, imageDirPath, opts)
+	if err != nil {
+		slog.Error("failed to collect unreferenced images", "error", err)
+		return 1
+	}
+
+	verb := "removed"
+	if opts.DryRun {
+		verb = "would remove"
+	}
+	for _, name := range result.Removed {
+		fmt.Printf("%s %s\n", verb, name)
+	}
+	fmt.Printf("%s: %d, referenced: %d, within grace period: %d\n", verb, len(result.Removed), result.Referenced, result.Skipped)
+	return 0
+}
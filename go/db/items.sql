@@ -1,14 +1,313 @@
--- カテゴリーテーブルを作成
-CREATE TABLE IF NOT EXISTS categories (
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    name TEXT UNIQUE NOT NULL
-);
-
--- アイテムテーブルを作成
-CREATE TABLE IF NOT EXISTS items (
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    name TEXT NOT NULL,
-    category_id INTEGER NOT NULL,
-    image_name TEXT,
-    FOREIGN KEY (category_id) REFERENCES categories(id)
-);
+-- カテゴリーテーブルを作成
+CREATE TABLE IF NOT EXISTS categories (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT UNIQUE NOT NULL
+);
+
+-- アイテムテーブルを作成
+CREATE TABLE IF NOT EXISTS items (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    category_id INTEGER NOT NULL,
+    image_name TEXT,
+    seller_id INTEGER,
+    image_hash BIGINT,
+    version INTEGER NOT NULL DEFAULT 1,
+    updated_at TIMESTAMP,
+    deleted_at TIMESTAMP,
+    like_count INTEGER NOT NULL DEFAULT 0,
+    price INTEGER NOT NULL DEFAULT 0,
+    listing_type TEXT NOT NULL DEFAULT 'fixed',
+    sold_at TIMESTAMP,
+    FOREIGN KEY (category_id) REFERENCES categories(id)
+);
+
+-- 監査ログ（追記のみ）
+CREATE TABLE IF NOT EXISTS audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at TIMESTAMP NOT NULL,
+    actor_id INTEGER,
+    action TEXT NOT NULL,
+    entity TEXT NOT NULL,
+    entity_id INTEGER NOT NULL,
+    before_json TEXT,
+    after_json TEXT,
+    diff_json TEXT,
+    request_id TEXT
+);
+CREATE INDEX IF NOT EXISTS audit_log_entity ON audit_log (entity, entity_id);
+CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+-- ドメインイベントの outbox（変更と同じトランザクションで書き込む）
+CREATE TABLE IF NOT EXISTS outbox (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at TIMESTAMP NOT NULL,
+    event_type TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL,
+    last_error TEXT,
+    delivered_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS outbox_pending ON outbox (delivered_at, next_attempt_at);
+
+-- 外部パートナー向けの Webhook 購読と配信
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    owner_id INTEGER NOT NULL,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    event_types TEXT NOT NULL DEFAULT '',
+    categories TEXT NOT NULL DEFAULT '',
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS webhook_subscriptions_owner ON webhook_subscriptions (owner_id);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    subscription_id INTEGER NOT NULL REFERENCES webhook_subscriptions (id) ON DELETE CASCADE,
+    event_id INTEGER NOT NULL,
+    event_type TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL,
+    response_status INTEGER,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL,
+    delivered_at TIMESTAMP,
+    UNIQUE (subscription_id, event_id)
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_pending ON webhook_deliveries (status, next_attempt_at);
+
+-- 商品ごとの購入希望者と出品者のメッセージ
+CREATE TABLE IF NOT EXISTS conversations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    item_id INTEGER NOT NULL,
+    buyer_id INTEGER NOT NULL,
+    seller_id INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    UNIQUE (item_id, buyer_id)
+);
+CREATE INDEX IF NOT EXISTS conversations_buyer ON conversations (buyer_id);
+CREATE INDEX IF NOT EXISTS conversations_seller ON conversations (seller_id);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    conversation_id INTEGER NOT NULL REFERENCES conversations (id) ON DELETE CASCADE,
+    sender_id INTEGER NOT NULL,
+    body TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    read_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS messages_conversation ON messages (conversation_id, id);
+
+-- WebSocket のフレームを他のサーバーに渡すためのログ。各サーバーが ID 順に読む
+CREATE TABLE IF NOT EXISTS message_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at TIMESTAMP NOT NULL,
+    origin TEXT NOT NULL,
+    user_ids TEXT NOT NULL,
+    frame TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS message_events_created ON message_events (created_at);
+
+-- 商品ページの公開コメント (Q&A)
+CREATE TABLE IF NOT EXISTS comments (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    item_id INTEGER NOT NULL REFERENCES items (id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL,
+    body TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS comments_item ON comments (item_id, id);
+
+CREATE TABLE IF NOT EXISTS comment_reports (
+    comment_id INTEGER NOT NULL REFERENCES comments (id) ON DELETE CASCADE,
+    reporter_id INTEGER NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (comment_id, reporter_id)
+);
+
+-- いいね。件数は items.like_count に同じトランザクションで反映する
+CREATE TABLE IF NOT EXISTS likes (
+    item_id INTEGER NOT NULL REFERENCES items (id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (item_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS likes_user ON likes (user_id, created_at);
+
+-- 保存した検索条件。新着商品が条件に合うと通知する
+CREATE TABLE IF NOT EXISTS saved_searches (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    keyword TEXT NOT NULL DEFAULT '',
+    category TEXT NOT NULL DEFAULT '',
+    min_price INTEGER NOT NULL DEFAULT 0,
+    max_price INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS saved_searches_user ON saved_searches (user_id);
+CREATE INDEX IF NOT EXISTS saved_searches_match ON saved_searches (category, min_price, max_price);
+
+-- ユーザーごとの通知の受信箱。dedupe_key が同じ通知は一度しか届けない
+CREATE TABLE IF NOT EXISTS notifications (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    type TEXT NOT NULL,
+    item_id INTEGER,
+    saved_search_id INTEGER,
+    params TEXT,
+    dedupe_key TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    read_at TIMESTAMP,
+    UNIQUE (user_id, dedupe_key)
+);
+CREATE INDEX IF NOT EXISTS notifications_user ON notifications (user_id, id);
+
+-- メールなど受信箱以外のチャネルへの送信キュー
+CREATE TABLE IF NOT EXISTS notification_deliveries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    notification_id INTEGER NOT NULL REFERENCES notifications (id) ON DELETE CASCADE,
+    channel TEXT NOT NULL,
+    status TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    sent_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS notification_deliveries_pending ON notification_deliveries (status, next_attempt_at);
+
+-- 通知の言語とメールで受け取る種類。行がなければ既定値を使う
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id INTEGER PRIMARY KEY,
+    language TEXT NOT NULL,
+    email TEXT NOT NULL DEFAULT '',
+    email_types TEXT NOT NULL DEFAULT '',
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- バックグラウンドジョブのキュー。実行中のジョブは locked_until を過ぎると他のワーカーが拾い直す
+-- unique_key は未完了のジョブの間だけ保持し、同じ仕事が二重に積まれないようにする
+CREATE TABLE IF NOT EXISTS jobs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    type TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    unique_key TEXT UNIQUE,
+    status TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL,
+    run_at TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS jobs_runnable ON jobs (status, run_at);
+
+-- オークション形式の出品。items.price は最高入札額 (入札がなければ開始価格) に合わせる
+CREATE TABLE IF NOT EXISTS auctions (
+    item_id INTEGER PRIMARY KEY REFERENCES items (id) ON DELETE CASCADE,
+    start_price INTEGER NOT NULL,
+    min_increment INTEGER NOT NULL,
+    ends_at TIMESTAMP NOT NULL,
+    highest_bid INTEGER NOT NULL DEFAULT 0,
+    highest_bidder_id INTEGER,
+    bid_count INTEGER NOT NULL DEFAULT 0,
+    closed_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS auctions_open ON auctions (closed_at, ends_at);
+
+CREATE TABLE IF NOT EXISTS bids (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    item_id INTEGER NOT NULL REFERENCES items (id) ON DELETE CASCADE,
+    bidder_id INTEGER NOT NULL,
+    amount INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS bids_item ON bids (item_id, id);
+
+-- 売買の記録。商品が完全に削除されても残す
+CREATE TABLE IF NOT EXISTS orders (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    item_id INTEGER NOT NULL UNIQUE,
+    seller_id INTEGER NOT NULL,
+    buyer_id INTEGER NOT NULL,
+    price INTEGER NOT NULL,
+    status TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    completed_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS orders_buyer ON orders (buyer_id, id);
+CREATE INDEX IF NOT EXISTS orders_seller ON orders (seller_id, id);
+
+-- 値下げ交渉。status の遷移はすべて offer_events に残す
+-- 承認された (accepted) 交渉は商品ごとに一つだけで、reserved_until まで購入者のために取り置く
+CREATE TABLE IF NOT EXISTS offers (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    item_id INTEGER NOT NULL REFERENCES items (id) ON DELETE CASCADE,
+    buyer_id INTEGER NOT NULL,
+    seller_id INTEGER NOT NULL,
+    amount INTEGER NOT NULL,
+    status TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    reserved_until TIMESTAMP,
+    order_id INTEGER,
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS offers_item ON offers (item_id, id);
+CREATE INDEX IF NOT EXISTS offers_buyer ON offers (buyer_id, id);
+CREATE INDEX IF NOT EXISTS offers_seller ON offers (seller_id, id);
+CREATE INDEX IF NOT EXISTS offers_status ON offers (status, expires_at);
+CREATE UNIQUE INDEX IF NOT EXISTS offers_accepted ON offers (item_id) WHERE status = 'accepted';
+
+CREATE TABLE IF NOT EXISTS offer_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    offer_id INTEGER NOT NULL REFERENCES offers (id) ON DELETE CASCADE,
+    action TEXT NOT NULL,
+    actor_id INTEGER,
+    from_status TEXT NOT NULL DEFAULT '',
+    to_status TEXT NOT NULL,
+    amount INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS offer_events_offer ON offer_events (offer_id, id);
+
+-- 取引が完了した注文について、購入者と出品者がお互いを一度ずつ評価する
+CREATE TABLE IF NOT EXISTS reviews (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    order_id INTEGER NOT NULL,
+    item_id INTEGER NOT NULL,
+    reviewer_id INTEGER NOT NULL,
+    reviewee_id INTEGER NOT NULL,
+    role TEXT NOT NULL,
+    rating TEXT NOT NULL,
+    comment TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE (order_id, reviewer_id)
+);
+CREATE INDEX IF NOT EXISTS reviews_reviewee ON reviews (reviewee_id, id);
+
+-- 公開プロフィール。保存していないユーザーは空のプロフィールになる
+CREATE TABLE IF NOT EXISTS profiles (
+    user_id INTEGER PRIMARY KEY,
+    display_name TEXT NOT NULL DEFAULT '',
+    bio TEXT NOT NULL DEFAULT '',
+    avatar_name TEXT,
+    updated_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,9 @@
+// Package db embeds the SQL schema so the binaries do not depend on the working directory.
+package db
+
+import "embed"
+
+// Schema holds items.sql (SQLite) and items.postgres.sql (PostgreSQL).
+//
+//go:embed *.sql
+var Schema embed.FS
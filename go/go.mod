@@ -9,9 +9,13 @@ require (
 	go.uber.org/mock v0.5.0
 )
 
+require github.com/lib/pq v1.10.9
+
+require github.com/gorilla/websocket v1.5.3
+
 require (
 	github.com/mattn/go-sqlite3 v1.14.24
 	golang.org/x/mod v0.18.0 // indirect
-	golang.org/x/sync v0.7.0 // indirect
+	golang.org/x/sync v0.7.0
 	golang.org/x/tools v0.22.0 // indirect
 )